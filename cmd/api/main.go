@@ -166,14 +166,36 @@ func main() {
 
 	rbacService := auth.NewRBACService(postgresDB.Pool)
 
+	// Create audit service
+	auditRepo := ci.NewAuditLogRepository(postgresDB.Pool, logger)
+	auditService := ci.NewAuditService(auditRepo, logger)
+
 	// Create CI services
 	ciRepo := ci.NewRepository(postgresDB.Pool, logger)
 	neo4jService := ci.NewNeo4jService(neo4jDB.Driver, logger)
-	ciService := ci.NewService(ciRepo, neo4jService, redisDB.Client, logger)
+	ciService := ci.NewService(ciRepo, neo4jService, auditService, redisDB.Client, logger)
+
+	// Start the PostgreSQL-to-Neo4j outbox dispatcher
+	syncCtx, stopSync := context.WithCancel(context.Background())
+	defer stopSync()
+	if cfg.GraphSync.Enabled {
+		dispatcher := ci.NewGraphSyncDispatcher(ciRepo, neo4jService, ci.GraphSyncOptions{
+			PollInterval: cfg.GraphSync.PollInterval,
+			BatchSize:    cfg.GraphSync.BatchSize,
+			MaxAttempts:  cfg.GraphSync.MaxAttempts,
+			BaseBackoff:  cfg.GraphSync.BaseBackoff,
+			MaxBackoff:   cfg.GraphSync.MaxBackoff,
+		}, logger)
+		go dispatcher.Run(syncCtx)
+	}
 
-	// Create audit service
-	auditRepo := ci.NewAuditLogRepository(postgresDB.Pool, logger)
-	auditService := ci.NewAuditService(auditRepo, logger)
+	// Start the CI type schema migration runner
+	schemaMigrationRunner := ci.NewSchemaMigrationRunner(ciService, ci.SchemaMigrationOptions{}, logger)
+	go schemaMigrationRunner.Run(syncCtx)
+
+	// Start the CSV import runner
+	ciImportRunner := ci.NewCIImportRunner(ciService, ci.CIImportOptions{}, logger)
+	go ciImportRunner.Run(syncCtx)
 
 	// Initialize admin user
 	if err := initializeAdminUser(postgresDB.Pool, rbacService, passwordService, cfg.Admin, logger); err != nil {
@@ -187,10 +209,15 @@ func main() {
 	ciHandlers := api.NewCIHandlers(baseHandler, ciService)
 	ciTypeHandlers := api.NewCITypeHandlers(baseHandler, ciService)
 	relationshipHandlers := api.NewRelationshipHandlers(baseHandler, ciService)
+	relationshipTypeHandlers := api.NewRelationshipTypeHandlers(baseHandler, ciService)
 	auditHandlers := api.NewAuditHandlers(baseHandler, auditService)
+	searchHandlers := api.NewSearchHandlers(baseHandler, ciService)
+	savedSearchHandlers := api.NewSavedSearchHandlers(baseHandler, ciService)
+	graphReconciler := ci.NewGraphReconciler(ciRepo, ci.NewNeo4jRepository(neo4jDB.Driver, logger), auditService, logger)
+	adminHandlers := api.NewAdminHandlers(baseHandler, ciService, graphReconciler)
 
 	// Setup router
-	router := setupRouter(cfg, logger, authHandler, userHandler, ciHandlers, ciTypeHandlers, relationshipHandlers, auditHandlers, jwtService, rbacService)
+	router := setupRouter(cfg, logger, authHandler, userHandler, ciHandlers, ciTypeHandlers, relationshipHandlers, relationshipTypeHandlers, auditHandlers, searchHandlers, savedSearchHandlers, adminHandlers, jwtService, rbacService)
 
 	// Create HTTP server
 	server := &http.Server{
@@ -227,6 +254,8 @@ func main() {
 		logger.Error().Err(err).Msg("Failed to shutdown HTTP server gracefully")
 	}
 
+	stopSync()
+
 	logger.Info().Msg("HTTP server stopped")
 }
 
@@ -238,7 +267,11 @@ func setupRouter(
 	ciHandlers *api.CIHandlers,
 	ciTypeHandlers *api.CITypeHandlers,
 	relationshipHandlers *api.RelationshipHandlers,
+	relationshipTypeHandlers *api.RelationshipTypeHandlers,
 	auditHandlers *api.AuditHandlers,
+	searchHandlers *api.SearchHandlers,
+	savedSearchHandlers *api.SavedSearchHandlers,
+	adminHandlers *api.AdminHandlers,
 	jwtService *auth.JWTService,
 	rbacService *auth.RBACService,
 ) *chi.Mux {
@@ -249,8 +282,12 @@ func setupRouter(
 	r.Use(chiMiddleware.RealIP)
 	r.Use(chiMiddleware.Recoverer)
 	r.Use(chiMiddleware.Timeout(60 * time.Second))
-	r.Use(chiMiddleware.AllowContentType("application/json"))
 	r.Use(chiMiddleware.CleanPath)
+	r.Use(middleware.AllowContentType([]string{"application/json"}, map[string][]string{
+		http.MethodPatch:               {ci.MergePatchContentType, ci.JSONPatchContentType},
+		"POST /api/v1/ci/imports":      {"multipart/form-data"},
+		"POST /api/v1/ci-types/import": {"application/schema+json"},
+	}))
 
 	// Custom middleware
 	r.Use(middleware.Logger)
@@ -289,6 +326,9 @@ func setupRouter(
 			// Audit logging middleware
 			r.Use(middleware.AuditLogging(rbacService, logger))
 
+			// Make client IP and user agent available to persisted audit events
+			r.Use(middleware.RequestMetadata)
+
 			// User routes
 			r.Route("/users", func(r chi.Router) {
 				r.Use(middleware.RBAC("user:read"))
@@ -316,15 +356,28 @@ func setupRouter(
 				r.Use(middleware.RBAC("ci_type:read"))
 				r.Get("/", ciTypeHandlers.ListCITypes)
 				r.Get("/{id}", ciTypeHandlers.GetCIType)
+				r.Get("/{id}/schema.json", ciTypeHandlers.GetCITypeJSONSchema)
+				r.Get("/{id}/versions", ciTypeHandlers.GetCITypeSchemaVersions)
+				r.Get("/{id}/versions/{version}", ciTypeHandlers.GetCITypeSchemaVersion)
+				r.Get("/{id}/migrations", ciTypeHandlers.ListCITypeMigrations)
+				r.Get("/{id}/migrations/{job_id}", ciTypeHandlers.GetCITypeMigration)
 
 				r.Group(func(r chi.Router) {
 					r.Use(middleware.RBAC("ci_type:create"))
 					r.Post("/", ciTypeHandlers.CreateCIType)
 				})
 
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RBAC("ci_type:create"))
+					r.Use(middleware.RBAC("ci_type:update"))
+					r.Post("/import", ciTypeHandlers.ImportCITypeJSONSchema)
+				})
+
 				r.Group(func(r chi.Router) {
 					r.Use(middleware.RBAC("ci_type:update"))
 					r.Put("/{id}", ciTypeHandlers.UpdateCIType)
+					r.Post("/{id}/dry-run", ciTypeHandlers.DryRunCITypeUpdate)
+					r.Post("/{id}/migrations", ciTypeHandlers.MigrateCIType)
 				})
 
 				r.Group(func(r chi.Router) {
@@ -337,7 +390,13 @@ func setupRouter(
 			r.Route("/ci", func(r chi.Router) {
 				r.Use(middleware.RBAC("ci:read"))
 				r.Get("/", ciHandlers.ListCIs)
+				r.Get("/export", ciHandlers.ExportCIs)
 				r.Get("/{id}", ciHandlers.GetCI)
+				r.Get("/{id}/history", ciHandlers.GetCIHistory)
+				r.Get("/{id}/diff", ciHandlers.DiffCIVersions)
+				r.Get("/imports", ciHandlers.ListCIImports)
+				r.Get("/imports/{id}", ciHandlers.GetCIImport)
+				r.Get("/imports/{id}/errors", ciHandlers.GetCIImportErrors)
 
 				r.Group(func(r chi.Router) {
 					r.Use(middleware.RBAC("ci:create"))
@@ -347,18 +406,40 @@ func setupRouter(
 				r.Group(func(r chi.Router) {
 					r.Use(middleware.RBAC("ci:update"))
 					r.Put("/{id}", ciHandlers.UpdateCI)
+					r.Patch("/{id}", ciHandlers.PatchCI)
+					r.Post("/{id}/versions/{version}/restore", ciHandlers.RestoreCIVersion)
 				})
 
 				r.Group(func(r chi.Router) {
 					r.Use(middleware.RBAC("ci:delete"))
 					r.Delete("/{id}", ciHandlers.DeleteCI)
 				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RBAC("ci:create"))
+					r.Use(middleware.RBAC("ci:update"))
+					r.Use(middleware.RBAC("ci:delete"))
+					r.Post("/bulk", ciHandlers.BulkCIs)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RBAC("ci:create"))
+					r.Use(middleware.RBAC("ci:update"))
+					r.Put("/by-key", ciHandlers.UpsertCI)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RBAC("ci:create"))
+					r.Use(middleware.RBAC("ci:update"))
+					r.Post("/imports", ciHandlers.StartCIImport)
+				})
 			})
 
 			// Relationship routes
 			r.Route("/relationships", func(r chi.Router) {
 				r.Use(middleware.RBAC("relationship:read"))
 				r.Get("/", relationshipHandlers.ListRelationships)
+				r.Get("/export", relationshipHandlers.ExportRelationships)
 				r.Get("/{id}", relationshipHandlers.GetRelationship)
 
 				r.Group(func(r chi.Router) {
@@ -369,6 +450,7 @@ func setupRouter(
 				r.Group(func(r chi.Router) {
 					r.Use(middleware.RBAC("relationship:update"))
 					r.Put("/{id}", relationshipHandlers.UpdateRelationship)
+					r.Patch("/{id}", relationshipHandlers.PatchRelationship)
 				})
 
 				r.Group(func(r chi.Router) {
@@ -377,6 +459,28 @@ func setupRouter(
 				})
 			})
 
+			// Relationship type routes
+			r.Route("/relationship-types", func(r chi.Router) {
+				r.Use(middleware.RBAC("relationship_type:read"))
+				r.Get("/", relationshipTypeHandlers.ListRelationshipTypes)
+				r.Get("/{id}", relationshipTypeHandlers.GetRelationshipType)
+
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RBAC("relationship_type:create"))
+					r.Post("/", relationshipTypeHandlers.CreateRelationshipType)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RBAC("relationship_type:update"))
+					r.Put("/{id}", relationshipTypeHandlers.UpdateRelationshipType)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RBAC("relationship_type:delete"))
+					r.Delete("/{id}", relationshipTypeHandlers.DeleteRelationshipType)
+				})
+			})
+
 			// Graph routes
 			r.Route("/graph", func(r chi.Router) {
 				r.Use(middleware.RBAC("ci:read"))
@@ -413,6 +517,30 @@ func setupRouter(
 				r.Get("/most-connected", relationshipHandlers.GetMostConnectedCIs)
 			})
 
+			// Search routes; results are limited to the kinds the user may read
+			r.Get("/search", searchHandlers.Search)
+
+			// Saved search routes; every user keeps their own, and only
+			// owners may change them
+			r.Route("/saved-searches", func(r chi.Router) {
+				r.Use(middleware.RBAC("ci:read"))
+				r.Get("/", savedSearchHandlers.ListSavedSearches)
+				r.Post("/", savedSearchHandlers.CreateSavedSearch)
+				r.Get("/{id}", savedSearchHandlers.GetSavedSearch)
+				r.Put("/{id}", savedSearchHandlers.UpdateSavedSearch)
+				r.Delete("/{id}", savedSearchHandlers.DeleteSavedSearch)
+				r.Get("/{id}/results", savedSearchHandlers.RunSavedSearch)
+			})
+
+			// Admin routes
+			r.Route("/admin", func(r chi.Router) {
+				r.Use(middleware.RBAC("system:admin"))
+				r.Get("/graph-sync/status", adminHandlers.GetGraphSyncStatus)
+				r.Post("/graph-sync/outbox/{id}/retry", adminHandlers.RetryGraphSyncEntry)
+				r.Post("/graph-sync/reconcile", adminHandlers.ReconcileGraph)
+				r.Get("/graph-sync/reconcile/{id}", adminHandlers.GetReconcileJob)
+			})
+
 			// Current user profile
 			r.Get("/me", authHandler.GetCurrentUser)
 
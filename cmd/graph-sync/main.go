@@ -0,0 +1,108 @@
+// Command graph-sync compares PostgreSQL with the Neo4j graph and, optionally,
+// repairs or rebuilds the graph from PostgreSQL.
+//
+// Usage:
+//
+//	graph-sync -user admin [-mode check|repair|rebuild] [-batch-size 500]
+//
+// The run is audited as performed by the given user, like one started through
+// the API. In check mode the command exits with status 2 when drift is found,
+// so it can be used from cron or CI jobs.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pustaka/pustaka/internal/auth"
+	"github.com/pustaka/pustaka/internal/ci"
+	"github.com/pustaka/pustaka/internal/config"
+	"github.com/pustaka/pustaka/internal/database"
+	pustakaLogger "github.com/pustaka/pustaka/pkg/logger"
+)
+
+func main() {
+	mode := flag.String("mode", ci.ReconcileModeCheck, "check, repair or rebuild")
+	batchSize := flag.Int("batch-size", 500, "number of entries written to Neo4j per batch")
+	username := flag.String("user", "", "username the run is audited as")
+	flag.Parse()
+
+	if *username == "" {
+		log.Fatalf("The -user flag is required")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	logger := pustakaLogger.New(pustakaLogger.Config{
+		Level:  cfg.Logging.Level,
+		Format: cfg.Logging.Format,
+	})
+
+	postgresDB, err := database.NewPostgresDB(
+		cfg.Database.URL,
+		cfg.Database.MaxOpenConns,
+		cfg.Database.MaxIdleConns,
+		cfg.Database.ConnMaxLifetime,
+	)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to connect to PostgreSQL")
+	}
+	defer postgresDB.Close()
+
+	neo4jDB, err := database.NewNeo4jDB(
+		cfg.Neo4j.URI,
+		cfg.Neo4j.Username,
+		cfg.Neo4j.Password,
+		cfg.Neo4j.Database,
+		cfg.Neo4j.MaxPool,
+	)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to connect to Neo4j")
+	}
+	defer neo4jDB.Close()
+
+	ctx := context.Background()
+
+	user, err := auth.NewRBACService(postgresDB.Pool).GetUserByUsername(ctx, *username)
+	if err != nil {
+		logger.Fatal().Err(err).Str("user", *username).Msg("Failed to look up user")
+	}
+
+	reconciler := ci.NewGraphReconciler(
+		ci.NewRepository(postgresDB.Pool, logger),
+		ci.NewNeo4jRepository(neo4jDB.Driver, logger),
+		ci.NewAuditService(ci.NewAuditLogRepository(postgresDB.Pool, logger), logger),
+		logger,
+	)
+
+	// A rebuild stopped after clearing the graph would leave it empty, and an
+	// interrupted run would never audit its outcome, so the run is not
+	// interruptible
+	signal.Ignore(syscall.SIGINT, syscall.SIGTERM)
+
+	report, err := reconciler.Reconcile(ctx, ci.ReconcileOptions{
+		Mode:      *mode,
+		BatchSize: *batchSize,
+	}, user.ID)
+	if err != nil {
+		logger.Fatal().Err(err).Str("mode", *mode).Msg("Graph reconciliation failed")
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to write report")
+	}
+
+	if report.Mode == ci.ReconcileModeCheck && report.HasDrift() {
+		os.Exit(2)
+	}
+}
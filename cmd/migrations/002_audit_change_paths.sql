@@ -0,0 +1,4 @@
+-- Index the flattened list of changed attribute paths recorded in audit details
+-- so the audit trail can be queried by field (e.g. attributes.ip_address)
+
+CREATE INDEX idx_audit_changed_paths ON audit_logs USING GIN ((details->'changed_paths'));
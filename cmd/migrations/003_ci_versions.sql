@@ -0,0 +1,26 @@
+-- Full snapshots of configuration items, one row per version, for history
+-- and point-in-time retrieval
+
+ALTER TABLE configuration_items ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- ci_id deliberately has no foreign key so history survives deletion of the CI
+CREATE TABLE configuration_item_versions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    ci_id UUID NOT NULL,
+    version INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    ci_type VARCHAR(100) NOT NULL,
+    attributes JSONB NOT NULL DEFAULT '{}',
+    tags TEXT[] DEFAULT '{}',
+    changed_by UUID REFERENCES users(id),
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT unique_ci_version UNIQUE (ci_id, version)
+);
+
+CREATE INDEX idx_ci_versions_changed_at ON configuration_item_versions(ci_id, changed_at);
+
+-- Seed the current state of existing CIs as their first recorded version
+INSERT INTO configuration_item_versions (ci_id, version, name, ci_type, attributes, tags, changed_by, changed_at)
+SELECT id, version, name, ci_type, attributes, tags, COALESCE(updated_by, created_by), COALESCE(updated_at, created_at, NOW())
+FROM configuration_items;
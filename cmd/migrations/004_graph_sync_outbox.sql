@@ -0,0 +1,26 @@
+-- Transactional outbox for PostgreSQL-to-Neo4j synchronization. Entries are
+-- written in the same transaction as the CI or relationship change and applied
+-- to the graph by a background dispatcher. Applied entries are deleted, so the
+-- table only holds pending work and dead letters.
+
+CREATE TABLE graph_sync_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    entity_type VARCHAR(50) NOT NULL,
+    entity_id UUID NOT NULL,
+    operation VARCHAR(20) NOT NULL,
+    payload JSONB,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT valid_outbox_entity_type CHECK (entity_type IN ('ci', 'relationship')),
+    CONSTRAINT valid_outbox_operation CHECK (operation IN ('upsert', 'delete')),
+    CONSTRAINT valid_outbox_status CHECK (status IN ('pending', 'dead'))
+);
+
+CREATE INDEX idx_graph_sync_outbox_pending ON graph_sync_outbox(next_attempt_at, id) WHERE status = 'pending';
+CREATE INDEX idx_graph_sync_outbox_entity ON graph_sync_outbox(entity_id, id) WHERE status = 'pending';
+CREATE INDEX idx_graph_sync_outbox_dead ON graph_sync_outbox(updated_at) WHERE status = 'dead';
@@ -0,0 +1,57 @@
+-- Registry of relationship types. Every relationship must use a registered
+-- type, which controls the CI types it may connect, how many relationships of
+-- that type a CI may take part in, and whether impact analysis follows it.
+
+CREATE TABLE relationship_type_definitions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(50) UNIQUE NOT NULL,
+    inverse_name VARCHAR(50) NOT NULL,
+    description TEXT,
+    source_ci_types TEXT[] NOT NULL DEFAULT '{}',
+    target_ci_types TEXT[] NOT NULL DEFAULT '{}',
+    cardinality VARCHAR(3) NOT NULL DEFAULT 'N:M',
+    is_dependency BOOLEAN NOT NULL DEFAULT false,
+    required_attributes JSONB NOT NULL DEFAULT '[]',
+    optional_attributes JSONB NOT NULL DEFAULT '[]',
+    created_by UUID REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+    CONSTRAINT valid_cardinality CHECK (cardinality IN ('1:1', '1:N', 'N:M'))
+);
+
+CREATE TRIGGER update_relationship_type_definitions_updated_at BEFORE UPDATE ON relationship_type_definitions
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Built-in types. A dependency type means the source depends on the target.
+INSERT INTO relationship_type_definitions (name, inverse_name, description, cardinality, is_dependency) VALUES
+('depends_on', 'dependency_of', 'The source needs the target to function', 'N:M', true),
+('runs_on', 'hosts', 'The source runs on the target', 'N:M', true),
+('connects_to', 'connected_from', 'Network connectivity from the source to the target', 'N:M', false),
+('manages', 'managed_by', 'The source manages the target', 'N:M', false),
+('contains', 'contained_in', 'The source physically contains the target', '1:N', false);
+
+-- Register any type already in use. They keep taking part in impact analysis,
+-- which used to follow every relationship.
+INSERT INTO relationship_type_definitions (name, inverse_name, description, cardinality, is_dependency)
+SELECT DISTINCT relationship_type, 'inverse_of_' || relationship_type, 'Registered from existing relationships', 'N:M', true
+FROM relationships
+WHERE relationship_type NOT IN (SELECT name FROM relationship_type_definitions);
+
+ALTER TABLE relationships
+    ADD CONSTRAINT fk_relationship_type FOREIGN KEY (relationship_type) REFERENCES relationship_type_definitions(name);
+
+INSERT INTO permissions (name, description, resource_type) VALUES
+('relationship_type:create', 'Create relationship type definitions', 'relationship_type'),
+('relationship_type:read', 'Read relationship type definitions', 'relationship_type'),
+('relationship_type:update', 'Update relationship type definitions', 'relationship_type'),
+('relationship_type:delete', 'Delete relationship type definitions', 'relationship_type');
+
+-- Admins get everything; editors and viewers can read the registry
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.resource_type = 'relationship_type';
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name IN ('editor', 'viewer') AND p.name = 'relationship_type:read';
@@ -0,0 +1,51 @@
+-- Versioned CI type schemas and the jobs that migrate existing CIs between
+-- schema versions
+
+ALTER TABLE ci_type_definitions ADD COLUMN schema_version INTEGER NOT NULL DEFAULT 1;
+
+-- ci_type_id deliberately has no foreign key so history survives deletion of the type
+CREATE TABLE ci_type_schema_versions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    ci_type_id UUID NOT NULL,
+    version INTEGER NOT NULL,
+    required_attributes JSONB NOT NULL DEFAULT '[]',
+    optional_attributes JSONB NOT NULL DEFAULT '[]',
+    changed_by UUID REFERENCES users(id),
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT unique_ci_type_schema_version UNIQUE (ci_type_id, version)
+);
+
+-- Seed the current schema of existing types as their first recorded version
+INSERT INTO ci_type_schema_versions (ci_type_id, version, required_attributes, optional_attributes, changed_by, changed_at)
+SELECT id, schema_version, required_attributes, optional_attributes, created_by, COALESCE(updated_at, created_at, NOW())
+FROM ci_type_definitions;
+
+CREATE TABLE ci_type_migration_jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    ci_type_id UUID NOT NULL REFERENCES ci_type_definitions(id) ON DELETE CASCADE,
+    from_version INTEGER NOT NULL,
+    to_version INTEGER NOT NULL,
+    operations JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    total_cis INTEGER NOT NULL DEFAULT 0,
+    processed_cis INTEGER NOT NULL DEFAULT 0,
+    migrated_cis INTEGER NOT NULL DEFAULT 0,
+    failed_cis INTEGER NOT NULL DEFAULT 0,
+    failures JSONB NOT NULL DEFAULT '[]',
+    last_ci_id UUID,
+    error TEXT,
+    created_by UUID REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP WITH TIME ZONE,
+
+    CONSTRAINT valid_migration_status CHECK (status IN ('pending', 'running', 'completed', 'failed'))
+);
+
+CREATE INDEX idx_ci_type_migration_jobs_ci_type ON ci_type_migration_jobs(ci_type_id, created_at DESC);
+
+-- At most one unfinished migration per CI type
+CREATE UNIQUE INDEX idx_ci_type_migration_jobs_active ON ci_type_migration_jobs(ci_type_id)
+    WHERE status IN ('pending', 'running');
@@ -0,0 +1,10 @@
+-- CI type inheritance. A type may extend a parent, inheriting its attribute
+-- definitions; abstract types only exist to be extended and hold no CIs.
+
+ALTER TABLE ci_type_definitions ADD COLUMN parent VARCHAR(100) REFERENCES ci_type_definitions(name);
+ALTER TABLE ci_type_definitions ADD COLUMN abstract BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE ci_type_definitions ADD CONSTRAINT ci_type_not_own_parent CHECK (parent <> name);
+
+CREATE INDEX idx_ci_type_definitions_parent ON ci_type_definitions(parent);
+
+ALTER TABLE ci_type_schema_versions ADD COLUMN parent VARCHAR(100);
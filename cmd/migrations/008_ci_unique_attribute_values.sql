@@ -0,0 +1,15 @@
+-- Values of attributes marked unique, one row per claimed value. The primary
+-- key makes two CIs claiming the same value in the same scope impossible, even
+-- under concurrent writes. scope is the CI type name, or '*' for attributes
+-- unique across all CI types.
+
+CREATE TABLE ci_unique_attribute_values (
+    scope VARCHAR(100) NOT NULL,
+    attribute VARCHAR(255) NOT NULL,
+    value TEXT NOT NULL,
+    ci_id UUID NOT NULL REFERENCES configuration_items(id) ON DELETE CASCADE,
+
+    PRIMARY KEY (scope, attribute, value)
+);
+
+CREATE INDEX idx_ci_unique_attribute_values_ci ON ci_unique_attribute_values(ci_id);
@@ -0,0 +1,6 @@
+-- Row version counters for CI types and relationships, bumped on every update.
+-- Like configuration_items.version they back the ETags used for optimistic
+-- concurrency control.
+
+ALTER TABLE ci_type_definitions ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE relationships ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,42 @@
+-- CSV imports of CIs, run as background jobs. The uploaded file is kept with
+-- the job so an interrupted import can resume; every rejected row is kept in
+-- ci_import_errors for the downloadable error report.
+
+CREATE TABLE ci_import_jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    ci_type VARCHAR(100) NOT NULL,
+    mode VARCHAR(20) NOT NULL DEFAULT 'create',
+    dry_run BOOLEAN NOT NULL DEFAULT false,
+    mapping JSONB NOT NULL DEFAULT '{}',
+    file_name TEXT,
+    content BYTEA NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    total_rows INTEGER NOT NULL DEFAULT 0,
+    processed_rows INTEGER NOT NULL DEFAULT 0,
+    created_rows INTEGER NOT NULL DEFAULT 0,
+    updated_rows INTEGER NOT NULL DEFAULT 0,
+    failed_rows INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    created_by UUID REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP WITH TIME ZONE,
+
+    CONSTRAINT valid_import_mode CHECK (mode IN ('create', 'upsert')),
+    CONSTRAINT valid_import_status CHECK (status IN ('pending', 'running', 'completed', 'failed'))
+);
+
+CREATE INDEX idx_ci_import_jobs_created_at ON ci_import_jobs(created_at DESC);
+CREATE INDEX idx_ci_import_jobs_status ON ci_import_jobs(status, created_at) WHERE status IN ('pending', 'running');
+
+CREATE TABLE ci_import_errors (
+    job_id UUID NOT NULL REFERENCES ci_import_jobs(id) ON DELETE CASCADE,
+    row_number INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    column_name TEXT,
+    field VARCHAR(255),
+    message TEXT NOT NULL,
+
+    PRIMARY KEY (job_id, row_number, position)
+);
@@ -0,0 +1,11 @@
+-- Keyset pagination reads listings in (sort key, id) order from a cursor.
+-- These indexes serve that order in both directions for each sort key.
+
+CREATE INDEX idx_cis_created_at_id ON configuration_items(created_at, id);
+CREATE INDEX idx_cis_updated_at_id ON configuration_items(updated_at, id);
+CREATE INDEX idx_cis_name_id ON configuration_items(name, id);
+CREATE INDEX idx_cis_type_id ON configuration_items(ci_type, id);
+
+CREATE INDEX idx_relationships_created_at_id ON relationships(created_at, id);
+
+CREATE INDEX idx_audit_timestamp_id ON audit_logs(timestamp, id);
@@ -0,0 +1,43 @@
+-- Full-text search over CIs, CI types and relationships.
+--
+-- Each table gets a stored, weighted search document: names and relationship
+-- types weigh most (A), then tags and descriptions (B), then the string values
+-- of attributes (C). The 'simple' configuration neither stems nor drops stop
+-- words, so host names and other identifiers stay whole and their prefixes
+-- match for typeahead.
+
+CREATE FUNCTION ci_search_vector(TEXT, TEXT[], JSONB) RETURNS tsvector
+    LANGUAGE SQL IMMUTABLE PARALLEL SAFE
+    AS $$
+        SELECT setweight(to_tsvector('simple', COALESCE($1, '')), 'A') ||
+               setweight(to_tsvector('simple', COALESCE(array_to_string($2, ' '), '')), 'B') ||
+               setweight(jsonb_to_tsvector('simple', COALESCE($3, '{}'), '["string"]'), 'C')
+    $$;
+
+CREATE FUNCTION ci_type_search_vector(TEXT, TEXT) RETURNS tsvector
+    LANGUAGE SQL IMMUTABLE PARALLEL SAFE
+    AS $$
+        SELECT setweight(to_tsvector('simple', COALESCE($1, '')), 'A') ||
+               setweight(to_tsvector('simple', COALESCE($2, '')), 'B')
+    $$;
+
+CREATE FUNCTION relationship_search_vector(TEXT, JSONB) RETURNS tsvector
+    LANGUAGE SQL IMMUTABLE PARALLEL SAFE
+    AS $$
+        SELECT setweight(to_tsvector('simple', COALESCE($1, '')), 'A') ||
+               setweight(jsonb_to_tsvector('simple', COALESCE($2, '{}'), '["string"]'), 'C')
+    $$;
+
+ALTER TABLE configuration_items ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (ci_search_vector(name, tags, attributes)) STORED;
+ALTER TABLE ci_type_definitions ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (ci_type_search_vector(name, description)) STORED;
+ALTER TABLE relationships ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (relationship_search_vector(relationship_type, attributes)) STORED;
+
+CREATE INDEX idx_cis_search_vector ON configuration_items USING GIN(search_vector);
+CREATE INDEX idx_ci_types_search_vector ON ci_type_definitions USING GIN(search_vector);
+CREATE INDEX idx_relationships_search_vector ON relationships USING GIN(search_vector);
+
+-- The name-only English index is superseded by idx_cis_search_vector
+DROP INDEX IF EXISTS idx_cis_name_fulltext;
@@ -0,0 +1,26 @@
+-- Saved searches: named CI listings and graph queries kept by a user, and
+-- optionally shared with everyone holding a role. A CI listing also keeps the
+-- columns its view shows.
+
+CREATE TABLE saved_searches (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    kind VARCHAR(20) NOT NULL,
+    ci_filters JSONB,
+    graph_filters JSONB,
+    columns TEXT[] NOT NULL DEFAULT '{}',
+    shared_with_role VARCHAR(50) REFERENCES roles(name) ON UPDATE CASCADE ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    version INTEGER NOT NULL DEFAULT 1,
+
+    CONSTRAINT valid_saved_search_kind CHECK (
+        (kind = 'ci' AND ci_filters IS NOT NULL AND graph_filters IS NULL) OR
+        (kind = 'graph' AND graph_filters IS NOT NULL AND ci_filters IS NULL)
+    ),
+    CONSTRAINT unique_saved_search_name UNIQUE (owner_id, name)
+);
+
+CREATE INDEX idx_saved_searches_shared_with_role ON saved_searches(shared_with_role) WHERE shared_with_role IS NOT NULL;
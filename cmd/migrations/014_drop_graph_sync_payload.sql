@@ -0,0 +1,4 @@
+-- The graph sync dispatcher reads the current row of each entity rather than
+-- replaying what was written, so outbox entries no longer carry a copy of it.
+
+ALTER TABLE graph_sync_outbox DROP COLUMN IF EXISTS payload;
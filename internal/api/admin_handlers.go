@@ -0,0 +1,145 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pustaka/pustaka/internal/api/middleware"
+	"github.com/pustaka/pustaka/internal/ci"
+)
+
+type AdminHandlers struct {
+	*Handler
+	ciService  *ci.Service
+	reconciler *ci.GraphReconciler
+}
+
+// NewAdminHandlers creates new admin handlers
+func NewAdminHandlers(handler *Handler, ciService *ci.Service, reconciler *ci.GraphReconciler) *AdminHandlers {
+	return &AdminHandlers{
+		Handler:    handler,
+		ciService:  ciService,
+		reconciler: reconciler,
+	}
+}
+
+// GetGraphSyncStatus handles GET /admin/graph-sync/status
+func (h *AdminHandlers) GetGraphSyncStatus(w http.ResponseWriter, r *http.Request) {
+	limit := h.getQueryInt(r, "limit", 50)
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	status, err := h.ciService.GetGraphSyncStatus(r.Context(), limit)
+	if err != nil {
+		h.logger.ErrorService("graph_sync", "GET_STATUS", err, nil)
+		h.writeError(w, http.StatusInternalServerError, "Failed to get graph sync status")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, status)
+}
+
+// RetryGraphSyncEntry handles POST /admin/graph-sync/outbox/{id}/retry
+func (h *AdminHandlers) RetryGraphSyncEntry(w http.ResponseWriter, r *http.Request) {
+	userIDStr, ok := middleware.GetUserIDFromContext(r)
+	if !ok {
+		h.writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Invalid user ID")
+		return
+	}
+
+	id, err := strconv.ParseInt(h.getPathParam(r, "id"), 10, 64)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid outbox entry ID")
+		return
+	}
+
+	entry, err := h.ciService.RetryGraphSync(r.Context(), id, userID)
+	if err != nil {
+		if err.Error() == "graph sync entry not found" {
+			h.writeError(w, http.StatusNotFound, "Dead-lettered graph sync entry not found")
+			return
+		}
+		h.logger.ErrorService("graph_sync", "RETRY_ENTRY", err, map[string]interface{}{
+			"outbox_id": id,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to retry graph sync entry")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, entry)
+}
+
+// ReconcileGraph handles POST /admin/graph-sync/reconcile. The run happens in
+// the background; poll GET /admin/graph-sync/reconcile/{id} for its report.
+func (h *AdminHandlers) ReconcileGraph(w http.ResponseWriter, r *http.Request) {
+	userIDStr, ok := middleware.GetUserIDFromContext(r)
+	if !ok {
+		h.writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Invalid user ID")
+		return
+	}
+
+	options := ci.ReconcileOptions{
+		Mode:      h.getQueryString(r, "mode"),
+		BatchSize: h.getQueryInt(r, "batch_size", 500),
+	}
+	if options.BatchSize < 1 || options.BatchSize > 5000 {
+		options.BatchSize = 500
+	}
+
+	job, err := h.reconciler.StartReconcile(r.Context(), options, userID)
+	if err != nil {
+		if err.Error() == "reconciliation already running" {
+			h.writeError(w, http.StatusConflict, "Graph reconciliation is already running")
+			return
+		}
+		if strings.HasPrefix(err.Error(), "invalid reconcile mode") {
+			h.writeError(w, http.StatusBadRequest, "Mode must be one of check, repair or rebuild")
+			return
+		}
+		h.logger.ErrorService("graph_sync", "RECONCILE", err, map[string]interface{}{
+			"mode":    options.Mode,
+			"user_id": userID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to start graph reconciliation")
+		return
+	}
+
+	h.logger.InfoService("graph_sync", "RECONCILE", map[string]interface{}{
+		"job_id":  job.ID,
+		"mode":    job.Mode,
+		"user_id": userID,
+	})
+
+	w.Header().Set("Location", "/api/v1/admin/graph-sync/reconcile/"+job.ID.String())
+	h.writeJSON(w, http.StatusAccepted, job)
+}
+
+// GetReconcileJob handles GET /admin/graph-sync/reconcile/{id}
+func (h *AdminHandlers) GetReconcileJob(w http.ResponseWriter, r *http.Request) {
+	id, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid reconcile job ID")
+		return
+	}
+
+	job, err := h.reconciler.GetReconcileJob(id)
+	if err != nil {
+		h.writeError(w, http.StatusNotFound, "Reconcile job not found")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, job)
+}
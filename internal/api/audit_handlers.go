@@ -27,6 +27,9 @@ func (h *AuditHandlers) ListAuditLogs(w http.ResponseWriter, r *http.Request) {
 
 	auditLogs, err := h.auditService.ListAuditLogs(r.Context(), filters)
 	if err != nil {
+		if h.writePagingError(w, err) {
+			return
+		}
 		h.writeError(w, http.StatusInternalServerError, "Failed to list audit logs")
 		return
 	}
@@ -167,10 +170,13 @@ func (h *AuditHandlers) buildAuditLogFilters(r *http.Request) ci.AuditLogFilters
 		EntityType: h.getQueryString(r, "entity_type"),
 		Action:     h.getQueryString(r, "action"),
 		Search:     h.getQueryString(r, "search"),
+		ChangedPath: h.getQueryString(r, "changed_path"),
 		Sort:       h.getQueryString(r, "sort"),
 		Order:      h.getQueryString(r, "order"),
 		Page:       h.getQueryInt(r, "page", 1),
 		Limit:      h.getQueryInt(r, "limit", 50),
+		Cursor:     h.getQueryString(r, "cursor"),
+		Count:      h.getQueryString(r, "count"),
 	}
 
 	// Parse entity_id if provided
@@ -1,8 +1,15 @@
 package api
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/google/uuid"
@@ -33,6 +40,7 @@ func NewCIHandlers(handler *Handler, ciService *ci.Service) *CIHandlers {
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]interface{}
 // @Failure 500 {object} map[string]string
 // @Router /api/v1/ci [post]
 func (h *CIHandlers) CreateCI(w http.ResponseWriter, r *http.Request) {
@@ -63,7 +71,7 @@ func (h *CIHandlers) CreateCI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ci, err := h.ciService.CreateCI(r.Context(), &req, userID)
+	created, err := h.ciService.CreateCI(r.Context(), &req, userID)
 	if err != nil {
 		if err.Error() == "Attribute validation failed" {
 			h.logger.ErrorService("ci", "CREATE_CI_VALIDATION", err, map[string]interface{}{
@@ -74,6 +82,16 @@ func (h *CIHandlers) CreateCI(w http.ResponseWriter, r *http.Request) {
 			h.writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		var validationErr ci.ServiceValidationError
+		if errors.As(err, &validationErr) {
+			h.writeValidationError(w, validationErr)
+			return
+		}
+		var uniqueErr ci.UniqueViolationError
+		if errors.As(err, &uniqueErr) {
+			h.writeUniqueViolation(w, uniqueErr)
+			return
+		}
 		h.logger.ErrorService("ci", "CREATE_CI", err, map[string]interface{}{
 			"request": req,
 			"user_id": userID,
@@ -82,16 +100,382 @@ func (h *CIHandlers) CreateCI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.writeJSON(w, http.StatusCreated, ci)
+	h.writeJSON(w, http.StatusCreated, created)
+}
+
+// BulkCIs godoc
+// @Summary Apply many CI operations at once
+// @Description Create, update, upsert or delete up to 1000 configuration items in one request. Each operation gets its own result. In atomic mode (the default) nothing is written unless every operation succeeds; in best_effort mode each operation stands on its own.
+// @Tags ci
+// @Accept json
+// @Produce json
+// @Param request body ci.BulkCIRequest true "Operations to apply"
+// @Success 200 {object} ci.BulkCIResponse
+// @Failure 400 {object} ci.BulkCIResponse
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci/bulk [post]
+func (h *CIHandlers) BulkCIs(w http.ResponseWriter, r *http.Request) {
+	userIDStr, ok := middleware.GetUserIDFromContext(r)
+	if !ok {
+		h.writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Invalid user ID")
+		return
+	}
+
+	var req ci.BulkCIRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	response, err := h.ciService.BulkCIs(r.Context(), &req, userID)
+	if err != nil {
+		var validationErr ci.ServiceValidationError
+		if errors.As(err, &validationErr) {
+			h.writeValidationError(w, validationErr)
+			return
+		}
+		h.logger.ErrorService("ci", "BULK_CIS", err, map[string]interface{}{
+			"mode":       req.Mode,
+			"operations": len(req.Operations),
+			"user_id":    userID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to apply bulk operations")
+		return
+	}
+
+	// An atomic request that failed wrote nothing
+	status := http.StatusOK
+	if response.Mode == ci.BulkModeAtomic && response.Failed > 0 {
+		status = http.StatusBadRequest
+	}
+	h.writeJSON(w, status, response)
+}
+
+// UpsertCI godoc
+// @Summary Create or update a configuration item by natural key
+// @Description Create the configuration item of ci_type with the given name, or the given value of a unique attribute, if it does not exist and update it if it does. Attributes and tags are replaced as in PUT /ci/{id}. Concurrent upserts of the same key never conflict with each other.
+// @Tags ci
+// @Accept json
+// @Produce json
+// @Param ci_type query string true "CI type name"
+// @Param name query string false "CI name"
+// @Param attribute query string false "Unique attribute to look the CI up by, instead of name"
+// @Param value query string false "Value of the unique attribute"
+// @Param request body ci.UpsertCIRequest true "Configuration item attributes and tags"
+// @Param If-Match header string false "ETag the configuration item must still have"
+// @Success 200 {object} ci.ConfigurationItem
+// @Success 201 {object} ci.ConfigurationItem
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]interface{}
+// @Failure 412 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci/by-key [put]
+func (h *CIHandlers) UpsertCI(w http.ResponseWriter, r *http.Request) {
+	userIDStr, ok := middleware.GetUserIDFromContext(r)
+	if !ok {
+		h.writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Invalid user ID")
+		return
+	}
+
+	key := ci.CIUpsertKey{
+		CIType:    h.getQueryString(r, "ci_type"),
+		Name:      h.getQueryString(r, "name"),
+		Attribute: h.getQueryString(r, "attribute"),
+		Value:     h.getQueryString(r, "value"),
+	}
+	if key.CIType == "" {
+		h.writeError(w, http.StatusBadRequest, "CI type is required")
+		return
+	}
+
+	var req ci.UpsertCIRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	upserted, created, err := h.ciService.UpsertCI(h.ifMatchContext(r), key, &req, userID)
+	if err != nil {
+		if err.Error() == fmt.Sprintf("CI type '%s' does not exist", key.CIType) || err.Error() == "Attribute validation failed" {
+			h.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var validationErr ci.ServiceValidationError
+		if errors.As(err, &validationErr) {
+			h.writeValidationError(w, validationErr)
+			return
+		}
+		var preconditionErr ci.PreconditionFailedError
+		if errors.As(err, &preconditionErr) {
+			h.writePreconditionFailed(w, preconditionErr)
+			return
+		}
+		var uniqueErr ci.UniqueViolationError
+		if errors.As(err, &uniqueErr) {
+			h.writeUniqueViolation(w, uniqueErr)
+			return
+		}
+		h.logger.ErrorService("ci", "UPSERT_CI", err, map[string]interface{}{
+			"ci_type":   key.CIType,
+			"name":      key.Name,
+			"attribute": key.Attribute,
+			"user_id":   userID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to upsert configuration item")
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	w.Header().Set("ETag", etag(upserted.Version))
+	h.writeJSON(w, status, upserted)
+}
+
+// StartCIImport godoc
+// @Summary Import configuration items from a CSV file
+// @Description Upload a CSV file and import its rows as configuration items of one CI type in the background. mapping maps column headers to name, tags or attributes of the type; without it, columns are matched by header. In create mode rows naming an existing CI fail; in upsert mode they update it. With dry_run set, rows are validated but nothing is written.
+// @Tags ci
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "CSV file with a header row"
+// @Param ci_type formData string true "CI type name"
+// @Param mode formData string false "create (default) or upsert"
+// @Param dry_run formData bool false "Validate the rows without writing them"
+// @Param mapping formData string false "JSON object mapping column headers to name, tags or attribute names"
+// @Success 202 {object} ci.CIImportJob
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci/imports [post]
+func (h *CIHandlers) StartCIImport(w http.ResponseWriter, r *http.Request) {
+	userIDStr, ok := middleware.GetUserIDFromContext(r)
+	if !ok {
+		h.writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Invalid user ID")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, ci.MaxCIImportFileSize+1<<20)
+	if err := r.ParseMultipartForm(ci.MaxCIImportFileSize); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.writeError(w, http.StatusRequestEntityTooLarge, "File is too large")
+			return
+		}
+		h.writeError(w, http.StatusBadRequest, "Invalid multipart form")
+		return
+	}
+
+	req := ci.CIImportRequest{
+		CIType: r.FormValue("ci_type"),
+		Mode:   r.FormValue("mode"),
+	}
+	if req.CIType == "" {
+		h.writeError(w, http.StatusBadRequest, "CI type is required")
+		return
+	}
+	if dryRun := r.FormValue("dry_run"); dryRun != "" {
+		if req.DryRun, err = strconv.ParseBool(dryRun); err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid dry_run value")
+			return
+		}
+	}
+	if mapping := r.FormValue("mapping"); mapping != "" {
+		if err := json.Unmarshal([]byte(mapping), &req.Mapping); err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid mapping: must be a JSON object of column headers to targets")
+			return
+		}
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "File is required")
+		return
+	}
+	defer file.Close()
+	if header.Size > ci.MaxCIImportFileSize {
+		h.writeError(w, http.StatusRequestEntityTooLarge, "File is too large")
+		return
+	}
+	req.FileName = header.Filename
+	if req.Content, err = io.ReadAll(file); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Failed to read file")
+		return
+	}
+
+	job, err := h.ciService.StartCIImport(r.Context(), &req, userID)
+	if err != nil {
+		var validationErr ci.ServiceValidationError
+		if errors.As(err, &validationErr) {
+			h.writeValidationError(w, validationErr)
+			return
+		}
+		h.logger.ErrorService("ci", "START_CI_IMPORT", err, map[string]interface{}{
+			"ci_type": req.CIType,
+			"mode":    req.Mode,
+			"user_id": userID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to start CSV import")
+		return
+	}
+
+	h.writeJSON(w, http.StatusAccepted, job)
+}
+
+// ListCIImports godoc
+// @Summary List CSV imports
+// @Description List the most recent CSV import jobs, newest first
+// @Tags ci
+// @Produce json
+// @Param limit query int false "Maximum number of jobs" default(20)
+// @Success 200 {array} ci.CIImportJob
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci/imports [get]
+func (h *CIHandlers) ListCIImports(w http.ResponseWriter, r *http.Request) {
+	limit := h.getQueryInt(r, "limit", 20)
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	jobs, err := h.ciService.ListCIImports(r.Context(), limit)
+	if err != nil {
+		h.logger.ErrorService("ci", "LIST_CI_IMPORTS", err, nil)
+		h.writeError(w, http.StatusInternalServerError, "Failed to list CSV imports")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, jobs)
+}
+
+// GetCIImport godoc
+// @Summary Get a CSV import
+// @Description Get the status and progress of a CSV import job
+// @Tags ci
+// @Produce json
+// @Param id path string true "Import job ID"
+// @Success 200 {object} ci.CIImportJob
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci/imports/{id} [get]
+func (h *CIHandlers) GetCIImport(w http.ResponseWriter, r *http.Request) {
+	jobID, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid import job ID")
+		return
+	}
+
+	job, err := h.ciService.GetCIImport(r.Context(), jobID)
+	if err != nil {
+		if err.Error() == "CI import job not found" {
+			h.writeError(w, http.StatusNotFound, "CSV import not found")
+			return
+		}
+		h.logger.ErrorService("ci", "GET_CI_IMPORT", err, map[string]interface{}{
+			"job_id": jobID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to get CSV import")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, job)
+}
+
+// GetCIImportErrors godoc
+// @Summary Download the error report of a CSV import
+// @Description Download every problem found in the rows of a CSV import, with the row number as counted in a spreadsheet (the header is row 1). The report is CSV unless format=json.
+// @Tags ci
+// @Produce text/csv
+// @Produce json
+// @Param id path string true "Import job ID"
+// @Param format query string false "csv (default) or json"
+// @Success 200 {array} ci.CIImportError
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci/imports/{id}/errors [get]
+func (h *CIHandlers) GetCIImportErrors(w http.ResponseWriter, r *http.Request) {
+	jobID, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid import job ID")
+		return
+	}
+
+	format := h.getQueryString(r, "format")
+	if format != "" && format != "csv" && format != "json" {
+		h.writeError(w, http.StatusBadRequest, "Invalid format: must be csv or json")
+		return
+	}
+
+	importErrors, err := h.ciService.GetCIImportErrors(r.Context(), jobID)
+	if err != nil {
+		if err.Error() == "CI import job not found" {
+			h.writeError(w, http.StatusNotFound, "CSV import not found")
+			return
+		}
+		h.logger.ErrorService("ci", "GET_CI_IMPORT_ERRORS", err, map[string]interface{}{
+			"job_id": jobID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to get CSV import errors")
+		return
+	}
+
+	if format == "json" {
+		h.writeJSON(w, http.StatusOK, importErrors)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="import-%s-errors.csv"`, jobID))
+	w.WriteHeader(http.StatusOK)
+
+	report := csv.NewWriter(w)
+	report.Write([]string{"row", "column", "field", "message"})
+	for _, e := range importErrors {
+		report.Write([]string{strconv.Itoa(e.Row), e.Column, e.Field, e.Message})
+	}
+	report.Flush()
 }
 
 // GetCI godoc
 // @Summary Get a configuration item
-// @Description Get a configuration item by ID
+// @Description Get a configuration item by ID, or the version that was current at as_of
 // @Tags ci
 // @Produce json
 // @Param id path string true "Configuration item ID"
+// @Param as_of query string false "Point in time (RFC 3339 timestamp or YYYY-MM-DD)"
+// @Param expand query string false "Set to references to inline referenced CIs" Enums(references)
+// @Param If-None-Match header string false "ETag of a cached copy"
 // @Success 200 {object} ci.ConfigurationItem
+// @Success 304
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 404 {object} map[string]string
@@ -107,6 +491,31 @@ func (h *CIHandlers) GetCI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if asOfStr := h.getQueryString(r, "as_of"); asOfStr != "" {
+		asOf, err := parseAsOf(asOfStr)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid as_of timestamp")
+			return
+		}
+
+		version, err := h.ciService.GetCIAsOf(r.Context(), ciID, asOf)
+		if err != nil {
+			if err.Error() == "CI version not found" {
+				h.writeError(w, http.StatusNotFound, "Configuration item did not exist at the requested time")
+				return
+			}
+			h.logger.ErrorService("ci", "GET_CI_AS_OF", err, map[string]interface{}{
+				"ci_id": ciID,
+				"as_of": asOf,
+			})
+			h.writeError(w, http.StatusInternalServerError, "Failed to get configuration item")
+			return
+		}
+
+		h.writeVersioned(w, r, version.Version, version)
+		return
+	}
+
 	ci, err := h.ciService.GetCI(r.Context(), ciID)
 	if err != nil {
 		if err.Error() == "CI not found" {
@@ -120,7 +529,23 @@ func (h *CIHandlers) GetCI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.writeJSON(w, http.StatusOK, ci)
+	if h.getQueryString(r, "expand") == "references" {
+		expanded, err := h.ciService.ExpandReferences(r.Context(), ci)
+		if err != nil {
+			h.logger.ErrorService("ci", "EXPAND_CI_REFERENCES", err, map[string]interface{}{
+				"ci_id": ciID,
+			})
+			h.writeError(w, http.StatusInternalServerError, "Failed to expand references")
+			return
+		}
+		// The ETag still names the CI's version for If-Match, but there is no
+		// 304: the referenced CIs can change without this one changing
+		w.Header().Set("ETag", etag(ci.Version))
+		h.writeJSON(w, http.StatusOK, expanded)
+		return
+	}
+
+	h.writeVersioned(w, r, ci.Version, ci)
 }
 
 // ListCIs godoc
@@ -129,26 +554,31 @@ func (h *CIHandlers) GetCI(w http.ResponseWriter, r *http.Request) {
 // @Tags ci
 // @Produce json
 // @Param ci_type query string false "Filter by CI type"
+// @Param include_subtypes query bool false "Also match CIs of types that inherit from ci_type"
 // @Param search query string false "Search in name and attributes"
 // @Param tags query []string false "Filter by tags"
 // @Param created_by query string false "Filter by creator ID"
 // @Param sort query string false "Sort field (name, type, created_at, updated_at)"
 // @Param order query string false "Sort order (asc, desc)" Enums(asc, desc)
+// @Param q query string false "Filter expression, e.g. ci_type:Server AND attributes.cpu_cores>=16 AND tags:prod"
 // @Param page query int false "Page number" default(1)
 // @Param limit query int false "Items per page" default(20)
+// @Param cursor query string false "next_cursor or prev_cursor of a previous page; replaces page, sort and order"
+// @Param count query string false "How to count the total: exact (default for page numbers), estimated or none (default for cursors)" Enums(exact, estimated, none)
+// @Param facets query []string false "Facets to count among the matching CIs: ci_type, tags, created_by, attributes (every enum attribute) or attributes.<name>"
+// @Param facet_limit query int false "Most common values counted per facet" default(20)
 // @Success 200 {object} ci.CIListResponse
 // @Failure 400 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/v1/ci [get]
 func (h *CIHandlers) ListCIs(w http.ResponseWriter, r *http.Request) {
-	filters := ci.ListCIFilters{
-		CIType:    h.getQueryString(r, "ci_type"),
-		Search:    h.getQueryString(r, "search"),
-		Tags:      h.getQueryStrings(r, "tags"),
-		CreatedBy: h.getQueryString(r, "created_by"),
-		Sort:      h.getQueryString(r, "sort"),
-		Order:     h.getQueryString(r, "order"),
+	filters := h.listCIFilters(r)
+	filters.Cursor = h.getQueryString(r, "cursor")
+	filters.Count = h.getQueryString(r, "count")
+	for _, facets := range h.getQueryStrings(r, "facets") {
+		filters.Facets = append(filters.Facets, strings.Split(facets, ",")...)
 	}
+	filters.FacetLimit = h.getQueryInt(r, "facet_limit", ci.DefaultFacetLimit)
 
 	page := h.getQueryInt(r, "page", 1)
 	limit := h.getQueryInt(r, "limit", 20)
@@ -167,6 +597,14 @@ func (h *CIHandlers) ListCIs(w http.ResponseWriter, r *http.Request) {
 			"page":    page,
 			"limit":   limit,
 		})
+		if h.writePagingError(w, err) {
+			return
+		}
+		var validationErr ci.ServiceValidationError
+		if errors.As(err, &validationErr) {
+			h.writeValidationError(w, validationErr)
+			return
+		}
 		h.writeError(w, http.StatusInternalServerError, "Failed to list configuration items")
 		return
 	}
@@ -174,6 +612,69 @@ func (h *CIHandlers) ListCIs(w http.ResponseWriter, r *http.Request) {
 	h.writeJSON(w, http.StatusOK, response)
 }
 
+// listCIFilters reads the CI list filters from the query string
+func (h *CIHandlers) listCIFilters(r *http.Request) ci.ListCIFilters {
+	return ci.ListCIFilters{
+		CIType:    h.getQueryString(r, "ci_type"),
+		Search:    h.getQueryString(r, "search"),
+		Tags:      h.getQueryStrings(r, "tags"),
+		CreatedBy: h.getQueryString(r, "created_by"),
+		Sort:      h.getQueryString(r, "sort"),
+		Order:     h.getQueryString(r, "order"),
+		Query:     h.getQueryString(r, "q"),
+
+		IncludeSubtypes: h.getQueryBool(r, "include_subtypes", false),
+	}
+}
+
+// ExportCIs godoc
+// @Summary Export configuration items
+// @Description Stream every configuration item matching the list filters as CSV, a JSON array or newline-delimited JSON. CSV has a column per attribute of the filtered CI type (and its subtypes when included), or of every CI type.
+// @Tags ci
+// @Produce text/csv
+// @Produce json
+// @Produce application/x-ndjson
+// @Param format query string false "csv (default), json or ndjson"
+// @Param ci_type query string false "Filter by CI type"
+// @Param include_subtypes query bool false "Also match CIs of types that inherit from ci_type"
+// @Param search query string false "Search in name and attributes"
+// @Param tags query []string false "Filter by tags"
+// @Param created_by query string false "Filter by creator ID"
+// @Param sort query string false "Sort field (name, type, created_at, updated_at)"
+// @Param order query string false "Sort order (asc, desc)" Enums(asc, desc)
+// @Param q query string false "Filter expression, as for listing"
+// @Success 200 {array} ci.ConfigurationItem
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci/export [get]
+func (h *CIHandlers) ExportCIs(w http.ResponseWriter, r *http.Request) {
+	filters := h.listCIFilters(r)
+	format := h.getQueryString(r, "format")
+	if format == "" {
+		format = ci.ExportFormatCSV
+	}
+
+	out := newExportResponse(w, format, "cis")
+	if err := h.ciService.ExportCIs(r.Context(), filters, format, out); err != nil {
+		h.logger.ErrorService("ci", "EXPORT_CIS", err, map[string]interface{}{
+			"filters": filters,
+			"format":  format,
+		})
+		// Once streaming has started the status is sent; the output stops short
+		if out.started {
+			return
+		}
+		var validationErr ci.ServiceValidationError
+		if errors.As(err, &validationErr) {
+			h.writeValidationError(w, validationErr)
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to export configuration items")
+	}
+}
+
 // UpdateCI godoc
 // @Summary Update a configuration item
 // @Description Update a configuration item with validation against CI type schema
@@ -182,11 +683,14 @@ func (h *CIHandlers) ListCIs(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param id path string true "Configuration item ID"
 // @Param request body ci.UpdateCIRequest true "Configuration item updates"
+// @Param If-Match header string false "ETag the configuration item must still have"
 // @Success 200 {object} ci.ConfigurationItem
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]interface{}
+// @Failure 412 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/v1/ci/{id} [put]
 func (h *CIHandlers) UpdateCI(w http.ResponseWriter, r *http.Request) {
@@ -212,16 +716,26 @@ func (h *CIHandlers) UpdateCI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ci, err := h.ciService.UpdateCI(r.Context(), ciID, &req, userID)
+	updated, err := h.ciService.UpdateCI(h.ifMatchContext(r), ciID, &req, userID)
 	if err != nil {
 		if err.Error() == "CI not found" {
 			h.writeError(w, http.StatusNotFound, "Configuration item not found")
 			return
 		}
+		var preconditionErr ci.PreconditionFailedError
+		if errors.As(err, &preconditionErr) {
+			h.writePreconditionFailed(w, preconditionErr)
+			return
+		}
 		if err.Error() == "Attribute validation failed" {
 			h.writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		var uniqueErr ci.UniqueViolationError
+		if errors.As(err, &uniqueErr) {
+			h.writeUniqueViolation(w, uniqueErr)
+			return
+		}
 		h.logger.ErrorService("ci", "UPDATE_CI", err, map[string]interface{}{
 			"ci_id":   ciID,
 			"request": req,
@@ -231,7 +745,88 @@ func (h *CIHandlers) UpdateCI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.writeJSON(w, http.StatusOK, ci)
+	w.Header().Set("ETag", etag(updated.Version))
+	h.writeJSON(w, http.StatusOK, updated)
+}
+
+// PatchCI godoc
+// @Summary Patch a configuration item
+// @Description Change some attributes or tags of a configuration item with a JSON Merge Patch (RFC 7396) or JSON Patch (RFC 6902) applied to {"attributes": {...}, "tags": [...]}. The result is validated against the CI type schema.
+// @Tags ci
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Produce json
+// @Param id path string true "Configuration item ID"
+// @Param If-Match header string false "ETag the configuration item must still have"
+// @Success 200 {object} ci.ConfigurationItem
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]interface{}
+// @Failure 412 {object} map[string]string
+// @Failure 415 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci/{id} [patch]
+func (h *CIHandlers) PatchCI(w http.ResponseWriter, r *http.Request) {
+	userIDStr, ok := middleware.GetUserIDFromContext(r)
+	if !ok {
+		h.writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Invalid user ID")
+		return
+	}
+	ciID, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid CI ID")
+		return
+	}
+
+	contentType, patch, ok := h.readPatch(w, r)
+	if !ok {
+		return
+	}
+
+	patched, err := h.ciService.PatchCI(h.ifMatchContext(r), ciID, contentType, patch, userID)
+	if err != nil {
+		if err.Error() == "CI not found" {
+			h.writeError(w, http.StatusNotFound, "Configuration item not found")
+			return
+		}
+		var preconditionErr ci.PreconditionFailedError
+		if errors.As(err, &preconditionErr) {
+			h.writePreconditionFailed(w, preconditionErr)
+			return
+		}
+		var patchErr ci.PatchError
+		if errors.As(err, &patchErr) {
+			h.writePatchError(w, patchErr)
+			return
+		}
+		var validationErr ci.ServiceValidationError
+		if errors.As(err, &validationErr) {
+			h.writeValidationError(w, validationErr)
+			return
+		}
+		var uniqueErr ci.UniqueViolationError
+		if errors.As(err, &uniqueErr) {
+			h.writeUniqueViolation(w, uniqueErr)
+			return
+		}
+		h.logger.ErrorService("ci", "PATCH_CI", err, map[string]interface{}{
+			"ci_id":        ciID,
+			"content_type": contentType,
+			"user_id":      userID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to patch configuration item")
+		return
+	}
+
+	w.Header().Set("ETag", etag(patched.Version))
+	h.writeJSON(w, http.StatusOK, patched)
 }
 
 // DeleteCI godoc
@@ -239,12 +834,14 @@ func (h *CIHandlers) UpdateCI(w http.ResponseWriter, r *http.Request) {
 // @Description Delete a configuration item (only if no relationships exist)
 // @Tags ci
 // @Param id path string true "Configuration item ID"
+// @Param If-Match header string false "ETag the configuration item must still have"
 // @Success 204
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
 // @Failure 409 {object} map[string]string
+// @Failure 412 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/v1/ci/{id} [delete]
 func (h *CIHandlers) DeleteCI(w http.ResponseWriter, r *http.Request) {
@@ -264,16 +861,29 @@ func (h *CIHandlers) DeleteCI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.ciService.DeleteCI(r.Context(), ciID, userID)
+	err = h.ciService.DeleteCI(h.ifMatchContext(r), ciID, userID)
 	if err != nil {
 		if err.Error() == "CI not found" {
 			h.writeError(w, http.StatusNotFound, "Configuration item not found")
 			return
 		}
+		var preconditionErr ci.PreconditionFailedError
+		if errors.As(err, &preconditionErr) {
+			h.writePreconditionFailed(w, preconditionErr)
+			return
+		}
 		if err.Error() == "cannot delete CI with existing relationships" {
 			h.writeError(w, http.StatusConflict, "Cannot delete configuration item with existing relationships")
 			return
 		}
+		var referencedErr ci.ReferencedCIError
+		if errors.As(err, &referencedErr) {
+			h.writeJSON(w, http.StatusConflict, map[string]interface{}{
+				"error":      "Cannot delete configuration item that is referenced by other configuration items",
+				"references": referencedErr.References,
+			})
+			return
+		}
 		h.logger.ErrorService("ci", "DELETE_CI", err, map[string]interface{}{
 			"ci_id": ciID,
 			"user_id": userID,
@@ -285,6 +895,195 @@ func (h *CIHandlers) DeleteCI(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// GetCIHistory godoc
+// @Summary Get CI version history
+// @Description List the recorded versions of a configuration item, newest first
+// @Tags ci
+// @Produce json
+// @Param id path string true "Configuration item ID"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(20)
+// @Success 200 {object} ci.CIVersionListResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci/{id}/history [get]
+func (h *CIHandlers) GetCIHistory(w http.ResponseWriter, r *http.Request) {
+	ciID, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid CI ID")
+		return
+	}
+
+	page := h.getQueryInt(r, "page", 1)
+	limit := h.getQueryInt(r, "limit", 20)
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	history, err := h.ciService.GetCIHistory(r.Context(), ciID, page, limit)
+	if err != nil {
+		h.logger.ErrorService("ci", "GET_CI_HISTORY", err, map[string]interface{}{
+			"ci_id": ciID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to get CI history")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, history)
+}
+
+// DiffCIVersions godoc
+// @Summary Diff two CI versions
+// @Description Compare two recorded versions of a configuration item
+// @Tags ci
+// @Produce json
+// @Param id path string true "Configuration item ID"
+// @Param from query string true "Version to compare from (e.g. v3)"
+// @Param to query string true "Version to compare to (e.g. v7)"
+// @Success 200 {object} ci.CIVersionDiff
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci/{id}/diff [get]
+func (h *CIHandlers) DiffCIVersions(w http.ResponseWriter, r *http.Request) {
+	ciID, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid CI ID")
+		return
+	}
+
+	fromVersion, err := parseVersion(h.getQueryString(r, "from"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid from version")
+		return
+	}
+	toVersion, err := parseVersion(h.getQueryString(r, "to"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid to version")
+		return
+	}
+
+	diff, err := h.ciService.DiffCIVersions(r.Context(), ciID, fromVersion, toVersion)
+	if err != nil {
+		if err.Error() == "CI version not found" {
+			h.writeError(w, http.StatusNotFound, "CI version not found")
+			return
+		}
+		h.logger.ErrorService("ci", "DIFF_CI_VERSIONS", err, map[string]interface{}{
+			"ci_id": ciID,
+			"from":  fromVersion,
+			"to":    toVersion,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to diff CI versions")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, diff)
+}
+
+// RestoreCIVersion godoc
+// @Summary Restore a CI version
+// @Description Restore a configuration item's attributes and tags from an earlier version. The restore is recorded as a new version.
+// @Tags ci
+// @Produce json
+// @Param id path string true "Configuration item ID"
+// @Param version path string true "Version to restore (e.g. v3)"
+// @Param If-Match header string false "ETag the configuration item must still have"
+// @Success 200 {object} ci.ConfigurationItem
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 412 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci/{id}/versions/{version}/restore [post]
+func (h *CIHandlers) RestoreCIVersion(w http.ResponseWriter, r *http.Request) {
+	userIDStr, ok := middleware.GetUserIDFromContext(r)
+	if !ok {
+		h.writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Invalid user ID")
+		return
+	}
+	ciID, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid CI ID")
+		return
+	}
+	version, err := parseVersion(h.getPathParam(r, "version"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid version")
+		return
+	}
+
+	restored, err := h.ciService.RestoreCIVersion(h.ifMatchContext(r), ciID, version, userID)
+	if err != nil {
+		if err.Error() == "CI not found" || err.Error() == "CI version not found" {
+			h.writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		var preconditionErr ci.PreconditionFailedError
+		if errors.As(err, &preconditionErr) {
+			h.writePreconditionFailed(w, preconditionErr)
+			return
+		}
+		if err.Error() == "Attribute validation failed" {
+			h.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var uniqueErr ci.UniqueViolationError
+		if errors.As(err, &uniqueErr) {
+			h.writeUniqueViolation(w, uniqueErr)
+			return
+		}
+		h.logger.ErrorService("ci", "RESTORE_CI_VERSION", err, map[string]interface{}{
+			"ci_id":   ciID,
+			"version": version,
+			"user_id": userID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to restore configuration item")
+		return
+	}
+
+	w.Header().Set("ETag", etag(restored.Version))
+	h.writeJSON(w, http.StatusOK, restored)
+}
+
+// parseVersion accepts a version number with an optional "v" prefix
+func parseVersion(value string) (int, error) {
+	version, err := strconv.Atoi(strings.TrimPrefix(value, "v"))
+	if err != nil {
+		return 0, err
+	}
+	if version < 1 {
+		return 0, fmt.Errorf("version must be positive")
+	}
+	return version, nil
+}
+
+// parseAsOf accepts an RFC 3339 timestamp or a plain date, which is read as
+// the end of that day in UTC
+func parseAsOf(value string) (time.Time, error) {
+	if asOf, err := time.Parse(time.RFC3339, value); err == nil {
+		return asOf, nil
+	}
+
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return day.Add(24*time.Hour - time.Nanosecond), nil
+}
+
 // GetCIRelationships godoc
 // @Summary Get CI relationships
 // @Description Get all relationships for a configuration item
@@ -322,6 +1121,7 @@ func (h *CIHandlers) GetCIRelationships(w http.ResponseWriter, r *http.Request)
 // @Tags graph
 // @Produce json
 // @Param ci_types query []string false "Filter by CI types"
+// @Param include_subtypes query bool false "Also match CIs of types that inherit from ci_types"
 // @Param search query string false "Search in CI names"
 // @Param limit query int false "Maximum number of nodes" default(100)
 // @Success 200 {object} ci.GraphData
@@ -332,6 +1132,8 @@ func (h *CIHandlers) GetGraphData(w http.ResponseWriter, r *http.Request) {
 		CITypes: h.getQueryStrings(r, "ci_types"),
 		Search:  h.getQueryString(r, "search"),
 		Limit:   h.getQueryInt(r, "limit", 100),
+
+		IncludeSubtypes: h.getQueryBool(r, "include_subtypes", false),
 	}
 
 	if filters.Limit < 1 || filters.Limit > 500 {
@@ -423,6 +1225,7 @@ func (h *CIHandlers) GetImpactAnalysis(w http.ResponseWriter, r *http.Request) {
 // @Tags graph
 // @Produce json
 // @Param ci_types query []string false "Filter by CI types"
+// @Param include_subtypes query bool false "Also match CIs of types that inherit from ci_types"
 // @Param search query string false "Search term"
 // @Param limit query int false "Maximum number of nodes" default(100)
 // @Success 200 {object} ci.GraphData
@@ -434,6 +1237,8 @@ func (h *CIHandlers) ExploreGraph(w http.ResponseWriter, r *http.Request) {
 		CITypes: h.getQueryStrings(r, "ci_types"),
 		Search:  h.getQueryString(r, "search"),
 		Limit:   h.getQueryInt(r, "limit", 100),
+
+		IncludeSubtypes: h.getQueryBool(r, "include_subtypes", false),
 	}
 	if filters.Limit < 1 || filters.Limit > 500 {
 		filters.Limit = 100
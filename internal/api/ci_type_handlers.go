@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -67,6 +68,11 @@ func (h *CITypeHandlers) CreateCIType(w http.ResponseWriter, r *http.Request) {
 			h.writeError(w, http.StatusConflict, "CI type with this name already exists")
 			return
 		}
+		var schemaErr ci.ServiceValidationError
+		if errors.As(err, &schemaErr) {
+			h.writeValidationError(w, schemaErr)
+			return
+		}
 		h.logger.ErrorService("ci_type", "CREATE_CI_TYPE", err, map[string]interface{}{
 			"request": req,
 			"user_id": userID,
@@ -80,11 +86,14 @@ func (h *CITypeHandlers) CreateCIType(w http.ResponseWriter, r *http.Request) {
 
 // GetCIType godoc
 // @Summary Get a CI type
-// @Description Get a configuration item type definition by ID
+// @Description Get a configuration item type definition by ID. With resolved=true the attributes inherited from parent types are merged in.
 // @Tags ci-types
 // @Produce json
 // @Param id path string true "CI type ID"
+// @Param resolved query bool false "Include inherited attributes"
+// @Param If-None-Match header string false "ETag of a cached copy; ignored with resolved=true"
 // @Success 200 {object} ci.CITypeDefinition
+// @Success 304
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 404 {object} map[string]string
@@ -100,7 +109,14 @@ func (h *CITypeHandlers) GetCIType(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ciType, err := h.ciService.GetCIType(r.Context(), ciTypeID)
+	resolved := h.getQueryBool(r, "resolved", false)
+
+	var ciType *ci.CITypeDefinition
+	if resolved {
+		ciType, err = h.ciService.GetResolvedCIType(r.Context(), ciTypeID)
+	} else {
+		ciType, err = h.ciService.GetCIType(r.Context(), ciTypeID)
+	}
 	if err != nil {
 		if err.Error() == "CI type not found" {
 			h.writeError(w, http.StatusNotFound, "CI type not found")
@@ -113,7 +129,13 @@ func (h *CITypeHandlers) GetCIType(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.writeJSON(w, http.StatusOK, ciType)
+	// A resolved type also changes with its ancestors, so only the type as
+	// stored carries its version as an ETag
+	if resolved {
+		h.writeJSON(w, http.StatusOK, ciType)
+		return
+	}
+	h.writeVersioned(w, r, ciType.Version, ciType)
 }
 
 // ListCITypes godoc
@@ -161,11 +183,14 @@ func (h *CITypeHandlers) ListCITypes(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param id path string true "CI type ID"
 // @Param request body ci.UpdateCITypeRequest true "CI type updates"
+// @Param If-Match header string false "ETag the CI type must still have"
 // @Success 200 {object} ci.CITypeDefinition
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 412 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/v1/ci-types/{id} [put]
 func (h *CITypeHandlers) UpdateCIType(w http.ResponseWriter, r *http.Request) {
@@ -193,12 +218,31 @@ func (h *CITypeHandlers) UpdateCIType(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ciType, err := h.ciService.UpdateCIType(r.Context(), ciTypeID, &req, userID)
+	ciType, err := h.ciService.UpdateCIType(h.ifMatchContext(r), ciTypeID, &req, userID)
 	if err != nil {
 		if err.Error() == "CI type not found" {
 			h.writeError(w, http.StatusNotFound, "CI type not found")
 			return
 		}
+		var preconditionErr ci.PreconditionFailedError
+		if errors.As(err, &preconditionErr) {
+			h.writePreconditionFailed(w, preconditionErr)
+			return
+		}
+		if err.Error() == "schema migration already in progress" {
+			h.writeError(w, http.StatusConflict, "A schema migration is already in progress for this CI type")
+			return
+		}
+		var schemaErr ci.ServiceValidationError
+		if errors.As(err, &schemaErr) {
+			h.writeValidationError(w, schemaErr)
+			return
+		}
+		var uniqueErr ci.UniqueViolationError
+		if errors.As(err, &uniqueErr) {
+			h.writeUniqueViolation(w, uniqueErr)
+			return
+		}
 		h.logger.ErrorService("ci_type", "UPDATE_CI_TYPE", err, map[string]interface{}{
 			"ci_type_id": ciTypeID,
 			"request":    req,
@@ -208,20 +252,23 @@ func (h *CITypeHandlers) UpdateCIType(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("ETag", etag(ciType.Version))
 	h.writeJSON(w, http.StatusOK, ciType)
 }
 
 // DeleteCIType godoc
 // @Summary Delete a CI type
-// @Description Delete a configuration item type definition (only if no CIs of this type exist)
+// @Description Delete a configuration item type definition (only if no CIs of this type exist and no type inherits from it)
 // @Tags ci-types
 // @Param id path string true "CI type ID"
+// @Param If-Match header string false "ETag the CI type must still have"
 // @Success 204
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
 // @Failure 409 {object} map[string]string
+// @Failure 412 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/v1/ci-types/{id} [delete]
 func (h *CITypeHandlers) DeleteCIType(w http.ResponseWriter, r *http.Request) {
@@ -243,16 +290,25 @@ func (h *CITypeHandlers) DeleteCIType(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.ciService.DeleteCIType(r.Context(), ciTypeID, userID)
+	err = h.ciService.DeleteCIType(h.ifMatchContext(r), ciTypeID, userID)
 	if err != nil {
 		if err.Error() == "CI type not found" {
 			h.writeError(w, http.StatusNotFound, "CI type not found")
 			return
 		}
+		var preconditionErr ci.PreconditionFailedError
+		if errors.As(err, &preconditionErr) {
+			h.writePreconditionFailed(w, preconditionErr)
+			return
+		}
 		if err.Error() == "cannot delete CI type with existing CIs" {
 			h.writeError(w, http.StatusConflict, "Cannot delete CI type with existing configuration items")
 			return
 		}
+		if err.Error() == "cannot delete CI type with subtypes" {
+			h.writeError(w, http.StatusConflict, "Cannot delete CI type that other CI types inherit from")
+			return
+		}
 		h.logger.ErrorService("ci_type", "DELETE_CI_TYPE", err, map[string]interface{}{
 			"ci_type_id": ciTypeID,
 			"user_id":    userID,
@@ -264,9 +320,110 @@ func (h *CITypeHandlers) DeleteCIType(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// GetCITypeJSONSchema godoc
+// @Summary Export a CI type as JSON Schema
+// @Description Get a JSON Schema (draft 2020-12) describing the body of a request that creates a CI of this type, inherited attributes included
+// @Tags ci-types
+// @Produce json
+// @Param id path string true "CI type ID"
+// @Success 200 {object} ci.JSONSchema
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci-types/{id}/schema.json [get]
+func (h *CITypeHandlers) GetCITypeJSONSchema(w http.ResponseWriter, r *http.Request) {
+	ciTypeID, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid CI type ID")
+		return
+	}
+
+	schema, err := h.ciService.GetCITypeJSONSchema(r.Context(), ciTypeID)
+	if err != nil {
+		if err.Error() == "CI type not found" {
+			h.writeError(w, http.StatusNotFound, "CI type not found")
+			return
+		}
+		h.logger.ErrorService("ci_type", "GET_CI_TYPE_JSON_SCHEMA", err, map[string]interface{}{
+			"ci_type_id": ciTypeID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to export CI type schema")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(schema)
+}
+
+// ImportCITypeJSONSchema godoc
+// @Summary Import a CI type from JSON Schema
+// @Description Create a CI type from a JSON Schema document, or update the type with the same name to match it. Importing an unchanged document changes nothing.
+// @Tags ci-types
+// @Accept json
+// @Produce json
+// @Param request body ci.JSONSchema true "JSON Schema document"
+// @Success 200 {object} ci.CITypeDefinition
+// @Success 201 {object} ci.CITypeDefinition
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci-types/import [post]
+func (h *CITypeHandlers) ImportCITypeJSONSchema(w http.ResponseWriter, r *http.Request) {
+	userIDStr, ok := middleware.GetUserIDFromContext(r)
+	if !ok {
+		h.writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Invalid user ID")
+		return
+	}
+
+	var doc ci.JSONSchema
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON Schema document")
+		return
+	}
+
+	ciType, created, err := h.ciService.ImportCITypeJSONSchema(r.Context(), &doc, userID)
+	if err != nil {
+		if err.Error() == "schema migration already in progress" {
+			h.writeError(w, http.StatusConflict, "A schema migration is already in progress for this CI type")
+			return
+		}
+		var schemaErr ci.ServiceValidationError
+		if errors.As(err, &schemaErr) {
+			h.writeValidationError(w, schemaErr)
+			return
+		}
+		var uniqueErr ci.UniqueViolationError
+		if errors.As(err, &uniqueErr) {
+			h.writeUniqueViolation(w, uniqueErr)
+			return
+		}
+		h.logger.ErrorService("ci_type", "IMPORT_CI_TYPE", err, map[string]interface{}{
+			"ci_type_name": doc.Title,
+			"user_id":      userID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to import CI type")
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	h.writeJSON(w, status, ciType)
+}
+
 // GetCITypesByUsage godoc
 // @Summary Get CI types by usage
-// @Description Get CI types sorted by usage frequency
+// @Description Get every CI type with its own CI count and a total that includes its subtypes, sorted by total
 // @Tags analytics
 // @Produce json
 // @Success 200 {array} ci.CITypeUsage
@@ -281,4 +438,297 @@ func (h *CITypeHandlers) GetCITypesByUsage(w http.ResponseWriter, r *http.Reques
 	}
 
 	h.writeJSON(w, http.StatusOK, usage)
-}
\ No newline at end of file
+}
+// GetCITypeSchemaVersions godoc
+// @Summary Get CI type schema history
+// @Description List the recorded schema versions of a CI type, newest first
+// @Tags ci-types
+// @Produce json
+// @Param id path string true "CI type ID"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(20)
+// @Success 200 {object} ci.CITypeSchemaVersionListResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci-types/{id}/versions [get]
+func (h *CITypeHandlers) GetCITypeSchemaVersions(w http.ResponseWriter, r *http.Request) {
+	ciTypeID, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid CI type ID")
+		return
+	}
+
+	page := h.getQueryInt(r, "page", 1)
+	limit := h.getQueryInt(r, "limit", 20)
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	versions, err := h.ciService.ListCITypeSchemaVersions(r.Context(), ciTypeID, page, limit)
+	if err != nil {
+		if err.Error() == "CI type not found" {
+			h.writeError(w, http.StatusNotFound, "CI type not found")
+			return
+		}
+		h.logger.ErrorService("ci_type", "LIST_CI_TYPE_SCHEMA_VERSIONS", err, map[string]interface{}{
+			"ci_type_id": ciTypeID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to get CI type schema history")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, versions)
+}
+
+// GetCITypeSchemaVersion godoc
+// @Summary Get a CI type schema version
+// @Description Get the attribute schema of a CI type as it was at one version
+// @Tags ci-types
+// @Produce json
+// @Param id path string true "CI type ID"
+// @Param version path string true "Schema version (e.g. v3)"
+// @Success 200 {object} ci.CITypeSchemaVersion
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci-types/{id}/versions/{version} [get]
+func (h *CITypeHandlers) GetCITypeSchemaVersion(w http.ResponseWriter, r *http.Request) {
+	ciTypeID, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid CI type ID")
+		return
+	}
+	version, err := parseVersion(h.getPathParam(r, "version"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid version")
+		return
+	}
+
+	schema, err := h.ciService.GetCITypeSchemaVersion(r.Context(), ciTypeID, version)
+	if err != nil {
+		if err.Error() == "CI type schema version not found" {
+			h.writeError(w, http.StatusNotFound, "CI type schema version not found")
+			return
+		}
+		h.logger.ErrorService("ci_type", "GET_CI_TYPE_SCHEMA_VERSION", err, map[string]interface{}{
+			"ci_type_id": ciTypeID,
+			"version":    version,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to get CI type schema version")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, schema)
+}
+
+// DryRunCITypeUpdate godoc
+// @Summary Dry-run a CI type schema change
+// @Description Report which existing configuration items would fail validation under a proposed schema. Nothing is changed.
+// @Tags ci-types
+// @Accept json
+// @Produce json
+// @Param id path string true "CI type ID"
+// @Param request body ci.UpdateCITypeRequest true "Proposed CI type changes"
+// @Success 200 {object} ci.SchemaDryRunReport
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci-types/{id}/dry-run [post]
+func (h *CITypeHandlers) DryRunCITypeUpdate(w http.ResponseWriter, r *http.Request) {
+	ciTypeID, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid CI type ID")
+		return
+	}
+
+	var req ci.UpdateCITypeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	report, err := h.ciService.DryRunCITypeUpdate(r.Context(), ciTypeID, &req)
+	if err != nil {
+		if err.Error() == "CI type not found" {
+			h.writeError(w, http.StatusNotFound, "CI type not found")
+			return
+		}
+		var schemaErr ci.ServiceValidationError
+		if errors.As(err, &schemaErr) {
+			h.writeValidationError(w, schemaErr)
+			return
+		}
+		h.logger.ErrorService("ci_type", "DRY_RUN_CI_TYPE_UPDATE", err, map[string]interface{}{
+			"ci_type_id": ciTypeID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to dry-run CI type update")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, report)
+}
+
+// MigrateCIType godoc
+// @Summary Migrate a CI type schema
+// @Description Apply declarative migration operations (rename, set_default, convert, drop) to a CI type's schema and, in the background, to every CI of the type. With dry_run set, report the effect without changing anything.
+// @Tags ci-types
+// @Accept json
+// @Produce json
+// @Param id path string true "CI type ID"
+// @Param request body ci.SchemaMigrationRequest true "Migration operations"
+// @Success 200 {object} ci.SchemaDryRunReport
+// @Success 202 {object} ci.SchemaMigrationJob
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci-types/{id}/migrations [post]
+func (h *CITypeHandlers) MigrateCIType(w http.ResponseWriter, r *http.Request) {
+	userIDStr, ok := middleware.GetUserIDFromContext(r)
+	if !ok {
+		h.writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Invalid user ID")
+		return
+	}
+
+	ciTypeID, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid CI type ID")
+		return
+	}
+
+	var req ci.SchemaMigrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var result interface{}
+	status := http.StatusAccepted
+	if req.DryRun {
+		result, err = h.ciService.DryRunCITypeMigration(r.Context(), ciTypeID, req.Operations)
+		status = http.StatusOK
+	} else {
+		result, err = h.ciService.StartCITypeMigration(r.Context(), ciTypeID, req.Operations, userID)
+	}
+	if err != nil {
+		if err.Error() == "CI type not found" {
+			h.writeError(w, http.StatusNotFound, "CI type not found")
+			return
+		}
+		if err.Error() == "schema migration already in progress" {
+			h.writeError(w, http.StatusConflict, "A schema migration is already in progress for this CI type")
+			return
+		}
+		var schemaErr ci.ServiceValidationError
+		if errors.As(err, &schemaErr) {
+			h.writeValidationError(w, schemaErr)
+			return
+		}
+		var uniqueErr ci.UniqueViolationError
+		if errors.As(err, &uniqueErr) {
+			h.writeUniqueViolation(w, uniqueErr)
+			return
+		}
+		h.logger.ErrorService("ci_type", "MIGRATE_CI_TYPE", err, map[string]interface{}{
+			"ci_type_id": ciTypeID,
+			"dry_run":    req.DryRun,
+			"user_id":    userID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to migrate CI type")
+		return
+	}
+
+	h.writeJSON(w, status, result)
+}
+
+// ListCITypeMigrations godoc
+// @Summary List CI type migrations
+// @Description List the most recent schema migration jobs of a CI type, newest first
+// @Tags ci-types
+// @Produce json
+// @Param id path string true "CI type ID"
+// @Param limit query int false "Maximum number of jobs" default(20)
+// @Success 200 {array} ci.SchemaMigrationJob
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci-types/{id}/migrations [get]
+func (h *CITypeHandlers) ListCITypeMigrations(w http.ResponseWriter, r *http.Request) {
+	ciTypeID, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid CI type ID")
+		return
+	}
+
+	limit := h.getQueryInt(r, "limit", 20)
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	jobs, err := h.ciService.ListCITypeMigrations(r.Context(), ciTypeID, limit)
+	if err != nil {
+		if err.Error() == "CI type not found" {
+			h.writeError(w, http.StatusNotFound, "CI type not found")
+			return
+		}
+		h.logger.ErrorService("ci_type", "LIST_CI_TYPE_MIGRATIONS", err, map[string]interface{}{
+			"ci_type_id": ciTypeID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to list CI type migrations")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, jobs)
+}
+
+// GetCITypeMigration godoc
+// @Summary Get a CI type migration
+// @Description Get the status and progress of a schema migration job
+// @Tags ci-types
+// @Produce json
+// @Param id path string true "CI type ID"
+// @Param job_id path string true "Migration job ID"
+// @Success 200 {object} ci.SchemaMigrationJob
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ci-types/{id}/migrations/{job_id} [get]
+func (h *CITypeHandlers) GetCITypeMigration(w http.ResponseWriter, r *http.Request) {
+	ciTypeID, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid CI type ID")
+		return
+	}
+	jobID, err := h.getUUIDParam(r, "job_id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid migration job ID")
+		return
+	}
+
+	job, err := h.ciService.GetCITypeMigration(r.Context(), ciTypeID, jobID)
+	if err != nil {
+		if err.Error() == "schema migration job not found" {
+			h.writeError(w, http.StatusNotFound, "Schema migration job not found")
+			return
+		}
+		h.logger.ErrorService("ci_type", "GET_CI_TYPE_MIGRATION", err, map[string]interface{}{
+			"ci_type_id": ciTypeID,
+			"job_id":     jobID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to get CI type migration")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, job)
+}
@@ -1,13 +1,20 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/pustaka/pustaka/internal/ci"
 	pustakaLogger "github.com/pustaka/pustaka/pkg/logger"
 )
 
@@ -33,9 +40,161 @@ func (h *Handler) writeError(w http.ResponseWriter, status int, message string)
 	h.writeJSON(w, status, map[string]string{"error": message})
 }
 
+// writeValidationError writes a 400 listing each failed field of a service validation error
+func (h *Handler) writeValidationError(w http.ResponseWriter, err ci.ServiceValidationError) {
+	h.writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+		"error":  err.Message,
+		"errors": err.Errors,
+	})
+}
+
+// writeUniqueViolation writes a 409 naming the CI that already holds a unique value
+func (h *Handler) writeUniqueViolation(w http.ResponseWriter, err ci.UniqueViolationError) {
+	h.writeJSON(w, http.StatusConflict, map[string]interface{}{
+		"error":    err.Error(),
+		"conflict": err,
+	})
+}
+
+// writePagingError answers 400 for a list request with an unusable cursor or
+// count mode, and reports whether it did
+func (h *Handler) writePagingError(w http.ResponseWriter, err error) bool {
+	switch err.Error() {
+	case "invalid cursor":
+		h.writeError(w, http.StatusBadRequest, "Invalid cursor")
+	case "invalid count mode":
+		h.writeError(w, http.StatusBadRequest, "Invalid count mode; must be exact, estimated or none")
+	default:
+		return false
+	}
+	return true
+}
+
+// exportContentTypes maps export formats to the Content-Type they are served with
+var exportContentTypes = map[string]string{
+	ci.ExportFormatCSV:    "text/csv; charset=utf-8",
+	ci.ExportFormatJSON:   "application/json",
+	ci.ExportFormatNDJSON: "application/x-ndjson",
+}
+
+// exportResponse sends the headers of a streamed export with its first write,
+// so an export that fails before writing anything can still answer with an
+// error status. The write deadline is lifted, as large exports outlast it.
+type exportResponse struct {
+	w        http.ResponseWriter
+	format   string
+	fileName string
+	started  bool
+}
+
+func newExportResponse(w http.ResponseWriter, format, name string) *exportResponse {
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	return &exportResponse{w: w, format: format, fileName: name + "." + format}
+}
+
+func (e *exportResponse) Write(p []byte) (int, error) {
+	if !e.started {
+		e.started = true
+		e.w.Header().Set("Content-Type", exportContentTypes[e.format])
+		e.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, e.fileName))
+		e.w.WriteHeader(http.StatusOK)
+	}
+	return e.w.Write(p)
+}
+
+// acceptPatch lists the patch media types PATCH endpoints understand
+var acceptPatch = ci.MergePatchContentType + ", " + ci.JSONPatchContentType
+
+// readPatch returns the media type and body of a PATCH request, or writes a
+// 415 advertising the supported patch formats
+func (h *Handler) readPatch(w http.ResponseWriter, r *http.Request) (string, []byte, bool) {
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (contentType != ci.MergePatchContentType && contentType != ci.JSONPatchContentType) {
+		w.Header().Set("Accept-Patch", acceptPatch)
+		h.writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be "+ci.MergePatchContentType+" or "+ci.JSONPatchContentType)
+		return "", nil, false
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return "", nil, false
+	}
+	return contentType, body, true
+}
+
+// writePatchError writes a 400 for a malformed patch, or a 409 for one that
+// does not fit the current document
+func (h *Handler) writePatchError(w http.ResponseWriter, err ci.PatchError) {
+	status := http.StatusBadRequest
+	if err.Conflict {
+		status = http.StatusConflict
+	}
+	h.writeError(w, status, err.Error())
+}
+
+// etag returns the strong entity tag of a row version
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// writeVersioned writes a 200 carrying the ETag of the entity's version, or a
+// 304 when the client's If-None-Match already names that version
+func (h *Handler) writeVersioned(w http.ResponseWriter, r *http.Request, version int, data interface{}) {
+	tag := etag(version)
+	w.Header().Set("ETag", tag)
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == tag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+	}
+
+	h.writeJSON(w, http.StatusOK, data)
+}
+
+// ifMatchContext returns the request context, carrying the versions named by
+// an If-Match header so the service can refuse changes to any other version.
+// If-Match: * only requires the entity to exist, and a missing header imposes
+// nothing. Weak or unparseable tags never match, as If-Match uses strong
+// comparison.
+func (h *Handler) ifMatchContext(r *http.Request) context.Context {
+	match := strings.TrimSpace(r.Header.Get("If-Match"))
+	if match == "" {
+		return r.Context()
+	}
+	if match == "*" {
+		return ci.WithIfMatchAny(r.Context())
+	}
+
+	versions := []int{}
+	for _, candidate := range strings.Split(match, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if len(candidate) < 2 || candidate[0] != '"' || candidate[len(candidate)-1] != '"' {
+			continue
+		}
+		if version, err := strconv.Atoi(candidate[1 : len(candidate)-1]); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	return ci.WithIfMatch(r.Context(), versions)
+}
+
+// writePreconditionFailed writes a 412 carrying the ETag of the current
+// version, if the entity exists
+func (h *Handler) writePreconditionFailed(w http.ResponseWriter, err ci.PreconditionFailedError) {
+	if err.CurrentVersion > 0 {
+		w.Header().Set("ETag", etag(err.CurrentVersion))
+	}
+	h.writeError(w, http.StatusPreconditionFailed, "Resource has been modified; fetch the current version and retry")
+}
+
 func (h *Handler) getUUIDParam(r *http.Request, param string) (uuid.UUID, error) {
-	vars := mux.Vars(r)
-	idStr := vars[param]
+	idStr := h.getPathParam(r, param)
 
 	// Fallback: try to extract ID from path manually
 	if idStr == "" && param == "id" {
@@ -54,9 +213,16 @@ func (h *Handler) getUUIDParam(r *http.Request, param string) (uuid.UUID, error)
 	return uuid.Parse(idStr)
 }
 
+// getPathParam returns a path parameter from either the gorilla/mux or chi router
+func (h *Handler) getPathParam(r *http.Request, param string) string {
+	if val, ok := mux.Vars(r)[param]; ok {
+		return val
+	}
+	return chi.URLParam(r, param)
+}
+
 func (h *Handler) getIntParam(r *http.Request, param string, defaultValue int) int {
-	vars := mux.Vars(r)
-	if val, ok := vars[param]; ok {
+	if val := h.getPathParam(r, param); val != "" {
 		if intVal, err := strconv.Atoi(val); err == nil {
 			return intVal
 		}
@@ -73,6 +239,15 @@ func (h *Handler) getQueryInt(r *http.Request, param string, defaultValue int) i
 	return defaultValue
 }
 
+func (h *Handler) getQueryBool(r *http.Request, param string, defaultValue bool) bool {
+	if val := r.URL.Query().Get(param); val != "" {
+		if boolVal, err := strconv.ParseBool(val); err == nil {
+			return boolVal
+		}
+	}
+	return defaultValue
+}
+
 func (h *Handler) getQueryString(r *http.Request, param string) string {
 	return r.URL.Query().Get(param)
 }
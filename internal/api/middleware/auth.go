@@ -2,10 +2,12 @@ package middleware
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"strings"
 
 	"github.com/pustaka/pustaka/internal/auth"
+	"github.com/pustaka/pustaka/internal/ci"
 	pustakaLogger "github.com/pustaka/pustaka/pkg/logger"
 )
 
@@ -125,6 +127,19 @@ func AuditLogging(rbacService *auth.RBACService, logger *pustakaLogger.Logger) f
 	}
 }
 
+// RequestMetadata stores the client IP address and user agent in the request
+// context so that services can record them with audit events
+func RequestMetadata(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ci.WithRequestMetadata(r.Context(), ci.RequestMetadata{
+			IPAddress: getRealIP(r),
+			UserAgent: r.UserAgent(),
+		})
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
@@ -211,8 +226,8 @@ func getRealIP(r *http.Request) string {
 	}
 
 	// Fall back to RemoteAddr
-	if idx := strings.LastIndex(r.RemoteAddr, ":"); idx != -1 {
-		return r.RemoteAddr[:idx]
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
 	}
 
 	return r.RemoteAddr
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// AllowContentType answers 415 Unsupported Media Type to requests whose body
+// is not of an allowed media type. The defaults apply to every route; routes
+// adds types keyed by method ("PATCH") or by method and path
+// ("POST /api/v1/ci/imports"). Requests without a body pass.
+func AllowContentType(defaults []string, routes map[string][]string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(defaults))
+	for _, contentType := range defaults {
+		allowed[strings.ToLower(contentType)] = true
+	}
+	extra := make(map[string]map[string]bool, len(routes))
+	for route, contentTypes := range routes {
+		extra[route] = make(map[string]bool, len(contentTypes))
+		for _, contentType := range contentTypes {
+			extra[route][strings.ToLower(contentType)] = true
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err == nil {
+				route := r.Method + " " + path.Clean(r.URL.Path)
+				if allowed[mediaType] || extra[r.Method][mediaType] || extra[route][mediaType] {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+		})
+	}
+}
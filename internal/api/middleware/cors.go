@@ -12,7 +12,7 @@ func CORS(allowedOrigins, allowedMethods, allowedHeaders []string) func(http.Han
 		AllowedOrigins:   allowedOrigins,
 		AllowedMethods:   allowedMethods,
 		AllowedHeaders:   allowedHeaders,
-		ExposedHeaders:   []string{"X-Request-ID", "X-Rate-Limit-Remaining"},
+		ExposedHeaders:   []string{"X-Request-ID", "X-Rate-Limit-Remaining", "ETag"},
 		AllowCredentials: true,
 		MaxAge:           300, // 5 minutes
 	})
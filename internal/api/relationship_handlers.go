@@ -2,7 +2,9 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/pustaka/pustaka/internal/api/middleware"
@@ -77,6 +79,15 @@ func (h *RelationshipHandlers) CreateRelationship(w http.ResponseWriter, r *http
 			h.writeError(w, http.StatusBadRequest, "Cannot create self-referencing relationship")
 			return
 		}
+		if strings.HasPrefix(err.Error(), "cardinality violation") {
+			h.writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		var validationErr ci.ServiceValidationError
+		if errors.As(err, &validationErr) {
+			h.writeValidationError(w, validationErr)
+			return
+		}
 		h.logger.ErrorService("relationship", "CREATE_RELATIONSHIP", err, map[string]interface{}{
 			"request": req,
 			"user_id": userID,
@@ -94,7 +105,9 @@ func (h *RelationshipHandlers) CreateRelationship(w http.ResponseWriter, r *http
 // @Tags relationships
 // @Produce json
 // @Param id path string true "Relationship ID"
+// @Param If-None-Match header string false "ETag of a cached copy"
 // @Success 200 {object} ci.Relationship
+// @Success 304
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 404 {object} map[string]string
@@ -120,7 +133,7 @@ func (h *RelationshipHandlers) GetRelationship(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	h.writeJSON(w, http.StatusOK, relationship)
+	h.writeVersioned(w, r, relationship.Version, relationship)
 }
 
 // ListRelationships godoc
@@ -134,10 +147,46 @@ func (h *RelationshipHandlers) GetRelationship(w http.ResponseWriter, r *http.Re
 // @Param search query string false "Search term for relationships"
 // @Param page query int false "Page number" default(1)
 // @Param limit query int false "Items per page" default(20)
+// @Param cursor query string false "next_cursor or prev_cursor of a previous page; replaces page"
+// @Param count query string false "How to count the total: exact (default for page numbers), estimated or none (default for cursors)" Enums(exact, estimated, none)
 // @Success 200 {object} ci.RelationshipListResponse
+// @Failure 400 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/v1/relationships [get]
 func (h *RelationshipHandlers) ListRelationships(w http.ResponseWriter, r *http.Request) {
+	filters := h.listRelationshipFilters(r)
+	filters.Cursor = h.getQueryString(r, "cursor")
+	filters.Count = h.getQueryString(r, "count")
+
+	page := h.getQueryInt(r, "page", 1)
+	limit := h.getQueryInt(r, "limit", 20)
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	response, err := h.ciService.ListRelationships(r.Context(), filters, page, limit)
+	if err != nil {
+		h.logger.ErrorService("relationship", "LIST_RELATIONSHIPS", err, map[string]interface{}{
+			"filters": filters,
+			"page":    page,
+			"limit":   limit,
+		})
+		if h.writePagingError(w, err) {
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to list relationships")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// listRelationshipFilters reads the relationship list filters from the query string
+func (h *RelationshipHandlers) listRelationshipFilters(r *http.Request) ci.ListRelationshipFilters {
 	filters := ci.ListRelationshipFilters{}
 
 	// Parse optional UUID parameters
@@ -156,28 +205,51 @@ func (h *RelationshipHandlers) ListRelationships(w http.ResponseWriter, r *http.
 	filters.RelationshipType = h.getQueryString(r, "relationship_type")
 	filters.Search = h.getQueryString(r, "search")
 
-	page := h.getQueryInt(r, "page", 1)
-	limit := h.getQueryInt(r, "limit", 20)
+	return filters
+}
 
-	if page < 1 {
-		page = 1
-	}
-	if limit < 1 || limit > 100 {
-		limit = 20
+// ExportRelationships godoc
+// @Summary Export relationships
+// @Description Stream every relationship matching the list filters as CSV, a JSON array or newline-delimited JSON. CSV has a column per attribute of the filtered relationship type, or of every relationship type.
+// @Tags relationships
+// @Produce text/csv
+// @Produce json
+// @Produce application/x-ndjson
+// @Param format query string false "csv (default), json or ndjson"
+// @Param source_id query string false "Filter by source CI ID"
+// @Param target_id query string false "Filter by target CI ID"
+// @Param relationship_type query string false "Filter by relationship type"
+// @Param search query string false "Search term for relationships"
+// @Success 200 {array} ci.Relationship
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/relationships/export [get]
+func (h *RelationshipHandlers) ExportRelationships(w http.ResponseWriter, r *http.Request) {
+	filters := h.listRelationshipFilters(r)
+	format := h.getQueryString(r, "format")
+	if format == "" {
+		format = ci.ExportFormatCSV
 	}
 
-	response, err := h.ciService.ListRelationships(r.Context(), filters, page, limit)
-	if err != nil {
-		h.logger.ErrorService("relationship", "LIST_RELATIONSHIPS", err, map[string]interface{}{
+	out := newExportResponse(w, format, "relationships")
+	if err := h.ciService.ExportRelationships(r.Context(), filters, format, out); err != nil {
+		h.logger.ErrorService("relationship", "EXPORT_RELATIONSHIPS", err, map[string]interface{}{
 			"filters": filters,
-			"page":    page,
-			"limit":   limit,
+			"format":  format,
 		})
-		h.writeError(w, http.StatusInternalServerError, "Failed to list relationships")
-		return
+		// Once streaming has started the status is sent; the output stops short
+		if out.started {
+			return
+		}
+		var validationErr ci.ServiceValidationError
+		if errors.As(err, &validationErr) {
+			h.writeValidationError(w, validationErr)
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to export relationships")
 	}
-
-	h.writeJSON(w, http.StatusOK, response)
 }
 
 // UpdateRelationship godoc
@@ -188,11 +260,13 @@ func (h *RelationshipHandlers) ListRelationships(w http.ResponseWriter, r *http.
 // @Produce json
 // @Param id path string true "Relationship ID"
 // @Param request body ci.UpdateRelationshipRequest true "Relationship updates"
+// @Param If-Match header string false "ETag the relationship must still have"
 // @Success 200 {object} ci.Relationship
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 412 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/v1/relationships/{id} [put]
 func (h *RelationshipHandlers) UpdateRelationship(w http.ResponseWriter, r *http.Request) {
@@ -220,12 +294,22 @@ func (h *RelationshipHandlers) UpdateRelationship(w http.ResponseWriter, r *http
 		return
 	}
 
-	relationship, err := h.ciService.UpdateRelationship(r.Context(), relationshipID, &req, userID)
+	relationship, err := h.ciService.UpdateRelationship(h.ifMatchContext(r), relationshipID, &req, userID)
 	if err != nil {
 		if err.Error() == "relationship not found" {
 			h.writeError(w, http.StatusNotFound, "Relationship not found")
 			return
 		}
+		var preconditionErr ci.PreconditionFailedError
+		if errors.As(err, &preconditionErr) {
+			h.writePreconditionFailed(w, preconditionErr)
+			return
+		}
+		var validationErr ci.ServiceValidationError
+		if errors.As(err, &validationErr) {
+			h.writeValidationError(w, validationErr)
+			return
+		}
 		h.logger.ErrorService("relationship", "UPDATE_RELATIONSHIP", err, map[string]interface{}{
 			"relationship_id": relationshipID,
 			"request":         req,
@@ -235,6 +319,83 @@ func (h *RelationshipHandlers) UpdateRelationship(w http.ResponseWriter, r *http
 		return
 	}
 
+	w.Header().Set("ETag", etag(relationship.Version))
+	h.writeJSON(w, http.StatusOK, relationship)
+}
+
+// PatchRelationship godoc
+// @Summary Patch a relationship
+// @Description Change some attributes of a relationship with a JSON Merge Patch (RFC 7396) or JSON Patch (RFC 6902) applied to {"attributes": {...}}
+// @Tags relationships
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Produce json
+// @Param id path string true "Relationship ID"
+// @Param If-Match header string false "ETag the relationship must still have"
+// @Success 200 {object} ci.Relationship
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 412 {object} map[string]string
+// @Failure 415 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/relationships/{id} [patch]
+func (h *RelationshipHandlers) PatchRelationship(w http.ResponseWriter, r *http.Request) {
+	userIDStr, ok := middleware.GetUserIDFromContext(r)
+	if !ok {
+		h.writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Invalid user ID")
+		return
+	}
+
+	relationshipID, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid relationship ID")
+		return
+	}
+
+	contentType, patch, ok := h.readPatch(w, r)
+	if !ok {
+		return
+	}
+
+	relationship, err := h.ciService.PatchRelationship(h.ifMatchContext(r), relationshipID, contentType, patch, userID)
+	if err != nil {
+		if err.Error() == "relationship not found" {
+			h.writeError(w, http.StatusNotFound, "Relationship not found")
+			return
+		}
+		var preconditionErr ci.PreconditionFailedError
+		if errors.As(err, &preconditionErr) {
+			h.writePreconditionFailed(w, preconditionErr)
+			return
+		}
+		var patchErr ci.PatchError
+		if errors.As(err, &patchErr) {
+			h.writePatchError(w, patchErr)
+			return
+		}
+		var validationErr ci.ServiceValidationError
+		if errors.As(err, &validationErr) {
+			h.writeValidationError(w, validationErr)
+			return
+		}
+		h.logger.ErrorService("relationship", "PATCH_RELATIONSHIP", err, map[string]interface{}{
+			"relationship_id": relationshipID,
+			"content_type":    contentType,
+			"user_id":         userID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to patch relationship")
+		return
+	}
+
+	w.Header().Set("ETag", etag(relationship.Version))
 	h.writeJSON(w, http.StatusOK, relationship)
 }
 
@@ -243,11 +404,13 @@ func (h *RelationshipHandlers) UpdateRelationship(w http.ResponseWriter, r *http
 // @Description Delete a relationship between configuration items
 // @Tags relationships
 // @Param id path string true "Relationship ID"
+// @Param If-Match header string false "ETag the relationship must still have"
 // @Success 204
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 412 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/v1/relationships/{id} [delete]
 func (h *RelationshipHandlers) DeleteRelationship(w http.ResponseWriter, r *http.Request) {
@@ -269,8 +432,17 @@ func (h *RelationshipHandlers) DeleteRelationship(w http.ResponseWriter, r *http
 		return
 	}
 
-	err = h.ciService.DeleteRelationship(r.Context(), relationshipID, userID)
+	err = h.ciService.DeleteRelationship(h.ifMatchContext(r), relationshipID, userID)
 	if err != nil {
+		if err.Error() == "relationship not found" {
+			h.writeError(w, http.StatusNotFound, "Relationship not found")
+			return
+		}
+		var preconditionErr ci.PreconditionFailedError
+		if errors.As(err, &preconditionErr) {
+			h.writePreconditionFailed(w, preconditionErr)
+			return
+		}
 		h.logger.ErrorService("relationship", "DELETE_RELATIONSHIP", err, map[string]interface{}{
 			"relationship_id": relationshipID,
 			"user_id":         userID,
@@ -0,0 +1,265 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pustaka/pustaka/internal/api/middleware"
+	"github.com/pustaka/pustaka/internal/ci"
+)
+
+type RelationshipTypeHandlers struct {
+	*Handler
+	ciService *ci.Service
+}
+
+func NewRelationshipTypeHandlers(handler *Handler, ciService *ci.Service) *RelationshipTypeHandlers {
+	return &RelationshipTypeHandlers{
+		Handler:   handler,
+		ciService: ciService,
+	}
+}
+
+// CreateRelationshipType godoc
+// @Summary Create a relationship type
+// @Description Register a relationship type with its inverse name, allowed CI types, cardinality and attribute schema
+// @Tags relationship-types
+// @Accept json
+// @Produce json
+// @Param request body ci.CreateRelationshipTypeRequest true "Relationship type to create"
+// @Success 201 {object} ci.RelationshipTypeDefinition
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/relationship-types [post]
+func (h *RelationshipTypeHandlers) CreateRelationshipType(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req ci.CreateRelationshipTypeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Name == "" || req.InverseName == "" {
+		h.writeError(w, http.StatusBadRequest, "Name and inverse name are required")
+		return
+	}
+
+	def, err := h.ciService.CreateRelationshipType(r.Context(), &req, userID)
+	if err != nil {
+		if err.Error() == "relationship type already exists" {
+			h.writeError(w, http.StatusConflict, "Relationship type with this name already exists")
+			return
+		}
+		var validationErr ci.ServiceValidationError
+		if errors.As(err, &validationErr) {
+			h.writeValidationError(w, validationErr)
+			return
+		}
+		h.logger.ErrorService("relationship_type", "CREATE_RELATIONSHIP_TYPE", err, map[string]interface{}{
+			"request": req,
+			"user_id": userID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to create relationship type")
+		return
+	}
+
+	h.writeJSON(w, http.StatusCreated, def)
+}
+
+// GetRelationshipType godoc
+// @Summary Get a relationship type
+// @Description Get a relationship type definition by ID
+// @Tags relationship-types
+// @Produce json
+// @Param id path string true "Relationship type ID"
+// @Success 200 {object} ci.RelationshipTypeDefinition
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/relationship-types/{id} [get]
+func (h *RelationshipTypeHandlers) GetRelationshipType(w http.ResponseWriter, r *http.Request) {
+	id, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid relationship type ID")
+		return
+	}
+
+	def, err := h.ciService.GetRelationshipType(r.Context(), id)
+	if err != nil {
+		if err.Error() == "relationship type not found" {
+			h.writeError(w, http.StatusNotFound, "Relationship type not found")
+			return
+		}
+		h.logger.ErrorService("relationship_type", "GET_RELATIONSHIP_TYPE", err, map[string]interface{}{
+			"relationship_type_id": id,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to get relationship type")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, def)
+}
+
+// ListRelationshipTypes godoc
+// @Summary List relationship types
+// @Description List relationship type definitions with pagination and search
+// @Tags relationship-types
+// @Produce json
+// @Param search query string false "Search in name, inverse name and description"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(20)
+// @Success 200 {object} ci.RelationshipTypeListResponse
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/relationship-types [get]
+func (h *RelationshipTypeHandlers) ListRelationshipTypes(w http.ResponseWriter, r *http.Request) {
+	search := h.getQueryString(r, "search")
+	page := h.getQueryInt(r, "page", 1)
+	limit := h.getQueryInt(r, "limit", 20)
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	response, err := h.ciService.ListRelationshipTypes(r.Context(), page, limit, search)
+	if err != nil {
+		h.logger.ErrorService("relationship_type", "LIST_RELATIONSHIP_TYPES", err, map[string]interface{}{
+			"search": search,
+			"page":   page,
+			"limit":  limit,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to list relationship types")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// UpdateRelationshipType godoc
+// @Summary Update a relationship type
+// @Description Update a relationship type definition. The name cannot change.
+// @Tags relationship-types
+// @Accept json
+// @Produce json
+// @Param id path string true "Relationship type ID"
+// @Param request body ci.UpdateRelationshipTypeRequest true "Relationship type updates"
+// @Success 200 {object} ci.RelationshipTypeDefinition
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/relationship-types/{id} [put]
+func (h *RelationshipTypeHandlers) UpdateRelationshipType(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid relationship type ID")
+		return
+	}
+
+	var req ci.UpdateRelationshipTypeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	def, err := h.ciService.UpdateRelationshipType(r.Context(), id, &req, userID)
+	if err != nil {
+		if err.Error() == "relationship type not found" {
+			h.writeError(w, http.StatusNotFound, "Relationship type not found")
+			return
+		}
+		if strings.HasPrefix(err.Error(), "relationship type change conflicts with") {
+			h.writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		var validationErr ci.ServiceValidationError
+		if errors.As(err, &validationErr) {
+			h.writeValidationError(w, validationErr)
+			return
+		}
+		h.logger.ErrorService("relationship_type", "UPDATE_RELATIONSHIP_TYPE", err, map[string]interface{}{
+			"relationship_type_id": id,
+			"request":              req,
+			"user_id":              userID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to update relationship type")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, def)
+}
+
+// DeleteRelationshipType godoc
+// @Summary Delete a relationship type
+// @Description Delete a relationship type definition (only if no relationships of this type exist)
+// @Tags relationship-types
+// @Param id path string true "Relationship type ID"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/relationship-types/{id} [delete]
+func (h *RelationshipTypeHandlers) DeleteRelationshipType(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid relationship type ID")
+		return
+	}
+
+	if err := h.ciService.DeleteRelationshipType(r.Context(), id, userID); err != nil {
+		if err.Error() == "relationship type not found" {
+			h.writeError(w, http.StatusNotFound, "Relationship type not found")
+			return
+		}
+		if err.Error() == "cannot delete relationship type with existing relationships" {
+			h.writeError(w, http.StatusConflict, "Cannot delete relationship type with existing relationships")
+			return
+		}
+		h.logger.ErrorService("relationship_type", "DELETE_RELATIONSHIP_TYPE", err, map[string]interface{}{
+			"relationship_type_id": id,
+			"user_id":              userID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to delete relationship type")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestUserID reads the authenticated user's ID, writing a 401 when it is missing
+func (h *RelationshipTypeHandlers) requestUserID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userIDStr, ok := middleware.GetUserIDFromContext(r)
+	if !ok {
+		h.writeError(w, http.StatusUnauthorized, "User not found in context")
+		return uuid.Nil, false
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Invalid user ID")
+		return uuid.Nil, false
+	}
+	return userID, true
+}
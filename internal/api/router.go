@@ -14,6 +14,9 @@ type Router struct {
 	ciHandlers  *CIHandlers
 	typeHandlers *CITypeHandlers
 	relHandlers *RelationshipHandlers
+	relTypeHandlers *RelationshipTypeHandlers
+	searchHandlers *SearchHandlers
+	savedSearchHandlers *SavedSearchHandlers
 }
 
 func NewRouter(
@@ -27,12 +30,18 @@ func NewRouter(
 	ciHandlers := NewCIHandlers(handler, ciService)
 	typeHandlers := NewCITypeHandlers(handler, ciService)
 	relHandlers := NewRelationshipHandlers(handler, ciService)
+	relTypeHandlers := NewRelationshipTypeHandlers(handler, ciService)
+	searchHandlers := NewSearchHandlers(handler, ciService)
+	savedSearchHandlers := NewSavedSearchHandlers(handler, ciService)
 
 	r := &Router{
 		router:       router,
 		ciHandlers:   ciHandlers,
 		typeHandlers: typeHandlers,
 		relHandlers:  relHandlers,
+		relTypeHandlers: relTypeHandlers,
+		searchHandlers: searchHandlers,
+		savedSearchHandlers: savedSearchHandlers,
 	}
 
 	r.setupRoutes()
@@ -59,9 +68,20 @@ func (r *Router) setupRoutes() {
 	// Configuration Items
 	v1.HandleFunc("/ci", r.ciHandlers.CreateCI).Methods("POST")
 	v1.HandleFunc("/ci", r.ciHandlers.ListCIs).Methods("GET")
+	v1.HandleFunc("/ci/export", r.ciHandlers.ExportCIs).Methods("GET")
+	v1.HandleFunc("/ci/bulk", r.ciHandlers.BulkCIs).Methods("POST")
+	v1.HandleFunc("/ci/by-key", r.ciHandlers.UpsertCI).Methods("PUT")
+	v1.HandleFunc("/ci/imports", r.ciHandlers.StartCIImport).Methods("POST")
+	v1.HandleFunc("/ci/imports", r.ciHandlers.ListCIImports).Methods("GET")
+	v1.HandleFunc("/ci/imports/{id}", r.ciHandlers.GetCIImport).Methods("GET")
+	v1.HandleFunc("/ci/imports/{id}/errors", r.ciHandlers.GetCIImportErrors).Methods("GET")
 	v1.HandleFunc("/ci/{id}", r.ciHandlers.GetCI).Methods("GET")
 	v1.HandleFunc("/ci/{id}", r.ciHandlers.UpdateCI).Methods("PUT")
+	v1.HandleFunc("/ci/{id}", r.ciHandlers.PatchCI).Methods("PATCH")
 	v1.HandleFunc("/ci/{id}", r.ciHandlers.DeleteCI).Methods("DELETE")
+	v1.HandleFunc("/ci/{id}/history", r.ciHandlers.GetCIHistory).Methods("GET")
+	v1.HandleFunc("/ci/{id}/diff", r.ciHandlers.DiffCIVersions).Methods("GET")
+	v1.HandleFunc("/ci/{id}/versions/{version}/restore", r.ciHandlers.RestoreCIVersion).Methods("POST")
 	v1.HandleFunc("/ci/{id}/relationships", r.ciHandlers.GetCIRelationships).Methods("GET")
 	v1.HandleFunc("/ci/{id}/network", r.ciHandlers.GetCINetwork).Methods("GET")
 	v1.HandleFunc("/ci/{id}/impact", r.ciHandlers.GetImpactAnalysis).Methods("GET")
@@ -69,17 +89,34 @@ func (r *Router) setupRoutes() {
 	// CI Types
 	v1.HandleFunc("/ci-types", r.typeHandlers.CreateCIType).Methods("POST")
 	v1.HandleFunc("/ci-types", r.typeHandlers.ListCITypes).Methods("GET")
+	v1.HandleFunc("/ci-types/import", r.typeHandlers.ImportCITypeJSONSchema).Methods("POST")
 	v1.HandleFunc("/ci-types/{id}", r.typeHandlers.GetCIType).Methods("GET")
 	v1.HandleFunc("/ci-types/{id}", r.typeHandlers.UpdateCIType).Methods("PUT")
 	v1.HandleFunc("/ci-types/{id}", r.typeHandlers.DeleteCIType).Methods("DELETE")
+	v1.HandleFunc("/ci-types/{id}/schema.json", r.typeHandlers.GetCITypeJSONSchema).Methods("GET")
+	v1.HandleFunc("/ci-types/{id}/versions", r.typeHandlers.GetCITypeSchemaVersions).Methods("GET")
+	v1.HandleFunc("/ci-types/{id}/versions/{version}", r.typeHandlers.GetCITypeSchemaVersion).Methods("GET")
+	v1.HandleFunc("/ci-types/{id}/dry-run", r.typeHandlers.DryRunCITypeUpdate).Methods("POST")
+	v1.HandleFunc("/ci-types/{id}/migrations", r.typeHandlers.MigrateCIType).Methods("POST")
+	v1.HandleFunc("/ci-types/{id}/migrations", r.typeHandlers.ListCITypeMigrations).Methods("GET")
+	v1.HandleFunc("/ci-types/{id}/migrations/{job_id}", r.typeHandlers.GetCITypeMigration).Methods("GET")
 
 	// Relationships
 	v1.HandleFunc("/relationships", r.relHandlers.CreateRelationship).Methods("POST")
 	v1.HandleFunc("/relationships", r.relHandlers.ListRelationships).Methods("GET")
+	v1.HandleFunc("/relationships/export", r.relHandlers.ExportRelationships).Methods("GET")
 	v1.HandleFunc("/relationships/{id}", r.relHandlers.GetRelationship).Methods("GET")
 	v1.HandleFunc("/relationships/{id}", r.relHandlers.UpdateRelationship).Methods("PUT")
+	v1.HandleFunc("/relationships/{id}", r.relHandlers.PatchRelationship).Methods("PATCH")
 	v1.HandleFunc("/relationships/{id}", r.relHandlers.DeleteRelationship).Methods("DELETE")
 
+	// Relationship Types
+	v1.HandleFunc("/relationship-types", r.relTypeHandlers.CreateRelationshipType).Methods("POST")
+	v1.HandleFunc("/relationship-types", r.relTypeHandlers.ListRelationshipTypes).Methods("GET")
+	v1.HandleFunc("/relationship-types/{id}", r.relTypeHandlers.GetRelationshipType).Methods("GET")
+	v1.HandleFunc("/relationship-types/{id}", r.relTypeHandlers.UpdateRelationshipType).Methods("PUT")
+	v1.HandleFunc("/relationship-types/{id}", r.relTypeHandlers.DeleteRelationshipType).Methods("DELETE")
+
 	// Graph operations
 	graph := v1.PathPrefix("/graph").Subrouter()
 
@@ -96,6 +133,17 @@ func (r *Router) setupRoutes() {
 	analytics.HandleFunc("/most-connected", r.relHandlers.GetMostConnectedCIs).Methods("GET")
 	analytics.HandleFunc("/ci-types/usage", r.typeHandlers.GetCITypesByUsage).Methods("GET")
 
+	// Search
+	v1.HandleFunc("/search", r.searchHandlers.Search).Methods("GET")
+
+	// Saved searches
+	v1.HandleFunc("/saved-searches", r.savedSearchHandlers.CreateSavedSearch).Methods("POST")
+	v1.HandleFunc("/saved-searches", r.savedSearchHandlers.ListSavedSearches).Methods("GET")
+	v1.HandleFunc("/saved-searches/{id}", r.savedSearchHandlers.GetSavedSearch).Methods("GET")
+	v1.HandleFunc("/saved-searches/{id}", r.savedSearchHandlers.UpdateSavedSearch).Methods("PUT")
+	v1.HandleFunc("/saved-searches/{id}", r.savedSearchHandlers.DeleteSavedSearch).Methods("DELETE")
+	v1.HandleFunc("/saved-searches/{id}/results", r.savedSearchHandlers.RunSavedSearch).Methods("GET")
+
 	// Dashboard
 	v1.HandleFunc("/dashboard/stats", r.ciHandlers.GetDashboardStats).Methods("GET")
 }
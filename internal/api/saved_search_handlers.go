@@ -0,0 +1,348 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/pustaka/pustaka/internal/api/middleware"
+	"github.com/pustaka/pustaka/internal/ci"
+)
+
+type SavedSearchHandlers struct {
+	*Handler
+	ciService *ci.Service
+}
+
+func NewSavedSearchHandlers(handler *Handler, ciService *ci.Service) *SavedSearchHandlers {
+	return &SavedSearchHandlers{
+		Handler:   handler,
+		ciService: ciService,
+	}
+}
+
+// CreateSavedSearch godoc
+// @Summary Create a saved search
+// @Description Save a named CI listing (ci_filters, with the columns its view shows) or graph query (graph_filters), optionally shared with everyone holding a role
+// @Tags saved-searches
+// @Accept json
+// @Produce json
+// @Param request body ci.CreateSavedSearchRequest true "Saved search to create"
+// @Success 201 {object} ci.SavedSearch
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/saved-searches [post]
+func (h *SavedSearchHandlers) CreateSavedSearch(w http.ResponseWriter, r *http.Request) {
+	viewer, ok := h.requestViewer(w, r)
+	if !ok {
+		return
+	}
+
+	var req ci.CreateSavedSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	search, err := h.ciService.CreateSavedSearch(r.Context(), &req, viewer.UserID)
+	if err != nil {
+		if h.writeSavedSearchError(w, err) {
+			return
+		}
+		h.logger.ErrorService("saved_search", "CREATE_SAVED_SEARCH", err, map[string]interface{}{
+			"request": req,
+			"user_id": viewer.UserID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to create saved search")
+		return
+	}
+
+	h.writeJSON(w, http.StatusCreated, search)
+}
+
+// GetSavedSearch godoc
+// @Summary Get a saved search
+// @Description Get a saved search of the user's own or shared with one of their roles
+// @Tags saved-searches
+// @Produce json
+// @Param id path string true "Saved search ID"
+// @Success 200 {object} ci.SavedSearch
+// @Success 304 "Not modified"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/saved-searches/{id} [get]
+func (h *SavedSearchHandlers) GetSavedSearch(w http.ResponseWriter, r *http.Request) {
+	viewer, ok := h.requestViewer(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid saved search ID")
+		return
+	}
+
+	search, err := h.ciService.GetSavedSearch(r.Context(), id, viewer)
+	if err != nil {
+		if h.writeSavedSearchError(w, err) {
+			return
+		}
+		h.logger.ErrorService("saved_search", "GET_SAVED_SEARCH", err, map[string]interface{}{
+			"saved_search_id": id,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to get saved search")
+		return
+	}
+
+	h.writeVersioned(w, r, search.Version, search)
+}
+
+// ListSavedSearches godoc
+// @Summary List saved searches
+// @Description List the user's saved searches and those shared with their roles, by name
+// @Tags saved-searches
+// @Produce json
+// @Param kind query string false "Only saved searches of this kind" Enums(ci, graph)
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(20)
+// @Success 200 {object} ci.SavedSearchListResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/saved-searches [get]
+func (h *SavedSearchHandlers) ListSavedSearches(w http.ResponseWriter, r *http.Request) {
+	viewer, ok := h.requestViewer(w, r)
+	if !ok {
+		return
+	}
+
+	kind := h.getQueryString(r, "kind")
+	page := h.getQueryInt(r, "page", 1)
+	limit := h.getQueryInt(r, "limit", 20)
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	response, err := h.ciService.ListSavedSearches(r.Context(), viewer, kind, page, limit)
+	if err != nil {
+		if h.writeSavedSearchError(w, err) {
+			return
+		}
+		h.logger.ErrorService("saved_search", "LIST_SAVED_SEARCHES", err, map[string]interface{}{
+			"kind":  kind,
+			"page":  page,
+			"limit": limit,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to list saved searches")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// UpdateSavedSearch godoc
+// @Summary Update a saved search
+// @Description Update a saved search of the user's own. The kind cannot change; an empty shared_with_role stops sharing.
+// @Tags saved-searches
+// @Accept json
+// @Produce json
+// @Param id path string true "Saved search ID"
+// @Param If-Match header string false "ETag of the version the update applies to"
+// @Param request body ci.UpdateSavedSearchRequest true "Saved search updates"
+// @Success 200 {object} ci.SavedSearch
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 412 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/saved-searches/{id} [put]
+func (h *SavedSearchHandlers) UpdateSavedSearch(w http.ResponseWriter, r *http.Request) {
+	viewer, ok := h.requestViewer(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid saved search ID")
+		return
+	}
+
+	var req ci.UpdateSavedSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	search, err := h.ciService.UpdateSavedSearch(h.ifMatchContext(r), id, &req, viewer)
+	if err != nil {
+		if h.writeSavedSearchError(w, err) {
+			return
+		}
+		h.logger.ErrorService("saved_search", "UPDATE_SAVED_SEARCH", err, map[string]interface{}{
+			"saved_search_id": id,
+			"request":         req,
+			"user_id":         viewer.UserID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to update saved search")
+		return
+	}
+
+	w.Header().Set("ETag", etag(search.Version))
+	h.writeJSON(w, http.StatusOK, search)
+}
+
+// DeleteSavedSearch godoc
+// @Summary Delete a saved search
+// @Description Delete a saved search of the user's own
+// @Tags saved-searches
+// @Param id path string true "Saved search ID"
+// @Param If-Match header string false "ETag of the version the delete applies to"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 412 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/saved-searches/{id} [delete]
+func (h *SavedSearchHandlers) DeleteSavedSearch(w http.ResponseWriter, r *http.Request) {
+	viewer, ok := h.requestViewer(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid saved search ID")
+		return
+	}
+
+	if err := h.ciService.DeleteSavedSearch(h.ifMatchContext(r), id, viewer); err != nil {
+		if h.writeSavedSearchError(w, err) {
+			return
+		}
+		h.logger.ErrorService("saved_search", "DELETE_SAVED_SEARCH", err, map[string]interface{}{
+			"saved_search_id": id,
+			"user_id":         viewer.UserID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to delete saved search")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RunSavedSearch godoc
+// @Summary Run a saved search
+// @Description Run a saved search in one call, returning it with the CI list page (cis) or graph data (graph) it finds. Paging parameters apply to CI searches.
+// @Tags saved-searches
+// @Produce json
+// @Param id path string true "Saved search ID"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(20)
+// @Param cursor query string false "next_cursor or prev_cursor of a previous page"
+// @Param count query string false "How to count the total" Enums(exact, estimated, none)
+// @Success 200 {object} ci.SavedSearchResults
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/saved-searches/{id}/results [get]
+func (h *SavedSearchHandlers) RunSavedSearch(w http.ResponseWriter, r *http.Request) {
+	viewer, ok := h.requestViewer(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := h.getUUIDParam(r, "id")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid saved search ID")
+		return
+	}
+
+	page := h.getQueryInt(r, "page", 1)
+	limit := h.getQueryInt(r, "limit", 20)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	results, err := h.ciService.RunSavedSearch(r.Context(), id, viewer, page, limit, h.getQueryString(r, "cursor"), h.getQueryString(r, "count"))
+	if err != nil {
+		if h.writePagingError(w, err) || h.writeSavedSearchError(w, err) {
+			return
+		}
+		h.logger.ErrorService("saved_search", "RUN_SAVED_SEARCH", err, map[string]interface{}{
+			"saved_search_id": id,
+			"user_id":         viewer.UserID,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to run saved search")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, results)
+}
+
+// writeSavedSearchError writes the response for a saved search error the
+// client can act on, reporting whether it did
+func (h *SavedSearchHandlers) writeSavedSearchError(w http.ResponseWriter, err error) bool {
+	switch err.Error() {
+	case "saved search not found":
+		h.writeError(w, http.StatusNotFound, "Saved search not found")
+		return true
+	case "saved search is owned by another user":
+		h.writeError(w, http.StatusForbidden, "Only the owner can change a saved search")
+		return true
+	case "saved search already exists":
+		h.writeError(w, http.StatusConflict, "Saved search with this name already exists")
+		return true
+	}
+
+	var validationErr ci.ServiceValidationError
+	if errors.As(err, &validationErr) {
+		h.writeValidationError(w, validationErr)
+		return true
+	}
+	var preconditionErr ci.PreconditionFailedError
+	if errors.As(err, &preconditionErr) {
+		h.writePreconditionFailed(w, preconditionErr)
+		return true
+	}
+	return false
+}
+
+// requestViewer reads the authenticated user's ID and roles, writing a 401
+// when the ID is missing
+func (h *SavedSearchHandlers) requestViewer(w http.ResponseWriter, r *http.Request) (ci.SavedSearchViewer, bool) {
+	userIDStr, ok := middleware.GetUserIDFromContext(r)
+	if !ok {
+		h.writeError(w, http.StatusUnauthorized, "User not found in context")
+		return ci.SavedSearchViewer{}, false
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Invalid user ID")
+		return ci.SavedSearchViewer{}, false
+	}
+
+	viewer := ci.SavedSearchViewer{UserID: userID}
+	if user, ok := middleware.GetUserFromContext(r); ok {
+		viewer.Roles = user.Roles
+	}
+	return viewer, true
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/pustaka/pustaka/internal/api/middleware"
+	"github.com/pustaka/pustaka/internal/ci"
+)
+
+// searchKindPermissions is the permission needed to see results of each kind
+var searchKindPermissions = map[string]string{
+	ci.SearchKindCI:           "ci:read",
+	ci.SearchKindCIType:       "ci_type:read",
+	ci.SearchKindRelationship: "relationship:read",
+}
+
+type SearchHandlers struct {
+	*Handler
+	ciService *ci.Service
+}
+
+func NewSearchHandlers(handler *Handler, ciService *ci.Service) *SearchHandlers {
+	return &SearchHandlers{
+		Handler:   handler,
+		ciService: ciService,
+	}
+}
+
+// Search godoc
+// @Summary Search CIs, CI types and relationships
+// @Description Full-text search over CI names, tags and string attribute values, CI type names and descriptions, and relationship types and string attribute values. Every word matches as a prefix, for typeahead. Results are ranked across kinds, names weighing most, and highlight matches with <mark>. Only kinds the user may read are searched.
+// @Tags search
+// @Produce json
+// @Param q query string true "Words to search for"
+// @Param kinds query []string false "Kinds of results: ci, ci_type, relationship (default: all the user may read)"
+// @Param limit query int false "Maximum number of results" default(20)
+// @Success 200 {object} ci.SearchResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/search [get]
+func (h *SearchHandlers) Search(w http.ResponseWriter, r *http.Request) {
+	req := ci.SearchRequest{
+		Query: h.getQueryString(r, "q"),
+		Limit: h.getQueryInt(r, "limit", ci.DefaultSearchLimit),
+	}
+	for _, kinds := range h.getQueryStrings(r, "kinds") {
+		for _, kind := range strings.Split(kinds, ",") {
+			if kind = strings.TrimSpace(kind); kind != "" {
+				req.Kinds = append(req.Kinds, kind)
+			}
+		}
+	}
+
+	// Keep to the kinds the user may read: all of them when none are asked for
+	if user, ok := middleware.GetUserFromContext(r); ok {
+		permitted := func(kind string) bool {
+			permission, known := searchKindPermissions[kind]
+			return !known || containsString(user.Permissions, permission)
+		}
+		if len(req.Kinds) == 0 {
+			for _, kind := range ci.SearchKinds {
+				if permitted(kind) {
+					req.Kinds = append(req.Kinds, kind)
+				}
+			}
+			if len(req.Kinds) == 0 {
+				h.writeError(w, http.StatusForbidden, "Insufficient permissions")
+				return
+			}
+		}
+		for _, kind := range req.Kinds {
+			if !permitted(kind) {
+				h.writeError(w, http.StatusForbidden, "Insufficient permissions")
+				return
+			}
+		}
+	}
+
+	response, err := h.ciService.Search(r.Context(), req)
+	if err != nil {
+		var validationErr ci.ServiceValidationError
+		if errors.As(err, &validationErr) {
+			h.writeValidationError(w, validationErr)
+			return
+		}
+		h.logger.ErrorService("search", "SEARCH", err, map[string]interface{}{
+			"query": req.Query,
+			"kinds": req.Kinds,
+		})
+		h.writeError(w, http.StatusInternalServerError, "Failed to search")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
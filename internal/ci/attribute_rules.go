@@ -0,0 +1,324 @@
+package ci
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// AttributeCondition matches a CI whose attribute equals Equals, or one of In
+type AttributeCondition struct {
+	Attribute string        `json:"attribute"`
+	Equals    interface{}   `json:"equals,omitempty"`
+	In        []interface{} `json:"in,omitempty"`
+}
+
+// matches reports whether the condition holds for a CI's attributes
+func (c *AttributeCondition) matches(attributes map[string]interface{}) bool {
+	value, exists := attributes[c.Attribute]
+	if !exists || value == nil {
+		return false
+	}
+	if c.Equals != nil {
+		return reflect.DeepEqual(value, c.Equals)
+	}
+	for _, candidate := range c.In {
+		if reflect.DeepEqual(value, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *AttributeCondition) String() string {
+	if c.Equals != nil {
+		return fmt.Sprintf("%s is %v", c.Attribute, c.Equals)
+	}
+	return fmt.Sprintf("%s is one of %v", c.Attribute, c.In)
+}
+
+// computedExpression is a parsed computed attribute expression: attribute
+// names and double-quoted string literals joined with +, for example
+// hostname + "." + domain
+type computedExpression struct {
+	terms []expressionTerm
+}
+
+// expressionTerm is either an attribute reference or a literal
+type expressionTerm struct {
+	attribute string
+	literal   string
+}
+
+func parseComputedExpression(expression string) (*computedExpression, error) {
+	expr := &computedExpression{}
+	input := []rune(expression)
+	pos := 0
+
+	skipSpace := func() {
+		for pos < len(input) && unicode.IsSpace(input[pos]) {
+			pos++
+		}
+	}
+
+	for {
+		skipSpace()
+		if pos >= len(input) {
+			return nil, fmt.Errorf("expected an attribute name or string literal at the end of the expression")
+		}
+
+		switch {
+		case input[pos] == '"':
+			var literal strings.Builder
+			pos++
+			closed := false
+			for pos < len(input) {
+				if input[pos] == '\\' && pos+1 < len(input) {
+					literal.WriteRune(input[pos+1])
+					pos += 2
+					continue
+				}
+				if input[pos] == '"' {
+					closed = true
+					pos++
+					break
+				}
+				literal.WriteRune(input[pos])
+				pos++
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated string literal")
+			}
+			expr.terms = append(expr.terms, expressionTerm{literal: literal.String()})
+
+		case input[pos] == '_' || unicode.IsLetter(input[pos]):
+			start := pos
+			for pos < len(input) && (input[pos] == '_' || unicode.IsLetter(input[pos]) || unicode.IsDigit(input[pos])) {
+				pos++
+			}
+			expr.terms = append(expr.terms, expressionTerm{attribute: string(input[start:pos])})
+
+		default:
+			return nil, fmt.Errorf("unexpected %q at position %d", input[pos], pos+1)
+		}
+
+		skipSpace()
+		if pos >= len(input) {
+			return expr, nil
+		}
+		if input[pos] != '+' {
+			return nil, fmt.Errorf("expected + at position %d", pos+1)
+		}
+		pos++
+	}
+}
+
+// attributes returns the attribute names the expression reads
+func (e *computedExpression) attributes() []string {
+	var names []string
+	for _, term := range e.terms {
+		if term.attribute != "" {
+			names = append(names, term.attribute)
+		}
+	}
+	return names
+}
+
+// evaluate joins the terms into a string. It returns nil when an attribute
+// the expression reads is missing or is not a string, number or boolean.
+func (e *computedExpression) evaluate(attributes map[string]interface{}) interface{} {
+	var result strings.Builder
+	for _, term := range e.terms {
+		if term.attribute == "" {
+			result.WriteString(term.literal)
+			continue
+		}
+		switch v := attributes[term.attribute].(type) {
+		case string:
+			result.WriteString(v)
+		case float64:
+			result.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			result.WriteString(strconv.FormatBool(v))
+		default:
+			return nil
+		}
+	}
+	return result.String()
+}
+
+// ApplyDefaults fills in the default of every attribute missing from a new CI
+func (ciType *CITypeDefinition) ApplyDefaults(attributes map[string]interface{}) {
+	for _, attrs := range [][]AttributeDefinition{ciType.RequiredAttributes, ciType.OptionalAttributes} {
+		for _, attr := range attrs {
+			if attr.Default == nil {
+				continue
+			}
+			if value, exists := attributes[attr.Name]; !exists || value == nil {
+				attributes[attr.Name] = attr.Default
+			}
+		}
+	}
+}
+
+// computeAttributes sets every computed attribute from its expression, or
+// removes it when its inputs are missing. Expressions that do not parse were
+// refused when the schema was saved and are skipped.
+func (ciType *CITypeDefinition) computeAttributes(attributes map[string]interface{}) {
+	for _, attrs := range [][]AttributeDefinition{ciType.RequiredAttributes, ciType.OptionalAttributes} {
+		for _, attr := range attrs {
+			if attr.Computed == "" {
+				continue
+			}
+			expr, err := parseComputedExpression(attr.Computed)
+			if err != nil {
+				continue
+			}
+			if value := expr.evaluate(attributes); value != nil {
+				attributes[attr.Name] = value
+			} else {
+				delete(attributes, attr.Name)
+			}
+		}
+	}
+}
+
+// validateAttributeRules checks the default, computed, required_if and unique
+// settings of each attribute on its own. References to other attributes are
+// checked by checkAttributeDependencies once the whole schema is known.
+func validateAttributeRules(section string, attrs []AttributeDefinition) []ValidationError {
+	var errors []ValidationError
+
+	for i, attr := range attrs {
+		field := fmt.Sprintf("%s[%d]", section, i)
+
+		if attr.Default != nil {
+			check := &CITypeDefinition{OptionalAttributes: []AttributeDefinition{{
+				Name:       attr.Name,
+				Type:       attr.Type,
+				Validation: attr.Validation,
+			}}}
+			for _, err := range check.ValidateAttributes(map[string]interface{}{attr.Name: attr.Default}) {
+				errors = append(errors, ValidationError{
+					Field:   field + ".default",
+					Message: fmt.Sprintf("default for attribute '%s' %s", attr.Name, err.Message),
+				})
+			}
+		}
+
+		if attr.Computed != "" {
+			if _, err := parseComputedExpression(attr.Computed); err != nil {
+				errors = append(errors, ValidationError{
+					Field:   field + ".computed",
+					Message: fmt.Sprintf("invalid expression for attribute '%s': %v", attr.Name, err),
+				})
+			}
+			if !isComputableType(attr.Type) {
+				errors = append(errors, ValidationError{
+					Field:   field + ".computed",
+					Message: fmt.Sprintf("computed attribute '%s' must be of a string type", attr.Name),
+				})
+			}
+			if attr.Default != nil || attr.RequiredIf != nil {
+				errors = append(errors, ValidationError{
+					Field:   field + ".computed",
+					Message: fmt.Sprintf("computed attribute '%s' cannot have a default or required_if", attr.Name),
+				})
+			}
+		}
+
+		switch attr.Unique {
+		case "", UniqueScopeType, UniqueScopeGlobal:
+			if attr.Unique != "" && (attr.Type == "array" || attr.Type == "object") {
+				errors = append(errors, ValidationError{
+					Field:   field + ".unique",
+					Message: fmt.Sprintf("%s attribute '%s' cannot be unique", attr.Type, attr.Name),
+				})
+			}
+		default:
+			errors = append(errors, ValidationError{
+				Field:   field + ".unique",
+				Message: fmt.Sprintf("unique for attribute '%s' must be %s or %s", attr.Name, UniqueScopeType, UniqueScopeGlobal),
+			})
+		}
+
+		if attr.RequiredIf != nil {
+			if section == "required_attributes" {
+				errors = append(errors, ValidationError{
+					Field:   field + ".required_if",
+					Message: fmt.Sprintf("attribute '%s' is always required; required_if only applies to optional attributes", attr.Name),
+				})
+			}
+			if attr.RequiredIf.Attribute == "" {
+				errors = append(errors, ValidationError{
+					Field:   field + ".required_if.attribute",
+					Message: "attribute is required",
+				})
+			}
+			if (attr.RequiredIf.Equals == nil) == (len(attr.RequiredIf.In) == 0) {
+				errors = append(errors, ValidationError{
+					Field:   field + ".required_if",
+					Message: "exactly one of equals or in is required",
+				})
+			}
+		}
+	}
+
+	return errors
+}
+
+// isComputableType reports whether a computed expression, which always yields
+// a string, can produce values of the attribute type
+func isComputableType(attrType string) bool {
+	if attrType == "string" {
+		return true
+	}
+	_, stringBacked := stringAttributeTypes[attrType]
+	return stringBacked && attrType != "reference"
+}
+
+// checkAttributeDependencies checks that computed expressions and required_if
+// conditions only refer to other, non-computed attributes of the schema
+func (ciType *CITypeDefinition) checkAttributeDependencies() []ValidationError {
+	var errors []ValidationError
+
+	checkReference := func(attr AttributeDefinition, field, name string) {
+		target := ciType.findAttribute(name)
+		switch {
+		case name == attr.Name:
+			errors = append(errors, ValidationError{
+				Field:   field,
+				Message: fmt.Sprintf("attribute '%s' cannot depend on itself", attr.Name),
+			})
+		case target == nil:
+			errors = append(errors, ValidationError{
+				Field:   field,
+				Message: fmt.Sprintf("attribute '%s' depends on undefined attribute '%s'", attr.Name, name),
+			})
+		case target.Computed != "":
+			errors = append(errors, ValidationError{
+				Field:   field,
+				Message: fmt.Sprintf("attribute '%s' cannot depend on computed attribute '%s'", attr.Name, name),
+			})
+		}
+	}
+
+	for _, attrs := range [][]AttributeDefinition{ciType.RequiredAttributes, ciType.OptionalAttributes} {
+		for _, attr := range attrs {
+			if attr.Computed != "" {
+				if expr, err := parseComputedExpression(attr.Computed); err == nil {
+					for _, name := range expr.attributes() {
+						checkReference(attr, attr.Name+".computed", name)
+					}
+				}
+			}
+			if attr.RequiredIf != nil && attr.RequiredIf.Attribute != "" {
+				checkReference(attr, attr.Name+".required_if", attr.RequiredIf.Attribute)
+			}
+		}
+	}
+
+	return errors
+}
@@ -0,0 +1,177 @@
+package ci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseComputedExpression(t *testing.T) {
+	expr, err := parseComputedExpression(`hostname + "." + domain`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hostname", "domain"}, expr.attributes())
+
+	assert.Equal(t, "web-01.example.com", expr.evaluate(map[string]interface{}{
+		"hostname": "web-01",
+		"domain":   "example.com",
+	}))
+	assert.Nil(t, expr.evaluate(map[string]interface{}{"hostname": "web-01"}))
+	assert.Nil(t, expr.evaluate(map[string]interface{}{"hostname": "web-01", "domain": []interface{}{"a"}}))
+
+	expr, err = parseComputedExpression(`"rack-" + rack + "-u" + unit + "\"x\""`)
+	require.NoError(t, err)
+	assert.Equal(t, `rack-7-u12.5"x"`, expr.evaluate(map[string]interface{}{
+		"rack": float64(7),
+		"unit": 12.5,
+	}))
+
+	for _, invalid := range []string{``, `hostname +`, `hostname domain`, `"open`, `hostname - domain`, `+ domain`} {
+		_, err := parseComputedExpression(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func rulesTestType() *CITypeDefinition {
+	return &CITypeDefinition{
+		Name: "Server",
+		RequiredAttributes: []AttributeDefinition{
+			{Name: "hostname", Type: "hostname"},
+			{Name: "deployment", Type: "string", Default: "virtual", Validation: &AttributeValidation{Enum: []string{"physical", "virtual"}}},
+		},
+		OptionalAttributes: []AttributeDefinition{
+			{Name: "domain", Type: "string"},
+			{Name: "fqdn", Type: "fqdn", Computed: `hostname + "." + domain`},
+			{Name: "rack_location", Type: "string", RequiredIf: &AttributeCondition{Attribute: "deployment", Equals: "physical"}},
+			{Name: "tier", Type: "integer", Default: float64(3)},
+		},
+	}
+}
+
+func TestApplyDefaultsAndComputeAttributes(t *testing.T) {
+	ciType := rulesTestType()
+
+	attributes := map[string]interface{}{
+		"hostname": "web-01",
+		"domain":   "example.com",
+		"tier":     float64(1),
+	}
+	ciType.ApplyDefaults(attributes)
+	ciType.NormalizeAttributes(attributes)
+
+	assert.Equal(t, map[string]interface{}{
+		"hostname":   "web-01",
+		"domain":     "example.com",
+		"deployment": "virtual",
+		"tier":       float64(1),
+		"fqdn":       "web-01.example.com",
+	}, attributes)
+	assert.Empty(t, ciType.ValidateAttributes(attributes))
+
+	// Losing an input removes the computed attribute
+	delete(attributes, "domain")
+	ciType.NormalizeAttributes(attributes)
+	assert.NotContains(t, attributes, "fqdn")
+}
+
+func TestValidateAttributeRulesAtValidation(t *testing.T) {
+	ciType := rulesTestType()
+
+	errors := ciType.ValidateAttributes(map[string]interface{}{
+		"hostname":   "db-01",
+		"deployment": "physical",
+	})
+	require.Len(t, errors, 1)
+	assert.Equal(t, "rack_location", errors[0].Field)
+	assert.Equal(t, "required when deployment is physical", errors[0].Message)
+
+	errors = ciType.ValidateAttributes(map[string]interface{}{
+		"hostname":   "db-01",
+		"deployment": "virtual",
+		"domain":     "example.com",
+		"fqdn":       "other.example.com",
+	})
+	require.Len(t, errors, 1)
+	assert.Equal(t, "fqdn", errors[0].Field)
+
+	in := &AttributeCondition{Attribute: "deployment", In: []interface{}{"physical", "colo"}}
+	assert.True(t, in.matches(map[string]interface{}{"deployment": "colo"}))
+	assert.False(t, in.matches(map[string]interface{}{"deployment": "virtual"}))
+	assert.False(t, in.matches(map[string]interface{}{}))
+}
+
+func TestValidateAttributeRules(t *testing.T) {
+	assert.Empty(t, validateAttributeRules("required_attributes", rulesTestType().RequiredAttributes))
+	assert.Empty(t, validateAttributeRules("optional_attributes", rulesTestType().OptionalAttributes))
+
+	errors := validateAttributeRules("required_attributes", []AttributeDefinition{
+		{Name: "tier", Type: "integer", Default: "high"},
+		{Name: "label", Type: "integer", Computed: `name + "-"`},
+		{Name: "note", Type: "string", Computed: `name +`},
+		{Name: "rack", Type: "string", RequiredIf: &AttributeCondition{Attribute: "deployment"}},
+	})
+
+	fields := make([]string, 0, len(errors))
+	for _, err := range errors {
+		fields = append(fields, err.Field)
+	}
+	assert.Equal(t, []string{
+		"required_attributes[0].default",
+		"required_attributes[1].computed",
+		"required_attributes[2].computed",
+		"required_attributes[3].required_if",
+		"required_attributes[3].required_if",
+	}, fields)
+}
+
+func TestCheckAttributeDependencies(t *testing.T) {
+	assert.Empty(t, rulesTestType().checkAttributeDependencies())
+
+	ciType := &CITypeDefinition{
+		Name: "Server",
+		OptionalAttributes: []AttributeDefinition{
+			{Name: "fqdn", Type: "string", Computed: `hostname + "." + domain`},
+			{Name: "url", Type: "string", Computed: `"https://" + fqdn`},
+			{Name: "rack", Type: "string", RequiredIf: &AttributeCondition{Attribute: "rack", Equals: "x"}},
+			{Name: "domain", Type: "string"},
+		},
+	}
+
+	errors := ciType.checkAttributeDependencies()
+	require.Len(t, errors, 3)
+	assert.Equal(t, "fqdn.computed", errors[0].Field)
+	assert.Contains(t, errors[0].Message, "undefined attribute 'hostname'")
+	assert.Equal(t, "url.computed", errors[1].Field)
+	assert.Contains(t, errors[1].Message, "computed attribute 'fqdn'")
+	assert.Equal(t, "rack.required_if", errors[2].Field)
+}
+
+func TestUniqueAttributes(t *testing.T) {
+	ciType := &CITypeDefinition{
+		Name: "Server",
+		RequiredAttributes: []AttributeDefinition{
+			{Name: "hostname", Type: "hostname", Unique: UniqueScopeType},
+		},
+		OptionalAttributes: []AttributeDefinition{
+			{Name: "serial_number", Type: "string", Unique: UniqueScopeGlobal},
+			{Name: "domain", Type: "string"},
+		},
+	}
+
+	assert.Equal(t, []uniqueConstraint{
+		{Attribute: "hostname", Scope: "Server"},
+		{Attribute: "serial_number", Scope: globalUniqueScope},
+	}, ciType.uniqueConstraints())
+	assert.Empty(t, validateAttributeRules("optional_attributes", ciType.OptionalAttributes))
+
+	errors := validateAttributeRules("optional_attributes", []AttributeDefinition{
+		{Name: "ips", Type: "array", Unique: UniqueScopeType},
+		{Name: "serial", Type: "string", Unique: "tenant"},
+	})
+	require.Len(t, errors, 2)
+	assert.Equal(t, "optional_attributes[0].unique", errors[0].Field)
+	assert.Equal(t, "optional_attributes[1].unique", errors[1].Field)
+
+	violation := UniqueViolationError{Attribute: "serial_number", Value: "SN-1", CIName: "web-01", CIType: "Server"}
+	assert.Equal(t, "attribute 'serial_number' value 'SN-1' is already used by CI 'web-01' (Server)", violation.Error())
+}
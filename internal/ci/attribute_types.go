@@ -0,0 +1,224 @@
+package ci
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// attributeTypes lists the attribute types understood by ValidateAttributes
+var attributeTypes = map[string]bool{
+	"string":      true,
+	"integer":     true,
+	"number":      true,
+	"boolean":     true,
+	"array":       true,
+	"object":      true,
+	"date":        true,
+	"datetime":    true,
+	"ipv4":        true,
+	"ipv6":        true,
+	"cidr":        true,
+	"mac_address": true,
+	"semver":      true,
+	"duration":    true,
+	"hostname":    true,
+	"fqdn":        true,
+	"reference":   true,
+}
+
+// stringAttributeType is an attribute type carried as a JSON string
+type stringAttributeType struct {
+	valid   func(string) bool
+	message string
+}
+
+var stringAttributeTypes = map[string]stringAttributeType{
+	"date":        {isValidDate, "must be a valid date (YYYY-MM-DD)"},
+	"datetime":    {isValidDateTime, "must be a valid datetime (ISO 8601)"},
+	"ipv4":        {isValidIPv4, "must be a valid IPv4 address"},
+	"ipv6":        {isValidIPv6, "must be a valid IPv6 address"},
+	"cidr":        {isValidCIDR, "must be a valid CIDR block (e.g. 10.0.0.0/8)"},
+	"mac_address": {isValidMACAddress, "must be a valid MAC address"},
+	"semver":      {isValidSemver, "must be a valid semantic version (e.g. 1.4.2)"},
+	"duration":    {isValidDuration, "must be a valid duration (e.g. 90s, 1h30m)"},
+	"hostname":    {isValidHostname, "must be a valid hostname"},
+	"fqdn":        {isValidFQDN, "must be a fully qualified domain name"},
+	"reference":   {isValidUUID, "must be the ID of a configuration item"},
+}
+
+// Layouts accepted for date and datetime values, most specific first
+var (
+	dateLayouts = []string{
+		"2006-01-02",
+		"2006/01/02",
+		time.RFC3339Nano,
+	}
+	dateTimeLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05Z07:00",
+		"2006-01-02 15:04:05",
+		"2006-01-02T15:04",
+		"2006-01-02",
+	}
+)
+
+// semverPattern is the regular expression recommended by semver.org
+var semverPattern = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+var hostnameLabel = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// NormalizeAttributes rewrites date and datetime values into canonical form in
+// place: dates become YYYY-MM-DD and datetimes RFC 3339 in UTC. Values that do
+// not parse are left alone for ValidateAttributes to report. Computed
+// attributes are then derived from the normalized values.
+func (ciType *CITypeDefinition) NormalizeAttributes(attributes map[string]interface{}) {
+
+	for _, attrs := range [][]AttributeDefinition{ciType.RequiredAttributes, ciType.OptionalAttributes} {
+		for _, attr := range attrs {
+			value, ok := attributes[attr.Name].(string)
+			if !ok {
+				continue
+			}
+			switch attr.Type {
+			case "date":
+				if t, ok := parseDate(value); ok {
+					attributes[attr.Name] = t.Format("2006-01-02")
+				}
+			case "datetime":
+				if t, ok := parseDateTime(value); ok {
+					attributes[attr.Name] = t.UTC().Format(time.RFC3339Nano)
+				}
+			}
+		}
+	}
+
+	ciType.computeAttributes(attributes)
+}
+
+func parseDate(value string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseDateTime(value string) (time.Time, bool) {
+	for _, layout := range dateTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func isValidDate(date string) bool {
+	_, ok := parseDate(date)
+	return ok
+}
+
+func isValidDateTime(datetime string) bool {
+	_, ok := parseDateTime(datetime)
+	return ok
+}
+
+func isValidIPv6(ip string) bool {
+	return strings.Contains(ip, ":") && net.ParseIP(ip) != nil
+}
+
+func isValidCIDR(cidr string) bool {
+	_, _, err := net.ParseCIDR(cidr)
+	return err == nil
+}
+
+func isValidMACAddress(mac string) bool {
+	_, err := net.ParseMAC(mac)
+	return err == nil
+}
+
+func isValidSemver(version string) bool {
+	return semverPattern.MatchString(version)
+}
+
+func isValidDuration(duration string) bool {
+	_, err := time.ParseDuration(duration)
+	return err == nil
+}
+
+// isValidHostname checks RFC 1123 host names; a single label is allowed
+func isValidHostname(hostname string) bool {
+	hostname = strings.TrimSuffix(hostname, ".")
+	if len(hostname) == 0 || len(hostname) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if !hostnameLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidFQDN requires at least two labels and a non-numeric top-level domain
+func isValidFQDN(fqdn string) bool {
+	if !isValidHostname(fqdn) {
+		return false
+	}
+	labels := strings.Split(strings.TrimSuffix(fqdn, "."), ".")
+	if len(labels) < 2 {
+		return false
+	}
+	tld := labels[len(labels)-1]
+	return strings.Trim(tld, "0123456789") != ""
+}
+
+func isValidUUID(id string) bool {
+	_, err := uuid.Parse(id)
+	return err == nil
+}
+
+// validateAttributeTypes rejects attribute definitions with an unknown type or
+// misplaced reference options. Fields are reported as section[index].type or
+// section[index].reference.
+func validateAttributeTypes(section string, attrs []AttributeDefinition) []ValidationError {
+	var errors []ValidationError
+
+	for i, attr := range attrs {
+		if !attributeTypes[attr.Type] {
+			errors = append(errors, ValidationError{
+				Field:   fmt.Sprintf("%s[%d].type", section, i),
+				Message: fmt.Sprintf("unknown type '%s' for attribute '%s'", attr.Type, attr.Name),
+			})
+		}
+
+		if attr.Reference == nil {
+			continue
+		}
+		if attr.Type != "reference" {
+			errors = append(errors, ValidationError{
+				Field:   fmt.Sprintf("%s[%d].reference", section, i),
+				Message: fmt.Sprintf("attribute '%s' has reference options but is not of type reference", attr.Name),
+			})
+			continue
+		}
+		switch attr.Reference.OnDelete {
+		case "", ReferenceOnDeleteRestrict, ReferenceOnDeleteCascade:
+		default:
+			errors = append(errors, ValidationError{
+				Field:   fmt.Sprintf("%s[%d].reference.on_delete", section, i),
+				Message: fmt.Sprintf("on_delete for attribute '%s' must be restrict or cascade", attr.Name),
+			})
+		}
+	}
+
+	return errors
+}
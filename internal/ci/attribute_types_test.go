@@ -0,0 +1,97 @@
+package ci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateFieldTypes(t *testing.T) {
+	min, max := 0.5, 2.5
+
+	tests := []struct {
+		attr  AttributeDefinition
+		value interface{}
+		valid bool
+	}{
+		{AttributeDefinition{Name: "cores", Type: "integer"}, float64(4), true},
+		{AttributeDefinition{Name: "cores", Type: "integer"}, 3.7, false},
+		{AttributeDefinition{Name: "load", Type: "number"}, 3.7, true},
+		{AttributeDefinition{Name: "load", Type: "number"}, "3.7", false},
+		{AttributeDefinition{Name: "ratio", Type: "number", Validation: &AttributeValidation{Min: &min, Max: &max}}, 0.4, false},
+		{AttributeDefinition{Name: "ratio", Type: "number", Validation: &AttributeValidation{Min: &min, Max: &max}}, 2.5, true},
+		{AttributeDefinition{Name: "purchased", Type: "date"}, "2024-02-29", true},
+		{AttributeDefinition{Name: "purchased", Type: "date"}, "2023-02-29", false},
+		{AttributeDefinition{Name: "seen_at", Type: "datetime"}, "2024-01-02T03:04:05+02:00", true},
+		{AttributeDefinition{Name: "seen_at", Type: "datetime"}, "yesterday", false},
+		{AttributeDefinition{Name: "ip", Type: "ipv6"}, "2001:db8::1", true},
+		{AttributeDefinition{Name: "ip", Type: "ipv6"}, "10.0.0.1", false},
+		{AttributeDefinition{Name: "subnet", Type: "cidr"}, "10.0.0.0/8", true},
+		{AttributeDefinition{Name: "subnet", Type: "cidr"}, "10.0.0.0/33", false},
+		{AttributeDefinition{Name: "mac", Type: "mac_address"}, "00:1A:2b:3c:4d:5e", true},
+		{AttributeDefinition{Name: "mac", Type: "mac_address"}, "00:1A:2b", false},
+		{AttributeDefinition{Name: "version", Type: "semver"}, "1.4.2-rc.1+build.5", true},
+		{AttributeDefinition{Name: "version", Type: "semver"}, "1.04.2", false},
+		{AttributeDefinition{Name: "timeout", Type: "duration"}, "1h30m", true},
+		{AttributeDefinition{Name: "timeout", Type: "duration"}, "90", false},
+		{AttributeDefinition{Name: "host", Type: "hostname"}, "web-01", true},
+		{AttributeDefinition{Name: "host", Type: "hostname"}, "-web", false},
+		{AttributeDefinition{Name: "domain", Type: "fqdn"}, "web-01.example.com.", true},
+		{AttributeDefinition{Name: "domain", Type: "fqdn"}, "web-01", false},
+	}
+
+	for _, tt := range tests {
+		errors := validateField(tt.attr, tt.value, &attributePatterns{})
+		assert.Equal(t, tt.valid, len(errors) == 0, "%s %v: %v", tt.attr.Type, tt.value, errors)
+	}
+}
+
+func TestNormalizeAttributes(t *testing.T) {
+	ciType := &CITypeDefinition{
+		RequiredAttributes: []AttributeDefinition{{Name: "purchased", Type: "date"}},
+		OptionalAttributes: []AttributeDefinition{
+			{Name: "seen_at", Type: "datetime"},
+			{Name: "note", Type: "string"},
+		},
+	}
+	attributes := map[string]interface{}{
+		"purchased": "2024/03/01",
+		"seen_at":   "2024-01-02T03:04:05+02:00",
+		"note":      "2024/03/01",
+	}
+
+	ciType.NormalizeAttributes(attributes)
+
+	assert.Equal(t, "2024-03-01", attributes["purchased"])
+	assert.Equal(t, "2024-01-02T01:04:05Z", attributes["seen_at"])
+	assert.Equal(t, "2024/03/01", attributes["note"])
+}
+
+func TestValidateAttributeTypes(t *testing.T) {
+	errors := validateAttributeTypes("required_attributes", []AttributeDefinition{
+		{Name: "hostname", Type: "hostname"},
+		{Name: "size", Type: "bignum"},
+	})
+
+	assert.Len(t, errors, 1)
+	assert.Equal(t, "required_attributes[1].type", errors[0].Field)
+}
+
+func TestValidateAttributeTypesReferenceOptions(t *testing.T) {
+	errors := validateAttributeTypes("optional_attributes", []AttributeDefinition{
+		{Name: "owner_team", Type: "reference", Reference: &ReferenceOptions{CITypes: []string{"Team"}}},
+		{Name: "hosted_in", Type: "reference", Reference: &ReferenceOptions{OnDelete: "set_null"}},
+		{Name: "owner", Type: "string", Reference: &ReferenceOptions{}},
+	})
+
+	assert.Len(t, errors, 2)
+	assert.Equal(t, "optional_attributes[1].reference.on_delete", errors[0].Field)
+	assert.Equal(t, "optional_attributes[2].reference", errors[1].Field)
+}
+
+func TestValidateFieldReference(t *testing.T) {
+	attr := AttributeDefinition{Name: "owner_team", Type: "reference"}
+
+	assert.Empty(t, validateField(attr, "550e8400-e29b-41d4-a716-446655440000", &attributePatterns{}))
+	assert.Len(t, validateField(attr, "team-a", &attributePatterns{}), 1)
+}
@@ -29,10 +29,15 @@ type AuditLogFilters struct {
 	StartDate  *time.Time `json:"start_date,omitempty"`
 	EndDate    *time.Time `json:"end_date,omitempty"`
 	Search     string     `json:"search,omitempty"`
+	ChangedPath string    `json:"changed_path,omitempty"`
 	Sort       string     `json:"sort,omitempty"`
 	Order      string     `json:"order,omitempty"`
 	Page       int        `json:"page,omitempty"`
 	Limit      int        `json:"limit,omitempty"`
+	// Cursor pages from a next_cursor or prev_cursor token instead of by page
+	// number; Count is exact, estimated or none
+	Cursor string `json:"cursor,omitempty"`
+	Count  string `json:"count,omitempty"`
 }
 
 // AuditLogListResponse represents a paginated list of audit logs
@@ -54,6 +59,7 @@ type AuditLogStats struct {
 // AuditLogRepository defines the interface for audit log operations
 type AuditLogRepository interface {
 	Create(ctx context.Context, auditLog *AuditLog) error
+	CreateBatch(ctx context.Context, auditLogs []*AuditLog) error
 	GetByID(ctx context.Context, id uuid.UUID) (*AuditLog, error)
 	List(ctx context.Context, filters AuditLogFilters) (*AuditLogListResponse, error)
 	GetStats(ctx context.Context, filters AuditLogFilters) (*AuditLogStats, error)
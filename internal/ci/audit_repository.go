@@ -2,12 +2,14 @@ package ci
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"fmt"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 	pustakaLogger "github.com/pustaka/pustaka/pkg/logger"
@@ -26,6 +28,10 @@ func NewAuditLogRepository(pool *pgxpool.Pool, logger *pustakaLogger.Logger) Aud
 	}
 }
 
+func (r *auditLogRepository) conn(ctx context.Context) querier {
+	return conn(ctx, r.pool)
+}
+
 func (r *auditLogRepository) Create(ctx context.Context, auditLog *AuditLog) error {
 	query := `
 		INSERT INTO audit_logs (entity_type, entity_id, action, performed_by, timestamp, details, ip_address, user_agent)
@@ -47,14 +53,21 @@ func (r *auditLogRepository) Create(ctx context.Context, auditLog *AuditLog) err
 		entityID = auditLog.EntityID
 	}
 
-	err = r.pool.QueryRow(ctx, query,
+	// ip_address is an INET column; store NULL rather than failing the
+	// surrounding transaction on an empty or malformed address
+	var ipAddress interface{}
+	if ip := net.ParseIP(auditLog.IPAddress); ip != nil {
+		ipAddress = ip.String()
+	}
+
+	err = r.conn(ctx).QueryRow(ctx, query,
 		auditLog.EntityType,
 		entityID,
 		auditLog.Action,
 		auditLog.PerformedBy,
 		auditLog.Timestamp,
 		detailsJSON,
-		auditLog.IPAddress,
+		ipAddress,
 		auditLog.UserAgent,
 	).Scan(&auditLog.ID)
 
@@ -77,9 +90,74 @@ func (r *auditLogRepository) Create(ctx context.Context, auditLog *AuditLog) err
 	return nil
 }
 
+// CreateBatch inserts several audit logs with one statement
+func (r *auditLogRepository) CreateBatch(ctx context.Context, auditLogs []*AuditLog) error {
+	if len(auditLogs) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(auditLogs))
+	args := make([]interface{}, 0, len(auditLogs)*8)
+	for _, auditLog := range auditLogs {
+		var detailsJSON []byte
+		if auditLog.Details != nil {
+			var err error
+			detailsJSON, err = json.Marshal(auditLog.Details)
+			if err != nil {
+				return fmt.Errorf("failed to marshal audit log details: %w", err)
+			}
+		}
+
+		var entityID interface{}
+		if auditLog.EntityID != nil {
+			entityID = auditLog.EntityID
+		}
+
+		var ipAddress interface{}
+		if ip := net.ParseIP(auditLog.IPAddress); ip != nil {
+			ipAddress = ip.String()
+		}
+
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8))
+		args = append(args, auditLog.EntityType, entityID, auditLog.Action, auditLog.PerformedBy, auditLog.Timestamp, detailsJSON, ipAddress, auditLog.UserAgent)
+	}
+
+	query := `
+		INSERT INTO audit_logs (entity_type, entity_id, action, performed_by, timestamp, details, ip_address, user_agent)
+		VALUES ` + strings.Join(values, ", ") + `
+		RETURNING id
+	`
+
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		r.logger.Error().
+			Err(err).
+			Int("count", len(auditLogs)).
+			Msg("Failed to create audit logs")
+		return fmt.Errorf("failed to create audit logs: %w", err)
+	}
+	defer rows.Close()
+
+	for i := 0; rows.Next() && i < len(auditLogs); i++ {
+		if err := rows.Scan(&auditLogs[i].ID); err != nil {
+			return fmt.Errorf("failed to create audit logs: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to create audit logs: %w", err)
+	}
+
+	r.logger.Debug().
+		Int("count", len(auditLogs)).
+		Msg("Audit logs created")
+
+	return nil
+}
+
 func (r *auditLogRepository) GetByID(ctx context.Context, id uuid.UUID) (*AuditLog, error) {
 	query := `
-		SELECT id, entity_type, entity_id, action, performed_by, timestamp, details, ip_address, user_agent
+		SELECT id, entity_type, entity_id, action, performed_by, timestamp, details, COALESCE(host(ip_address), ''), COALESCE(user_agent, '')
 		FROM audit_logs
 		WHERE id = $1
 	`
@@ -88,7 +166,7 @@ func (r *auditLogRepository) GetByID(ctx context.Context, id uuid.UUID) (*AuditL
 	var entityID pgtype.UUID
 	var detailsJSON []byte
 
-	err := r.pool.QueryRow(ctx, query, id).Scan(
+	err := r.conn(ctx).QueryRow(ctx, query, id).Scan(
 		&auditLog.ID,
 		&auditLog.EntityType,
 		&entityID,
@@ -101,14 +179,14 @@ func (r *auditLogRepository) GetByID(ctx context.Context, id uuid.UUID) (*AuditL
 	)
 
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == pgx.ErrNoRows {
 			return nil, fmt.Errorf("audit log not found")
 		}
 		r.logger.Error().Err(err).Str("audit_log_id", id.String()).Msg("Failed to get audit log")
 		return nil, fmt.Errorf("failed to get audit log: %w", err)
 	}
 
-	if len(entityID.Bytes) > 0 {
+	if entityID.Valid {
 		uid := uuid.UUID(entityID.Bytes)
 		auditLog.EntityID = &uid
 	}
@@ -141,8 +219,6 @@ func (r *auditLogRepository) List(ctx context.Context, filters AuditLogFilters)
 		filters.Order = "desc"
 	}
 
-	offset := (filters.Page - 1) * filters.Limit
-
 	// Build WHERE clause
 	whereClause := "WHERE 1=1"
 	args := []interface{}{}
@@ -191,15 +267,14 @@ func (r *auditLogRepository) List(ctx context.Context, filters AuditLogFilters)
 		argIndex += 3
 	}
 
+	if filters.ChangedPath != "" {
+		whereClause += fmt.Sprintf(" AND details->'changed_paths' ? $%d", argIndex)
+		args = append(args, filters.ChangedPath)
+		argIndex++
+	}
+
 	// Validate sort column
-	validSortColumns := map[string]bool{
-		"timestamp":    true,
-		"entity_type":  true,
-		"action":       true,
-		"performed_by": true,
-		"ip_address":   true,
-	}
-	if !validSortColumns[filters.Sort] {
+	if _, ok := auditKeysetColumns[filters.Sort]; !ok {
 		filters.Sort = "timestamp"
 	}
 
@@ -208,25 +283,28 @@ func (r *auditLogRepository) List(ctx context.Context, filters AuditLogFilters)
 		filters.Order = "desc"
 	}
 
+	keyset, err := newKeysetPage(auditKeysetColumns, filters.Sort, filters.Order == "desc", filters.Page, filters.Limit, filters.Cursor, filters.Count)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get total count
-	countQuery := "SELECT COUNT(*) FROM audit_logs " + whereClause
-	var total int64
-	err := r.pool.QueryRow(ctx, countQuery, args...).Scan(&total)
+	total, err := keyset.countRows(ctx, r.conn(ctx), "audit_logs", whereClause, args)
 	if err != nil {
 		r.logger.Error().Err(err).Msg("Failed to count audit logs")
 		return nil, fmt.Errorf("failed to count audit logs: %w", err)
 	}
 
 	// Get audit logs
+	if condition := keyset.where(&args); condition != "" {
+		whereClause += " AND " + condition
+	}
 	query := `
-		SELECT id, entity_type, entity_id, action, performed_by, timestamp, details, ip_address, user_agent
+		SELECT id, entity_type, entity_id, action, performed_by, timestamp, details, COALESCE(host(ip_address), ''), COALESCE(user_agent, '')
 		FROM audit_logs ` + whereClause + `
-		ORDER BY ` + filters.Sort + " " + filters.Order + `
-		LIMIT $` + fmt.Sprintf("%d", argIndex) + ` OFFSET $` + fmt.Sprintf("%d", argIndex+1)
-
-	args = append(args, filters.Limit, offset)
+		` + keyset.orderBy(&args)
 
-	rows, err := r.pool.Query(ctx, query, args...)
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
 	if err != nil {
 		r.logger.Error().Err(err).Msg("Failed to query audit logs")
 		return nil, fmt.Errorf("failed to query audit logs: %w", err)
@@ -256,7 +334,7 @@ func (r *auditLogRepository) List(ctx context.Context, filters AuditLogFilters)
 			continue
 		}
 
-		if len(entityID.Bytes) > 0 {
+		if entityID.Valid {
 			uid := uuid.UUID(entityID.Bytes)
 			auditLog.EntityID = &uid
 		}
@@ -271,17 +349,45 @@ func (r *auditLogRepository) List(ctx context.Context, filters AuditLogFilters)
 		auditLogs = append(auditLogs, auditLog)
 	}
 
-	totalPages := int((total + int64(filters.Limit) - 1) / int64(filters.Limit))
+	n, more := keyset.trim(len(auditLogs))
+	auditLogs = auditLogs[:n]
+	if keyset.backward() {
+		for i, j := 0, len(auditLogs)-1; i < j; i, j = i+1, j-1 {
+			auditLogs[i], auditLogs[j] = auditLogs[j], auditLogs[i]
+		}
+	}
 
-	return &AuditLogListResponse{
+	response := &AuditLogListResponse{
 		AuditLogs: auditLogs,
 		Pagination: PaginationResponse{
-			Page:       filters.Page,
+			Page:       keyset.pageNumber(),
 			Limit:      filters.Limit,
 			Total:      total,
-			TotalPages: totalPages,
+			TotalPages: keyset.totalPages(total),
+			CursorPage: keyset.cursors(more, nil, uuid.Nil, nil, uuid.Nil),
 		},
-	}, nil
+	}
+	if n > 0 {
+		first, last := &auditLogs[0], &auditLogs[n-1]
+		response.Pagination.CursorPage = keyset.cursors(more,
+			auditSortValue(first, keyset.sort), first.ID, auditSortValue(last, keyset.sort), last.ID)
+	}
+	return response, nil
+}
+
+// auditSortValue returns an audit log's value of a sort key
+func auditSortValue(auditLog *AuditLog, sort string) interface{} {
+	switch sort {
+	case "entity_type":
+		return auditLog.EntityType
+	case "action":
+		return auditLog.Action
+	case "performed_by":
+		return auditLog.PerformedBy
+	case "ip_address":
+		return auditLog.IPAddress
+	}
+	return auditLog.Timestamp
 }
 
 func (r *auditLogRepository) GetStats(ctx context.Context, filters AuditLogFilters) (*AuditLogStats, error) {
@@ -311,7 +417,7 @@ func (r *auditLogRepository) GetStats(ctx context.Context, filters AuditLogFilte
 
 	// Get total count
 	totalQuery := "SELECT COUNT(*) FROM audit_logs " + whereClause
-	err := r.pool.QueryRow(ctx, totalQuery, args...).Scan(&stats.TotalEvents)
+	err := r.conn(ctx).QueryRow(ctx, totalQuery, args...).Scan(&stats.TotalEvents)
 	if err != nil {
 		r.logger.Error().Err(err).Msg("Failed to get total audit log count")
 		return nil, fmt.Errorf("failed to get total audit log count: %w", err)
@@ -325,7 +431,7 @@ func (r *auditLogRepository) GetStats(ctx context.Context, filters AuditLogFilte
 		ORDER BY COUNT(*) DESC
 		LIMIT 10
 	`
-	typeRows, err := r.pool.Query(ctx, typeQuery, args...)
+	typeRows, err := r.conn(ctx).Query(ctx, typeQuery, args...)
 	if err != nil {
 		r.logger.Error().Err(err).Msg("Failed to get events by type")
 	} else {
@@ -347,7 +453,7 @@ func (r *auditLogRepository) GetStats(ctx context.Context, filters AuditLogFilte
 		ORDER BY COUNT(*) DESC
 		LIMIT 10
 	`
-	actionRows, err := r.pool.Query(ctx, actionQuery, args...)
+	actionRows, err := r.conn(ctx).Query(ctx, actionQuery, args...)
 	if err != nil {
 		r.logger.Error().Err(err).Msg("Failed to get events by action")
 	} else {
@@ -371,7 +477,7 @@ func (r *auditLogRepository) GetStats(ctx context.Context, filters AuditLogFilte
 		ORDER BY COUNT(*) DESC
 		LIMIT 10
 	`
-	userRows, err := r.pool.Query(ctx, userQuery, args...)
+	userRows, err := r.conn(ctx).Query(ctx, userQuery, args...)
 	if err != nil {
 		r.logger.Error().Err(err).Msg("Failed to get events by user")
 	} else {
@@ -393,7 +499,7 @@ func (r *auditLogRepository) GetStats(ctx context.Context, filters AuditLogFilte
 		GROUP BY DATE(timestamp)
 		ORDER BY date DESC
 	`
-	dailyRows, err := r.pool.Query(ctx, dailyQuery)
+	dailyRows, err := r.conn(ctx).Query(ctx, dailyQuery)
 	if err != nil {
 		r.logger.Error().Err(err).Msg("Failed to get daily activity")
 	} else {
@@ -409,14 +515,14 @@ func (r *auditLogRepository) GetStats(ctx context.Context, filters AuditLogFilte
 
 	// Get recent activity
 	recentQuery := `
-		SELECT al.id, al.entity_type, al.entity_id, al.action, al.performed_by, al.timestamp, al.ip_address, u.username
+		SELECT al.id, al.entity_type, al.entity_id, al.action, al.performed_by, al.timestamp, COALESCE(host(al.ip_address), ''), u.username
 		FROM audit_logs al
 		JOIN users u ON al.performed_by = u.id
 		` + whereClause + `
 		ORDER BY al.timestamp DESC
 		LIMIT 10
 	`
-	recentRows, err := r.pool.Query(ctx, recentQuery, args...)
+	recentRows, err := r.conn(ctx).Query(ctx, recentQuery, args...)
 	if err != nil {
 		r.logger.Error().Err(err).Msg("Failed to get recent activity")
 	} else {
@@ -438,7 +544,7 @@ func (r *auditLogRepository) GetStats(ctx context.Context, filters AuditLogFilte
 			)
 
 			if err == nil {
-				if len(entityID.Bytes) > 0 {
+				if entityID.Valid {
 					uid := uuid.UUID(entityID.Bytes)
 					auditLog.EntityID = &uid
 				}
@@ -456,7 +562,7 @@ func (r *auditLogRepository) GetStats(ctx context.Context, filters AuditLogFilte
 func (r *auditLogRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	query := "DELETE FROM audit_logs WHERE id = $1"
 
-	result, err := r.pool.Exec(ctx, query, id)
+	result, err := r.conn(ctx).Exec(ctx, query, id)
 	if err != nil {
 		r.logger.Error().Err(err).Str("audit_log_id", id.String()).Msg("Failed to delete audit log")
 		return fmt.Errorf("failed to delete audit log: %w", err)
@@ -474,7 +580,7 @@ func (r *auditLogRepository) Delete(ctx context.Context, id uuid.UUID) error {
 func (r *auditLogRepository) DeleteOld(ctx context.Context, olderThan time.Time) (int64, error) {
 	query := "DELETE FROM audit_logs WHERE timestamp < $1"
 
-	result, err := r.pool.Exec(ctx, query, olderThan)
+	result, err := r.conn(ctx).Exec(ctx, query, olderThan)
 	if err != nil {
 		r.logger.Error().Err(err).Time("older_than", olderThan).Msg("Failed to delete old audit logs")
 		return 0, fmt.Errorf("failed to delete old audit logs: %w", err)
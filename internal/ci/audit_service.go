@@ -52,6 +52,26 @@ func (s *AuditService) CreateAuditLog(ctx context.Context, entityType string, en
 	return nil
 }
 
+// CreateAuditLogs creates several audit log entries at once
+func (s *AuditService) CreateAuditLogs(ctx context.Context, auditLogs []*AuditLog) error {
+	now := time.Now()
+	for _, auditLog := range auditLogs {
+		if auditLog.Timestamp.IsZero() {
+			auditLog.Timestamp = now
+		}
+	}
+
+	if err := s.repo.CreateBatch(ctx, auditLogs); err != nil {
+		s.logger.Error().
+			Err(err).
+			Int("count", len(auditLogs)).
+			Msg("Failed to create audit logs")
+		return err
+	}
+
+	return nil
+}
+
 // GetAuditLog gets an audit log by ID
 func (s *AuditService) GetAuditLog(ctx context.Context, id uuid.UUID) (*AuditLog, error) {
 	return s.repo.GetByID(ctx, id)
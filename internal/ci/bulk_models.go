@@ -0,0 +1,138 @@
+package ci
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Bulk CI operations
+const (
+	BulkOpCreate = "create"
+	BulkOpUpdate = "update"
+	BulkOpUpsert = "upsert"
+	BulkOpDelete = "delete"
+)
+
+// Bulk request modes. In atomic mode either every operation is applied or
+// none is; in best_effort mode each operation stands on its own.
+const (
+	BulkModeAtomic     = "atomic"
+	BulkModeBestEffort = "best_effort"
+)
+
+// Bulk operation result statuses
+const (
+	BulkStatusCreated = "created"
+	BulkStatusUpdated = "updated"
+	BulkStatusDeleted = "deleted"
+	BulkStatusFailed  = "failed"
+	BulkStatusSkipped = "skipped"
+)
+
+// MaxBulkOperations caps the number of operations in one bulk request
+const MaxBulkOperations = 1000
+
+// bulkBatchSize is how many new CIs are inserted with one statement
+const bulkBatchSize = 500
+
+// BulkCIOperation is one operation of a bulk request. Creates and upserts
+// name the CI by name and ci_type; updates and deletes name it either by ID or
+// by name and ci_type. Update and upsert replace attributes and tags like
+// PUT /ci/{id}, leaving out what is not given.
+type BulkCIOperation struct {
+	Op         string                 `json:"op"`
+	ID         *uuid.UUID             `json:"id,omitempty"`
+	Name       string                 `json:"name,omitempty"`
+	CIType     string                 `json:"ci_type,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Tags       []string               `json:"tags,omitempty"`
+}
+
+type BulkCIRequest struct {
+	Mode       string            `json:"mode,omitempty"`
+	Operations []BulkCIOperation `json:"operations"`
+}
+
+// BulkCIResult is the outcome of one operation, in request order. Skipped
+// operations were valid but not applied because an atomic request failed.
+type BulkCIResult struct {
+	Index  int                `json:"index"`
+	Op     string             `json:"op"`
+	Status string             `json:"status"`
+	ID     *uuid.UUID         `json:"id,omitempty"`
+	CI     *ConfigurationItem `json:"ci,omitempty"`
+	Error  string             `json:"error,omitempty"`
+	Errors []ValidationError  `json:"errors,omitempty"`
+}
+
+type BulkCIResponse struct {
+	Mode      string         `json:"mode"`
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+	Skipped   int            `json:"skipped"`
+	Results   []BulkCIResult `json:"results"`
+}
+
+// ciKey identifies a CI by name within its type
+type ciKey struct {
+	Name   string
+	CIType string
+}
+
+// validate checks the fields an operation needs, without looking at the database
+func (op *BulkCIOperation) validate() []ValidationError {
+	var errors []ValidationError
+	byKey := op.Name != "" && op.CIType != ""
+
+	switch op.Op {
+	case BulkOpCreate, BulkOpUpsert:
+		if op.ID != nil {
+			errors = append(errors, ValidationError{Field: "id", Message: fmt.Sprintf("%s identifies the CI by name and ci_type", op.Op)})
+		}
+		if op.Name == "" {
+			errors = append(errors, ValidationError{Field: "name", Message: "name is required"})
+		}
+		if op.CIType == "" {
+			errors = append(errors, ValidationError{Field: "ci_type", Message: "ci_type is required"})
+		}
+
+	case BulkOpUpdate, BulkOpDelete:
+		if op.ID == nil && !byKey {
+			errors = append(errors, ValidationError{Field: "id", Message: "id, or name and ci_type, is required"})
+		}
+		if op.ID != nil && (op.Name != "" || op.CIType != "") {
+			errors = append(errors, ValidationError{Field: "id", Message: "give either id or name and ci_type, not both"})
+		}
+		if op.Op == BulkOpDelete && (op.Attributes != nil || op.Tags != nil) {
+			errors = append(errors, ValidationError{Field: "op", Message: "delete takes no attributes or tags"})
+		}
+
+	default:
+		errors = append(errors, ValidationError{
+			Field:   "op",
+			Message: fmt.Sprintf("unknown operation '%s'; must be create, update, upsert or delete", op.Op),
+		})
+	}
+
+	return errors
+}
+
+// bulkFailure turns an error from applying an operation into the message and
+// field errors reported for it
+func bulkFailure(err error) (string, []ValidationError) {
+	switch e := err.(type) {
+	case ServiceValidationError:
+		return e.Message, e.Errors
+	case ReferencedCIError:
+		errors := make([]ValidationError, 0, len(e.References))
+		for _, ref := range e.References {
+			errors = append(errors, ValidationError{
+				Field:   ref.Attribute,
+				Message: fmt.Sprintf("referenced by CI '%s' (%s)", ref.CIName, ref.CIType),
+			})
+		}
+		return e.Error(), errors
+	}
+	return err.Error(), nil
+}
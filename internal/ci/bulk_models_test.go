@@ -0,0 +1,60 @@
+package ci
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkCIOperationValidate(t *testing.T) {
+	id := uuid.New()
+
+	valid := []BulkCIOperation{
+		{Op: BulkOpCreate, Name: "web-01", CIType: "Server"},
+		{Op: BulkOpUpsert, Name: "web-01", CIType: "Server", Tags: []string{"web"}},
+		{Op: BulkOpUpdate, ID: &id, Attributes: map[string]interface{}{"cpu": float64(4)}},
+		{Op: BulkOpUpdate, Name: "web-01", CIType: "Server"},
+		{Op: BulkOpDelete, ID: &id},
+	}
+	for _, op := range valid {
+		assert.Empty(t, op.validate(), op.Op)
+	}
+
+	invalid := map[string]BulkCIOperation{
+		"id":      {Op: BulkOpCreate, ID: &id, Name: "web-01", CIType: "Server"},
+		"name":    {Op: BulkOpUpsert, CIType: "Server"},
+		"ci_type": {Op: BulkOpCreate, Name: "web-01"},
+		"op":      {Op: "merge", Name: "web-01", CIType: "Server"},
+	}
+	for field, op := range invalid {
+		errors := op.validate()
+		require.Len(t, errors, 1, field)
+		assert.Equal(t, field, errors[0].Field)
+	}
+
+	assert.NotEmpty(t, (&BulkCIOperation{Op: BulkOpDelete}).validate())
+	assert.NotEmpty(t, (&BulkCIOperation{Op: BulkOpUpdate, ID: &id, Name: "web-01"}).validate())
+	assert.NotEmpty(t, (&BulkCIOperation{Op: BulkOpDelete, ID: &id, Tags: []string{"x"}}).validate())
+}
+
+func TestBulkFailure(t *testing.T) {
+	message, errors := bulkFailure(ServiceValidationError{
+		Message: "Attribute validation failed",
+		Errors:  []ValidationError{{Field: "hostname", Message: "is required"}},
+	})
+	assert.Equal(t, "Attribute validation failed", message)
+	assert.Equal(t, []ValidationError{{Field: "hostname", Message: "is required"}}, errors)
+
+	message, errors = bulkFailure(ReferencedCIError{References: []CIReference{
+		{CIName: "app-01", CIType: "Application", Attribute: "host"},
+	}})
+	assert.Equal(t, "cannot delete CI referenced by other CIs", message)
+	assert.Equal(t, []ValidationError{{Field: "host", Message: "referenced by CI 'app-01' (Application)"}}, errors)
+
+	message, errors = bulkFailure(fmt.Errorf("CI not found"))
+	assert.Equal(t, "CI not found", message)
+	assert.Nil(t, errors)
+}
@@ -0,0 +1,233 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Bulk CI operations
+
+// CreateCIs inserts a batch of CIs, and their first versions, with one
+// statement each. CIs without an ID are given one. The CIs are returned in
+// the order given.
+func (r *Repository) CreateCIs(ctx context.Context, cis []ConfigurationItem) ([]ConfigurationItem, error) {
+	if len(cis) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	values := make([]string, 0, len(cis))
+	args := make([]interface{}, 0, len(cis)*7)
+	for i := range cis {
+		if cis[i].ID == uuid.Nil {
+			cis[i].ID = uuid.New()
+		}
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+7))
+		args = append(args, cis[i].ID, cis[i].Name, cis[i].CIType, cis[i].Attributes, cis[i].Tags, cis[i].CreatedBy, now)
+	}
+
+	query := `
+		INSERT INTO configuration_items (id, name, ci_type, attributes, tags, created_by, created_at, updated_at)
+		VALUES ` + strings.Join(values, ", ") + `
+		RETURNING ` + ciColumns
+
+	created := make(map[uuid.UUID]ConfigurationItem, len(cis))
+	err := r.WithTx(ctx, func(ctx context.Context) error {
+		rows, err := r.conn(ctx).Query(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var ci ConfigurationItem
+			if err := scanCI(rows, &ci); err != nil {
+				rows.Close()
+				return err
+			}
+			created[ci.ID] = ci
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		return r.createCIVersions(ctx, created)
+	})
+	if err != nil {
+		r.logger.ErrorDatabase("INSERT", "configuration_items", err, map[string]interface{}{
+			"count": len(cis),
+		})
+		return nil, fmt.Errorf("failed to create CIs: %w", err)
+	}
+
+	result := make([]ConfigurationItem, 0, len(cis))
+	for _, ci := range cis {
+		result = append(result, created[ci.ID])
+	}
+
+	r.logger.InfoDatabase("INSERT", "configuration_items", 0, map[string]interface{}{
+		"count": len(result),
+	})
+
+	return result, nil
+}
+
+// ciUpdate is one CI's part of a batch update; nil attributes or tags are
+// left unchanged
+type ciUpdate struct {
+	ID         uuid.UUID
+	Attributes map[string]interface{}
+	Tags       []string
+}
+
+// UpdateCIs writes a batch of CI updates, and their new versions, with one
+// statement each. The updated CIs are returned in the order given; IDs that
+// no longer exist are skipped.
+func (r *Repository) UpdateCIs(ctx context.Context, updates []ciUpdate, updatedBy uuid.UUID) ([]ConfigurationItem, error) {
+	if len(updates) == 0 {
+		return nil, nil
+	}
+
+	values := make([]string, 0, len(updates))
+	args := []interface{}{time.Now(), updatedBy}
+	for _, update := range updates {
+		var attributes, tags interface{}
+		if update.Attributes != nil {
+			attributes = update.Attributes
+		}
+		if update.Tags != nil {
+			tags = update.Tags
+		}
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d::uuid, $%d::jsonb, $%d::text[])", n+1, n+2, n+3))
+		args = append(args, update.ID, attributes, tags)
+	}
+
+	query := `
+		UPDATE configuration_items
+		SET attributes = COALESCE(changes.new_attributes, attributes),
+			tags = COALESCE(changes.new_tags, tags),
+			updated_at = $1,
+			updated_by = $2,
+			version = version + 1
+		FROM (VALUES ` + strings.Join(values, ", ") + `) AS changes(ci_id, new_attributes, new_tags)
+		WHERE id = changes.ci_id
+		RETURNING ` + ciColumns
+
+	updated := make(map[uuid.UUID]ConfigurationItem, len(updates))
+	err := r.WithTx(ctx, func(ctx context.Context) error {
+		rows, err := r.conn(ctx).Query(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var ci ConfigurationItem
+			if err := scanCI(rows, &ci); err != nil {
+				rows.Close()
+				return err
+			}
+			updated[ci.ID] = ci
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if len(updated) == 0 {
+			return nil
+		}
+		return r.createCIVersions(ctx, updated)
+	})
+	if err != nil {
+		r.logger.ErrorDatabase("UPDATE", "configuration_items", err, map[string]interface{}{
+			"count": len(updates),
+		})
+		return nil, fmt.Errorf("failed to update CIs: %w", err)
+	}
+
+	result := make([]ConfigurationItem, 0, len(updated))
+	for _, update := range updates {
+		if ci, ok := updated[update.ID]; ok {
+			result = append(result, ci)
+		}
+	}
+
+	r.logger.InfoDatabase("UPDATE", "configuration_items", 0, map[string]interface{}{
+		"count": len(result),
+	})
+
+	return result, nil
+}
+
+// createCIVersions records the current version of each CI with one statement.
+// Each version is attributed to the CI's last writer.
+func (r *Repository) createCIVersions(ctx context.Context, cis map[uuid.UUID]ConfigurationItem) error {
+	values := make([]string, 0, len(cis))
+	args := make([]interface{}, 0, len(cis)*8)
+	for _, ci := range cis {
+		changedBy := ci.CreatedBy
+		if ci.UpdatedBy != nil {
+			changedBy = *ci.UpdatedBy
+		}
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8))
+		args = append(args, ci.ID, ci.Version, ci.Name, ci.CIType, ci.Attributes, ci.Tags, changedBy, ci.UpdatedAt)
+	}
+
+	query := `
+		INSERT INTO configuration_item_versions (ci_id, version, name, ci_type, attributes, tags, changed_by, changed_at)
+		VALUES ` + strings.Join(values, ", ")
+
+	if _, err := r.conn(ctx).Exec(ctx, query, args...); err != nil {
+		r.logger.ErrorDatabase("INSERT", "configuration_item_versions", err, map[string]interface{}{
+			"count": len(cis),
+		})
+		return fmt.Errorf("failed to record CI versions: %w", err)
+	}
+
+	return nil
+}
+
+// GetCIsByKeys returns the CIs with the given names and types; keys that do
+// not exist are skipped
+func (r *Repository) GetCIsByKeys(ctx context.Context, keys []ciKey) ([]ConfigurationItem, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(keys))
+	ciTypes := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, key.Name)
+		ciTypes = append(ciTypes, key.CIType)
+	}
+
+	query := `
+		SELECT ` + ciColumns + `
+		FROM configuration_items
+		WHERE (name, ci_type) IN (SELECT * FROM unnest($1::text[], $2::text[]))
+	`
+
+	rows, err := r.conn(ctx).Query(ctx, query, names, ciTypes)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "configuration_items", err, nil)
+		return nil, fmt.Errorf("failed to get CIs by name and type: %w", err)
+	}
+	defer rows.Close()
+
+	var cis []ConfigurationItem
+	for rows.Next() {
+		var ci ConfigurationItem
+		if err := scanCI(rows, &ci); err != nil {
+			r.logger.ErrorDatabase("SELECT", "configuration_items", err, nil)
+			return nil, fmt.Errorf("failed to scan CI: %w", err)
+		}
+		cis = append(cis, ci)
+	}
+
+	return cis, rows.Err()
+}
@@ -0,0 +1,175 @@
+package ci
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pustakaLogger "github.com/pustaka/pustaka/pkg/logger"
+)
+
+// bulkTx is a transaction holding a set of CIs and the source and target of
+// each relationship. It answers reads and CI updates from them and records
+// every statement it runs.
+type bulkTx struct {
+	pgx.Tx
+	cis           map[uuid.UUID]ConfigurationItem
+	relationships [][2]uuid.UUID
+	statements    []string
+}
+
+func (tx *bulkTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	tx.statements = append(tx.statements, sql)
+	return pgconn.CommandTag{}, nil
+}
+
+func (tx *bulkTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	tx.statements = append(tx.statements, sql)
+
+	rows := &bulkRows{}
+	switch {
+	case strings.Contains(sql, "FROM relationships"):
+		rows.relationships = tx.relationships
+	case strings.Contains(sql, "UPDATE configuration_items"):
+		for _, ci := range tx.cis {
+			ci.Version++
+			rows.cis = append(rows.cis, ci)
+		}
+	case strings.Contains(sql, "FROM configuration_items"):
+		for _, ci := range tx.cis {
+			rows.cis = append(rows.cis, ci)
+		}
+	case strings.Contains(sql, "INSERT INTO audit_logs"):
+		rows.ids = len(args) / 8
+	}
+	return rows, nil
+}
+
+// bulkRows yields CIs, relationship endpoints or new audit log IDs
+type bulkRows struct {
+	pgx.Rows
+	cis           []ConfigurationItem
+	relationships [][2]uuid.UUID
+	ids           int
+	next          int
+}
+
+func (rows *bulkRows) Next() bool {
+	rows.next++
+	return rows.next <= len(rows.cis)+len(rows.relationships)+rows.ids
+}
+
+func (rows *bulkRows) Scan(dest ...interface{}) error {
+	if len(rows.relationships) > 0 {
+		endpoints := rows.relationships[rows.next-1]
+		*dest[0].(*uuid.UUID) = endpoints[0]
+		*dest[1].(*uuid.UUID) = endpoints[1]
+		return nil
+	}
+	if len(rows.cis) == 0 {
+		*dest[0].(*uuid.UUID) = uuid.New()
+		return nil
+	}
+
+	ci := rows.cis[rows.next-1]
+	*dest[0].(*uuid.UUID) = ci.ID
+	*dest[1].(*string) = ci.Name
+	*dest[2].(*string) = ci.CIType
+	*dest[3].(*map[string]interface{}) = ci.Attributes
+	*dest[4].(*[]string) = ci.Tags
+	*dest[5].(*time.Time) = ci.CreatedAt
+	*dest[6].(*time.Time) = ci.UpdatedAt
+	*dest[7].(*uuid.UUID) = ci.CreatedBy
+	*dest[8].(**uuid.UUID) = ci.UpdatedBy
+	*dest[9].(*int) = ci.Version
+	return nil
+}
+
+func (rows *bulkRows) Close()     {}
+func (rows *bulkRows) Err() error { return nil }
+
+func TestChangeBulkCIsBatchesUpdates(t *testing.T) {
+	logger := pustakaLogger.Default()
+	tx := &bulkTx{cis: make(map[uuid.UUID]ConfigurationItem)}
+	ciType := &CITypeDefinition{Name: "Server"}
+
+	var items []*bulkItem
+	for _, name := range []string{"web-01", "web-02", "web-03"} {
+		ci := ConfigurationItem{ID: uuid.New(), Name: name, CIType: "Server", Attributes: map[string]interface{}{}, Tags: []string{}, Version: 1}
+		tx.cis[ci.ID] = ci
+		items = append(items, &bulkItem{
+			op:      BulkCIOperation{Op: BulkOpUpdate, ID: &ci.ID, Tags: []string{"web"}},
+			action:  BulkOpUpdate,
+			ciType:  ciType,
+			current: &ci,
+			update:  &UpdateCIRequest{Tags: []string{"web"}},
+			result:  &BulkCIResult{},
+		})
+	}
+
+	cache := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	defer cache.Close()
+	service := NewService(NewRepository(nil, logger), nil, NewAuditService(NewAuditLogRepository(nil, logger), logger), cache, logger)
+	ctx := context.WithValue(context.Background(), txContextKey{}, pgx.Tx(tx))
+
+	failed, err := service.changeBulkCIs(ctx, items, uuid.New())
+	require.NoError(t, err)
+	assert.Nil(t, failed)
+
+	counts := make(map[string]int)
+	for _, statement := range tx.statements {
+		for _, kind := range []string{"FOR NO KEY UPDATE", "UPDATE configuration_items", "INSERT INTO configuration_item_versions", "INSERT INTO audit_logs", "INSERT INTO graph_sync_outbox"} {
+			if strings.Contains(statement, kind) {
+				counts[kind]++
+			}
+		}
+	}
+	assert.Equal(t, map[string]int{
+		"FOR NO KEY UPDATE":                       1,
+		"UPDATE configuration_items":              1,
+		"INSERT INTO configuration_item_versions": 1,
+		"INSERT INTO audit_logs":                  1,
+		"INSERT INTO graph_sync_outbox":           1,
+	}, counts)
+
+	for _, item := range items {
+		assert.Equal(t, BulkStatusUpdated, item.result.Status)
+		require.NotNil(t, item.result.CI)
+		assert.Equal(t, 2, item.result.CI.Version)
+	}
+}
+
+func TestChangeBulkCIsChecksRelationshipsUnderLock(t *testing.T) {
+	logger := pustakaLogger.Default()
+	ci := ConfigurationItem{ID: uuid.New(), Name: "web-01", CIType: "Server", Attributes: map[string]interface{}{}, Tags: []string{}, Version: 1}
+	tx := &bulkTx{
+		cis:           map[uuid.UUID]ConfigurationItem{ci.ID: ci},
+		relationships: [][2]uuid.UUID{{uuid.New(), ci.ID}},
+	}
+	item := &bulkItem{
+		op:      BulkCIOperation{Op: BulkOpDelete, ID: &ci.ID},
+		action:  BulkOpDelete,
+		ciType:  &CITypeDefinition{Name: "Server"},
+		current: &ci,
+		result:  &BulkCIResult{},
+	}
+
+	service := NewService(NewRepository(nil, logger), nil, nil, nil, logger)
+	ctx := context.WithValue(context.Background(), txContextKey{}, pgx.Tx(tx))
+
+	failed, err := service.changeBulkCIs(ctx, []*bulkItem{item}, uuid.New())
+
+	assert.EqualError(t, err, "cannot delete CI with existing relationships")
+	assert.Same(t, item, failed)
+	require.Len(t, tx.statements, 2)
+	assert.Contains(t, tx.statements[0], "FOR NO KEY UPDATE")
+	assert.Contains(t, tx.statements[1], "FROM relationships")
+}
@@ -0,0 +1,272 @@
+package ci
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Ways of counting the total of a listing. An estimate comes from the query
+// planner and costs next to nothing; with none, Total is left at 0.
+const (
+	CountExact     = "exact"
+	CountEstimated = "estimated"
+	CountNone      = "none"
+)
+
+// CursorPage is the keyset pagination part of a list response. NextCursor and
+// PrevCursor are opaque tokens for the pages after and before this one, left
+// out at either end; Count is how the total was counted.
+type CursorPage struct {
+	Count      string `json:"count"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// keysetColumn is a sort key of a listing: the SQL expression rows are
+// ordered by, never NULL, and the type of its values
+type keysetColumn struct {
+	expr   string
+	pgType string
+}
+
+// Sort keys of the keyset-paginated listings
+var (
+	ciKeysetColumns = map[string]keysetColumn{
+		"name":       {"name", "text"},
+		"ci_type":    {"ci_type", "text"},
+		"created_at": {"created_at", "timestamptz"},
+		"updated_at": {"updated_at", "timestamptz"},
+	}
+	relationshipKeysetColumns = map[string]keysetColumn{
+		"created_at": {"created_at", "timestamptz"},
+	}
+	auditKeysetColumns = map[string]keysetColumn{
+		"timestamp":    {"timestamp", "timestamptz"},
+		"entity_type":  {"entity_type", "text"},
+		"action":       {"action", "text"},
+		"performed_by": {"performed_by", "uuid"},
+		"ip_address":   {"COALESCE(host(ip_address), '')", "text"},
+	}
+)
+
+// pageCursor is a decoded cursor token: the sort key and id of the row a page
+// starts after, or with Before, ends before
+type pageCursor struct {
+	Sort   string      `json:"s"`
+	Desc   bool        `json:"d,omitempty"`
+	Value  interface{} `json:"v"`
+	ID     uuid.UUID   `json:"i"`
+	Before bool        `json:"b,omitempty"`
+}
+
+func encodeCursor(cursor pageCursor) string {
+	encoded, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// decodeCursor reads a cursor token of a listing with the given sort keys and
+// converts its sort value back to the key's type
+func decodeCursor(token string, columns map[string]keysetColumn) (*pageCursor, error) {
+	invalid := fmt.Errorf("invalid cursor")
+
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, invalid
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(decoded, &cursor); err != nil {
+		return nil, invalid
+	}
+	column, ok := columns[cursor.Sort]
+	if !ok {
+		return nil, invalid
+	}
+
+	text, ok := cursor.Value.(string)
+	if !ok {
+		return nil, invalid
+	}
+	switch column.pgType {
+	case "timestamptz":
+		t, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return nil, invalid
+		}
+		cursor.Value = t
+	case "uuid":
+		id, err := uuid.Parse(text)
+		if err != nil {
+			return nil, invalid
+		}
+		cursor.Value = id
+	}
+	return &cursor, nil
+}
+
+// keysetPage plans the query for one page of a listing ordered by a sort key
+// and then id, so the order is total. A page is asked for by number, which
+// reads with OFFSET, or by cursor, which reads from the cursor's row through
+// the index on the sort key and id. One row more than the limit is read to
+// tell whether another page follows.
+type keysetPage struct {
+	sort   string
+	column keysetColumn
+	desc   bool
+	limit  int
+	offset int
+	cursor *pageCursor
+	count  string
+}
+
+// newKeysetPage plans a page. A cursor carries its own sort key and order,
+// which replace sort and desc. The total is counted exactly by default, or
+// not at all when paging by cursor.
+func newKeysetPage(columns map[string]keysetColumn, sort string, desc bool, page, limit int, cursorToken, count string) (*keysetPage, error) {
+	p := &keysetPage{sort: sort, desc: desc, limit: limit, count: count}
+
+	if cursorToken != "" {
+		cursor, err := decodeCursor(cursorToken, columns)
+		if err != nil {
+			return nil, err
+		}
+		p.cursor, p.sort, p.desc = cursor, cursor.Sort, cursor.Desc
+		if p.count == "" {
+			p.count = CountNone
+		}
+	} else if page > 1 {
+		p.offset = (page - 1) * limit
+	}
+
+	switch p.count {
+	case "":
+		p.count = CountExact
+	case CountExact, CountEstimated, CountNone:
+	default:
+		return nil, fmt.Errorf("invalid count mode")
+	}
+
+	p.column = columns[p.sort]
+	return p, nil
+}
+
+// backward reports whether the page is read backwards from its cursor
+func (p *keysetPage) backward() bool {
+	return p.cursor != nil && p.cursor.Before
+}
+
+// where returns the condition keeping the rows on the page's side of the
+// cursor, or an empty string without a cursor
+func (p *keysetPage) where(args *[]interface{}) string {
+	if p.cursor == nil {
+		return ""
+	}
+	op := ">"
+	if p.desc != p.cursor.Before {
+		op = "<"
+	}
+	return fmt.Sprintf("(%s, id) %s (%s::%s, %s::uuid)",
+		p.column.expr, op, addQueryArg(args, p.cursor.Value), p.column.pgType, addQueryArg(args, p.cursor.ID))
+}
+
+// orderBy returns the ORDER BY and LIMIT clauses of the page's query
+func (p *keysetPage) orderBy(args *[]interface{}) string {
+	dir := "ASC"
+	if p.desc != p.backward() {
+		dir = "DESC"
+	}
+	return fmt.Sprintf("ORDER BY %s %s, id %s LIMIT %s OFFSET %s",
+		p.column.expr, dir, dir, addQueryArg(args, p.limit+1), addQueryArg(args, p.offset))
+}
+
+// trim returns how many of the fetched rows belong to the page and whether
+// more rows follow in the direction read. Rows read backwards must then be
+// reversed by the caller.
+func (p *keysetPage) trim(fetched int) (int, bool) {
+	if fetched > p.limit {
+		return p.limit, true
+	}
+	return fetched, false
+}
+
+// cursors returns the cursors of the pages around this one. first and last
+// are the sort values and ids of the page's first and last rows; an empty
+// page passes nil values.
+func (p *keysetPage) cursors(more bool, firstValue interface{}, firstID uuid.UUID, lastValue interface{}, lastID uuid.UUID) CursorPage {
+	page := CursorPage{Count: p.count}
+	at := func(value interface{}, id uuid.UUID, before bool) string {
+		return encodeCursor(pageCursor{Sort: p.sort, Desc: p.desc, Value: value, ID: id, Before: before})
+	}
+
+	if firstValue == nil {
+		// An empty page past either end still leads back to the cursor's row
+		if p.cursor != nil {
+			if p.cursor.Before {
+				page.NextCursor = at(p.cursor.Value, p.cursor.ID, false)
+			} else {
+				page.PrevCursor = at(p.cursor.Value, p.cursor.ID, true)
+			}
+		}
+		return page
+	}
+
+	hasNext, hasPrev := more, p.cursor != nil || p.offset > 0
+	if p.backward() {
+		hasNext, hasPrev = true, more
+	}
+	if hasNext {
+		page.NextCursor = at(lastValue, lastID, false)
+	}
+	if hasPrev {
+		page.PrevCursor = at(firstValue, firstID, true)
+	}
+	return page
+}
+
+// countRows counts the rows of table matching whereClause in the page's count
+// mode. The estimate is the planner's row estimate for the query.
+func (p *keysetPage) countRows(ctx context.Context, q querier, table, whereClause string, args []interface{}) (int64, error) {
+	switch p.count {
+	case CountNone:
+		return 0, nil
+	case CountEstimated:
+		var plan []byte
+		if err := q.QueryRow(ctx, fmt.Sprintf("EXPLAIN (FORMAT JSON) SELECT 1 FROM %s %s", table, whereClause), args...).Scan(&plan); err != nil {
+			return 0, err
+		}
+		var explained []struct {
+			Plan struct {
+				Rows float64 `json:"Plan Rows"`
+			} `json:"Plan"`
+		}
+		if err := json.Unmarshal(plan, &explained); err != nil || len(explained) == 0 {
+			return 0, fmt.Errorf("failed to read query plan: %v", err)
+		}
+		return int64(explained[0].Plan.Rows), nil
+	}
+
+	var total int64
+	err := q.QueryRow(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s %s", table, whereClause), args...).Scan(&total)
+	return total, err
+}
+
+// totalPages returns the number of pages a counted total fills
+func (p *keysetPage) totalPages(total int64) int {
+	if p.count == CountNone {
+		return 0
+	}
+	return int((total + int64(p.limit) - 1) / int64(p.limit))
+}
+
+// pageNumber returns the page number reported with the page; pages read by
+// cursor have none
+func (p *keysetPage) pageNumber() int {
+	if p.cursor != nil {
+		return 0
+	}
+	return p.offset/p.limit + 1
+}
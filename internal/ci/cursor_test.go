@@ -0,0 +1,124 @@
+package ci
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPageCursorRoundTrip(t *testing.T) {
+	id := uuid.New()
+	created := time.Date(2024, 3, 1, 10, 0, 0, 123456000, time.UTC)
+
+	cursor, err := decodeCursor(encodeCursor(pageCursor{Sort: "created_at", Desc: true, Value: created, ID: id}), ciKeysetColumns)
+	require.NoError(t, err)
+	assert.Equal(t, &pageCursor{Sort: "created_at", Desc: true, Value: created, ID: id}, cursor)
+
+	performer := uuid.New()
+	cursor, err = decodeCursor(encodeCursor(pageCursor{Sort: "performed_by", Value: performer, ID: id, Before: true}), auditKeysetColumns)
+	require.NoError(t, err)
+	assert.Equal(t, performer, cursor.Value)
+	assert.True(t, cursor.Before)
+
+	for _, token := range []string{
+		"not base64!",
+		"e30", // {}
+		encodeCursor(pageCursor{Sort: "name", Value: "web-01", ID: id}) + "x",
+		encodeCursor(pageCursor{Sort: "performed_by", Value: "web-01", ID: id}),
+		encodeCursor(pageCursor{Sort: "created_at", Value: 12, ID: id}),
+	} {
+		_, err := decodeCursor(token, auditKeysetColumns)
+		assert.EqualError(t, err, "invalid cursor", token)
+	}
+
+	// A cursor only fits the listing it came from
+	_, err = decodeCursor(encodeCursor(pageCursor{Sort: "name", Value: "web-01", ID: id}), relationshipKeysetColumns)
+	assert.EqualError(t, err, "invalid cursor")
+}
+
+func TestKeysetPage(t *testing.T) {
+	// Page numbers read with OFFSET and count exactly by default
+	page, err := newKeysetPage(ciKeysetColumns, "name", false, 3, 20, "", "")
+	require.NoError(t, err)
+	args := []interface{}{"Server"}
+	assert.Empty(t, page.where(&args))
+	assert.Equal(t, "ORDER BY name ASC, id ASC LIMIT $2 OFFSET $3", page.orderBy(&args))
+	assert.Equal(t, []interface{}{"Server", 21, 40}, args)
+	assert.Equal(t, CountExact, page.count)
+	assert.Equal(t, 3, page.pageNumber())
+	assert.Equal(t, 5, page.totalPages(81))
+
+	_, err = newKeysetPage(ciKeysetColumns, "name", false, 1, 20, "", "roughly")
+	assert.EqualError(t, err, "invalid count mode")
+
+	// A cursor replaces the sort and order and skips counting by default
+	id := uuid.New()
+	token := encodeCursor(pageCursor{Sort: "created_at", Desc: true, Value: time.Now(), ID: id})
+	page, err = newKeysetPage(ciKeysetColumns, "name", false, 3, 20, token, "")
+	require.NoError(t, err)
+	args = nil
+	assert.Equal(t, "(created_at, id) < ($1::timestamptz, $2::uuid)", page.where(&args))
+	assert.Equal(t, "ORDER BY created_at DESC, id DESC LIMIT $3 OFFSET $4", page.orderBy(&args))
+	assert.Equal(t, 0, args[3])
+	assert.Equal(t, CountNone, page.count)
+	assert.Equal(t, 0, page.pageNumber())
+	assert.Equal(t, 0, page.totalPages(0))
+
+	// Going back reads the other way from the cursor
+	token = encodeCursor(pageCursor{Sort: "created_at", Desc: true, Value: time.Now(), ID: id, Before: true})
+	page, err = newKeysetPage(ciKeysetColumns, "", false, 1, 20, token, CountEstimated)
+	require.NoError(t, err)
+	args = nil
+	assert.True(t, page.backward())
+	assert.Equal(t, "(created_at, id) > ($1::timestamptz, $2::uuid)", page.where(&args))
+	assert.Equal(t, "ORDER BY created_at ASC, id ASC LIMIT $3 OFFSET $4", page.orderBy(&args))
+	assert.Equal(t, CountEstimated, page.count)
+}
+
+func TestKeysetPageCursors(t *testing.T) {
+	first, last := uuid.New(), uuid.New()
+	decode := func(token string) *pageCursor {
+		require.NotEmpty(t, token)
+		cursor, err := decodeCursor(token, ciKeysetColumns)
+		require.NoError(t, err)
+		return cursor
+	}
+
+	// The first page has no previous page, and a next page only if more rows follow
+	page, err := newKeysetPage(ciKeysetColumns, "name", false, 1, 2, "", "")
+	require.NoError(t, err)
+	n, more := page.trim(3)
+	assert.Equal(t, 2, n)
+	assert.True(t, more)
+	cursors := page.cursors(more, "a", first, "b", last)
+	assert.Empty(t, cursors.PrevCursor)
+	assert.Equal(t, &pageCursor{Sort: "name", Value: "b", ID: last}, decode(cursors.NextCursor))
+
+	_, more = page.trim(2)
+	assert.Empty(t, page.cursors(more, "a", first, "b", last).NextCursor)
+
+	// Following the next cursor leads back with the previous cursor
+	page, err = newKeysetPage(ciKeysetColumns, "", false, 1, 2, cursors.NextCursor, "")
+	require.NoError(t, err)
+	cursors = page.cursors(false, "c", first, "d", last)
+	assert.Empty(t, cursors.NextCursor)
+	assert.Equal(t, &pageCursor{Sort: "name", Value: "c", ID: first, Before: true}, decode(cursors.PrevCursor))
+
+	// Read backwards, there is always a next page and a previous one if more rows follow
+	page, err = newKeysetPage(ciKeysetColumns, "", false, 1, 2, cursors.PrevCursor, "")
+	require.NoError(t, err)
+	cursors = page.cursors(false, "a", first, "b", last)
+	assert.Empty(t, cursors.PrevCursor)
+	assert.NotEmpty(t, cursors.NextCursor)
+
+	// An empty page past the end leads back to the cursor's row
+	token := encodeCursor(pageCursor{Sort: "name", Value: "z", ID: last})
+	page, err = newKeysetPage(ciKeysetColumns, "", false, 1, 2, token, "")
+	require.NoError(t, err)
+	cursors = page.cursors(false, nil, uuid.Nil, nil, uuid.Nil)
+	assert.Empty(t, cursors.NextCursor)
+	assert.Equal(t, &pageCursor{Sort: "name", Value: "z", ID: last, Before: true}, decode(cursors.PrevCursor))
+}
@@ -0,0 +1,36 @@
+package ci
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pustakaLogger "github.com/pustaka/pustaka/pkg/logger"
+)
+
+func TestDeleteCIChecksRelationshipsUnderLock(t *testing.T) {
+	tx := &ciRowTx{version: 1}
+	ctx := context.WithValue(context.Background(), txContextKey{}, pgx.Tx(tx))
+	service := NewService(NewRepository(nil, pustakaLogger.Default()), nil, nil, nil, pustakaLogger.Default())
+
+	err := service.DeleteCI(ctx, uuid.New(), uuid.New())
+
+	assert.EqualError(t, err, "cannot delete CI with existing relationships")
+	lock, check := -1, -1
+	for i, statement := range tx.statements {
+		assert.NotContains(t, statement, "DELETE")
+		if strings.Contains(statement, "FOR NO KEY UPDATE") {
+			lock = i
+		}
+		if strings.Contains(statement, "FROM relationships") {
+			check = i
+		}
+	}
+	require.NotEqual(t, -1, lock)
+	assert.Greater(t, check, lock)
+}
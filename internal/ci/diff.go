@@ -0,0 +1,174 @@
+package ci
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Change operations recorded in FieldChange.Op
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// FieldChange describes a single difference between two states of an entity.
+// Path is dot-separated and descends into nested objects, e.g.
+// "attributes.network.ip_address".
+type FieldChange struct {
+	Path     string      `json:"path"`
+	Op       string      `json:"op"`
+	OldValue interface{} `json:"old_value,omitempty"`
+	NewValue interface{} `json:"new_value,omitempty"`
+}
+
+// auditIgnoredFields are bookkeeping fields that change on every write and
+// would only add noise to a diff
+var auditIgnoredFields = map[string]bool{
+	"created_at": true,
+	"updated_at": true,
+	"updated_by": true,
+	"version":    true,
+}
+
+// DiffStates compares two states of an entity and returns the added, removed
+// and changed fields. Either state may be nil, in which case every field of the
+// other is reported as added or removed. Objects are compared key by key at any
+// depth, so changes are always reported against leaf paths; arrays and scalars
+// are compared as whole values.
+func DiffStates(before, after interface{}) ([]FieldChange, error) {
+	beforeMap, err := toStateMap(before)
+	if err != nil {
+		return nil, err
+	}
+	afterMap, err := toStateMap(after)
+	if err != nil {
+		return nil, err
+	}
+
+	for field := range auditIgnoredFields {
+		delete(beforeMap, field)
+		delete(afterMap, field)
+	}
+
+	var changes []FieldChange
+	diffMaps("", beforeMap, afterMap, &changes)
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes, nil
+}
+
+// ChangedPaths returns every path touched by changes together with its parent
+// paths, so that a change to "attributes.network.ip" can also be found by
+// looking for "attributes.network" or "attributes"
+func ChangedPaths(changes []FieldChange) []string {
+	seen := make(map[string]bool)
+	var paths []string
+
+	for _, change := range changes {
+		path := change.Path
+		for {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+			idx := strings.LastIndexByte(path, '.')
+			if idx == -1 {
+				break
+			}
+			path = path[:idx]
+		}
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+// withChangeDetails adds the diff between before and after to an audit
+// details map under "changes", along with the flattened "changed_paths" used to
+// query the audit trail by field
+func withChangeDetails(details map[string]interface{}, before, after interface{}) (map[string]interface{}, error) {
+	changes, err := DiffStates(before, after)
+	if err != nil {
+		return nil, err
+	}
+	if changes == nil {
+		changes = []FieldChange{}
+	}
+
+	if details == nil {
+		details = make(map[string]interface{})
+	}
+	details["changes"] = changes
+	details["changed_paths"] = ChangedPaths(changes)
+
+	return details, nil
+}
+
+func diffMaps(prefix string, before, after map[string]interface{}, changes *[]FieldChange) {
+	for key, oldValue := range before {
+		path := joinPath(prefix, key)
+		newValue, exists := after[key]
+		oldObject, oldIsObject := oldValue.(map[string]interface{})
+		if !exists {
+			if oldIsObject && len(oldObject) > 0 {
+				diffMaps(path, oldObject, map[string]interface{}{}, changes)
+				continue
+			}
+			*changes = append(*changes, FieldChange{Path: path, Op: ChangeRemoved, OldValue: oldValue})
+			continue
+		}
+
+		newObject, newIsObject := newValue.(map[string]interface{})
+		if oldIsObject && newIsObject {
+			diffMaps(path, oldObject, newObject, changes)
+			continue
+		}
+
+		if !reflect.DeepEqual(oldValue, newValue) {
+			*changes = append(*changes, FieldChange{Path: path, Op: ChangeChanged, OldValue: oldValue, NewValue: newValue})
+		}
+	}
+
+	for key, newValue := range after {
+		if _, exists := before[key]; !exists {
+			if newObject, ok := newValue.(map[string]interface{}); ok && len(newObject) > 0 {
+				diffMaps(joinPath(prefix, key), map[string]interface{}{}, newObject, changes)
+				continue
+			}
+			*changes = append(*changes, FieldChange{Path: joinPath(prefix, key), Op: ChangeAdded, NewValue: newValue})
+		}
+	}
+}
+
+// toStateMap converts an entity into its generic JSON representation so that
+// diffs use the same field names and value types the API exposes
+func toStateMap(state interface{}) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	if state == nil || (reflect.ValueOf(state).Kind() == reflect.Ptr && reflect.ValueOf(state).IsNil()) {
+		return result, nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal state for diff: %w", err)
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state for diff: %w", err)
+	}
+
+	return result, nil
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
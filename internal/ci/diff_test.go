@@ -0,0 +1,86 @@
+package ci
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffStates(t *testing.T) {
+	before := map[string]interface{}{
+		"name": "web-01",
+		"attributes": map[string]interface{}{
+			"ip_address": "10.0.0.5",
+			"cpu_cores":  4,
+			"network": map[string]interface{}{
+				"vlan":    10,
+				"gateway": "10.0.0.1",
+			},
+		},
+		"tags":       []string{"web"},
+		"updated_at": "2024-01-01T00:00:00Z",
+	}
+	after := map[string]interface{}{
+		"name": "web-01",
+		"attributes": map[string]interface{}{
+			"ip_address": "10.0.0.6",
+			"network": map[string]interface{}{
+				"vlan": 10,
+				"mtu":  9000,
+			},
+		},
+		"tags":       []string{"web", "prod"},
+		"updated_at": "2024-02-01T00:00:00Z",
+	}
+
+	changes, err := DiffStates(before, after)
+	require.NoError(t, err)
+
+	assert.Equal(t, []FieldChange{
+		{Path: "attributes.cpu_cores", Op: ChangeRemoved, OldValue: float64(4)},
+		{Path: "attributes.ip_address", Op: ChangeChanged, OldValue: "10.0.0.5", NewValue: "10.0.0.6"},
+		{Path: "attributes.network.gateway", Op: ChangeRemoved, OldValue: "10.0.0.1"},
+		{Path: "attributes.network.mtu", Op: ChangeAdded, NewValue: float64(9000)},
+		{Path: "tags", Op: ChangeChanged, OldValue: []interface{}{"web"}, NewValue: []interface{}{"web", "prod"}},
+	}, changes)
+
+	assert.Equal(t, []string{
+		"attributes",
+		"attributes.cpu_cores",
+		"attributes.ip_address",
+		"attributes.network",
+		"attributes.network.gateway",
+		"attributes.network.mtu",
+		"tags",
+	}, ChangedPaths(changes))
+}
+
+func TestDiffStatesCreateAndDelete(t *testing.T) {
+	ci := &ConfigurationItem{
+		ID:         uuid.New(),
+		Name:       "db-01",
+		CIType:     "Database",
+		Attributes: map[string]interface{}{"engine": "postgres"},
+	}
+
+	created, err := DiffStates(nil, ci)
+	require.NoError(t, err)
+	for _, change := range created {
+		assert.Equal(t, ChangeAdded, change.Op)
+	}
+	assert.Contains(t, created, FieldChange{Path: "attributes.engine", Op: ChangeAdded, NewValue: "postgres"})
+
+	var missing *ConfigurationItem
+	deleted, err := DiffStates(ci, missing)
+	require.NoError(t, err)
+	for _, change := range deleted {
+		assert.Equal(t, ChangeRemoved, change.Op)
+	}
+	assert.Contains(t, deleted, FieldChange{Path: "name", Op: ChangeRemoved, OldValue: "db-01"})
+
+	unchanged, err := DiffStates(ci, ci)
+	require.NoError(t, err)
+	assert.Empty(t, unchanged)
+}
@@ -0,0 +1,166 @@
+package ci
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats
+const (
+	ExportFormatCSV    = "csv"
+	ExportFormatJSON   = "json"
+	ExportFormatNDJSON = "ndjson"
+)
+
+// exportBatchSize is how many rows an export fetches from its cursor at a time
+const exportBatchSize = 1000
+
+// Fixed CSV columns of CI and relationship exports. Attribute columns, named
+// after the attribute, go between the identifying columns and the metadata.
+var (
+	ciExportColumns           = []string{"id", "name", "ci_type", "tags"}
+	ciExportMetaColumns       = []string{"version", "created_by", "created_at", "updated_at"}
+	relationshipExportColumns = []string{"id", "source_id", "target_id", "relationship_type"}
+	relationshipExportMeta    = []string{"version", "created_by", "created_at", "updated_at"}
+)
+
+// ValidExportFormat reports whether format is csv, json or ndjson
+func ValidExportFormat(format string) bool {
+	return format == ExportFormatCSV || format == ExportFormatJSON || format == ExportFormatNDJSON
+}
+
+// exportWriter writes records to out in one export format: CSV with a header
+// row, a JSON array, or newline-delimited JSON
+type exportWriter struct {
+	format  string
+	out     io.Writer
+	csv     *csv.Writer
+	json    *json.Encoder
+	records int
+}
+
+func newExportWriter(format string, out io.Writer, header []string) (*exportWriter, error) {
+	e := &exportWriter{format: format, out: out}
+	switch format {
+	case ExportFormatCSV:
+		e.csv = csv.NewWriter(out)
+		if err := e.csv.Write(header); err != nil {
+			return nil, err
+		}
+	case ExportFormatJSON:
+		e.json = json.NewEncoder(out)
+		if _, err := io.WriteString(out, "["); err != nil {
+			return nil, err
+		}
+	case ExportFormatNDJSON:
+		e.json = json.NewEncoder(out)
+	default:
+		return nil, fmt.Errorf("unknown export format '%s'", format)
+	}
+	return e, nil
+}
+
+// write writes one record: the record itself as JSON, or the cells returned
+// by row as CSV
+func (e *exportWriter) write(record interface{}, row func() []string) error {
+	defer func() { e.records++ }()
+
+	if e.csv != nil {
+		return e.csv.Write(row())
+	}
+	if e.format == ExportFormatJSON && e.records > 0 {
+		if _, err := io.WriteString(e.out, ","); err != nil {
+			return err
+		}
+	}
+	return e.json.Encode(record)
+}
+
+// close finishes the output
+func (e *exportWriter) close() error {
+	switch e.format {
+	case ExportFormatCSV:
+		e.csv.Flush()
+		return e.csv.Error()
+	case ExportFormatJSON:
+		_, err := io.WriteString(e.out, "]\n")
+		return err
+	}
+	return nil
+}
+
+// exportAttributeNames lists the attributes of the given schemas, required
+// before optional, without repeats
+func exportAttributeNames(schemas ...[]AttributeDefinition) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, attrs := range schemas {
+		for _, attr := range attrs {
+			if !seen[attr.Name] {
+				seen[attr.Name] = true
+				names = append(names, attr.Name)
+			}
+		}
+	}
+	return names
+}
+
+// ciExportRow flattens a CI into CSV cells, one per attribute column
+func ciExportRow(item *ConfigurationItem, attributes []string) []string {
+	row := make([]string, 0, len(ciExportColumns)+len(attributes)+len(ciExportMetaColumns))
+	row = append(row, item.ID.String(), item.Name, item.CIType, strings.Join(item.Tags, ","))
+	for _, name := range attributes {
+		row = append(row, exportCell(item.Attributes[name]))
+	}
+	return append(row,
+		strconv.Itoa(item.Version),
+		item.CreatedBy.String(),
+		item.CreatedAt.UTC().Format(time.RFC3339),
+		item.UpdatedAt.UTC().Format(time.RFC3339),
+	)
+}
+
+// relationshipExportRow flattens a relationship into CSV cells, one per
+// attribute column
+func relationshipExportRow(rel *Relationship, attributes []string) []string {
+	row := make([]string, 0, len(relationshipExportColumns)+len(attributes)+len(relationshipExportMeta))
+	row = append(row, rel.ID.String(), rel.SourceID.String(), rel.TargetID.String(), rel.RelationshipType)
+	for _, name := range attributes {
+		row = append(row, exportCell(rel.Attributes[name]))
+	}
+	updatedAt := ""
+	if rel.UpdatedAt != nil {
+		updatedAt = rel.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+	return append(row,
+		strconv.Itoa(rel.Version),
+		rel.CreatedBy.String(),
+		rel.CreatedAt.UTC().Format(time.RFC3339),
+		updatedAt,
+	)
+}
+
+// exportCell renders an attribute value the way a CSV import reads it back:
+// scalars as text and arrays and objects as JSON
+func exportCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
@@ -0,0 +1,129 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Streaming exports
+
+// StreamCIs passes every CI matching filters to fn, in list order
+func (r *Repository) StreamCIs(ctx context.Context, filters ListCIFilters, fn func(*ConfigurationItem) error) error {
+	whereClause, args, orderBy := ciListQuery(filters)
+	query := fmt.Sprintf("SELECT %s FROM configuration_items %s %s, id", ciColumns, whereClause, orderBy)
+
+	return r.streamQuery(ctx, "configuration_items", query, args, func(rows pgx.Rows) error {
+		var ci ConfigurationItem
+		if err := scanCI(rows, &ci); err != nil {
+			return fmt.Errorf("failed to scan CI: %w", err)
+		}
+		return fn(&ci)
+	})
+}
+
+// StreamRelationships passes every relationship matching filters to fn, in list order
+func (r *Repository) StreamRelationships(ctx context.Context, filters ListRelationshipFilters, fn func(*Relationship) error) error {
+	whereClause, args := relationshipListQuery(filters)
+	query := fmt.Sprintf("SELECT %s FROM relationships %s ORDER BY created_at DESC, id", relationshipColumns, whereClause)
+
+	return r.streamQuery(ctx, "relationships", query, args, func(rows pgx.Rows) error {
+		var rel Relationship
+		if err := scanRelationship(rows, &rel); err != nil {
+			return fmt.Errorf("failed to scan relationship: %w", err)
+		}
+		return fn(&rel)
+	})
+}
+
+// streamQuery reads the result of query through a server-side cursor, a batch
+// at a time, and passes each row to fn, so a result of any size is never held
+// in memory at once. The read-only, repeatable read transaction gives the
+// whole stream one snapshot.
+func (r *Repository) streamQuery(ctx context.Context, table, query string, args []interface{}, fn func(pgx.Rows) error) error {
+	err := r.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := r.conn(ctx).Exec(ctx, "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY"); err != nil {
+			return err
+		}
+		if _, err := r.conn(ctx).Exec(ctx, "DECLARE export_cursor NO SCROLL CURSOR FOR "+query, args...); err != nil {
+			return err
+		}
+
+		fetch := fmt.Sprintf("FETCH %d FROM export_cursor", exportBatchSize)
+		for {
+			rows, err := r.conn(ctx).Query(ctx, fetch)
+			if err != nil {
+				return err
+			}
+			fetched := 0
+			for rows.Next() {
+				fetched++
+				if err := fn(rows); err != nil {
+					rows.Close()
+					return err
+				}
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			if fetched < exportBatchSize {
+				return nil
+			}
+		}
+	})
+	if err != nil && ctx.Err() == nil {
+		r.logger.ErrorDatabase("SELECT", table, err, nil)
+	}
+	return err
+}
+
+// ListCITypeNames returns the names of all CI types, sorted
+func (r *Repository) ListCITypeNames(ctx context.Context) ([]string, error) {
+	rows, err := r.conn(ctx).Query(ctx, "SELECT name FROM ci_type_definitions ORDER BY name")
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "ci_type_definitions", err, nil)
+		return nil, fmt.Errorf("failed to list CI type names: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan CI type name: %w", err)
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// ListRelationshipTypeSchemas returns the attribute schemas of the named
+// relationship type, or of every relationship type when name is empty, with
+// required before optional attributes for each type
+func (r *Repository) ListRelationshipTypeSchemas(ctx context.Context, name string) ([][]AttributeDefinition, error) {
+	query := `
+		SELECT required_attributes, optional_attributes
+		FROM relationship_type_definitions
+		WHERE $1 = '' OR name = $1
+		ORDER BY name
+	`
+
+	rows, err := r.conn(ctx).Query(ctx, query, name)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "relationship_type_definitions", err, nil)
+		return nil, fmt.Errorf("failed to list relationship type schemas: %w", err)
+	}
+	defer rows.Close()
+
+	var schemas [][]AttributeDefinition
+	for rows.Next() {
+		var required, optional []AttributeDefinition
+		if err := rows.Scan(&required, &optional); err != nil {
+			return nil, fmt.Errorf("failed to scan relationship type schema: %w", err)
+		}
+		schemas = append(schemas, required, optional)
+	}
+	return schemas, rows.Err()
+}
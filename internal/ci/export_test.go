@@ -0,0 +1,93 @@
+package ci
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exportTestCI() *ConfigurationItem {
+	created := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	return &ConfigurationItem{
+		ID:     uuid.MustParse("550e8400-e29b-41d4-a716-446655440002"),
+		Name:   "web-01",
+		CIType: "Server",
+		Attributes: map[string]interface{}{
+			"hostname":  "web-01.example.com",
+			"cpu_cores": float64(8),
+			"disks":     []interface{}{"sda"},
+		},
+		Tags:      []string{"web", "prod"},
+		Version:   3,
+		CreatedBy: uuid.MustParse("550e8400-e29b-41d4-a716-446655440000"),
+		CreatedAt: created,
+		UpdatedAt: created,
+	}
+}
+
+func TestExportWriter(t *testing.T) {
+	item := exportTestCI()
+	attributes := exportAttributeNames(importTestType().RequiredAttributes, importTestType().OptionalAttributes)
+	require.Equal(t, []string{"hostname", "cpu_cores", "disks"}, attributes)
+
+	header := append(append(append([]string{}, ciExportColumns...), attributes...), ciExportMetaColumns...)
+	row := func() []string { return ciExportRow(item, attributes) }
+
+	var out bytes.Buffer
+	writer, err := newExportWriter(ExportFormatCSV, &out, header)
+	require.NoError(t, err)
+	require.NoError(t, writer.write(item, row))
+	require.NoError(t, writer.close())
+	assert.Equal(t,
+		"id,name,ci_type,tags,hostname,cpu_cores,disks,version,created_by,created_at,updated_at\n"+
+			"550e8400-e29b-41d4-a716-446655440002,web-01,Server,\"web,prod\",web-01.example.com,8,\"[\"\"sda\"\"]\",3,"+
+			"550e8400-e29b-41d4-a716-446655440000,2024-01-15T10:30:00Z,2024-01-15T10:30:00Z\n",
+		out.String())
+
+	// An exported file reads back as an import
+	file, errors := parseCIImportFile(out.Bytes(), nil, importTestType())
+	require.Empty(t, errors)
+	imported := file.row(0, importTestType())
+	assert.Empty(t, imported.Errors)
+	assert.Equal(t, item.Attributes, imported.Attributes)
+	assert.Equal(t, item.Tags, imported.Tags)
+
+	out.Reset()
+	writer, err = newExportWriter(ExportFormatJSON, &out, header)
+	require.NoError(t, err)
+	require.NoError(t, writer.close())
+	assert.Equal(t, "[]\n", out.String())
+
+	out.Reset()
+	writer, err = newExportWriter(ExportFormatJSON, &out, header)
+	require.NoError(t, err)
+	require.NoError(t, writer.write(map[string]int{"a": 1}, row))
+	require.NoError(t, writer.write(map[string]int{"a": 2}, row))
+	require.NoError(t, writer.close())
+	assert.JSONEq(t, `[{"a":1},{"a":2}]`, out.String())
+
+	out.Reset()
+	writer, err = newExportWriter(ExportFormatNDJSON, &out, header)
+	require.NoError(t, err)
+	require.NoError(t, writer.write(map[string]int{"a": 1}, row))
+	require.NoError(t, writer.write(map[string]int{"a": 2}, row))
+	require.NoError(t, writer.close())
+	assert.Equal(t, "{\"a\":1}\n{\"a\":2}\n", out.String())
+	assert.Equal(t, 2, writer.records)
+
+	_, err = newExportWriter("xml", &out, header)
+	assert.Error(t, err)
+}
+
+func TestExportCell(t *testing.T) {
+	assert.Equal(t, "", exportCell(nil))
+	assert.Equal(t, "text", exportCell("text"))
+	assert.Equal(t, "2.5", exportCell(2.5))
+	assert.Equal(t, "1000000", exportCell(float64(1000000)))
+	assert.Equal(t, "true", exportCell(true))
+	assert.Equal(t, `{"k":"v"}`, exportCell(map[string]interface{}{"k": "v"}))
+}
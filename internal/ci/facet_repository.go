@@ -0,0 +1,55 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// CountCIFacets counts the values of each facet among the CIs matching
+// filters, keeping the limit most common values of each. Every facet has an
+// entry, empty when no listed CI has a value for it.
+func (r *Repository) CountCIFacets(ctx context.Context, filters ListCIFilters, facets []ciFacet, limit int) (map[string][]FacetCount, error) {
+	counts := make(map[string][]FacetCount, len(facets))
+	if len(facets) == 0 {
+		return counts, nil
+	}
+	for _, facet := range facets {
+		counts[facet.Name] = []FacetCount{}
+	}
+
+	whereClause, args, _ := ciListQuery(filters)
+	selects := make([]string, len(facets))
+	for i, facet := range facets {
+		selects[i] = facet.sql(&args)
+	}
+	query := fmt.Sprintf(`
+		WITH matched AS (
+			SELECT ci_type, tags, created_by, attributes FROM configuration_items %s
+		)
+		SELECT facet, value, count FROM (
+			SELECT facet, value, count,
+				ROW_NUMBER() OVER (PARTITION BY facet ORDER BY count DESC, value) AS rank
+			FROM (%s) AS facet_counts
+		) AS ranked
+		WHERE rank <= %s
+		ORDER BY facet, rank
+	`, whereClause, strings.Join(selects, " UNION ALL "), addQueryArg(&args, limit))
+
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "configuration_items", err, nil)
+		return nil, fmt.Errorf("failed to count CI facets: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var facet string
+		var count FacetCount
+		if err := rows.Scan(&facet, &count.Value, &count.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan CI facet count: %w", err)
+		}
+		counts[facet] = append(counts[facet], count)
+	}
+	return counts, rows.Err()
+}
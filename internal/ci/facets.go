@@ -0,0 +1,114 @@
+package ci
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Facet counts of CI listings
+
+// Facets that can always be counted; enum attributes of the listed CI types
+// are counted as attributes.<name>
+const (
+	FacetCIType     = "ci_type"
+	FacetTags       = "tags"
+	FacetCreatedBy  = "created_by"
+	FacetAttributes = "attributes"
+)
+
+// Bounds of the number of values counted per facet
+const (
+	DefaultFacetLimit = 20
+	MaxFacetLimit     = 100
+)
+
+// FacetCount is the number of listed CIs with one value of a facet
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// ciFacet is a facet to count: a built-in field, or an enum attribute
+type ciFacet struct {
+	Name      string
+	Attribute string
+}
+
+// planCIFacets resolves requested facet names against the CI types in scope.
+// "attributes" stands for every enum attribute of those types.
+func planCIFacets(requested []string, scope []*CITypeDefinition) ([]ciFacet, []ValidationError) {
+	enums := map[string]bool{}
+	for _, ciType := range scope {
+		for _, attrs := range [][]AttributeDefinition{ciType.RequiredAttributes, ciType.OptionalAttributes} {
+			for _, attr := range attrs {
+				if attr.Validation != nil && len(attr.Validation.Enum) > 0 {
+					enums[attr.Name] = true
+				}
+			}
+		}
+	}
+
+	var facets []ciFacet
+	var errors []ValidationError
+	seen := map[string]bool{}
+	add := func(facet ciFacet) {
+		if !seen[facet.Name] {
+			seen[facet.Name] = true
+			facets = append(facets, facet)
+		}
+	}
+
+	for _, name := range requested {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+		case name == FacetCIType, name == FacetTags, name == FacetCreatedBy:
+			add(ciFacet{Name: name})
+		case name == FacetAttributes:
+			attrs := make([]string, 0, len(enums))
+			for attr := range enums {
+				attrs = append(attrs, attr)
+			}
+			sort.Strings(attrs)
+			for _, attr := range attrs {
+				add(ciFacet{Name: FacetAttributes + "." + attr, Attribute: attr})
+			}
+		case strings.HasPrefix(name, FacetAttributes+"."):
+			attr := strings.TrimPrefix(name, FacetAttributes+".")
+			if !enums[attr] {
+				errors = append(errors, ValidationError{
+					Field:   "facets",
+					Message: fmt.Sprintf("attribute '%s' is not an enum attribute of the listed CI types", attr),
+				})
+				continue
+			}
+			add(ciFacet{Name: name, Attribute: attr})
+		default:
+			errors = append(errors, ValidationError{
+				Field:   "facets",
+				Message: fmt.Sprintf("unknown facet '%s'", name),
+			})
+		}
+	}
+	return facets, errors
+}
+
+// sql returns the query counting the facet's values among the matched CIs
+func (f ciFacet) sql(args *[]interface{}) string {
+	name := addQueryArg(args, f.Name)
+	switch f.Name {
+	case FacetCIType:
+		return fmt.Sprintf("SELECT %s::text AS facet, ci_type AS value, COUNT(*) AS count FROM matched GROUP BY ci_type", name)
+	case FacetTags:
+		return fmt.Sprintf("SELECT %s::text AS facet, tag AS value, COUNT(*) AS count FROM matched CROSS JOIN LATERAL unnest(tags) AS tag GROUP BY tag", name)
+	case FacetCreatedBy:
+		return fmt.Sprintf("SELECT %s::text AS facet, created_by::text AS value, COUNT(*) AS count FROM matched WHERE created_by IS NOT NULL GROUP BY created_by", name)
+	}
+	// Array attributes count each of their elements
+	key := addQueryArg(args, f.Attribute)
+	return fmt.Sprintf(`SELECT %s::text AS facet, value, COUNT(*) AS count FROM matched
+		CROSS JOIN LATERAL jsonb_array_elements_text(CASE WHEN jsonb_typeof(attributes -> %s::text) = 'array'
+			THEN attributes -> %s::text ELSE jsonb_build_array(attributes -> %s::text) END) AS value
+		WHERE value IS NOT NULL GROUP BY value`, name, key, key, key)
+}
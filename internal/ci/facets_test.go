@@ -0,0 +1,51 @@
+package ci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanCIFacets(t *testing.T) {
+	server := &CITypeDefinition{
+		Name: "Server",
+		RequiredAttributes: []AttributeDefinition{
+			{Name: "os", Type: "string", Validation: &AttributeValidation{Enum: []string{"linux", "windows"}}},
+			{Name: "hostname", Type: "string"},
+		},
+		OptionalAttributes: []AttributeDefinition{
+			{Name: "environment", Type: "string", Validation: &AttributeValidation{Enum: []string{"prod", "test"}}},
+		},
+	}
+
+	facets, errors := planCIFacets([]string{"tags", " ci_type", "attributes", "attributes.os", "tags", ""}, []*CITypeDefinition{server})
+	require.Empty(t, errors)
+	assert.Equal(t, []ciFacet{
+		{Name: "tags"},
+		{Name: "ci_type"},
+		{Name: "attributes.environment", Attribute: "environment"},
+		{Name: "attributes.os", Attribute: "os"},
+	}, facets)
+
+	_, errors = planCIFacets([]string{"owner", "attributes.hostname", "attributes.os"}, []*CITypeDefinition{server})
+	require.Len(t, errors, 2)
+	assert.Equal(t, ValidationError{Field: "facets", Message: "unknown facet 'owner'"}, errors[0])
+	assert.Contains(t, errors[1].Message, "'hostname' is not an enum attribute")
+
+	// Attributes of types the listing cannot match are not facets
+	_, errors = planCIFacets([]string{"attributes.os"}, nil)
+	assert.Len(t, errors, 1)
+}
+
+func TestCIFacetSQL(t *testing.T) {
+	var args []interface{}
+	sql := ciFacet{Name: "tags"}.sql(&args)
+	assert.Contains(t, sql, "unnest(tags)")
+	assert.Equal(t, []interface{}{"tags"}, args)
+
+	sql = ciFacet{Name: "attributes.os", Attribute: "os"}.sql(&args)
+	assert.Contains(t, sql, "SELECT $2::text AS facet")
+	assert.Contains(t, sql, "jsonb_typeof(attributes -> $3::text) = 'array'")
+	assert.Equal(t, []interface{}{"tags", "attributes.os", "os"}, args)
+}
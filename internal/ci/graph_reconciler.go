@@ -0,0 +1,493 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	pustakaLogger "github.com/pustaka/pustaka/pkg/logger"
+)
+
+// Reconciliation modes
+const (
+	ReconcileModeCheck   = "check"
+	ReconcileModeRepair  = "repair"
+	ReconcileModeRebuild = "rebuild"
+)
+
+// reconcileSampleLimit caps how many IDs of each kind a report lists
+const reconcileSampleLimit = 100
+
+// Reconcile job statuses
+const (
+	ReconcileJobRunning   = "running"
+	ReconcileJobCompleted = "completed"
+	ReconcileJobFailed    = "failed"
+)
+
+// reconcileJobHistory caps how many jobs a reconciler remembers
+const reconcileJobHistory = 20
+
+// ReconcileOptions selects what a reconciliation run does. Check only reports,
+// repair fixes drift in batches, and rebuild wipes the graph and reloads it.
+type ReconcileOptions struct {
+	Mode      string `json:"mode"`
+	BatchSize int    `json:"batch_size"`
+}
+
+// ReconcileReport describes the drift found between PostgreSQL and Neo4j and
+// what was done about it
+type ReconcileReport struct {
+	Mode          string                `json:"mode"`
+	StartedAt     time.Time             `json:"started_at"`
+	FinishedAt    time.Time             `json:"finished_at"`
+	GraphCleared  int                   `json:"graph_cleared,omitempty"`
+	CIs           EntityReconcileReport `json:"cis"`
+	Relationships EntityReconcileReport `json:"relationships"`
+}
+
+// EntityReconcileReport compares one kind of entity. Missing entries exist in
+// PostgreSQL but not in the graph, orphaned entries exist only in the graph and
+// stale entries are older in the graph than in PostgreSQL. The ID lists are
+// capped samples; the counts are exact.
+type EntityReconcileReport struct {
+	PostgresCount int      `json:"postgres_count"`
+	GraphCount    int      `json:"graph_count"`
+	MissingCount  int      `json:"missing_count"`
+	OrphanedCount int      `json:"orphaned_count"`
+	StaleCount    int      `json:"stale_count"`
+	Missing       []string `json:"missing"`
+	Orphaned      []string `json:"orphaned"`
+	Stale         []string `json:"stale"`
+	Synced        int      `json:"synced"`
+	Deleted       int      `json:"deleted"`
+	Skipped       int      `json:"skipped"`
+}
+
+// HasDrift reports whether any missing, orphaned or stale entries were found
+func (r *ReconcileReport) HasDrift() bool {
+	for _, entity := range []EntityReconcileReport{r.CIs, r.Relationships} {
+		if entity.MissingCount+entity.OrphanedCount+entity.StaleCount > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ReconcileJob is a reconciliation started through the API. It runs in the
+// background, detached from the request that started it, and is kept in
+// memory so that its outcome can be polled.
+type ReconcileJob struct {
+	ID          uuid.UUID        `json:"id"`
+	Mode        string           `json:"mode"`
+	BatchSize   int              `json:"batch_size"`
+	Status      string           `json:"status"`
+	RequestedBy uuid.UUID        `json:"requested_by"`
+	StartedAt   time.Time        `json:"started_at"`
+	FinishedAt  *time.Time       `json:"finished_at,omitempty"`
+	Report      *ReconcileReport `json:"report,omitempty"`
+	Error       string           `json:"error,omitempty"`
+}
+
+// GraphReconciler compares PostgreSQL, the source of truth, with the Neo4j
+// graph and repairs or rebuilds the graph from it
+type GraphReconciler struct {
+	repo    *Repository
+	graph   *Neo4jRepository
+	audit   *AuditService
+	logger  *pustakaLogger.Logger
+	running sync.Mutex
+
+	jobsMu sync.Mutex
+	jobs   []*ReconcileJob
+}
+
+func NewGraphReconciler(repo *Repository, graph *Neo4jRepository, audit *AuditService, logger *pustakaLogger.Logger) *GraphReconciler {
+	return &GraphReconciler{
+		repo:   repo,
+		graph:  graph,
+		audit:  audit,
+		logger: logger,
+	}
+}
+
+// Reconcile runs a reconciliation in the foreground for userID and returns its
+// report. Like StartReconcile, the run is audited and keeps going when ctx is
+// cancelled.
+func (g *GraphReconciler) Reconcile(ctx context.Context, options ReconcileOptions, userID uuid.UUID) (*ReconcileReport, error) {
+	options, err := normalizeReconcileOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if !g.running.TryLock() {
+		return nil, fmt.Errorf("reconciliation already running")
+	}
+	defer g.running.Unlock()
+
+	job, err := g.startJob(ctx, options, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.runJob(context.WithoutCancel(ctx), job, options)
+}
+
+// StartReconcile starts a reconciliation in the background and returns its
+// job. The run keeps going when ctx is cancelled, since a rebuild stopped
+// after clearing the graph would leave it empty. The start and the outcome
+// are both audited.
+func (g *GraphReconciler) StartReconcile(ctx context.Context, options ReconcileOptions, userID uuid.UUID) (*ReconcileJob, error) {
+	options, err := normalizeReconcileOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if !g.running.TryLock() {
+		return nil, fmt.Errorf("reconciliation already running")
+	}
+
+	job, err := g.startJob(ctx, options, userID)
+	if err != nil {
+		g.running.Unlock()
+		return nil, err
+	}
+
+	g.jobsMu.Lock()
+	started := *job
+	g.jobsMu.Unlock()
+
+	runCtx := context.WithoutCancel(ctx)
+	go func() {
+		defer g.running.Unlock()
+		g.runJob(runCtx, job, options)
+	}()
+
+	return &started, nil
+}
+
+// startJob audits the start of a run and records its job. The caller must
+// hold the running lock.
+func (g *GraphReconciler) startJob(ctx context.Context, options ReconcileOptions, userID uuid.UUID) (*ReconcileJob, error) {
+	job := &ReconcileJob{
+		ID:          uuid.New(),
+		Mode:        options.Mode,
+		BatchSize:   options.BatchSize,
+		Status:      ReconcileJobRunning,
+		RequestedBy: userID,
+		StartedAt:   time.Now(),
+	}
+	if err := g.auditJob(ctx, job, "reconcile", map[string]interface{}{
+		"mode":       job.Mode,
+		"batch_size": job.BatchSize,
+	}); err != nil {
+		return nil, err
+	}
+
+	g.jobsMu.Lock()
+	g.jobs = append(g.jobs, job)
+	if len(g.jobs) > reconcileJobHistory {
+		g.jobs = g.jobs[len(g.jobs)-reconcileJobHistory:]
+	}
+	g.jobsMu.Unlock()
+
+	return job, nil
+}
+
+// runJob runs a started job to completion and audits its outcome
+func (g *GraphReconciler) runJob(ctx context.Context, job *ReconcileJob, options ReconcileOptions) (*ReconcileReport, error) {
+	report, err := g.reconcile(ctx, options)
+
+	g.jobsMu.Lock()
+	finishedAt := time.Now()
+	job.FinishedAt = &finishedAt
+	job.Report = report
+	job.Status = ReconcileJobCompleted
+	if err != nil {
+		job.Status = ReconcileJobFailed
+		job.Error = err.Error()
+	}
+	finished := *job
+	g.jobsMu.Unlock()
+
+	details := map[string]interface{}{
+		"mode":   finished.Mode,
+		"status": finished.Status,
+	}
+	if err != nil {
+		g.logger.ErrorService("graph_sync", "reconcile", err, map[string]interface{}{
+			"job_id": finished.ID,
+			"mode":   finished.Mode,
+		})
+		details["error"] = finished.Error
+	} else {
+		details["has_drift"] = report.HasDrift()
+		details["graph_cleared"] = report.GraphCleared
+	}
+	if auditErr := g.auditJob(ctx, &finished, "reconcile_"+finished.Status, details); auditErr != nil {
+		g.logger.ErrorService("graph_sync", "reconcile", auditErr, map[string]interface{}{
+			"job_id": finished.ID,
+		})
+	}
+
+	return report, err
+}
+
+// GetReconcileJob returns one of the recent jobs started by StartReconcile
+func (g *GraphReconciler) GetReconcileJob(id uuid.UUID) (*ReconcileJob, error) {
+	g.jobsMu.Lock()
+	defer g.jobsMu.Unlock()
+
+	for _, job := range g.jobs {
+		if job.ID == id {
+			found := *job
+			return &found, nil
+		}
+	}
+	return nil, fmt.Errorf("reconcile job not found")
+}
+
+func (g *GraphReconciler) auditJob(ctx context.Context, job *ReconcileJob, action string, details map[string]interface{}) error {
+	meta := RequestMetadataFromContext(ctx)
+	if err := g.audit.CreateAuditLog(ctx, "graph_sync", &job.ID, action, job.RequestedBy, details, meta.IPAddress, meta.UserAgent); err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
+
+func normalizeReconcileOptions(options ReconcileOptions) (ReconcileOptions, error) {
+	switch options.Mode {
+	case "":
+		options.Mode = ReconcileModeCheck
+	case ReconcileModeCheck, ReconcileModeRepair, ReconcileModeRebuild:
+	default:
+		return options, fmt.Errorf("invalid reconcile mode: %s", options.Mode)
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 500
+	}
+	return options, nil
+}
+
+func (g *GraphReconciler) reconcile(ctx context.Context, options ReconcileOptions) (*ReconcileReport, error) {
+	report := &ReconcileReport{
+		Mode:      options.Mode,
+		StartedAt: time.Now(),
+	}
+
+	if options.Mode == ReconcileModeRebuild {
+		cleared, err := g.graph.DeleteAllCIs(ctx, options.BatchSize)
+		report.GraphCleared = cleared
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// CIs go first so that repaired relationships find their endpoints. The
+	// graph is read before PostgreSQL: anything written to the graph has
+	// already been committed, so whatever synced in between shows up in both
+	// reads instead of being taken for an orphan and deleted.
+	ciGraph, err := g.graph.ListCIStates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ciStates, err := g.repo.ListCIUpdateTimes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ciDrift := compareGraphState(ciStates, ciGraph)
+	report.CIs = ciDrift.report(len(ciStates), len(ciGraph))
+
+	if options.Mode != ReconcileModeCheck {
+		if err := g.repairCIs(ctx, ciDrift, options.BatchSize, &report.CIs); err != nil {
+			return nil, err
+		}
+	}
+
+	relGraph, err := g.graph.ListRelationshipStates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	relStates, err := g.repo.ListRelationshipUpdateTimes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	relDrift := compareGraphState(relStates, relGraph)
+	report.Relationships = relDrift.report(len(relStates), len(relGraph))
+
+	if options.Mode != ReconcileModeCheck {
+		if err := g.repairRelationships(ctx, relDrift, options.BatchSize, &report.Relationships); err != nil {
+			return nil, err
+		}
+	}
+
+	report.FinishedAt = time.Now()
+
+	g.logger.InfoService("graph_sync", "reconcile", map[string]interface{}{
+		"mode":                  report.Mode,
+		"ci_missing":            report.CIs.MissingCount,
+		"ci_orphaned":           report.CIs.OrphanedCount,
+		"ci_stale":              report.CIs.StaleCount,
+		"relationship_missing":  report.Relationships.MissingCount,
+		"relationship_orphaned": report.Relationships.OrphanedCount,
+		"relationship_stale":    report.Relationships.StaleCount,
+		"duration_ms":           report.FinishedAt.Sub(report.StartedAt).Milliseconds(),
+	})
+
+	return report, nil
+}
+
+func (g *GraphReconciler) repairCIs(ctx context.Context, drift graphDrift, batchSize int, report *EntityReconcileReport) error {
+	for _, batch := range batchUUIDs(drift.toSync(), batchSize) {
+		cis, err := g.repo.GetCIsByIDs(ctx, batch)
+		if err != nil {
+			return err
+		}
+		if err := g.graph.SyncCIs(ctx, cis); err != nil {
+			return fmt.Errorf("failed to sync CIs: %w", err)
+		}
+		report.Synced += len(cis)
+		report.Skipped += len(batch) - len(cis)
+	}
+
+	for _, batch := range batchStrings(drift.orphaned, batchSize) {
+		if err := g.graph.DeleteCIsByIDs(ctx, batch); err != nil {
+			return fmt.Errorf("failed to delete orphaned CIs: %w", err)
+		}
+		report.Deleted += len(batch)
+	}
+
+	return nil
+}
+
+func (g *GraphReconciler) repairRelationships(ctx context.Context, drift graphDrift, batchSize int, report *EntityReconcileReport) error {
+	for _, batch := range batchUUIDs(drift.toSync(), batchSize) {
+		relationships, err := g.repo.GetRelationshipsByIDs(ctx, batch)
+		if err != nil {
+			return err
+		}
+		written, err := g.graph.SyncRelationships(ctx, relationships)
+		if err != nil {
+			return err
+		}
+		report.Synced += written
+		report.Skipped += len(batch) - written
+	}
+
+	for _, batch := range batchStrings(drift.orphaned, batchSize) {
+		if err := g.graph.DeleteRelationshipsByIDs(ctx, batch); err != nil {
+			return fmt.Errorf("failed to delete orphaned relationships: %w", err)
+		}
+		report.Deleted += len(batch)
+	}
+
+	return nil
+}
+
+// graphDrift holds the sorted IDs that differ between PostgreSQL and the graph
+type graphDrift struct {
+	missing  []uuid.UUID
+	stale    []uuid.UUID
+	orphaned []string
+}
+
+// compareGraphState diffs PostgreSQL update times against the graph's.
+// The graph stores Unix seconds, so PostgreSQL times are truncated to match.
+func compareGraphState(postgres map[uuid.UUID]time.Time, graph map[string]int64) graphDrift {
+	var drift graphDrift
+
+	for id, updatedAt := range postgres {
+		graphUpdatedAt, exists := graph[id.String()]
+		if !exists {
+			drift.missing = append(drift.missing, id)
+			continue
+		}
+		if graphUpdatedAt < updatedAt.Unix() {
+			drift.stale = append(drift.stale, id)
+		}
+	}
+
+	for id := range graph {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			drift.orphaned = append(drift.orphaned, id)
+			continue
+		}
+		if _, exists := postgres[parsed]; !exists {
+			drift.orphaned = append(drift.orphaned, id)
+		}
+	}
+
+	sortUUIDs(drift.missing)
+	sortUUIDs(drift.stale)
+	sort.Strings(drift.orphaned)
+
+	return drift
+}
+
+func (d graphDrift) toSync() []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(d.missing)+len(d.stale))
+	ids = append(ids, d.missing...)
+	return append(ids, d.stale...)
+}
+
+func (d graphDrift) report(postgresCount, graphCount int) EntityReconcileReport {
+	return EntityReconcileReport{
+		PostgresCount: postgresCount,
+		GraphCount:    graphCount,
+		MissingCount:  len(d.missing),
+		OrphanedCount: len(d.orphaned),
+		StaleCount:    len(d.stale),
+		Missing:       sampleUUIDs(d.missing),
+		Orphaned:      sampleStrings(d.orphaned),
+		Stale:         sampleUUIDs(d.stale),
+	}
+}
+
+func sortUUIDs(ids []uuid.UUID) {
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].String() < ids[j].String()
+	})
+}
+
+func sampleUUIDs(ids []uuid.UUID) []string {
+	sample := []string{}
+	for i := 0; i < len(ids) && i < reconcileSampleLimit; i++ {
+		sample = append(sample, ids[i].String())
+	}
+	return sample
+}
+
+func sampleStrings(ids []string) []string {
+	if len(ids) > reconcileSampleLimit {
+		ids = ids[:reconcileSampleLimit]
+	}
+	return append([]string{}, ids...)
+}
+
+func batchUUIDs(ids []uuid.UUID, size int) [][]uuid.UUID {
+	var batches [][]uuid.UUID
+	for start := 0; start < len(ids); start += size {
+		end := start + size
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batches = append(batches, ids[start:end])
+	}
+	return batches
+}
+
+func batchStrings(ids []string, size int) [][]string {
+	var batches [][]string
+	for start := 0; start < len(ids); start += size {
+		end := start + size
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batches = append(batches, ids[start:end])
+	}
+	return batches
+}
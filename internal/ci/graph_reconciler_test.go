@@ -0,0 +1,72 @@
+package ci
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareGraphState(t *testing.T) {
+	now := time.Now()
+	inSync := uuid.New()
+	missing := uuid.New()
+	stale := uuid.New()
+	orphaned := uuid.New().String()
+
+	postgres := map[uuid.UUID]time.Time{
+		inSync:  now,
+		missing: now,
+		stale:   now,
+	}
+	graph := map[string]int64{
+		inSync.String(): now.Unix(),
+		stale.String():  now.Add(-time.Minute).Unix(),
+		orphaned:        now.Unix(),
+		"not-a-uuid":    now.Unix(),
+	}
+
+	drift := compareGraphState(postgres, graph)
+
+	assert.Equal(t, []uuid.UUID{missing}, drift.missing)
+	assert.Equal(t, []uuid.UUID{stale}, drift.stale)
+	assert.ElementsMatch(t, []string{orphaned, "not-a-uuid"}, drift.orphaned)
+	assert.ElementsMatch(t, []uuid.UUID{missing, stale}, drift.toSync())
+
+	report := drift.report(len(postgres), len(graph))
+	assert.Equal(t, 1, report.MissingCount)
+	assert.Equal(t, 2, report.OrphanedCount)
+	assert.Equal(t, 1, report.StaleCount)
+	assert.True(t, (&ReconcileReport{CIs: report}).HasDrift())
+	assert.False(t, (&ReconcileReport{}).HasDrift())
+}
+
+func TestBatchUUIDs(t *testing.T) {
+	ids := make([]uuid.UUID, 5)
+	for i := range ids {
+		ids[i] = uuid.New()
+	}
+
+	batches := batchUUIDs(ids, 2)
+	assert.Len(t, batches, 3)
+	assert.Len(t, batches[2], 1)
+	assert.Nil(t, batchUUIDs(nil, 2))
+}
+
+func TestStartReconcileRejectsBeforeRunning(t *testing.T) {
+	reconciler := NewGraphReconciler(nil, nil, nil, nil)
+	ctx := context.Background()
+
+	_, err := reconciler.StartReconcile(ctx, ReconcileOptions{Mode: "wipe"}, uuid.New())
+	assert.EqualError(t, err, "invalid reconcile mode: wipe")
+
+	reconciler.running.Lock()
+	_, err = reconciler.StartReconcile(ctx, ReconcileOptions{Mode: ReconcileModeRebuild}, uuid.New())
+	assert.EqualError(t, err, "reconciliation already running")
+	reconciler.running.Unlock()
+
+	_, err = reconciler.GetReconcileJob(uuid.New())
+	assert.EqualError(t, err, "reconcile job not found")
+}
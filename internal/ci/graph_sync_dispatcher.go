@@ -0,0 +1,231 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	pustakaLogger "github.com/pustaka/pustaka/pkg/logger"
+)
+
+// GraphSyncOptions controls how the dispatcher drains the outbox
+type GraphSyncOptions struct {
+	PollInterval time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+}
+
+// GraphSyncDispatcher applies graph_sync_outbox entries to Neo4j in the
+// background. Failed entries are retried with exponential backoff and moved to
+// the dead-letter state after MaxAttempts.
+type GraphSyncDispatcher struct {
+	repo    *Repository
+	neo4j   *Neo4jService
+	options GraphSyncOptions
+	logger  *pustakaLogger.Logger
+}
+
+func NewGraphSyncDispatcher(repo *Repository, neo4j *Neo4jService, options GraphSyncOptions, logger *pustakaLogger.Logger) *GraphSyncDispatcher {
+	if options.PollInterval <= 0 {
+		options.PollInterval = time.Second
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 100
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 10
+	}
+	if options.BaseBackoff <= 0 {
+		options.BaseBackoff = time.Second
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = 10 * time.Minute
+	}
+
+	return &GraphSyncDispatcher{
+		repo:    repo,
+		neo4j:   neo4j,
+		options: options,
+		logger:  logger,
+	}
+}
+
+// Run drains the outbox until ctx is cancelled
+func (d *GraphSyncDispatcher) Run(ctx context.Context) {
+	d.logger.InfoService("graph_sync", "dispatcher_start", map[string]interface{}{
+		"poll_interval": d.options.PollInterval.String(),
+		"batch_size":    d.options.BatchSize,
+		"max_attempts":  d.options.MaxAttempts,
+	})
+
+	ticker := time.NewTicker(d.options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep going while full batches come back so a backlog drains quickly
+		for {
+			processed, err := d.ProcessBatch(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					d.logger.ErrorService("graph_sync", "process_batch", err, nil)
+				}
+				break
+			}
+			if processed < d.options.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			d.logger.InfoService("graph_sync", "dispatcher_stop", nil)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessBatch applies one batch of due entries and returns how many were claimed
+func (d *GraphSyncDispatcher) ProcessBatch(ctx context.Context) (int, error) {
+	processed := 0
+
+	err := d.repo.WithTx(ctx, func(ctx context.Context) error {
+		entries, err := d.repo.ClaimGraphSyncBatch(ctx, d.options.BatchSize)
+		if err != nil {
+			return err
+		}
+		processed = len(entries)
+
+		batched, err := d.applyCIUpserts(ctx, entries)
+		if err != nil {
+			return err
+		}
+
+		for i := range entries {
+			entry := &entries[i]
+			if batched[entry.ID] {
+				continue
+			}
+
+			if applyErr := d.apply(ctx, entry); applyErr != nil {
+				attempts := entry.Attempts + 1
+				dead := attempts >= d.options.MaxAttempts
+				nextAttemptAt := time.Now().Add(d.backoff(attempts))
+
+				d.logger.ErrorService("graph_sync", "apply_entry", applyErr, map[string]interface{}{
+					"outbox_id":   entry.ID,
+					"entity_type": entry.EntityType,
+					"entity_id":   entry.EntityID,
+					"operation":   entry.Operation,
+					"attempts":    attempts,
+					"dead":        dead,
+				})
+
+				if err := d.repo.FailGraphSync(ctx, entry.ID, applyErr, nextAttemptAt, dead); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := d.repo.CompleteGraphSync(ctx, entry.ID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return processed, err
+}
+
+// applyCIUpserts syncs the CIs of all CI upserts among entries to Neo4j with
+// one write and completes those entries, returning their IDs. A claimed batch
+// holds at most one entry per entity, so no ordering is lost. If the write
+// fails nothing is returned and each entry is applied, and retried, on its own.
+func (d *GraphSyncDispatcher) applyCIUpserts(ctx context.Context, entries []GraphSyncEntry) (map[int64]bool, error) {
+	var entryIDs []int64
+	var ciIDs []uuid.UUID
+	for _, entry := range entries {
+		if entry.EntityType == GraphSyncEntityCI && entry.Operation != GraphSyncOpDelete {
+			entryIDs = append(entryIDs, entry.ID)
+			ciIDs = append(ciIDs, entry.EntityID)
+		}
+	}
+	if len(entryIDs) < 2 {
+		return nil, nil
+	}
+
+	// CIs deleted since are skipped; the delete entries that follow handle the graph
+	cis, err := d.repo.GetCIsByIDs(ctx, ciIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.neo4j.SyncCIs(ctx, cis); err != nil {
+		d.logger.ErrorService("graph_sync", "apply_ci_batch", err, map[string]interface{}{
+			"count": len(cis),
+		})
+		return nil, nil
+	}
+
+	if err := d.repo.CompleteGraphSyncBatch(ctx, entryIDs); err != nil {
+		return nil, err
+	}
+
+	batched := make(map[int64]bool, len(entryIDs))
+	for _, id := range entryIDs {
+		batched[id] = true
+	}
+	return batched, nil
+}
+
+// apply brings Neo4j in line with the entity's current state in PostgreSQL.
+// Upserts read the current row, so a retried or re-queued entry can never
+// overwrite the graph with stale data.
+func (d *GraphSyncDispatcher) apply(ctx context.Context, entry *GraphSyncEntry) error {
+	switch entry.EntityType {
+	case GraphSyncEntityCI:
+		if entry.Operation == GraphSyncOpDelete {
+			return d.neo4j.DeleteCI(ctx, entry.EntityID)
+		}
+
+		ci, err := d.repo.GetCI(ctx, entry.EntityID)
+		if err != nil {
+			if err.Error() == "CI not found" {
+				// Deleted since; the delete entry that follows handles the graph
+				return nil
+			}
+			return err
+		}
+		return d.neo4j.SyncCI(ctx, ci)
+
+	case GraphSyncEntityRelationship:
+		if entry.Operation == GraphSyncOpDelete {
+			return d.neo4j.DeleteRelationship(ctx, entry.EntityID)
+		}
+
+		rel, err := d.repo.GetRelationship(ctx, entry.EntityID)
+		if err != nil {
+			if err.Error() == "relationship not found" {
+				return nil
+			}
+			return err
+		}
+		return d.neo4j.SyncRelationship(ctx, rel)
+	}
+
+	return fmt.Errorf("unknown graph sync entity type: %s", entry.EntityType)
+}
+
+// backoff returns the delay before the given attempt is retried
+func (d *GraphSyncDispatcher) backoff(attempts int) time.Duration {
+	delay := float64(d.options.BaseBackoff) * math.Pow(2, float64(attempts-1))
+	if delay > float64(d.options.MaxBackoff) {
+		return d.options.MaxBackoff
+	}
+	return time.Duration(delay)
+}
@@ -0,0 +1,31 @@
+package ci
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphSyncDispatcherBackoff(t *testing.T) {
+	d := NewGraphSyncDispatcher(nil, nil, GraphSyncOptions{
+		BaseBackoff: time.Second,
+		MaxBackoff:  time.Minute,
+	}, nil)
+
+	assert.Equal(t, time.Second, d.backoff(1))
+	assert.Equal(t, 2*time.Second, d.backoff(2))
+	assert.Equal(t, 32*time.Second, d.backoff(6))
+	assert.Equal(t, time.Minute, d.backoff(7))
+	assert.Equal(t, time.Minute, d.backoff(50))
+}
+
+func TestGraphSyncDispatcherDefaults(t *testing.T) {
+	d := NewGraphSyncDispatcher(nil, nil, GraphSyncOptions{}, nil)
+
+	assert.Equal(t, time.Second, d.options.PollInterval)
+	assert.Equal(t, 100, d.options.BatchSize)
+	assert.Equal(t, 10, d.options.MaxAttempts)
+	assert.Equal(t, time.Second, d.options.BaseBackoff)
+	assert.Equal(t, 10*time.Minute, d.options.MaxBackoff)
+}
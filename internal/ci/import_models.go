@@ -0,0 +1,327 @@
+package ci
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CSV import modes. create refuses rows whose CI already exists; upsert
+// updates them.
+const (
+	CIImportModeCreate = "create"
+	CIImportModeUpsert = "upsert"
+)
+
+// CSV import job statuses
+const (
+	CIImportStatusPending   = "pending"
+	CIImportStatusRunning   = "running"
+	CIImportStatusCompleted = "completed"
+	CIImportStatusFailed    = "failed"
+)
+
+// Column mapping targets besides attribute names
+const (
+	CIImportTargetName = "name"
+	CIImportTargetTags = "tags"
+)
+
+// MaxCIImportFileSize caps the size of an uploaded CSV file
+const MaxCIImportFileSize = 10 << 20
+
+// CIImportRequest starts a CSV import. Mapping maps CSV column headers to
+// "name", "tags" or an attribute of the CI type; columns left out are ignored.
+// Without a mapping, columns are matched to targets by header.
+type CIImportRequest struct {
+	CIType   string            `json:"ci_type"`
+	Mode     string            `json:"mode"`
+	DryRun   bool              `json:"dry_run"`
+	Mapping  map[string]string `json:"mapping"`
+	FileName string            `json:"file_name"`
+	Content  []byte            `json:"-"`
+}
+
+// CIImportJob tracks a CSV import run in the background. A dry run validates
+// every row without writing anything, so CreatedRows and UpdatedRows count
+// what the import would do.
+type CIImportJob struct {
+	ID            uuid.UUID         `json:"id" db:"id"`
+	CIType        string            `json:"ci_type" db:"ci_type"`
+	Mode          string            `json:"mode" db:"mode"`
+	DryRun        bool              `json:"dry_run" db:"dry_run"`
+	Mapping       map[string]string `json:"mapping" db:"mapping"`
+	FileName      *string           `json:"file_name,omitempty" db:"file_name"`
+	Status        string            `json:"status" db:"status"`
+	TotalRows     int               `json:"total_rows" db:"total_rows"`
+	ProcessedRows int               `json:"processed_rows" db:"processed_rows"`
+	CreatedRows   int               `json:"created_rows" db:"created_rows"`
+	UpdatedRows   int               `json:"updated_rows" db:"updated_rows"`
+	FailedRows    int               `json:"failed_rows" db:"failed_rows"`
+	Error         *string           `json:"error,omitempty" db:"error"`
+	CreatedBy     uuid.UUID         `json:"created_by" db:"created_by"`
+	CreatedAt     time.Time         `json:"created_at" db:"created_at"`
+	StartedAt     *time.Time        `json:"started_at,omitempty" db:"started_at"`
+	UpdatedAt     time.Time         `json:"updated_at" db:"updated_at"`
+	FinishedAt    *time.Time        `json:"finished_at,omitempty" db:"finished_at"`
+}
+
+// CIImportError is one problem with one row of an import. Row counts lines
+// of the file the way a spreadsheet does, so the header is row 1.
+type CIImportError struct {
+	Row     int    `json:"row"`
+	Column  string `json:"column,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// ciImportRow is a CSV record turned into the CI it describes. Tags is nil
+// when the file has no tags for the row.
+type ciImportRow struct {
+	Number     int
+	Name       string
+	Attributes map[string]interface{}
+	Tags       []string
+	Errors     []CIImportError
+}
+
+// ciImportFile is a parsed CSV file with each column's mapping target; an
+// empty target means the column is ignored
+type ciImportFile struct {
+	Header  []string
+	Targets []string
+	Records [][]string
+}
+
+// parseCIImportFile reads a CSV file and resolves its column mapping against
+// the CI type. A leading byte order mark, as written by spreadsheets, is
+// ignored.
+func parseCIImportFile(content []byte, mapping map[string]string, ciType *CITypeDefinition) (*ciImportFile, []ValidationError) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, []ValidationError{{Field: "file", Message: fmt.Sprintf("invalid CSV: %v", err)}}
+	}
+	if len(records) == 0 {
+		return nil, []ValidationError{{Field: "file", Message: "file is empty"}}
+	}
+
+	file := &ciImportFile{Header: records[0], Records: records[1:]}
+	var errors []ValidationError
+	file.Targets, errors = resolveCIImportMapping(file.Header, mapping, ciType)
+	if len(errors) > 0 {
+		return nil, errors
+	}
+	return file, nil
+}
+
+// resolveCIImportMapping returns the target of each header column. Every
+// mapped column must exist, every target must be name, tags or an attribute
+// of the type, no target may be mapped twice and name must be mapped.
+func resolveCIImportMapping(header []string, mapping map[string]string, ciType *CITypeDefinition) ([]string, []ValidationError) {
+	var errors []ValidationError
+	targets := make([]string, len(header))
+
+	isTarget := func(target string) bool {
+		return target == CIImportTargetName || target == CIImportTargetTags || ciType.findAttribute(target) != nil
+	}
+
+	if len(mapping) == 0 {
+		// Match headers to targets by name
+		for i, column := range header {
+			column = strings.TrimSpace(column)
+			if isTarget(column) {
+				targets[i] = column
+			}
+		}
+	} else {
+		columns := make(map[string]int, len(header))
+		for i, column := range header {
+			columns[column] = i
+		}
+		for column, target := range mapping {
+			i, ok := columns[column]
+			if !ok {
+				errors = append(errors, ValidationError{Field: "mapping", Message: fmt.Sprintf("column '%s' is not in the file", column)})
+				continue
+			}
+			if !isTarget(target) {
+				errors = append(errors, ValidationError{
+					Field:   "mapping",
+					Message: fmt.Sprintf("column '%s' maps to '%s', which is not name, tags or an attribute of CI type '%s'", column, target, ciType.Name),
+				})
+				continue
+			}
+			targets[i] = target
+		}
+	}
+
+	seen := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if target == "" {
+			continue
+		}
+		if seen[target] {
+			errors = append(errors, ValidationError{Field: "mapping", Message: fmt.Sprintf("more than one column maps to '%s'", target)})
+		}
+		seen[target] = true
+	}
+	if !seen[CIImportTargetName] {
+		errors = append(errors, ValidationError{Field: "mapping", Message: "no column maps to name"})
+	}
+
+	return targets, errors
+}
+
+// row turns the record at index i into the CI it describes. Empty cells are
+// left out. Attribute cells are converted to the attribute's type; arrays and
+// objects are written as JSON. Tags are separated by commas.
+func (file *ciImportFile) row(i int, ciType *CITypeDefinition) ciImportRow {
+	record := file.Records[i]
+	row := ciImportRow{Number: i + 2, Attributes: map[string]interface{}{}}
+
+	if len(record) != len(file.Header) {
+		row.Errors = append(row.Errors, CIImportError{
+			Row:     row.Number,
+			Message: fmt.Sprintf("row has %d columns; the header has %d", len(record), len(file.Header)),
+		})
+		return row
+	}
+
+	for col, target := range file.Targets {
+		cell := strings.TrimSpace(record[col])
+		if target == "" || cell == "" {
+			continue
+		}
+
+		switch target {
+		case CIImportTargetName:
+			row.Name = cell
+		case CIImportTargetTags:
+			for _, tag := range strings.Split(cell, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					row.Tags = append(row.Tags, tag)
+				}
+			}
+		default:
+			value, err := ciImportCellValue(cell, ciType.findAttribute(target).Type)
+			if err != nil {
+				row.Errors = append(row.Errors, CIImportError{Row: row.Number, Column: file.Header[col], Field: target, Message: err.Error()})
+				continue
+			}
+			row.Attributes[target] = value
+		}
+	}
+
+	if row.Name == "" {
+		row.Errors = append(row.Errors, CIImportError{Row: row.Number, Column: file.column(CIImportTargetName), Field: "name", Message: "name is required"})
+	}
+
+	return row
+}
+
+// mapping returns the column mapping in effect, for storing with the job
+func (file *ciImportFile) mapping() map[string]string {
+	mapping := make(map[string]string, len(file.Targets))
+	for i, target := range file.Targets {
+		if target != "" {
+			mapping[file.Header[i]] = target
+		}
+	}
+	return mapping
+}
+
+// column returns the header of the column mapped to target
+func (file *ciImportFile) column(target string) string {
+	for i, t := range file.Targets {
+		if t == target {
+			return file.Header[i]
+		}
+	}
+	return ""
+}
+
+// rowErrors reports validation errors of a row against the columns they came from
+func (file *ciImportFile) rowErrors(number int, errors []ValidationError) []CIImportError {
+	result := make([]CIImportError, 0, len(errors))
+	for _, e := range errors {
+		result = append(result, CIImportError{Row: number, Column: file.column(e.Field), Field: e.Field, Message: e.Message})
+	}
+	return result
+}
+
+func ciImportCellValue(cell, attrType string) (interface{}, error) {
+	if attrType == "array" || attrType == "object" {
+		var value interface{}
+		if err := json.Unmarshal([]byte(cell), &value); err != nil {
+			return nil, fmt.Errorf("%s value must be JSON: %v", attrType, err)
+		}
+		return convertAttributeValue(value, attrType)
+	}
+	return convertAttributeValue(cell, attrType)
+}
+
+// ciImportDryRun holds what a dry run would have written so far, so each row
+// is checked against the rows before it the way a real run would be
+type ciImportDryRun struct {
+	// cis are the CIs earlier rows would have created or updated, by name
+	cis map[string]*ConfigurationItem
+	// claims name the CI that would hold each unique value
+	claims map[ciImportClaim]string
+}
+
+type ciImportClaim struct {
+	constraint uniqueConstraint
+	value      string
+}
+
+func newCIImportDryRun() *ciImportDryRun {
+	return &ciImportDryRun{
+		cis:    make(map[string]*ConfigurationItem),
+		claims: make(map[ciImportClaim]string),
+	}
+}
+
+// record notes that a row would have left ci as it is, holding its values of
+// the unique attributes
+func (d *ciImportDryRun) record(ci *ConfigurationItem, constraints []uniqueConstraint) error {
+	d.cis[ci.Name] = ci
+	for _, constraint := range constraints {
+		value := ci.Attributes[constraint.Attribute]
+		if value == nil {
+			continue
+		}
+		claimed, err := claimedValue(value)
+		if err != nil {
+			return err
+		}
+		d.claims[ciImportClaim{constraint: constraint, value: claimed}] = ci.Name
+	}
+	return nil
+}
+
+// holder returns the CI that would hold a unique value, or nil when no CI
+// recorded by the dry run still does
+func (d *ciImportDryRun) holder(constraint uniqueConstraint, claimed string) (*ConfigurationItem, error) {
+	name, ok := d.claims[ciImportClaim{constraint: constraint, value: claimed}]
+	if !ok {
+		return nil, nil
+	}
+	ci := d.cis[name]
+	value := ci.Attributes[constraint.Attribute]
+	if value == nil {
+		return nil, nil
+	}
+	current, err := claimedValue(value)
+	if err != nil || current != claimed {
+		return nil, err
+	}
+	return ci, nil
+}
@@ -0,0 +1,96 @@
+package ci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func importTestType() *CITypeDefinition {
+	return &CITypeDefinition{
+		Name: "Server",
+		RequiredAttributes: []AttributeDefinition{
+			{Name: "hostname", Type: "string"},
+		},
+		OptionalAttributes: []AttributeDefinition{
+			{Name: "cpu_cores", Type: "integer"},
+			{Name: "disks", Type: "array"},
+		},
+	}
+}
+
+func TestParseCIImportFile(t *testing.T) {
+	ciType := importTestType()
+	content := []byte("\xef\xbb\xbfname,hostname,cpu_cores,owner\nweb-01,web-01.example.com,8,ops\n")
+
+	// Headers are matched by name and unknown columns ignored
+	file, errors := parseCIImportFile(content, nil, ciType)
+	require.Empty(t, errors)
+	assert.Equal(t, []string{"name", "hostname", "cpu_cores", ""}, file.Targets)
+	assert.Equal(t, map[string]string{"name": "name", "hostname": "hostname", "cpu_cores": "cpu_cores"}, file.mapping())
+	assert.Len(t, file.Records, 1)
+
+	file, errors = parseCIImportFile([]byte("Server,Host\nweb-01,web-01.example.com\n"), map[string]string{
+		"Server": "name",
+		"Host":   "hostname",
+	}, ciType)
+	require.Empty(t, errors)
+	assert.Equal(t, []string{"name", "hostname"}, file.Targets)
+
+	_, errors = parseCIImportFile([]byte("Server,Host\n"), map[string]string{
+		"Server": "name",
+		"Host":   "hostname",
+		"Owner":  "owner",
+		"CPU":    "cpu_cores",
+	}, ciType)
+	assert.Len(t, errors, 2)
+
+	_, errors = parseCIImportFile([]byte("Server,Host\n"), map[string]string{"Server": "hostname", "Host": "hostname"}, ciType)
+	assert.Len(t, errors, 2, "hostname mapped twice and name not mapped")
+
+	_, errors = parseCIImportFile([]byte("name,\"host\n"), nil, ciType)
+	require.Len(t, errors, 1)
+	assert.Equal(t, "file", errors[0].Field)
+}
+
+func TestCIImportFileRow(t *testing.T) {
+	ciType := importTestType()
+	file, errors := parseCIImportFile([]byte(
+		"name,hostname,cpu_cores,disks,tags\n"+
+			"web-01,web-01.example.com,8,\"[\"\"sda\"\"]\",\"web, prod\"\n"+
+			"web-02,,eight,,\n"+
+			",web-03.example.com,,,\n"+
+			"web-04\n",
+	), nil, ciType)
+	require.Empty(t, errors)
+
+	row := file.row(0, ciType)
+	assert.Empty(t, row.Errors)
+	assert.Equal(t, 2, row.Number)
+	assert.Equal(t, "web-01", row.Name)
+	assert.Equal(t, map[string]interface{}{
+		"hostname":  "web-01.example.com",
+		"cpu_cores": float64(8),
+		"disks":     []interface{}{"sda"},
+	}, row.Attributes)
+	assert.Equal(t, []string{"web", "prod"}, row.Tags)
+
+	// Empty cells are left out
+	row = file.row(1, ciType)
+	require.Len(t, row.Errors, 1)
+	assert.Equal(t, CIImportError{Row: 3, Column: "cpu_cores", Field: "cpu_cores", Message: "cannot convert eight to integer"}, row.Errors[0])
+	assert.NotContains(t, row.Attributes, "hostname")
+	assert.Nil(t, row.Tags)
+
+	row = file.row(2, ciType)
+	require.Len(t, row.Errors, 1)
+	assert.Equal(t, "name", row.Errors[0].Field)
+
+	row = file.row(3, ciType)
+	require.Len(t, row.Errors, 1)
+	assert.Equal(t, 5, row.Errors[0].Row)
+
+	assert.Equal(t, []CIImportError{{Row: 2, Column: "hostname", Field: "hostname", Message: "is required"}},
+		file.rowErrors(2, []ValidationError{{Field: "hostname", Message: "is required"}}))
+}
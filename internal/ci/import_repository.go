@@ -0,0 +1,268 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// CSV import jobs
+
+// ciImportJobColumns leaves out the uploaded file, which only the runner reads
+const ciImportJobColumns = "id, ci_type, mode, dry_run, mapping, file_name, status, total_rows, processed_rows, created_rows, updated_rows, failed_rows, error, created_by, created_at, started_at, updated_at, finished_at"
+
+func scanCIImportJob(row pgx.Row, job *CIImportJob) error {
+	return row.Scan(
+		&job.ID,
+		&job.CIType,
+		&job.Mode,
+		&job.DryRun,
+		&job.Mapping,
+		&job.FileName,
+		&job.Status,
+		&job.TotalRows,
+		&job.ProcessedRows,
+		&job.CreatedRows,
+		&job.UpdatedRows,
+		&job.FailedRows,
+		&job.Error,
+		&job.CreatedBy,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.UpdatedAt,
+		&job.FinishedAt,
+	)
+}
+
+func (r *Repository) CreateCIImportJob(ctx context.Context, job *CIImportJob, content []byte) (*CIImportJob, error) {
+	query := `
+		INSERT INTO ci_import_jobs (ci_type, mode, dry_run, mapping, file_name, content, total_rows, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING ` + ciImportJobColumns
+
+	var result CIImportJob
+	err := scanCIImportJob(r.conn(ctx).QueryRow(ctx, query,
+		job.CIType,
+		job.Mode,
+		job.DryRun,
+		job.Mapping,
+		job.FileName,
+		content,
+		job.TotalRows,
+		job.CreatedBy,
+	), &result)
+	if err != nil {
+		r.logger.ErrorDatabase("INSERT", "ci_import_jobs", err, map[string]interface{}{
+			"ci_type": job.CIType,
+		})
+		return nil, fmt.Errorf("failed to create CI import job: %w", err)
+	}
+
+	return &result, nil
+}
+
+func (r *Repository) GetCIImportJob(ctx context.Context, id uuid.UUID) (*CIImportJob, error) {
+	query := `
+		SELECT ` + ciImportJobColumns + `
+		FROM ci_import_jobs
+		WHERE id = $1
+	`
+
+	var job CIImportJob
+	err := scanCIImportJob(r.conn(ctx).QueryRow(ctx, query, id), &job)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("CI import job not found")
+		}
+		r.logger.ErrorDatabase("SELECT", "ci_import_jobs", err, map[string]interface{}{
+			"job_id": id,
+		})
+		return nil, fmt.Errorf("failed to get CI import job: %w", err)
+	}
+
+	return &job, nil
+}
+
+// ListCIImportJobs returns the most recent import jobs, newest first
+func (r *Repository) ListCIImportJobs(ctx context.Context, limit int) ([]CIImportJob, error) {
+	query := `
+		SELECT ` + ciImportJobColumns + `
+		FROM ci_import_jobs
+		ORDER BY created_at DESC
+		LIMIT $1
+	`
+
+	rows, err := r.conn(ctx).Query(ctx, query, limit)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "ci_import_jobs", err, nil)
+		return nil, fmt.Errorf("failed to list CI import jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []CIImportJob{}
+	for rows.Next() {
+		var job CIImportJob
+		if err := scanCIImportJob(rows, &job); err != nil {
+			r.logger.ErrorDatabase("SELECT", "ci_import_jobs", err, nil)
+			return nil, fmt.Errorf("failed to scan CI import job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// GetCIImportContent returns the uploaded file of an import job
+func (r *Repository) GetCIImportContent(ctx context.Context, id uuid.UUID) ([]byte, error) {
+	var content []byte
+	if err := r.conn(ctx).QueryRow(ctx, "SELECT content FROM ci_import_jobs WHERE id = $1", id).Scan(&content); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("CI import job not found")
+		}
+		r.logger.ErrorDatabase("SELECT", "ci_import_jobs", err, map[string]interface{}{
+			"job_id": id,
+		})
+		return nil, fmt.Errorf("failed to get CI import file: %w", err)
+	}
+	return content, nil
+}
+
+// ClaimCIImportJob marks the oldest pending import as running and returns it.
+// A running import whose progress has not moved for staleAfter is claimed
+// again. Returns nil when there is nothing to do.
+func (r *Repository) ClaimCIImportJob(ctx context.Context, staleAfter time.Duration) (*CIImportJob, error) {
+	query := `
+		UPDATE ci_import_jobs
+		SET status = 'running', started_at = COALESCE(started_at, NOW()), updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM ci_import_jobs
+			WHERE status = 'pending' OR (status = 'running' AND updated_at < $1)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + ciImportJobColumns
+
+	var job CIImportJob
+	err := scanCIImportJob(r.conn(ctx).QueryRow(ctx, query, time.Now().Add(-staleAfter)), &job)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		r.logger.ErrorDatabase("UPDATE", "ci_import_jobs", err, nil)
+		return nil, fmt.Errorf("failed to claim CI import job: %w", err)
+	}
+
+	return &job, nil
+}
+
+// UpdateCIImportProgress saves a job's counters, which also marks the job as
+// alive. Run in the transaction that applied a row, it records the row as
+// done exactly when its changes commit.
+func (r *Repository) UpdateCIImportProgress(ctx context.Context, job *CIImportJob) error {
+	query := `
+		UPDATE ci_import_jobs
+		SET processed_rows = $2, created_rows = $3, updated_rows = $4, failed_rows = $5, updated_at = NOW()
+		WHERE id = $1
+	`
+
+	_, err := r.conn(ctx).Exec(ctx, query, job.ID, job.ProcessedRows, job.CreatedRows, job.UpdatedRows, job.FailedRows)
+	if err != nil {
+		r.logger.ErrorDatabase("UPDATE", "ci_import_jobs", err, map[string]interface{}{
+			"job_id": job.ID,
+		})
+		return fmt.Errorf("failed to update CI import job: %w", err)
+	}
+	return nil
+}
+
+// AddCIImportErrors records the problems with one row. Errors already
+// recorded for the row by an interrupted run are kept.
+func (r *Repository) AddCIImportErrors(ctx context.Context, jobID uuid.UUID, errors []CIImportError) error {
+	if len(errors) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(errors))
+	args := make([]interface{}, 0, len(errors)*5+1)
+	args = append(args, jobID)
+	for i, e := range errors {
+		n := len(args)
+		values = append(values, fmt.Sprintf("($1, $%d, $%d, NULLIF($%d, ''), NULLIF($%d, ''), $%d)", n+1, n+2, n+3, n+4, n+5))
+		args = append(args, e.Row, i, e.Column, e.Field, e.Message)
+	}
+
+	query := `
+		INSERT INTO ci_import_errors (job_id, row_number, position, column_name, field, message)
+		VALUES ` + strings.Join(values, ", ") + `
+		ON CONFLICT (job_id, row_number, position) DO NOTHING
+	`
+
+	if _, err := r.conn(ctx).Exec(ctx, query, args...); err != nil {
+		r.logger.ErrorDatabase("INSERT", "ci_import_errors", err, map[string]interface{}{
+			"job_id": jobID,
+		})
+		return fmt.Errorf("failed to record CI import errors: %w", err)
+	}
+	return nil
+}
+
+// ListCIImportErrors returns every error of an import, by row
+func (r *Repository) ListCIImportErrors(ctx context.Context, jobID uuid.UUID) ([]CIImportError, error) {
+	query := `
+		SELECT row_number, COALESCE(column_name, ''), COALESCE(field, ''), message
+		FROM ci_import_errors
+		WHERE job_id = $1
+		ORDER BY row_number, position
+	`
+
+	rows, err := r.conn(ctx).Query(ctx, query, jobID)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "ci_import_errors", err, map[string]interface{}{
+			"job_id": jobID,
+		})
+		return nil, fmt.Errorf("failed to list CI import errors: %w", err)
+	}
+	defer rows.Close()
+
+	errors := []CIImportError{}
+	for rows.Next() {
+		var e CIImportError
+		if err := rows.Scan(&e.Row, &e.Column, &e.Field, &e.Message); err != nil {
+			r.logger.ErrorDatabase("SELECT", "ci_import_errors", err, nil)
+			return nil, fmt.Errorf("failed to scan CI import error: %w", err)
+		}
+		errors = append(errors, e)
+	}
+
+	return errors, rows.Err()
+}
+
+// FinishCIImportJob records a job's final status and, for failed jobs, the error
+func (r *Repository) FinishCIImportJob(ctx context.Context, id uuid.UUID, status string, jobErr error) error {
+	var message *string
+	if jobErr != nil {
+		text := jobErr.Error()
+		message = &text
+	}
+
+	query := `
+		UPDATE ci_import_jobs
+		SET status = $2, error = $3, finished_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+	`
+
+	_, err := r.conn(ctx).Exec(ctx, query, id, status, message)
+	if err != nil {
+		r.logger.ErrorDatabase("UPDATE", "ci_import_jobs", err, map[string]interface{}{
+			"job_id": id,
+			"status": status,
+		})
+		return fmt.Errorf("failed to finish CI import job: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pustakaLogger "github.com/pustaka/pustaka/pkg/logger"
+)
+
+// CIImportOptions controls how the runner picks up import jobs
+type CIImportOptions struct {
+	PollInterval time.Duration
+	StaleAfter   time.Duration
+}
+
+// CIImportRunner works through queued CSV imports in the background. Progress
+// is saved with every row; an import left running by a stopped process is
+// picked up again once it has gone stale and resumes at the next row.
+type CIImportRunner struct {
+	service *Service
+	options CIImportOptions
+	logger  *pustakaLogger.Logger
+}
+
+func NewCIImportRunner(service *Service, options CIImportOptions, logger *pustakaLogger.Logger) *CIImportRunner {
+	if options.PollInterval <= 0 {
+		options.PollInterval = 5 * time.Second
+	}
+	if options.StaleAfter <= 0 {
+		options.StaleAfter = 5 * time.Minute
+	}
+
+	return &CIImportRunner{
+		service: service,
+		options: options,
+		logger:  logger,
+	}
+}
+
+// Run processes import jobs until ctx is cancelled
+func (m *CIImportRunner) Run(ctx context.Context) {
+	m.logger.InfoService("ci_import", "runner_start", map[string]interface{}{
+		"poll_interval": m.options.PollInterval.String(),
+	})
+
+	ticker := time.NewTicker(m.options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			job, err := m.service.repo.ClaimCIImportJob(ctx, m.options.StaleAfter)
+			if err != nil {
+				if ctx.Err() == nil {
+					m.logger.ErrorService("ci_import", "claim_job", err, nil)
+				}
+				break
+			}
+			if job == nil {
+				break
+			}
+			m.RunJob(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			m.logger.InfoService("ci_import", "runner_stop", nil)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunJob imports the rows of a claimed job and records its outcome. A job
+// interrupted by cancellation stays running so it can be resumed.
+func (m *CIImportRunner) RunJob(ctx context.Context, job *CIImportJob) {
+	m.logger.InfoService("ci_import", "job_start", map[string]interface{}{
+		"job_id":     job.ID,
+		"ci_type":    job.CIType,
+		"mode":       job.Mode,
+		"dry_run":    job.DryRun,
+		"total_rows": job.TotalRows,
+	})
+
+	err := m.importRows(ctx, job)
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	status := CIImportStatusCompleted
+	if err != nil {
+		status = CIImportStatusFailed
+		m.logger.ErrorService("ci_import", "run_job", err, map[string]interface{}{
+			"job_id": job.ID,
+		})
+	}
+
+	if err := m.service.repo.FinishCIImportJob(ctx, job.ID, status, err); err != nil {
+		m.logger.ErrorService("ci_import", "finish_job", err, map[string]interface{}{
+			"job_id": job.ID,
+		})
+		return
+	}
+
+	m.logger.InfoService("ci_import", "job_finish", map[string]interface{}{
+		"job_id":         job.ID,
+		"status":         status,
+		"processed_rows": job.ProcessedRows,
+		"created_rows":   job.CreatedRows,
+		"updated_rows":   job.UpdatedRows,
+		"failed_rows":    job.FailedRows,
+	})
+}
+
+func (m *CIImportRunner) importRows(ctx context.Context, job *CIImportJob) error {
+	content, err := m.service.repo.GetCIImportContent(ctx, job.ID)
+	if err != nil {
+		return err
+	}
+
+	// The type's schema may have changed since the file was accepted
+	ciType, err := m.service.resolveCITypeByName(ctx, job.CIType)
+	if err != nil {
+		return fmt.Errorf("CI type '%s' does not exist", job.CIType)
+	}
+	file, validationErrors := parseCIImportFile(content, job.Mapping, ciType)
+	if len(validationErrors) > 0 {
+		return fmt.Errorf("file no longer fits CI type '%s': %s", job.CIType, validationErrors[0].Message)
+	}
+
+	// A dry run keeps what the rows would have written in memory, so a
+	// resumed dry run only checks rows against those after where it stopped
+	var dryRun *ciImportDryRun
+	if job.DryRun {
+		dryRun = newCIImportDryRun()
+	}
+
+	for i := job.ProcessedRows; i < len(file.Records); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := m.service.importCIRow(ctx, ciType, job, file, i, dryRun); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
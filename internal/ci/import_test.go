@@ -0,0 +1,69 @@
+package ci
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pustakaLogger "github.com/pustaka/pustaka/pkg/logger"
+)
+
+// emptyTx is a transaction in which no row exists
+type emptyTx struct {
+	pgx.Tx
+}
+
+func (tx *emptyTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return emptyRow{}
+}
+
+type emptyRow struct{}
+
+func (emptyRow) Scan(dest ...interface{}) error {
+	return pgx.ErrNoRows
+}
+
+func TestApplyCIImportRowDryRunChecksEarlierRows(t *testing.T) {
+	ciType := &CITypeDefinition{
+		Name: "Server",
+		RequiredAttributes: []AttributeDefinition{
+			{Name: "hostname", Type: "string", Unique: UniqueScopeType},
+		},
+	}
+	service := NewService(NewRepository(nil, pustakaLogger.Default()), nil, nil, nil, pustakaLogger.Default())
+	ctx := context.WithValue(context.Background(), txContextKey{}, pgx.Tx(&emptyTx{}))
+	row := func(name, hostname string) ciImportRow {
+		return ciImportRow{Number: 2, Name: name, Attributes: map[string]interface{}{"hostname": hostname}}
+	}
+
+	// In create mode a name seen before already exists
+	job := &CIImportJob{ID: uuid.New(), Mode: CIImportModeCreate, DryRun: true}
+	dryRun := newCIImportDryRun()
+	_, created, err := service.applyCIImportRow(ctx, ciType, job, row("web-01", "web-01.example.com"), dryRun)
+	require.NoError(t, err)
+	assert.True(t, created)
+	_, _, err = service.applyCIImportRow(ctx, ciType, job, row("web-01", "web-01.example.com"), dryRun)
+	assert.EqualError(t, err, "CI already exists")
+
+	// A unique value taken by an earlier row is a violation
+	_, _, err = service.applyCIImportRow(ctx, ciType, job, row("web-02", "web-01.example.com"), dryRun)
+	var uniqueErr UniqueViolationError
+	require.ErrorAs(t, err, &uniqueErr)
+	assert.Equal(t, "web-01", uniqueErr.CIName)
+
+	// In upsert mode a name seen before is updated, and may give up its value
+	job.Mode = CIImportModeUpsert
+	dryRun = newCIImportDryRun()
+	_, _, err = service.applyCIImportRow(ctx, ciType, job, row("web-01", "web-01.example.com"), dryRun)
+	require.NoError(t, err)
+	_, created, err = service.applyCIImportRow(ctx, ciType, job, row("web-01", "web-03.example.com"), dryRun)
+	require.NoError(t, err)
+	assert.False(t, created)
+	_, created, err = service.applyCIImportRow(ctx, ciType, job, row("web-02", "web-01.example.com"), dryRun)
+	require.NoError(t, err)
+	assert.True(t, created)
+}
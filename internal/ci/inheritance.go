@@ -0,0 +1,242 @@
+package ci
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// resolveCIType merges a lineage, root first, into the effective definition of
+// its last type. Attributes are inherited from every ancestor; a type that
+// redefines an inherited attribute replaces it, and the section it is defined
+// in decides whether it is required. UpdatedAt is the latest across the
+// lineage so cached patterns are recompiled when any ancestor changes.
+func resolveCIType(lineage []CITypeDefinition) *CITypeDefinition {
+	if len(lineage) == 0 {
+		return nil
+	}
+
+	own := lineage[len(lineage)-1]
+	resolved := own
+	resolved.RequiredAttributes = []AttributeDefinition{}
+	resolved.OptionalAttributes = []AttributeDefinition{}
+	resolved.Ancestors = nil
+
+	for i, ciType := range lineage {
+		if i < len(lineage)-1 {
+			resolved.Ancestors = append(resolved.Ancestors, ciType.Name)
+		}
+		if ciType.UpdatedAt.After(resolved.UpdatedAt) {
+			resolved.UpdatedAt = ciType.UpdatedAt
+		}
+
+		for _, attr := range ciType.RequiredAttributes {
+			resolved.RequiredAttributes = removeAttribute(resolved.RequiredAttributes, attr.Name)
+			resolved.OptionalAttributes = removeAttribute(resolved.OptionalAttributes, attr.Name)
+			resolved.RequiredAttributes = append(resolved.RequiredAttributes, attr)
+		}
+		for _, attr := range ciType.OptionalAttributes {
+			resolved.RequiredAttributes = removeAttribute(resolved.RequiredAttributes, attr.Name)
+			resolved.OptionalAttributes = removeAttribute(resolved.OptionalAttributes, attr.Name)
+			resolved.OptionalAttributes = append(resolved.OptionalAttributes, attr)
+		}
+	}
+
+	return &resolved
+}
+
+// lineageNames returns the type names a CI of the resolved type answers to:
+// its own name followed by its ancestors, nearest first
+func (ciType *CITypeDefinition) lineageNames() []string {
+	names := []string{ciType.Name}
+	for i := len(ciType.Ancestors) - 1; i >= 0; i-- {
+		names = append(names, ciType.Ancestors[i])
+	}
+	return names
+}
+
+// checkInheritedAttributes checks a type's own attributes against the resolved
+// definition of its parent. An override must keep the inherited attribute's
+// type and cannot make a required attribute optional.
+func checkInheritedAttributes(ciType, parent *CITypeDefinition) []ValidationError {
+	var errors []ValidationError
+
+	check := func(attr AttributeDefinition, required bool) {
+		inherited := parent.findAttribute(attr.Name)
+		if inherited == nil {
+			return
+		}
+		if inherited.Type != attr.Type {
+			errors = append(errors, ValidationError{
+				Field:   attr.Name,
+				Message: fmt.Sprintf("overrides attribute inherited from '%s' with type %s; must stay %s", parent.Name, attr.Type, inherited.Type),
+			})
+		}
+		if !required && parent.findRequiredAttribute(attr.Name) != nil {
+			errors = append(errors, ValidationError{
+				Field:   attr.Name,
+				Message: fmt.Sprintf("is required by '%s' and cannot be made optional", parent.Name),
+			})
+		}
+	}
+
+	for _, attr := range ciType.RequiredAttributes {
+		check(attr, true)
+	}
+	for _, attr := range ciType.OptionalAttributes {
+		check(attr, false)
+	}
+
+	return errors
+}
+
+// findRequiredAttribute returns the definition of the named required attribute, or nil
+func (ciType *CITypeDefinition) findRequiredAttribute(name string) *AttributeDefinition {
+	for i := range ciType.RequiredAttributes {
+		if ciType.RequiredAttributes[i].Name == name {
+			return &ciType.RequiredAttributes[i]
+		}
+	}
+	return nil
+}
+
+// proposedLineage returns lineage with the stored definition of the type named
+// like proposed, and everything above it, replaced by parentLineage followed
+// by proposed. The result belongs to a schema that is not saved yet, so its
+// types carry no ID and their patterns are not cached.
+func proposedLineage(lineage, parentLineage []CITypeDefinition, proposed *CITypeDefinition) []CITypeDefinition {
+	rest := []CITypeDefinition{}
+	for i := range lineage {
+		if lineage[i].Name == proposed.Name {
+			rest = lineage[i+1:]
+			break
+		}
+	}
+
+	result := make([]CITypeDefinition, 0, len(parentLineage)+1+len(rest))
+	result = append(result, parentLineage...)
+	result = append(result, *proposed)
+	result = append(result, rest...)
+	for i := range result {
+		result[i].ID = uuid.Nil
+	}
+	return result
+}
+
+// ciTypeHierarchy holds every CI type by name, so types can be resolved and
+// expanded to their subtypes without a query each
+type ciTypeHierarchy map[string]CITypeDefinition
+
+func newCITypeHierarchy(ciTypes []CITypeDefinition) ciTypeHierarchy {
+	hierarchy := make(ciTypeHierarchy, len(ciTypes))
+	for _, ciType := range ciTypes {
+		hierarchy[ciType.Name] = ciType
+	}
+	return hierarchy
+}
+
+// resolve returns the effective definition of the named type, or nil when it
+// is not defined
+func (h ciTypeHierarchy) resolve(name string) *CITypeDefinition {
+	ciType, ok := h[name]
+	if !ok {
+		return nil
+	}
+
+	lineage := []CITypeDefinition{ciType}
+	for depth := 0; ciType.Parent != nil && depth < ciTypeHierarchyMaxDepth; depth++ {
+		if ciType, ok = h[*ciType.Parent]; !ok {
+			break
+		}
+		lineage = append([]CITypeDefinition{ciType}, lineage...)
+	}
+	return resolveCIType(lineage)
+}
+
+// expand returns the given names together with the names of every type that
+// inherits from them, like ExpandCITypes
+func (h ciTypeHierarchy) expand(names []string) []string {
+	children := make(map[string][]string)
+	for _, ciType := range h {
+		if ciType.Parent != nil {
+			children[*ciType.Parent] = append(children[*ciType.Parent], ciType.Name)
+		}
+	}
+	for _, names := range children {
+		sort.Strings(names)
+	}
+
+	seen := make(map[string]bool, len(names))
+	expanded := make([]string, 0, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			expanded = append(expanded, name)
+		}
+	}
+	for i := 0; i < len(expanded); i++ {
+		for _, child := range children[expanded[i]] {
+			if !seen[child] {
+				seen[child] = true
+				expanded = append(expanded, child)
+			}
+		}
+	}
+	return expanded
+}
+
+// ciTypeNames returns the names of a set of CI type schemas, sorted
+func ciTypeNames(schemas map[string]*CITypeDefinition) []string {
+	names := make([]string, 0, len(schemas))
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rollUpCITypeUsage combines per-type CI counts with the type hierarchy. Every
+// defined type is listed, and each type's TotalCount includes the CIs of all
+// of its subtypes. Counted types that are no longer defined are kept as roots.
+func rollUpCITypeUsage(ciTypes []CITypeDefinition, counts []CITypeUsage) []CITypeUsage {
+	usage := make(map[string]*CITypeUsage, len(ciTypes))
+	for _, ciType := range ciTypes {
+		usage[ciType.Name] = &CITypeUsage{
+			Type:     ciType.Name,
+			Parent:   ciType.Parent,
+			Abstract: ciType.Abstract,
+		}
+	}
+	for _, count := range counts {
+		entry, ok := usage[count.Type]
+		if !ok {
+			entry = &CITypeUsage{Type: count.Type}
+			usage[count.Type] = entry
+		}
+		entry.Count += count.Count
+	}
+
+	for _, entry := range usage {
+		current := entry
+		for depth := 0; current != nil && depth <= ciTypeHierarchyMaxDepth; depth++ {
+			current.TotalCount += entry.Count
+			if current.Parent == nil {
+				break
+			}
+			current = usage[*current.Parent]
+		}
+	}
+
+	result := make([]CITypeUsage, 0, len(usage))
+	for _, entry := range usage {
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TotalCount != result[j].TotalCount {
+			return result[i].TotalCount > result[j].TotalCount
+		}
+		return result[i].Type < result[j].Type
+	})
+	return result
+}
@@ -0,0 +1,127 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+)
+
+// CI type inheritance
+
+// ciTypeHierarchyMaxDepth bounds the recursive hierarchy queries. Cycles are
+// refused when a parent is set, so this only guards against corrupt data.
+const ciTypeHierarchyMaxDepth = 32
+
+// ciTypeFamilyQuery returns a query selecting the names of the CI types whose
+// names are in the array parameter at argIndex, plus every type that inherits
+// from them
+func ciTypeFamilyQuery(argIndex int) string {
+	return fmt.Sprintf(`
+		WITH RECURSIVE family AS (
+			SELECT name, 0 AS depth FROM ci_type_definitions WHERE name = ANY($%d)
+			UNION
+			SELECT t.name, f.depth + 1 FROM ci_type_definitions t JOIN family f ON t.parent = f.name
+			WHERE f.depth < %d
+		)
+		SELECT name FROM family`, argIndex, ciTypeHierarchyMaxDepth)
+}
+
+// GetCITypeLineage returns a CI type and its ancestors, root first
+func (r *Repository) GetCITypeLineage(ctx context.Context, name string) ([]CITypeDefinition, error) {
+	query := fmt.Sprintf(`
+		WITH RECURSIVE lineage AS (
+			SELECT %[1]s, 0 AS depth FROM ci_type_definitions WHERE name = $1
+			UNION ALL
+			SELECT t.id, t.name, t.description, t.required_attributes, t.optional_attributes, t.created_by,
+				t.created_at, t.updated_at, t.schema_version, t.parent, t.abstract, t.version, l.depth + 1
+			FROM ci_type_definitions t JOIN lineage l ON t.name = l.parent
+			WHERE l.depth < %[2]d
+		)
+		SELECT %[1]s FROM lineage ORDER BY depth DESC
+	`, ciTypeColumns, ciTypeHierarchyMaxDepth)
+
+	rows, err := r.conn(ctx).Query(ctx, query, name)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "ci_type_definitions", err, map[string]interface{}{
+			"ci_type_name": name,
+		})
+		return nil, fmt.Errorf("failed to get CI type lineage: %w", err)
+	}
+	defer rows.Close()
+
+	var lineage []CITypeDefinition
+	for rows.Next() {
+		var ciType CITypeDefinition
+		if err := scanCIType(rows, &ciType); err != nil {
+			r.logger.ErrorDatabase("SELECT", "ci_type_definitions", err, nil)
+			return nil, fmt.Errorf("failed to scan CI type: %w", err)
+		}
+		lineage = append(lineage, ciType)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get CI type lineage: %w", err)
+	}
+
+	if len(lineage) == 0 {
+		return nil, fmt.Errorf("CI type not found")
+	}
+
+	return lineage, nil
+}
+
+// ExpandCITypes returns the given CI type names together with the names of
+// every type that inherits from them. Names that are not defined are kept.
+func (r *Repository) ExpandCITypes(ctx context.Context, names []string) ([]string, error) {
+	rows, err := r.conn(ctx).Query(ctx, ciTypeFamilyQuery(1), names)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "ci_type_definitions", err, map[string]interface{}{
+			"ci_types": names,
+		})
+		return nil, fmt.Errorf("failed to expand CI types: %w", err)
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool, len(names))
+	expanded := make([]string, 0, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			expanded = append(expanded, name)
+		}
+	}
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			r.logger.ErrorDatabase("SELECT", "ci_type_definitions", err, nil)
+			return nil, fmt.Errorf("failed to scan CI type name: %w", err)
+		}
+		if !seen[name] {
+			seen[name] = true
+			expanded = append(expanded, name)
+		}
+	}
+
+	return expanded, rows.Err()
+}
+
+// ListAllCITypes returns every CI type ordered by name
+func (r *Repository) ListAllCITypes(ctx context.Context) ([]CITypeDefinition, error) {
+	rows, err := r.conn(ctx).Query(ctx, "SELECT "+ciTypeColumns+" FROM ci_type_definitions ORDER BY name")
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "ci_type_definitions", err, nil)
+		return nil, fmt.Errorf("failed to list CI types: %w", err)
+	}
+	defer rows.Close()
+
+	var ciTypes []CITypeDefinition
+	for rows.Next() {
+		var ciType CITypeDefinition
+		if err := scanCIType(rows, &ciType); err != nil {
+			r.logger.ErrorDatabase("SELECT", "ci_type_definitions", err, nil)
+			return nil, fmt.Errorf("failed to scan CI type: %w", err)
+		}
+		ciTypes = append(ciTypes, ciType)
+	}
+
+	return ciTypes, rows.Err()
+}
@@ -0,0 +1,177 @@
+package ci
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serverLineage() []CITypeDefinition {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	server := "Server"
+	return []CITypeDefinition{
+		{
+			ID:       uuid.New(),
+			Name:     "Server",
+			Abstract: true,
+			RequiredAttributes: []AttributeDefinition{
+				{Name: "hostname", Type: "hostname"},
+				{Name: "cpu_cores", Type: "integer"},
+			},
+			OptionalAttributes: []AttributeDefinition{
+				{Name: "os_version", Type: "string"},
+			},
+			UpdatedAt: base.Add(time.Hour),
+		},
+		{
+			ID:     uuid.New(),
+			Name:   "LinuxServer",
+			Parent: &server,
+			RequiredAttributes: []AttributeDefinition{
+				{Name: "os_version", Type: "string", Validation: &AttributeValidation{Pattern: `^\d+\.\d+$`}},
+				{Name: "kernel", Type: "string"},
+			},
+			UpdatedAt: base,
+		},
+	}
+}
+
+func TestResolveCIType(t *testing.T) {
+	lineage := serverLineage()
+
+	resolved := resolveCIType(lineage)
+	require.NotNil(t, resolved)
+
+	assert.Equal(t, lineage[1].ID, resolved.ID)
+	assert.Equal(t, "LinuxServer", resolved.Name)
+	assert.False(t, resolved.Abstract)
+	assert.Equal(t, []string{"Server"}, resolved.Ancestors)
+	assert.Equal(t, []string{"LinuxServer", "Server"}, resolved.lineageNames())
+	assert.Equal(t, lineage[0].UpdatedAt, resolved.UpdatedAt)
+
+	// The override replaces the inherited optional attribute and makes it required
+	assert.Equal(t, []AttributeDefinition{
+		{Name: "hostname", Type: "hostname"},
+		{Name: "cpu_cores", Type: "integer"},
+		lineage[1].RequiredAttributes[0],
+		{Name: "kernel", Type: "string"},
+	}, resolved.RequiredAttributes)
+	assert.Empty(t, resolved.OptionalAttributes)
+
+	errors := resolved.ValidateAttributes(map[string]interface{}{
+		"hostname":   "web-01",
+		"cpu_cores":  float64(8),
+		"os_version": "22.04",
+	})
+	require.Len(t, errors, 1)
+	assert.Equal(t, "kernel", errors[0].Field)
+
+	// The lineage itself is left alone
+	assert.Len(t, lineage[0].OptionalAttributes, 1)
+	assert.Nil(t, resolveCIType(nil))
+}
+
+func TestCITypeHierarchy(t *testing.T) {
+	lineage := serverLineage()
+	linux := "LinuxServer"
+	ciTypes := append(lineage,
+		CITypeDefinition{Name: "UbuntuServer", Parent: &linux},
+		CITypeDefinition{Name: "Switch"},
+	)
+	hierarchy := newCITypeHierarchy(ciTypes)
+
+	// A type resolves as it does from its lineage
+	assert.Equal(t, resolveCIType(lineage), hierarchy.resolve("LinuxServer"))
+	assert.Equal(t, []string{"Server", "LinuxServer"}, hierarchy.resolve("UbuntuServer").Ancestors)
+	assert.Nil(t, hierarchy.resolve("Router"))
+
+	assert.Equal(t, []string{"Server", "LinuxServer", "UbuntuServer"}, hierarchy.expand([]string{"Server"}))
+	assert.Equal(t, []string{"Switch", "Router"}, hierarchy.expand([]string{"Switch", "Router", "Switch"}))
+}
+
+func TestCheckInheritedAttributes(t *testing.T) {
+	lineage := serverLineage()
+	parent := resolveCIType(lineage[:1])
+
+	assert.Empty(t, checkInheritedAttributes(&lineage[1], parent))
+
+	child := &CITypeDefinition{
+		Name: "WindowsServer",
+		RequiredAttributes: []AttributeDefinition{
+			{Name: "os_version", Type: "semver"},
+		},
+		OptionalAttributes: []AttributeDefinition{
+			{Name: "hostname", Type: "hostname"},
+			{Name: "domain", Type: "string"},
+		},
+	}
+
+	errors := checkInheritedAttributes(child, parent)
+	require.Len(t, errors, 2)
+	assert.Equal(t, "os_version", errors[0].Field)
+	assert.Contains(t, errors[0].Message, "must stay string")
+	assert.Equal(t, "hostname", errors[1].Field)
+	assert.Contains(t, errors[1].Message, "cannot be made optional")
+}
+
+func TestProposedLineage(t *testing.T) {
+	lineage := serverLineage()
+	device := CITypeDefinition{ID: uuid.New(), Name: "Device", RequiredAttributes: []AttributeDefinition{{Name: "serial", Type: "string"}}}
+	deviceName := "Device"
+
+	proposed := lineage[0].schemaCopy()
+	proposed.Parent = &deviceName
+
+	full := proposedLineage(lineage, []CITypeDefinition{device}, proposed)
+	require.Len(t, full, 3)
+	assert.Equal(t, "Device", full[0].Name)
+	assert.Equal(t, "Server", full[1].Name)
+	assert.Equal(t, "LinuxServer", full[2].Name)
+	for _, ciType := range full {
+		assert.Equal(t, uuid.Nil, ciType.ID)
+	}
+	assert.NotEqual(t, uuid.Nil, lineage[1].ID)
+
+	resolved := resolveCIType(full)
+	assert.Equal(t, []string{"Device", "Server"}, resolved.Ancestors)
+	assert.NotNil(t, resolved.findRequiredAttribute("serial"))
+}
+
+func TestRollUpCITypeUsage(t *testing.T) {
+	server := "Server"
+	linux := "LinuxServer"
+	ciTypes := []CITypeDefinition{
+		{Name: "Server", Abstract: true},
+		{Name: "LinuxServer", Parent: &server},
+		{Name: "UbuntuServer", Parent: &linux},
+		{Name: "WindowsServer", Parent: &server},
+		{Name: "Database"},
+	}
+
+	usage := rollUpCITypeUsage(ciTypes, []CITypeUsage{
+		{Type: "LinuxServer", Count: 4},
+		{Type: "UbuntuServer", Count: 3},
+		{Type: "WindowsServer", Count: 2},
+		{Type: "Retired", Count: 1},
+	})
+
+	byType := map[string]CITypeUsage{}
+	for _, u := range usage {
+		byType[u.Type] = u
+	}
+	require.Len(t, usage, 6)
+
+	assert.Equal(t, "Server", usage[0].Type)
+	assert.Equal(t, 0, byType["Server"].Count)
+	assert.Equal(t, 9, byType["Server"].TotalCount)
+	assert.True(t, byType["Server"].Abstract)
+	assert.Equal(t, 7, byType["LinuxServer"].TotalCount)
+	assert.Equal(t, 3, byType["UbuntuServer"].TotalCount)
+	assert.Equal(t, 2, byType["WindowsServer"].TotalCount)
+	assert.Equal(t, 1, byType["Retired"].TotalCount)
+	assert.Equal(t, 0, byType["Database"].TotalCount)
+	assert.Equal(t, "Database", usage[len(usage)-1].Type)
+}
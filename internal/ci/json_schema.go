@@ -0,0 +1,483 @@
+package ci
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// jsonSchemaDialect is the JSON Schema draft CI type schemas are written in
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchema is the subset of a JSON Schema (draft 2020-12) document that CI
+// type definitions map onto. The x-pustaka-* keywords carry the settings JSON
+// Schema has no keyword for, so a type survives a round trip unchanged;
+// validators ignore them.
+type JSONSchema struct {
+	Schema               string               `json:"$schema,omitempty"`
+	Title                string               `json:"title,omitempty"`
+	Description          string               `json:"description,omitempty"`
+	Type                 string               `json:"type,omitempty"`
+	Format               string               `json:"format,omitempty"`
+	Pattern              string               `json:"pattern,omitempty"`
+	MinLength            *int                 `json:"minLength,omitempty"`
+	MaxLength            *int                 `json:"maxLength,omitempty"`
+	Minimum              *float64             `json:"minimum,omitempty"`
+	Maximum              *float64             `json:"maximum,omitempty"`
+	MinItems             *int                 `json:"minItems,omitempty"`
+	MaxItems             *int                 `json:"maxItems,omitempty"`
+	MinProperties        *int                 `json:"minProperties,omitempty"`
+	MaxProperties        *int                 `json:"maxProperties,omitempty"`
+	Enum                 []interface{}        `json:"enum,omitempty"`
+	Const                interface{}          `json:"const,omitempty"`
+	Default              interface{}          `json:"default,omitempty"`
+	ReadOnly             bool                 `json:"readOnly,omitempty"`
+	Items                *JSONSchema          `json:"items,omitempty"`
+	Properties           JSONSchemaProperties `json:"properties,omitempty"`
+	Required             []string             `json:"required,omitempty"`
+	AdditionalProperties *bool                `json:"additionalProperties,omitempty"`
+	AllOf                []JSONSchema         `json:"allOf,omitempty"`
+	If                   *JSONSchema          `json:"if,omitempty"`
+	Then                 *JSONSchema          `json:"then,omitempty"`
+
+	XType          string            `json:"x-pustaka-type,omitempty"`
+	XRequired      bool              `json:"x-pustaka-required,omitempty"`
+	XReference     *ReferenceOptions `json:"x-pustaka-reference,omitempty"`
+	XComputed      string            `json:"x-pustaka-computed,omitempty"`
+	XUnique        string            `json:"x-pustaka-unique,omitempty"`
+	XParent        string            `json:"x-pustaka-parent,omitempty"`
+	XAbstract      bool              `json:"x-pustaka-abstract,omitempty"`
+	XSchemaVersion int               `json:"x-pustaka-schema-version,omitempty"`
+}
+
+// JSONSchemaProperty is one named entry of a properties keyword
+type JSONSchemaProperty struct {
+	Name   string
+	Schema *JSONSchema
+}
+
+// JSONSchemaProperties keeps properties in document order, so attributes keep
+// the order they are defined in
+type JSONSchemaProperties []JSONSchemaProperty
+
+func (p JSONSchemaProperties) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, property := range p {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(property.Name)
+		if err != nil {
+			return nil, err
+		}
+		schema, err := json.Marshal(property.Schema)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(schema)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (p *JSONSchemaProperties) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		*p = nil
+		return nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("properties must be an object")
+	}
+
+	properties := JSONSchemaProperties{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		schema := &JSONSchema{}
+		if err := decoder.Decode(schema); err != nil {
+			return err
+		}
+		properties = append(properties, JSONSchemaProperty{Name: token.(string), Schema: schema})
+	}
+	*p = properties
+	return nil
+}
+
+// get returns the schema of the named property, or nil
+func (p JSONSchemaProperties) get(name string) *JSONSchema {
+	for _, property := range p {
+		if property.Name == name {
+			return property.Schema
+		}
+	}
+	return nil
+}
+
+// jsonSchemaTypes maps attribute types to a JSON Schema type and format.
+// Types without a standard format are identified by x-pustaka-type alone.
+var jsonSchemaTypes = map[string][2]string{
+	"string":      {"string", ""},
+	"integer":     {"integer", ""},
+	"number":      {"number", ""},
+	"boolean":     {"boolean", ""},
+	"array":       {"array", ""},
+	"object":      {"object", ""},
+	"date":        {"string", "date"},
+	"datetime":    {"string", "date-time"},
+	"ipv4":        {"string", "ipv4"},
+	"ipv6":        {"string", "ipv6"},
+	"cidr":        {"string", ""},
+	"mac_address": {"string", ""},
+	"semver":      {"string", ""},
+	"duration":    {"string", ""},
+	"hostname":    {"string", "hostname"},
+	"fqdn":        {"string", "hostname"},
+	"reference":   {"string", "uuid"},
+}
+
+// validationFormats maps AttributeValidation formats to JSON Schema formats
+var validationFormats = map[string]string{
+	"email":    "email",
+	"url":      "uri",
+	"ipv4":     "ipv4",
+	"date":     "date",
+	"datetime": "date-time",
+}
+
+// JSONSchema describes the body of a request creating a CI of this type. It
+// should be called on a resolved type so inherited attributes are included.
+func (ciType *CITypeDefinition) JSONSchema() *JSONSchema {
+	closed := false
+	attributes := &JSONSchema{
+		Type:                 "object",
+		Properties:           JSONSchemaProperties{},
+		AdditionalProperties: &closed,
+	}
+
+	for _, attr := range ciType.RequiredAttributes {
+		property := attributeJSONSchema(attr)
+		// The API fills in defaults, so a payload may leave these out
+		if attr.Default != nil {
+			property.XRequired = true
+		} else {
+			attributes.Required = append(attributes.Required, attr.Name)
+		}
+		attributes.Properties = append(attributes.Properties, JSONSchemaProperty{Name: attr.Name, Schema: property})
+	}
+	for _, attr := range ciType.OptionalAttributes {
+		attributes.Properties = append(attributes.Properties, JSONSchemaProperty{Name: attr.Name, Schema: attributeJSONSchema(attr)})
+		if attr.RequiredIf != nil {
+			attributes.AllOf = append(attributes.AllOf, requiredIfJSONSchema(attr.Name, attr.RequiredIf))
+		}
+	}
+
+	minNameLength := 1
+	doc := &JSONSchema{
+		Schema: jsonSchemaDialect,
+		Title:  ciType.Name,
+		Type:   "object",
+		Properties: JSONSchemaProperties{
+			{Name: "name", Schema: &JSONSchema{Type: "string", MinLength: &minNameLength}},
+			{Name: "ci_type", Schema: &JSONSchema{Const: ciType.Name}},
+			{Name: "attributes", Schema: attributes},
+			{Name: "tags", Schema: &JSONSchema{Type: "array", Items: &JSONSchema{Type: "string"}}},
+		},
+		Required:       []string{"name", "ci_type"},
+		XAbstract:      ciType.Abstract,
+		XSchemaVersion: ciType.SchemaVersion,
+	}
+	if ciType.Description != nil {
+		doc.Description = *ciType.Description
+	}
+	if ciType.Parent != nil {
+		doc.XParent = *ciType.Parent
+	}
+	return doc
+}
+
+func attributeJSONSchema(attr AttributeDefinition) *JSONSchema {
+	mapped := jsonSchemaTypes[attr.Type]
+	schema := &JSONSchema{
+		Type:        mapped[0],
+		Format:      mapped[1],
+		Description: attr.Description,
+		Default:     attr.Default,
+		ReadOnly:    attr.Computed != "",
+		XType:       attr.Type,
+		XReference:  attr.Reference,
+		XComputed:   attr.Computed,
+		XUnique:     attr.Unique,
+	}
+
+	v := attr.Validation
+	if v == nil {
+		return schema
+	}
+	if schema.Format == "" && v.Format != "" {
+		schema.Format = v.Format
+		if format, ok := validationFormats[v.Format]; ok {
+			schema.Format = format
+		}
+	}
+
+	var enum []interface{}
+	for _, value := range v.Enum {
+		enum = append(enum, value)
+	}
+
+	switch attr.Type {
+	case "array":
+		schema.MinItems, schema.MaxItems = v.MinLength, v.MaxLength
+		if len(enum) > 0 {
+			schema.Items = &JSONSchema{Enum: enum}
+		}
+	case "object":
+		schema.MinProperties, schema.MaxProperties = v.MinLength, v.MaxLength
+	default:
+		schema.MinLength, schema.MaxLength = v.MinLength, v.MaxLength
+		schema.Pattern = v.Pattern
+		schema.Minimum, schema.Maximum = v.Min, v.Max
+		schema.Enum = enum
+	}
+	return schema
+}
+
+// requiredIfJSONSchema expresses a required_if condition as an if/then rule
+func requiredIfJSONSchema(name string, condition *AttributeCondition) JSONSchema {
+	match := &JSONSchema{Const: condition.Equals}
+	if condition.Equals == nil {
+		match = &JSONSchema{Enum: condition.In}
+	}
+	return JSONSchema{
+		If: &JSONSchema{
+			Properties: JSONSchemaProperties{{Name: condition.Attribute, Schema: match}},
+			Required:   []string{condition.Attribute},
+		},
+		Then: &JSONSchema{Required: []string{name}},
+	}
+}
+
+// CITypeRequest reads a CI type definition back from a JSON Schema document.
+// The document may describe the whole CI payload, as JSONSchema writes it, or
+// just the attributes object. Keywords with no CI type equivalent are ignored.
+func (doc *JSONSchema) CITypeRequest() (*CreateCITypeRequest, []ValidationError) {
+	var errors []ValidationError
+
+	req := &CreateCITypeRequest{
+		Name:               doc.Title,
+		RequiredAttributes: []AttributeDefinition{},
+		OptionalAttributes: []AttributeDefinition{},
+		Abstract:           doc.XAbstract,
+	}
+	if req.Name == "" {
+		if ciType := doc.Properties.get("ci_type"); ciType != nil {
+			req.Name, _ = ciType.Const.(string)
+		}
+	}
+	if req.Name == "" {
+		errors = append(errors, ValidationError{Field: "title", Message: "the CI type name is required"})
+	}
+	if doc.Description != "" {
+		req.Description = &doc.Description
+	}
+	if doc.XParent != "" {
+		req.Parent = &doc.XParent
+	}
+
+	attributes := doc
+	if nested := doc.Properties.get("attributes"); nested != nil && nested.Type == "object" {
+		attributes = nested
+	}
+	if attributes.Type != "" && attributes.Type != "object" {
+		errors = append(errors, ValidationError{Field: "type", Message: "attributes must be described by an object schema"})
+		return req, errors
+	}
+
+	required := make(map[string]bool, len(attributes.Required))
+	for _, name := range attributes.Required {
+		required[name] = true
+	}
+
+	for _, property := range attributes.Properties {
+		attr, attrErrors := property.Schema.attributeDefinition(property.Name)
+		errors = append(errors, attrErrors...)
+		if required[property.Name] || property.Schema.XRequired {
+			req.RequiredAttributes = append(req.RequiredAttributes, attr)
+		} else {
+			req.OptionalAttributes = append(req.OptionalAttributes, attr)
+		}
+		delete(required, property.Name)
+	}
+	for _, name := range attributes.Required {
+		if required[name] {
+			errors = append(errors, ValidationError{
+				Field:   "required",
+				Message: fmt.Sprintf("required attribute '%s' has no schema in properties", name),
+			})
+		}
+	}
+
+	for i, rule := range attributes.AllOf {
+		name, condition := rule.requiredIf()
+		field := fmt.Sprintf("allOf[%d]", i)
+		if condition == nil {
+			errors = append(errors, ValidationError{
+				Field:   field,
+				Message: "only if/then rules that require one attribute when another has a given value are supported",
+			})
+			continue
+		}
+		found := false
+		for j := range req.OptionalAttributes {
+			if req.OptionalAttributes[j].Name == name {
+				req.OptionalAttributes[j].RequiredIf = condition
+				found = true
+			}
+		}
+		if !found {
+			errors = append(errors, ValidationError{
+				Field:   field,
+				Message: fmt.Sprintf("'%s' must be an optional attribute to be conditionally required", name),
+			})
+		}
+	}
+
+	return req, errors
+}
+
+// attributeDefinition reads one attribute from its property schema. Without
+// x-pustaka-type the attribute type is inferred from type and format.
+func (schema *JSONSchema) attributeDefinition(name string) (AttributeDefinition, []ValidationError) {
+	var errors []ValidationError
+
+	attrType := schema.XType
+	if attrType == "" {
+		attrType = schema.Type
+		if schema.Type == "string" {
+			switch schema.Format {
+			case "date", "ipv4", "ipv6", "hostname":
+				attrType = schema.Format
+			case "date-time":
+				attrType = "datetime"
+			}
+		}
+	}
+	if _, known := jsonSchemaTypes[attrType]; !known {
+		message := fmt.Sprintf("unsupported type '%s'", attrType)
+		if attrType == "" {
+			message = "type is required"
+		}
+		errors = append(errors, ValidationError{Field: name + ".type", Message: message})
+	}
+
+	attr := AttributeDefinition{
+		Name:        name,
+		Type:        attrType,
+		Description: schema.Description,
+		Reference:   schema.XReference,
+		Default:     schema.Default,
+		Computed:    schema.XComputed,
+		Unique:      schema.XUnique,
+	}
+
+	v := AttributeValidation{}
+	if schema.Format != "" && schema.Format != jsonSchemaTypes[attrType][1] {
+		v.Format = schema.Format
+		for format, jsonFormat := range validationFormats {
+			if jsonFormat == schema.Format {
+				v.Format = format
+			}
+		}
+	}
+
+	enum := schema.Enum
+	switch attrType {
+	case "array":
+		v.MinLength, v.MaxLength = schema.MinItems, schema.MaxItems
+		enum = nil
+		if schema.Items != nil {
+			enum = schema.Items.Enum
+		}
+	case "object":
+		v.MinLength, v.MaxLength = schema.MinProperties, schema.MaxProperties
+		enum = nil
+	default:
+		v.MinLength, v.MaxLength = schema.MinLength, schema.MaxLength
+		v.Pattern = schema.Pattern
+		v.Min, v.Max = schema.Minimum, schema.Maximum
+	}
+	for _, value := range enum {
+		s, ok := value.(string)
+		if !ok {
+			errors = append(errors, ValidationError{Field: name + ".enum", Message: "enum values must be strings"})
+			break
+		}
+		v.Enum = append(v.Enum, s)
+	}
+
+	if !reflect.DeepEqual(v, AttributeValidation{}) {
+		attr.Validation = &v
+	}
+	return attr, errors
+}
+
+// requiredIf recognises the if/then rule requiredIfJSONSchema writes and
+// returns the attribute it requires and the condition
+func (rule *JSONSchema) requiredIf() (string, *AttributeCondition) {
+	if rule.If == nil || rule.Then == nil || len(rule.If.Properties) != 1 || len(rule.Then.Required) != 1 {
+		return "", nil
+	}
+	property := rule.If.Properties[0]
+	if len(rule.If.Required) != 1 || rule.If.Required[0] != property.Name {
+		return "", nil
+	}
+
+	condition := &AttributeCondition{Attribute: property.Name}
+	switch {
+	case property.Schema.Const != nil:
+		condition.Equals = property.Schema.Const
+	case len(property.Schema.Enum) > 0:
+		condition.In = property.Schema.Enum
+	default:
+		return "", nil
+	}
+	return rule.Then.Required[0], condition
+}
+
+// withoutInherited drops the attributes a type would inherit unchanged from
+// its parent, leaving only its own definitions and overrides
+func withoutInherited(attrs, inherited []AttributeDefinition) []AttributeDefinition {
+	own := []AttributeDefinition{}
+	for _, attr := range attrs {
+		keep := true
+		for _, parentAttr := range inherited {
+			if parentAttr.Name == attr.Name && reflect.DeepEqual(parentAttr, attr) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			own = append(own, attr)
+		}
+	}
+	return own
+}
+
+// sameAttributes compares attribute lists, treating nil and empty as equal
+func sameAttributes(a, b []AttributeDefinition) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
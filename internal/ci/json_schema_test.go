@@ -0,0 +1,116 @@
+package ci
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONSchemaRoundTrip(t *testing.T) {
+	description := "Physical or virtual server"
+	minLength := 3
+	maxItems := 4
+	min := float64(1)
+	ciType := rulesTestType()
+	ciType.Description = &description
+	ciType.SchemaVersion = 3
+	ciType.RequiredAttributes = append(ciType.RequiredAttributes,
+		AttributeDefinition{Name: "owner", Type: "reference", Reference: &ReferenceOptions{CITypes: []string{"Team"}}},
+		AttributeDefinition{Name: "contact", Type: "string", Validation: &AttributeValidation{Format: "email", MinLength: &minLength}},
+	)
+	ciType.OptionalAttributes = append(ciType.OptionalAttributes,
+		AttributeDefinition{Name: "roles", Type: "array", Validation: &AttributeValidation{MaxLength: &maxItems, Enum: []string{"web", "db"}}},
+		AttributeDefinition{Name: "cores", Type: "integer", Validation: &AttributeValidation{Min: &min}},
+		AttributeDefinition{Name: "serial", Type: "string", Unique: UniqueScopeGlobal},
+	)
+
+	data, err := json.Marshal(ciType.JSONSchema())
+	require.NoError(t, err)
+
+	var doc JSONSchema
+	require.NoError(t, json.Unmarshal(data, &doc))
+	assert.Equal(t, jsonSchemaDialect, doc.Schema)
+
+	attributes := doc.Properties.get("attributes")
+	require.NotNil(t, attributes)
+	// deployment has a default, so a payload may leave it out
+	assert.Equal(t, []string{"hostname", "owner", "contact"}, attributes.Required)
+	assert.Equal(t, "hostname", attributes.Properties.get("hostname").Format)
+	assert.Equal(t, "email", attributes.Properties.get("contact").Format)
+	assert.Equal(t, []interface{}{"web", "db"}, attributes.Properties.get("roles").Items.Enum)
+	assert.True(t, attributes.Properties.get("fqdn").ReadOnly)
+	require.Len(t, attributes.AllOf, 1)
+
+	req, errors := doc.CITypeRequest()
+	require.Empty(t, errors)
+	assert.Equal(t, "Server", req.Name)
+	assert.Equal(t, &description, req.Description)
+	assert.Equal(t, ciType.RequiredAttributes, req.RequiredAttributes)
+	assert.Equal(t, ciType.OptionalAttributes, req.OptionalAttributes)
+}
+
+func TestJSONSchemaImportPlainSchema(t *testing.T) {
+	var doc JSONSchema
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title": "Switch",
+		"type": "object",
+		"properties": {
+			"mgmt_ip": {"type": "string", "format": "ipv4"},
+			"ports": {"type": "integer", "minimum": 1, "maximum": 96},
+			"installed": {"type": "string", "format": "date-time"},
+			"vendor": {"type": "string", "enum": ["cisco", "arista"]},
+			"site": {"type": "string"}
+		},
+		"required": ["mgmt_ip", "ports"],
+		"allOf": [
+			{"if": {"properties": {"vendor": {"const": "cisco"}}, "required": ["vendor"]}, "then": {"required": ["site"]}}
+		]
+	}`), &doc))
+
+	req, errors := doc.CITypeRequest()
+	require.Empty(t, errors)
+
+	require.Len(t, req.RequiredAttributes, 2)
+	assert.Equal(t, "ipv4", req.RequiredAttributes[0].Type)
+	assert.Nil(t, req.RequiredAttributes[0].Validation)
+	assert.Equal(t, float64(96), *req.RequiredAttributes[1].Validation.Max)
+
+	require.Len(t, req.OptionalAttributes, 3)
+	assert.Equal(t, "datetime", req.OptionalAttributes[0].Type)
+	assert.Equal(t, []string{"cisco", "arista"}, req.OptionalAttributes[1].Validation.Enum)
+	assert.Equal(t, &AttributeCondition{Attribute: "vendor", Equals: "cisco"}, req.OptionalAttributes[2].RequiredIf)
+}
+
+func TestJSONSchemaImportErrors(t *testing.T) {
+	var doc JSONSchema
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"type": "object",
+		"properties": {
+			"size": {"type": "integer", "enum": [1, 2]},
+			"blob": {"type": "null"},
+			"rack": {"type": "string"}
+		},
+		"required": ["rack", "missing"],
+		"allOf": [{"not": {"required": ["size"]}}]
+	}`), &doc))
+
+	_, errors := doc.CITypeRequest()
+	fields := make([]string, 0, len(errors))
+	for _, err := range errors {
+		fields = append(fields, err.Field)
+	}
+	assert.Equal(t, []string{"title", "size.enum", "blob.type", "required", "allOf[0]"}, fields)
+}
+
+func TestWithoutInherited(t *testing.T) {
+	parent := []AttributeDefinition{{Name: "hostname", Type: "hostname"}, {Name: "os", Type: "string"}}
+	own := withoutInherited([]AttributeDefinition{
+		{Name: "hostname", Type: "hostname"},
+		{Name: "os", Type: "string", Validation: &AttributeValidation{Enum: []string{"linux"}}},
+		{Name: "kernel", Type: "string"},
+	}, parent)
+	assert.Equal(t, []string{"os", "kernel"}, []string{own[0].Name, own[1].Name})
+}
@@ -2,6 +2,8 @@ package ci
 
 import (
 	"fmt"
+	"math"
+	"reflect"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,6 +19,7 @@ type ConfigurationItem struct {
 	UpdatedAt time.Time            `json:"updated_at" db:"updated_at"`
 	CreatedBy uuid.UUID            `json:"created_by" db:"created_by"`
 	UpdatedBy *uuid.UUID           `json:"updated_by,omitempty" db:"updated_by"`
+	Version   int                  `json:"version" db:"version"`
 }
 
 type CITypeDefinition struct {
@@ -28,21 +31,47 @@ type CITypeDefinition struct {
 	CreatedBy         uuid.UUID              `json:"created_by" db:"created_by"`
 	CreatedAt         time.Time              `json:"created_at" db:"created_at"`
 	UpdatedAt         time.Time              `json:"updated_at" db:"updated_at"`
-}
-
+	SchemaVersion     int                    `json:"schema_version" db:"schema_version"`
+	Parent            *string                `json:"parent,omitempty" db:"parent"`
+	Abstract          bool                   `json:"abstract" db:"abstract"`
+	// Ancestors lists the parent chain, root first, when the type has been
+	// resolved with its inherited attributes
+	Ancestors         []string               `json:"ancestors,omitempty" db:"-"`
+	// Version counts every change to the definition; SchemaVersion only
+	// counts attribute schema changes
+	Version           int                    `json:"version" db:"version"`
+}
+
+// AttributeDefinition describes one attribute of a CI type. Default is filled
+// in when a CI is created without the attribute. Computed attributes are
+// derived from other attributes on every write (see parseComputedExpression).
+// RequiredIf makes an optional attribute required while a condition holds.
+// Unique (type or global) refuses a value another CI of the same type, or of
+// any type, already holds.
 type AttributeDefinition struct {
 	Name        string                 `json:"name"`
 	Type        string                 `json:"type"`
 	Description string                 `json:"description"`
 	Validation  *AttributeValidation   `json:"validation,omitempty"`
+	Reference   *ReferenceOptions      `json:"reference,omitempty"`
+	Default     interface{}            `json:"default,omitempty"`
+	Computed    string                 `json:"computed,omitempty"`
+	RequiredIf  *AttributeCondition    `json:"required_if,omitempty"`
+	Unique      string                 `json:"unique,omitempty"`
+}
+
+// ReferenceOptions configures an attribute of type reference
+type ReferenceOptions struct {
+	CITypes  []string `json:"ci_types,omitempty"`
+	OnDelete string   `json:"on_delete,omitempty"`
 }
 
 type AttributeValidation struct {
 	Pattern    string      `json:"pattern,omitempty"`
 	MinLength  *int        `json:"min_length,omitempty"`
 	MaxLength  *int        `json:"max_length,omitempty"`
-	Min        *int        `json:"min,omitempty"`
-	Max        *int        `json:"max,omitempty"`
+	Min        *float64    `json:"min,omitempty"`
+	Max        *float64    `json:"max,omitempty"`
 	Enum       []string    `json:"enum,omitempty"`
 	Format     string      `json:"format,omitempty"`
 }
@@ -57,6 +86,7 @@ type Relationship struct {
 	UpdatedAt       *time.Time           `json:"updated_at,omitempty" db:"updated_at"`
 	CreatedBy       uuid.UUID            `json:"created_by" db:"created_by"`
 	UpdatedBy       *uuid.UUID           `json:"updated_by,omitempty" db:"updated_by"`
+	Version         int                  `json:"version" db:"version"`
 }
 
 type CreateCIRequest struct {
@@ -71,17 +101,40 @@ type UpdateCIRequest struct {
 	Tags      []string               `json:"tags,omitempty"`
 }
 
+// UpsertCIRequest is the body of an upsert by natural key. Name is only read
+// when the key is a unique attribute and the CI has to be created.
+type UpsertCIRequest struct {
+	Name       string                 `json:"name,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Tags       []string               `json:"tags,omitempty"`
+}
+
+// CIUpsertKey names a CI by its type and either its name or the value of one
+// of its unique attributes
+type CIUpsertKey struct {
+	CIType    string
+	Name      string
+	Attribute string
+	Value     string
+}
+
 type CreateCITypeRequest struct {
 	Name              string                 `json:"name" validate:"required"`
 	Description       *string                `json:"description,omitempty"`
 	RequiredAttributes []AttributeDefinition `json:"required_attributes" validate:"required,dive"`
 	OptionalAttributes []AttributeDefinition `json:"optional_attributes,omitempty,dive"`
+	Parent            *string                `json:"parent,omitempty"`
+	Abstract          bool                   `json:"abstract,omitempty"`
 }
 
+// UpdateCITypeRequest changes a CI type. An empty Parent detaches the type
+// from its parent.
 type UpdateCITypeRequest struct {
 	Description       *string                `json:"description,omitempty"`
 	RequiredAttributes []AttributeDefinition `json:"required_attributes,omitempty,dive"`
 	OptionalAttributes []AttributeDefinition `json:"optional_attributes,omitempty,dive"`
+	Parent            *string                `json:"parent,omitempty"`
+	Abstract          *bool                  `json:"abstract,omitempty"`
 }
 
 type CreateRelationshipRequest struct {
@@ -101,6 +154,9 @@ type CIListResponse struct {
 	Limit      int                 `json:"limit"`
 	Total      int64               `json:"total"`
 	TotalPages int                 `json:"total_pages"`
+	CursorPage
+	// Facets holds the requested facet counts, keyed by facet
+	Facets map[string][]FacetCount `json:"facets,omitempty"`
 }
 
 type CITypeListResponse struct {
@@ -117,6 +173,7 @@ type RelationshipListResponse struct {
 	Limit         int             `json:"limit"`
 	Total         int64           `json:"total"`
 	TotalPages    int             `json:"total_pages"`
+	CursorPage
 }
 
 type PaginationResponse struct {
@@ -124,6 +181,7 @@ type PaginationResponse struct {
 	Limit      int   `json:"limit"`
 	Total      int64 `json:"total"`
 	TotalPages int   `json:"total_pages"`
+	CursorPage
 }
 
 type ListCIFilters struct {
@@ -133,6 +191,21 @@ type ListCIFilters struct {
 	CreatedBy string  `json:"created_by,omitempty"`
 	Sort     string   `json:"sort,omitempty"`
 	Order    string   `json:"order,omitempty"`
+	// IncludeSubtypes widens the CIType filter to types that inherit from it
+	IncludeSubtypes bool `json:"include_subtypes,omitempty"`
+	// Query is a filter expression (see query.go); the service parses and
+	// type-checks it into query before the repository applies it
+	Query string `json:"q,omitempty"`
+	query ciQueryNode
+	// Cursor pages from a next_cursor or prev_cursor token instead of by page
+	// number; Count is exact, estimated or none
+	Cursor string `json:"cursor,omitempty"`
+	Count  string `json:"count,omitempty"`
+	// Facets asks for counts per value of ci_type, tags, created_by,
+	// attributes (every enum attribute) or attributes.<name>, keeping the
+	// FacetLimit most common values of each
+	Facets     []string `json:"facets,omitempty"`
+	FacetLimit int      `json:"facet_limit,omitempty"`
 }
 
 type ListRelationshipFilters struct {
@@ -142,11 +215,16 @@ type ListRelationshipFilters struct {
 	Search           string     `json:"search,omitempty"`
 	Sort             string     `json:"sort,omitempty"`
 	Order            string     `json:"order,omitempty"`
+	// Cursor pages from a next_cursor or prev_cursor token instead of by page
+	// number; Count is exact, estimated or none
+	Cursor string `json:"cursor,omitempty"`
+	Count  string `json:"count,omitempty"`
 }
 
 // ValidateAttributes validates CI attributes against a CI type definition
 func (ciType *CITypeDefinition) ValidateAttributes(attributes map[string]interface{}) []ValidationError {
 	var errors []ValidationError
+	patterns := ciTypePatterns.get(ciType)
 
 	// Check required attributes
 	for _, reqAttr := range ciType.RequiredAttributes {
@@ -160,7 +238,7 @@ func (ciType *CITypeDefinition) ValidateAttributes(attributes map[string]interfa
 		}
 
 		// Validate field type and constraints
-		if fieldErrors := validateField(reqAttr, value); len(fieldErrors) > 0 {
+		if fieldErrors := validateField(reqAttr, value, patterns); len(fieldErrors) > 0 {
 			errors = append(errors, fieldErrors...)
 		}
 	}
@@ -169,15 +247,44 @@ func (ciType *CITypeDefinition) ValidateAttributes(attributes map[string]interfa
 	for _, optAttr := range ciType.OptionalAttributes {
 		value, exists := attributes[optAttr.Name]
 		if !exists || value == nil {
+			if optAttr.RequiredIf != nil && optAttr.RequiredIf.matches(attributes) {
+				errors = append(errors, ValidationError{
+					Field:   optAttr.Name,
+					Message: fmt.Sprintf("required when %s", optAttr.RequiredIf),
+				})
+			}
 			continue
 		}
 
 		// Validate field type and constraints
-		if fieldErrors := validateField(optAttr, value); len(fieldErrors) > 0 {
+		if fieldErrors := validateField(optAttr, value, patterns); len(fieldErrors) > 0 {
 			errors = append(errors, fieldErrors...)
 		}
 	}
 
+	// Check computed attributes hold the value of their expression
+	for _, attrs := range [][]AttributeDefinition{ciType.RequiredAttributes, ciType.OptionalAttributes} {
+		for _, attr := range attrs {
+			if attr.Computed == "" {
+				continue
+			}
+			expr, err := parseComputedExpression(attr.Computed)
+			if err != nil {
+				continue
+			}
+			value, exists := attributes[attr.Name]
+			if !exists || value == nil {
+				continue
+			}
+			if expected := expr.evaluate(attributes); !reflect.DeepEqual(value, expected) {
+				errors = append(errors, ValidationError{
+					Field:   attr.Name,
+					Message: fmt.Sprintf("computed attribute must equal %s", attr.Computed),
+				})
+			}
+		}
+	}
+
 	// Check for unknown attributes
 	knownAttrs := make(map[string]bool)
 	for _, attr := range ciType.RequiredAttributes {
@@ -279,7 +386,7 @@ func validateObjectField(attrDef AttributeDefinition, value map[string]interface
 	return errors
 }
 
-func validateField(attrDef AttributeDefinition, value interface{}) []ValidationError {
+func validateField(attrDef AttributeDefinition, value interface{}, patterns *attributePatterns) []ValidationError {
 	var errors []ValidationError
 
 	// Type validation
@@ -293,13 +400,21 @@ func validateField(attrDef AttributeDefinition, value interface{}) []ValidationE
 			return errors
 		}
 	case "integer":
-		if _, ok := value.(float64); !ok {
+		if number, ok := value.(float64); !ok || number != math.Trunc(number) {
 			errors = append(errors, ValidationError{
 				Field:   attrDef.Name,
 				Message: "must be an integer",
 			})
 			return errors
 		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			errors = append(errors, ValidationError{
+				Field:   attrDef.Name,
+				Message: "must be a number",
+			})
+			return errors
+		}
 	case "boolean":
 		if _, ok := value.(bool); !ok {
 			errors = append(errors, ValidationError{
@@ -334,15 +449,25 @@ func validateField(attrDef AttributeDefinition, value interface{}) []ValidationE
 				errors = append(errors, fieldErrors...)
 			}
 		}
+	default:
+		if stringType, known := stringAttributeTypes[attrDef.Type]; known {
+			if strValue, ok := value.(string); !ok || !stringType.valid(strValue) {
+				errors = append(errors, ValidationError{
+					Field:   attrDef.Name,
+					Message: stringType.message,
+				})
+				return errors
+			}
+		}
 	}
 
 	// Type-specific validation rules
 	if attrDef.Validation != nil {
 		if strValue, ok := value.(string); ok {
-			validationErrors := validateStringField(attrDef, strValue)
+			validationErrors := validateStringField(attrDef, strValue, patterns)
 			errors = append(errors, validationErrors...)
-		} else if intValue, ok := value.(float64); ok {
-			validationErrors := validateIntegerField(attrDef, int(intValue))
+		} else if numberValue, ok := value.(float64); ok {
+			validationErrors := validateNumberField(attrDef, numberValue)
 			errors = append(errors, validationErrors...)
 		}
 	}
@@ -350,7 +475,7 @@ func validateField(attrDef AttributeDefinition, value interface{}) []ValidationE
 	return errors
 }
 
-func validateStringField(attrDef AttributeDefinition, value string) []ValidationError {
+func validateStringField(attrDef AttributeDefinition, value string, patterns *attributePatterns) []ValidationError {
 	var errors []ValidationError
 	validation := attrDef.Validation
 
@@ -376,7 +501,12 @@ func validateStringField(attrDef AttributeDefinition, value string) []Validation
 
 	// Pattern validation (regex)
 	if validation.Pattern != "" {
-		if !matchesPattern(value, validation.Pattern) {
+		if err, invalid := patterns.invalid[attrDef.Name]; invalid {
+			errors = append(errors, ValidationError{
+				Field:   attrDef.Name,
+				Message: fmt.Sprintf("CI type has an invalid pattern for this attribute: %v", err),
+			})
+		} else if re := patterns.compiled[attrDef.Name]; re != nil && !re.MatchString(value) {
 			errors = append(errors, ValidationError{
 				Field:   attrDef.Name,
 				Message: fmt.Sprintf("must match pattern: %s", validation.Pattern),
@@ -411,7 +541,7 @@ func validateStringField(attrDef AttributeDefinition, value string) []Validation
 	return errors
 }
 
-func validateIntegerField(attrDef AttributeDefinition, value int) []ValidationError {
+func validateNumberField(attrDef AttributeDefinition, value float64) []ValidationError {
 	var errors []ValidationError
 	validation := attrDef.Validation
 
@@ -423,7 +553,7 @@ func validateIntegerField(attrDef AttributeDefinition, value int) []ValidationEr
 	if validation.Min != nil && value < *validation.Min {
 		errors = append(errors, ValidationError{
 			Field:   attrDef.Name,
-			Message: fmt.Sprintf("minimum value is %d", *validation.Min),
+			Message: fmt.Sprintf("minimum value is %v", *validation.Min),
 		})
 	}
 
@@ -431,7 +561,7 @@ func validateIntegerField(attrDef AttributeDefinition, value int) []ValidationEr
 	if validation.Max != nil && value > *validation.Max {
 		errors = append(errors, ValidationError{
 			Field:   attrDef.Name,
-			Message: fmt.Sprintf("maximum value is %d", *validation.Max),
+			Message: fmt.Sprintf("maximum value is %v", *validation.Max),
 		})
 	}
 
@@ -464,19 +594,27 @@ func validateFormat(fieldName, value, format string) []ValidationError {
 			})
 		}
 	case "date":
-		if !isValidDate(value) {
+		// Formats are not normalized, so only the canonical layouts are accepted
+		if _, err := time.Parse("2006-01-02", value); err != nil {
 			errors = append(errors, ValidationError{
 				Field:   fieldName,
 				Message: "must be a valid date (YYYY-MM-DD)",
 			})
 		}
 	case "datetime":
-		if !isValidDateTime(value) {
+		if _, err := time.Parse(time.RFC3339Nano, value); err != nil {
 			errors = append(errors, ValidationError{
 				Field:   fieldName,
 				Message: "must be a valid datetime (ISO 8601)",
 			})
 		}
+	default:
+		if stringType, known := stringAttributeTypes[format]; known && !stringType.valid(value) {
+			errors = append(errors, ValidationError{
+				Field:   fieldName,
+				Message: stringType.message,
+			})
+		}
 	}
 
 	return errors
@@ -486,9 +624,9 @@ func validateFormat(fieldName, value, format string) []ValidationError {
 func isValidEmail(email string) bool {
 	// Basic email validation - in production, use proper regex or email package
 	return len(email) > 3 && len(email) < 254 &&
-		   len(email) > 0 && email[0] != '@' &&
-		   len(email) > 0 && email[len(email)-1] != '@' &&
-		   contains(email, "@")
+		email[0] != '@' &&
+		email[len(email)-1] != '@' &&
+		contains(email, "@")
 }
 
 func isValidURL(url string) bool {
@@ -524,23 +662,6 @@ func isValidIPv4(ip string) bool {
 	return true
 }
 
-func isValidDate(date string) bool {
-	// Basic date validation (YYYY-MM-DD)
-	if len(date) != 10 {
-		return false
-	}
-	return date[4] == '-' && date[7] == '-'
-}
-
-func isValidDateTime(datetime string) bool {
-	// Basic datetime validation (ISO 8601)
-	if len(datetime) < 19 {
-		return false
-	}
-	return datetime[4] == '-' && datetime[7] == '-' &&
-		   datetime[10] == 'T' && datetime[13] == ':' && datetime[16] == ':'
-}
-
 // Helper functions for string operations (simplified implementations)
 func contains(s, substr string) bool {
 	for i := 0; i <= len(s)-len(substr); i++ {
@@ -572,33 +693,6 @@ func splitBy(s, sep string) []string {
 	return parts
 }
 
-func matchesPattern(value, pattern string) bool {
-	// For common patterns, implement direct validation
-	// This avoids the complexity of regexp package for basic patterns
-
-	// Handle hostname pattern: letters, numbers, and hyphens
-	if pattern == "^[a-zA-Z0-9-]+$" {
-		if len(value) == 0 {
-			return false
-		}
-		for _, c := range value {
-			if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-') {
-				return false
-			}
-		}
-		return true
-	}
-
-	// Handle IP address pattern: basic IPv4 validation
-	if pattern == "^(?:(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\\.){3}(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)$" {
-		return isValidIPv4(value)
-	}
-
-	// For other patterns, use a simple contains check as fallback
-	// In production, you'd want to use the regexp package
-	return true
-}
-
 // Graph Models
 
 type RelationshipGraph struct {
@@ -635,6 +729,8 @@ type GraphFilters struct {
 	CITypes []string `json:"ci_types,omitempty"`
 	Search  string   `json:"search,omitempty"`
 	Limit   int      `json:"limit,omitempty"`
+	// IncludeSubtypes widens CITypes to types that inherit from them
+	IncludeSubtypes bool `json:"include_subtypes,omitempty"`
 }
 
 type CINetwork struct {
@@ -657,9 +753,14 @@ type ImpactAnalysis struct {
 	Upstream   []CIImpact `json:"upstream"`
 }
 
+// CITypeUsage counts the CIs of a type. Count covers the type itself and
+// TotalCount adds the CIs of every type that inherits from it.
 type CITypeUsage struct {
-	Type  string `json:"type"`
-	Count int    `json:"count"`
+	Type       string  `json:"type"`
+	Parent     *string `json:"parent,omitempty"`
+	Abstract   bool    `json:"abstract"`
+	Count      int     `json:"count"`
+	TotalCount int     `json:"total_count"`
 }
 
 type CIConnectivity struct {
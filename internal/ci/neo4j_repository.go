@@ -226,6 +226,71 @@ func (r *Neo4jRepository) CreateRelationship(ctx context.Context, rel *Relations
 	return nil
 }
 
+// SyncRelationship creates or updates a RELATES_TO edge keyed by relationship
+// ID, so it is safe to apply more than once. It fails if either CI node has not
+// been synced yet.
+func (r *Neo4jRepository) SyncRelationship(ctx context.Context, rel *Relationship) error {
+	session := r.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	_, err := session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (interface{}, error) {
+		cypher := `
+			MATCH (source:ConfigurationItem {id: $source_id})
+			MATCH (target:ConfigurationItem {id: $target_id})
+			MERGE (source)-[r:RELATES_TO {id: $rel_id}]->(target)
+			SET r.type = $rel_type,
+				r.attributes = $attributes,
+				r.created_at = $created_at,
+				r.created_by = $created_by,
+				r.updated_at = $updated_at
+			RETURN r.id
+		`
+
+		attributesJSON, _ := json.Marshal(rel.Attributes)
+
+		updatedAt := rel.CreatedAt
+		if rel.UpdatedAt != nil {
+			updatedAt = *rel.UpdatedAt
+		}
+
+		params := map[string]interface{}{
+			"source_id":  rel.SourceID.String(),
+			"target_id":  rel.TargetID.String(),
+			"rel_id":     rel.ID.String(),
+			"rel_type":   rel.RelationshipType,
+			"attributes": string(attributesJSON),
+			"created_at": rel.CreatedAt.Unix(),
+			"created_by": rel.CreatedBy.String(),
+			"updated_at": updatedAt.Unix(),
+		}
+
+		result, err := tx.Run(ctx, cypher, params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sync relationship: %w", err)
+		}
+
+		if !result.Next(ctx) {
+			if err := result.Err(); err != nil {
+				return nil, fmt.Errorf("failed to sync relationship: %w", err)
+			}
+			return nil, fmt.Errorf("source or target CI not found in graph")
+		}
+
+		return nil, nil
+	})
+
+	if err != nil {
+		r.logger.Error().Err(err).Interface("details", map[string]interface{}{
+			"relationship_id": rel.ID,
+			"source_id":       rel.SourceID,
+			"target_id":       rel.TargetID,
+		}).Msg("Failed to sync relationship to Neo4j")
+		return err
+	}
+
+	return nil
+}
+
 func (r *Neo4jRepository) UpdateRelationship(ctx context.Context, rel *Relationship) error {
 	session := r.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
 	defer session.Close(ctx)
@@ -594,4 +659,213 @@ func (r *Neo4jRepository) InitializeSchema(ctx context.Context) error {
 
 	r.logger.Info()
 	return nil
-}
\ No newline at end of file
+}
+// Bulk Operations used by graph reconciliation
+
+// ListCIStates returns the updated_at (Unix seconds) of every ConfigurationItem node, keyed by ID
+func (r *Neo4jRepository) ListCIStates(ctx context.Context) (map[string]int64, error) {
+	return r.listStates(ctx, `
+		MATCH (ci:ConfigurationItem)
+		RETURN ci.id, coalesce(ci.updated_at, ci.created_at, 0)
+	`)
+}
+
+// ListRelationshipStates returns the updated_at (Unix seconds) of every RELATES_TO edge, keyed by ID
+func (r *Neo4jRepository) ListRelationshipStates(ctx context.Context) (map[string]int64, error) {
+	return r.listStates(ctx, `
+		MATCH ()-[r:RELATES_TO]->()
+		RETURN r.id, coalesce(r.updated_at, r.created_at, 0)
+	`)
+}
+
+func (r *Neo4jRepository) listStates(ctx context.Context, cypher string) (map[string]int64, error) {
+	session := r.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(ctx)
+
+	states, err := session.ExecuteRead(ctx, func(tx neo4j.ManagedTransaction) (interface{}, error) {
+		result, err := tx.Run(ctx, cypher, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		states := make(map[string]int64)
+		for result.Next(ctx) {
+			values := result.Record().Values
+			id, _ := values[0].(string)
+			updatedAt, _ := values[1].(int64)
+			states[id] = updatedAt
+		}
+
+		return states, result.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list graph state: %w", err)
+	}
+
+	return states.(map[string]int64), nil
+}
+
+// SyncCIs creates or updates a batch of ConfigurationItem nodes
+func (r *Neo4jRepository) SyncCIs(ctx context.Context, cis []ConfigurationItem) error {
+	rows := make([]map[string]interface{}, 0, len(cis))
+	for _, ci := range cis {
+		attributesJSON, _ := json.Marshal(ci.Attributes)
+		tagsJSON, _ := json.Marshal(ci.Tags)
+
+		rows = append(rows, map[string]interface{}{
+			"id":         ci.ID.String(),
+			"name":       ci.Name,
+			"type":       ci.CIType,
+			"attributes": string(attributesJSON),
+			"tags":       string(tagsJSON),
+			"created_at": ci.CreatedAt.Unix(),
+			"updated_at": ci.UpdatedAt.Unix(),
+			"created_by": ci.CreatedBy.String(),
+		})
+	}
+
+	return r.runWrite(ctx, `
+		UNWIND $rows AS row
+		MERGE (ci:ConfigurationItem {id: row.id})
+		SET ci.name = row.name,
+			ci.type = row.type,
+			ci.attributes = row.attributes,
+			ci.tags = row.tags,
+			ci.created_at = row.created_at,
+			ci.updated_at = row.updated_at,
+			ci.created_by = row.created_by
+	`, map[string]interface{}{"rows": rows})
+}
+
+// SyncRelationships creates or updates a batch of RELATES_TO edges and returns
+// how many were written. Edges whose CI nodes are missing are skipped.
+func (r *Neo4jRepository) SyncRelationships(ctx context.Context, relationships []Relationship) (int, error) {
+	rows := make([]map[string]interface{}, 0, len(relationships))
+	for _, rel := range relationships {
+		attributesJSON, _ := json.Marshal(rel.Attributes)
+
+		updatedAt := rel.CreatedAt
+		if rel.UpdatedAt != nil {
+			updatedAt = *rel.UpdatedAt
+		}
+
+		rows = append(rows, map[string]interface{}{
+			"id":         rel.ID.String(),
+			"source_id":  rel.SourceID.String(),
+			"target_id":  rel.TargetID.String(),
+			"type":       rel.RelationshipType,
+			"attributes": string(attributesJSON),
+			"created_at": rel.CreatedAt.Unix(),
+			"created_by": rel.CreatedBy.String(),
+			"updated_at": updatedAt.Unix(),
+		})
+	}
+
+	session := r.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	written, err := session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (interface{}, error) {
+		cypher := `
+			UNWIND $rows AS row
+			MATCH (source:ConfigurationItem {id: row.source_id})
+			MATCH (target:ConfigurationItem {id: row.target_id})
+			MERGE (source)-[r:RELATES_TO {id: row.id}]->(target)
+			SET r.type = row.type,
+				r.attributes = row.attributes,
+				r.created_at = row.created_at,
+				r.created_by = row.created_by,
+				r.updated_at = row.updated_at
+			RETURN count(r)
+		`
+
+		result, err := tx.Run(ctx, cypher, map[string]interface{}{"rows": rows})
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := result.Single(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		count, _ := record.Values[0].(int64)
+		return int(count), nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to sync relationships: %w", err)
+	}
+
+	return written.(int), nil
+}
+
+// DeleteCIsByIDs removes ConfigurationItem nodes and their edges
+func (r *Neo4jRepository) DeleteCIsByIDs(ctx context.Context, ids []string) error {
+	return r.runWrite(ctx, `
+		UNWIND $ids AS id
+		MATCH (ci:ConfigurationItem {id: id})
+		DETACH DELETE ci
+	`, map[string]interface{}{"ids": ids})
+}
+
+// DeleteRelationshipsByIDs removes RELATES_TO edges
+func (r *Neo4jRepository) DeleteRelationshipsByIDs(ctx context.Context, ids []string) error {
+	return r.runWrite(ctx, `
+		MATCH ()-[r:RELATES_TO]->()
+		WHERE r.id IN $ids
+		DELETE r
+	`, map[string]interface{}{"ids": ids})
+}
+
+// DeleteAllCIs empties the graph in batches and returns the number of nodes removed
+func (r *Neo4jRepository) DeleteAllCIs(ctx context.Context, batchSize int) (int, error) {
+	session := r.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	total := 0
+	for {
+		deleted, err := session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (interface{}, error) {
+			cypher := `
+				MATCH (ci:ConfigurationItem)
+				WITH ci LIMIT $limit
+				DETACH DELETE ci
+				RETURN count(*)
+			`
+
+			result, err := tx.Run(ctx, cypher, map[string]interface{}{"limit": batchSize})
+			if err != nil {
+				return nil, err
+			}
+
+			record, err := result.Single(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			count, _ := record.Values[0].(int64)
+			return int(count), nil
+		})
+		if err != nil {
+			return total, fmt.Errorf("failed to clear graph: %w", err)
+		}
+
+		total += deleted.(int)
+		if deleted.(int) < batchSize {
+			return total, nil
+		}
+	}
+}
+
+func (r *Neo4jRepository) runWrite(ctx context.Context, cypher string, params map[string]interface{}) error {
+	session := r.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	_, err := session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (interface{}, error) {
+		result, err := tx.Run(ctx, cypher, params)
+		if err != nil {
+			return nil, err
+		}
+		return result.Consume(ctx)
+	})
+
+	return err
+}
@@ -35,6 +35,11 @@ func (s *Neo4jService) SyncCI(ctx context.Context, ci *ConfigurationItem) error
 	return s.repo.SyncCI(ctx, ci)
 }
 
+// SyncCIs creates or updates a batch of CI nodes with one write
+func (s *Neo4jService) SyncCIs(ctx context.Context, cis []ConfigurationItem) error {
+	return s.repo.SyncCIs(ctx, cis)
+}
+
 func (s *Neo4jService) UpdateCI(ctx context.Context, ci *ConfigurationItem) error {
 	return s.repo.UpdateCI(ctx, ci)
 }
@@ -49,6 +54,10 @@ func (s *Neo4jService) CreateRelationship(ctx context.Context, rel *Relationship
 	return s.repo.CreateRelationship(ctx, rel, sourceCI, targetCI)
 }
 
+func (s *Neo4jService) SyncRelationship(ctx context.Context, rel *Relationship) error {
+	return s.repo.SyncRelationship(ctx, rel)
+}
+
 func (s *Neo4jService) UpdateRelationship(ctx context.Context, rel *Relationship) error {
 	return s.repo.UpdateRelationship(ctx, rel)
 }
@@ -189,7 +198,9 @@ func (s *Neo4jService) FindCycles(ctx context.Context) ([][]uuid.UUID, error) {
 	return result.([][]uuid.UUID), nil
 }
 
-func (s *Neo4jService) GetImpactAnalysis(ctx context.Context, ciID uuid.UUID) (*ImpactAnalysis, error) {
+// GetImpactAnalysis walks dependency relationships only. A dependency edge
+// points from the dependent CI to the CI it depends on.
+func (s *Neo4jService) GetImpactAnalysis(ctx context.Context, ciID uuid.UUID, dependencyTypes []string) (*ImpactAnalysis, error) {
 	session := s.repo.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
 	defer session.Close(ctx)
 
@@ -198,33 +209,35 @@ func (s *Neo4jService) GetImpactAnalysis(ctx context.Context, ciID uuid.UUID) (*
 			MATCH (ci:ConfigurationItem {id: $ci_id})
 
 			// Downstream dependencies (what depends on this CI)
-			MATCH (ci)<-[depRel:RELATES_TO*]-(dependent:ConfigurationItem)
-			WITH ci, dependent, depRel, length(depRel) AS depth
+			OPTIONAL MATCH down = (ci)<-[:RELATES_TO*]-(dependent:ConfigurationItem)
+			WHERE dependent <> ci AND all(rel IN relationships(down) WHERE rel.type IN $dependency_types)
+			WITH ci, dependent, min(length(down)) AS depth
+			WITH ci, COLLECT(CASE WHEN dependent IS NULL THEN NULL ELSE {
+				id: dependent.id,
+				name: dependent.name,
+				type: dependent.type,
+				depth: depth,
+				direction: 'downstream'
+			} END)[..100] AS downstream
 
 			// Upstream dependencies (what this CI depends on)
-			MATCH (ci)-[depRel2:RELATES_TO*]->(dependency:ConfigurationItem)
-			WITH ci, dependent, dependency, depRel, depRel2, depth, length(depRel2) AS depth2
-
+			OPTIONAL MATCH up = (ci)-[:RELATES_TO*]->(dependency:ConfigurationItem)
+			WHERE dependency <> ci AND all(rel IN relationships(up) WHERE rel.type IN $dependency_types)
+			WITH downstream, dependency, min(length(up)) AS depth
 			RETURN
-				COLLECT(DISTINCT {
-					id: dependent.id,
-					name: dependent.name,
-					type: dependent.type,
-					depth: depth,
-					direction: 'downstream'
-				}) AS downstream,
-				COLLECT(DISTINCT {
+				downstream,
+				COLLECT(CASE WHEN dependency IS NULL THEN NULL ELSE {
 					id: dependency.id,
 					name: dependency.name,
 					type: dependency.type,
-					depth: depth2,
+					depth: depth,
 					direction: 'upstream'
-				}) AS upstream
-			LIMIT 100
+				} END)[..100] AS upstream
 		`
 
 		params := map[string]interface{}{
-			"ci_id": ciID.String(),
+			"ci_id":            ciID.String(),
+			"dependency_types": dependencyTypes,
 		}
 
 		cursor, err := tx.Run(ctx, cypher, params)
@@ -0,0 +1,43 @@
+package ci
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Graph sync outbox entity types, operations and statuses
+const (
+	GraphSyncEntityCI           = "ci"
+	GraphSyncEntityRelationship = "relationship"
+
+	GraphSyncOpUpsert = "upsert"
+	GraphSyncOpDelete = "delete"
+
+	GraphSyncStatusPending = "pending"
+	GraphSyncStatusDead    = "dead"
+)
+
+// GraphSyncEntry is a pending or dead-lettered change waiting to be applied to Neo4j
+type GraphSyncEntry struct {
+	ID            int64     `json:"id" db:"id"`
+	EntityType    string    `json:"entity_type" db:"entity_type"`
+	EntityID      uuid.UUID `json:"entity_id" db:"entity_id"`
+	Operation     string    `json:"operation" db:"operation"`
+	Status        string    `json:"status" db:"status"`
+	Attempts      int       `json:"attempts" db:"attempts"`
+	LastError     *string   `json:"last_error,omitempty" db:"last_error"`
+	NextAttemptAt time.Time `json:"next_attempt_at" db:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// GraphSyncStatus summarizes how far Neo4j lags behind PostgreSQL
+type GraphSyncStatus struct {
+	PendingCount    int64            `json:"pending_count"`
+	RetryingCount   int64            `json:"retrying_count"`
+	DeadCount       int64            `json:"dead_count"`
+	OldestPendingAt *time.Time       `json:"oldest_pending_at,omitempty"`
+	LagSeconds      float64          `json:"lag_seconds"`
+	Failed          []GraphSyncEntry `json:"failed"`
+}
@@ -0,0 +1,247 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Graph sync outbox operations
+
+const graphSyncColumns = "id, entity_type, entity_id, operation, status, attempts, last_error, next_attempt_at, created_at, updated_at"
+
+func scanGraphSyncEntry(row pgx.Row, entry *GraphSyncEntry) error {
+	return row.Scan(
+		&entry.ID,
+		&entry.EntityType,
+		&entry.EntityID,
+		&entry.Operation,
+		&entry.Status,
+		&entry.Attempts,
+		&entry.LastError,
+		&entry.NextAttemptAt,
+		&entry.CreatedAt,
+		&entry.UpdatedAt,
+	)
+}
+
+// EnqueueGraphSync records a change to be applied to Neo4j. Call it with the
+// context of the transaction making the change so both commit together.
+func (r *Repository) EnqueueGraphSync(ctx context.Context, entityType string, entityID uuid.UUID, operation string) error {
+	query := `
+		INSERT INTO graph_sync_outbox (entity_type, entity_id, operation)
+		VALUES ($1, $2, $3)
+	`
+
+	_, err := r.conn(ctx).Exec(ctx, query, entityType, entityID, operation)
+	if err != nil {
+		r.logger.ErrorDatabase("INSERT", "graph_sync_outbox", err, map[string]interface{}{
+			"entity_type": entityType,
+			"entity_id":   entityID,
+			"operation":   operation,
+		})
+		return fmt.Errorf("failed to enqueue graph sync: %w", err)
+	}
+
+	return nil
+}
+
+// EnqueueGraphSyncBatch records the same change for several entities with one
+// statement
+func (r *Repository) EnqueueGraphSyncBatch(ctx context.Context, entityType string, entityIDs []uuid.UUID, operation string) error {
+	if len(entityIDs) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO graph_sync_outbox (entity_type, entity_id, operation)
+		SELECT $1, entity_id, $3
+		FROM unnest($2::uuid[]) AS batch(entity_id)
+	`
+
+	_, err := r.conn(ctx).Exec(ctx, query, entityType, entityIDs, operation)
+	if err != nil {
+		r.logger.ErrorDatabase("INSERT", "graph_sync_outbox", err, map[string]interface{}{
+			"entity_type": entityType,
+			"operation":   operation,
+			"count":       len(entityIDs),
+		})
+		return fmt.Errorf("failed to enqueue graph sync: %w", err)
+	}
+
+	return nil
+}
+
+// CompleteGraphSyncBatch removes entries that have been applied to Neo4j
+func (r *Repository) CompleteGraphSyncBatch(ctx context.Context, ids []int64) error {
+	_, err := r.conn(ctx).Exec(ctx, "DELETE FROM graph_sync_outbox WHERE id = ANY($1)", ids)
+	if err != nil {
+		r.logger.ErrorDatabase("DELETE", "graph_sync_outbox", err, map[string]interface{}{
+			"count": len(ids),
+		})
+		return fmt.Errorf("failed to complete graph sync entries: %w", err)
+	}
+
+	return nil
+}
+
+// ClaimGraphSyncBatch locks up to limit entries that are due. An entry is only
+// claimed once every earlier pending entry for the same entity has been
+// applied, so changes to one entity reach Neo4j in commit order. Must be
+// called inside a transaction; the locks are held until it ends.
+func (r *Repository) ClaimGraphSyncBatch(ctx context.Context, limit int) ([]GraphSyncEntry, error) {
+	query := `
+		SELECT ` + graphSyncColumns + `
+		FROM graph_sync_outbox o
+		WHERE o.status = 'pending'
+		  AND o.next_attempt_at <= NOW()
+		  AND NOT EXISTS (
+			SELECT 1 FROM graph_sync_outbox earlier
+			WHERE earlier.entity_id = o.entity_id
+			  AND earlier.status = 'pending'
+			  AND earlier.id < o.id
+		  )
+		ORDER BY o.id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`
+
+	rows, err := r.conn(ctx).Query(ctx, query, limit)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "graph_sync_outbox", err, nil)
+		return nil, fmt.Errorf("failed to claim graph sync entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []GraphSyncEntry
+	for rows.Next() {
+		var entry GraphSyncEntry
+		if err := scanGraphSyncEntry(rows, &entry); err != nil {
+			r.logger.ErrorDatabase("SELECT", "graph_sync_outbox", err, nil)
+			return nil, fmt.Errorf("failed to scan graph sync entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// CompleteGraphSync removes an entry that has been applied to Neo4j
+func (r *Repository) CompleteGraphSync(ctx context.Context, id int64) error {
+	_, err := r.conn(ctx).Exec(ctx, "DELETE FROM graph_sync_outbox WHERE id = $1", id)
+	if err != nil {
+		r.logger.ErrorDatabase("DELETE", "graph_sync_outbox", err, map[string]interface{}{
+			"outbox_id": id,
+		})
+		return fmt.Errorf("failed to complete graph sync entry: %w", err)
+	}
+
+	return nil
+}
+
+// FailGraphSync records a failed attempt. The entry is retried at nextAttemptAt,
+// or moved to the dead-letter state when dead is set.
+func (r *Repository) FailGraphSync(ctx context.Context, id int64, syncErr error, nextAttemptAt time.Time, dead bool) error {
+	status := GraphSyncStatusPending
+	if dead {
+		status = GraphSyncStatusDead
+	}
+
+	query := `
+		UPDATE graph_sync_outbox
+		SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3, status = $4, updated_at = NOW()
+		WHERE id = $1
+	`
+
+	_, err := r.conn(ctx).Exec(ctx, query, id, syncErr.Error(), nextAttemptAt, status)
+	if err != nil {
+		r.logger.ErrorDatabase("UPDATE", "graph_sync_outbox", err, map[string]interface{}{
+			"outbox_id": id,
+		})
+		return fmt.Errorf("failed to record graph sync failure: %w", err)
+	}
+
+	return nil
+}
+
+// RetryGraphSync moves a dead-lettered entry back to pending with a fresh
+// attempt count
+func (r *Repository) RetryGraphSync(ctx context.Context, id int64) (*GraphSyncEntry, error) {
+	query := `
+		UPDATE graph_sync_outbox
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status = 'dead'
+		RETURNING ` + graphSyncColumns
+
+	var entry GraphSyncEntry
+	err := scanGraphSyncEntry(r.conn(ctx).QueryRow(ctx, query, id), &entry)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("graph sync entry not found")
+		}
+		r.logger.ErrorDatabase("UPDATE", "graph_sync_outbox", err, map[string]interface{}{
+			"outbox_id": id,
+		})
+		return nil, fmt.Errorf("failed to retry graph sync entry: %w", err)
+	}
+
+	return &entry, nil
+}
+
+// GetGraphSyncStatus reports the outbox backlog along with the most recent
+// failed entries, both retrying and dead
+func (r *Repository) GetGraphSyncStatus(ctx context.Context, failedLimit int) (*GraphSyncStatus, error) {
+	query := `
+		SELECT
+			COUNT(*) FILTER (WHERE status = 'pending'),
+			COUNT(*) FILTER (WHERE status = 'pending' AND attempts > 0),
+			COUNT(*) FILTER (WHERE status = 'dead'),
+			MIN(created_at) FILTER (WHERE status = 'pending')
+		FROM graph_sync_outbox
+	`
+
+	status := &GraphSyncStatus{Failed: []GraphSyncEntry{}}
+	err := r.conn(ctx).QueryRow(ctx, query).Scan(
+		&status.PendingCount,
+		&status.RetryingCount,
+		&status.DeadCount,
+		&status.OldestPendingAt,
+	)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "graph_sync_outbox", err, nil)
+		return nil, fmt.Errorf("failed to get graph sync status: %w", err)
+	}
+
+	if status.OldestPendingAt != nil {
+		status.LagSeconds = time.Since(*status.OldestPendingAt).Seconds()
+	}
+
+	failedQuery := `
+		SELECT ` + graphSyncColumns + `
+		FROM graph_sync_outbox
+		WHERE status = 'dead' OR attempts > 0
+		ORDER BY updated_at DESC
+		LIMIT $1
+	`
+
+	rows, err := r.conn(ctx).Query(ctx, failedQuery, failedLimit)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "graph_sync_outbox", err, nil)
+		return nil, fmt.Errorf("failed to list failed graph sync entries: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry GraphSyncEntry
+		if err := scanGraphSyncEntry(rows, &entry); err != nil {
+			r.logger.ErrorDatabase("SELECT", "graph_sync_outbox", err, nil)
+			return nil, fmt.Errorf("failed to scan graph sync entry: %w", err)
+		}
+		status.Failed = append(status.Failed, entry)
+	}
+
+	return status, rows.Err()
+}
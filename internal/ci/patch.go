@@ -0,0 +1,338 @@
+package ci
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Patch media types accepted by PatchCI and PatchRelationship
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+// PatchError is returned for a patch that cannot be applied. Conflict is set
+// when the patch is well formed but does not fit the current document, such as
+// a failed test operation or a path that does not exist.
+type PatchError struct {
+	Message  string
+	Conflict bool
+}
+
+func (e PatchError) Error() string {
+	return e.Message
+}
+
+func invalidPatch(format string, args ...interface{}) error {
+	return PatchError{Message: fmt.Sprintf(format, args...)}
+}
+
+func conflictingPatch(format string, args ...interface{}) error {
+	return PatchError{Message: fmt.Sprintf(format, args...), Conflict: true}
+}
+
+// JSONPatchOperation is one operation of an RFC 6902 JSON Patch
+type JSONPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// applyPatch applies a merge patch or JSON Patch, chosen by content type, to
+// a copy of doc and returns the result
+func applyPatch(doc map[string]interface{}, contentType string, patch []byte) (map[string]interface{}, error) {
+	var result interface{}
+	switch contentType {
+	case MergePatchContentType:
+		var mergePatch interface{}
+		if err := json.Unmarshal(patch, &mergePatch); err != nil {
+			return nil, invalidPatch("invalid merge patch: %v", err)
+		}
+		if _, ok := mergePatch.(map[string]interface{}); !ok {
+			return nil, invalidPatch("merge patch must be a JSON object")
+		}
+		result = applyMergePatch(deepCopyJSON(doc), mergePatch)
+
+	case JSONPatchContentType:
+		var operations []JSONPatchOperation
+		if err := json.Unmarshal(patch, &operations); err != nil {
+			return nil, invalidPatch("invalid JSON Patch: %v", err)
+		}
+		var err error
+		result, err = applyJSONPatch(deepCopyJSON(doc), operations)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, invalidPatch("unsupported patch content type '%s'", contentType)
+	}
+
+	patched, ok := result.(map[string]interface{})
+	if !ok {
+		return nil, invalidPatch("patch must leave a JSON object")
+	}
+	return patched, nil
+}
+
+// applyMergePatch implements RFC 7396: objects are merged key by key, null
+// removes a key and anything else replaces the target
+func applyMergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = applyMergePatch(targetObject[key], value)
+	}
+	return targetObject
+}
+
+// applyJSONPatch implements RFC 6902. Operations are applied in order and the
+// whole patch fails if any of them does.
+func applyJSONPatch(doc interface{}, operations []JSONPatchOperation) (interface{}, error) {
+	for i, op := range operations {
+		path, err := parseJSONPointer(op.Path)
+		if err != nil {
+			return nil, invalidPatch("operation %d: %v", i, err)
+		}
+
+		var value interface{}
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, invalidPatch("operation %d: %s requires a value", i, op.Op)
+			}
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, invalidPatch("operation %d: invalid value: %v", i, err)
+			}
+		}
+
+		switch op.Op {
+		case "add":
+			doc, err = jsonPointerAdd(doc, path, value)
+
+		case "remove":
+			doc, _, err = jsonPointerRemove(doc, path)
+
+		case "replace":
+			if _, err = jsonPointerGet(doc, path); err == nil {
+				doc, err = jsonPointerSet(doc, path, value)
+			}
+
+		case "move", "copy":
+			from, fromErr := parseJSONPointer(op.From)
+			if fromErr != nil {
+				return nil, invalidPatch("operation %d: from: %v", i, fromErr)
+			}
+			if op.Op == "move" && len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+				return nil, invalidPatch("operation %d: cannot move a value into itself", i)
+			}
+			var moved interface{}
+			if op.Op == "move" {
+				doc, moved, err = jsonPointerRemove(doc, from)
+			} else if moved, err = jsonPointerGet(doc, from); err == nil {
+				moved = deepCopyJSON(moved)
+			}
+			if err == nil {
+				doc, err = jsonPointerAdd(doc, path, moved)
+			}
+
+		case "test":
+			var current interface{}
+			if current, err = jsonPointerGet(doc, path); err == nil && !reflect.DeepEqual(current, value) {
+				err = conflictingPatch("test failed at '%s'", op.Path)
+			}
+
+		default:
+			return nil, invalidPatch("operation %d: unknown op '%s'", i, op.Op)
+		}
+
+		if err != nil {
+			if _, ok := err.(PatchError); ok {
+				return nil, err
+			}
+			return nil, conflictingPatch("operation %d: %v", i, err)
+		}
+	}
+	return doc, nil
+}
+
+// parseJSONPointer splits an RFC 6901 pointer into unescaped reference tokens
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path '%s' must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func jsonPointerGet(doc interface{}, path []string) (interface{}, error) {
+	current := doc
+	for _, token := range path {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path '/%s' does not exist", strings.Join(path, "/"))
+			}
+			current = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("path '/%s' does not exist", strings.Join(path, "/"))
+		}
+	}
+	return current, nil
+}
+
+// jsonPointerSet replaces the value at an existing path, or adds a member to an
+// object, and returns the updated document
+func jsonPointerSet(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	return jsonPointerUpdate(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			node[index] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("cannot set a member of a scalar")
+	}, value)
+}
+
+// jsonPointerAdd adds a value: arrays grow at the index (or at the end for -),
+// objects gain or replace the member
+func jsonPointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	return jsonPointerUpdate(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			index := len(node)
+			if token != "-" {
+				var err error
+				if index, err = arrayIndex(token, len(node)); err != nil {
+					return nil, err
+				}
+			}
+			grown := append(node[:index:index], value)
+			return append(grown, node[index:]...), nil
+		}
+		return nil, fmt.Errorf("cannot add a member to a scalar")
+	}, value)
+}
+
+// jsonPointerRemove removes the value at a path and returns the updated
+// document and the removed value
+func jsonPointerRemove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole document")
+	}
+	removed, err := jsonPointerGet(doc, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err = jsonPointerUpdate(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:index:index], node[index+1:]...), nil
+		}
+		return nil, fmt.Errorf("cannot remove a member of a scalar")
+	}, nil)
+	return doc, removed, err
+}
+
+// jsonPointerUpdate walks to the parent of the last token, lets change replace
+// the parent and writes the new parent back up the path. An empty path
+// replaces the whole document with root.
+func jsonPointerUpdate(doc interface{}, path []string, change func(parent interface{}, token string) (interface{}, error), root interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return root, nil
+	}
+	parent, err := jsonPointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	updated, err := change(parent, path[len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	if len(path) == 1 {
+		return updated, nil
+	}
+	return jsonPointerSet(doc, path[:len(path)-1], updated)
+}
+
+// arrayIndex parses an array reference token no greater than max
+func arrayIndex(token string, max int) (int, error) {
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index '%s'", token)
+	}
+	if index > max {
+		return 0, fmt.Errorf("array index %d is out of range", index)
+	}
+	return index, nil
+}
+
+// deepCopyJSON copies a decoded JSON value so patching leaves the original alone
+func deepCopyJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = deepCopyJSON(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = deepCopyJSON(item)
+		}
+		return copied
+	case []string:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = item
+		}
+		return copied
+	}
+	return value
+}
@@ -0,0 +1,115 @@
+package ci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func patchTestDoc() map[string]interface{} {
+	return map[string]interface{}{
+		"attributes": map[string]interface{}{
+			"hostname": "web-01",
+			"cpu":      float64(4),
+			"ips":      []interface{}{"10.0.0.1"},
+		},
+		"tags": []string{"prod"},
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	doc := patchTestDoc()
+
+	patched, err := applyPatch(doc, MergePatchContentType, []byte(`{"attributes": {"cpu": 8, "ips": null, "os": "linux"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"hostname": "web-01",
+		"cpu":      float64(8),
+		"os":       "linux",
+	}, patched["attributes"])
+	assert.Equal(t, []interface{}{"prod"}, patched["tags"])
+
+	// The original document is left alone
+	assert.Equal(t, float64(4), doc["attributes"].(map[string]interface{})["cpu"])
+
+	_, err = applyPatch(doc, MergePatchContentType, []byte(`["not", "an", "object"]`))
+	assert.Equal(t, PatchError{Message: "merge patch must be a JSON object"}, err)
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	patched, err := applyPatch(patchTestDoc(), JSONPatchContentType, []byte(`[
+		{"op": "test", "path": "/attributes/hostname", "value": "web-01"},
+		{"op": "replace", "path": "/attributes/cpu", "value": 16},
+		{"op": "add", "path": "/attributes/ips/-", "value": "10.0.0.2"},
+		{"op": "add", "path": "/attributes/ips/0", "value": "10.0.0.0"},
+		{"op": "copy", "from": "/attributes/hostname", "path": "/attributes/alias"},
+		{"op": "move", "from": "/attributes/alias", "path": "/attributes/name~1alias"},
+		{"op": "remove", "path": "/tags/0"},
+		{"op": "add", "path": "/tags/-", "value": "critical"}
+	]`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"hostname":   "web-01",
+		"cpu":        float64(16),
+		"ips":        []interface{}{"10.0.0.0", "10.0.0.1", "10.0.0.2"},
+		"name/alias": "web-01",
+	}, patched["attributes"])
+	assert.Equal(t, []interface{}{"critical"}, patched["tags"])
+}
+
+func TestApplyJSONPatchErrors(t *testing.T) {
+	tests := []struct {
+		patch    string
+		conflict bool
+	}{
+		{`{"op": "add"}`, false},
+		{`[{"op": "frobnicate", "path": "/tags"}]`, false},
+		{`[{"op": "add", "path": "tags", "value": 1}]`, false},
+		{`[{"op": "replace", "path": "/attributes/cpu"}]`, false},
+		{`[{"op": "move", "from": "/attributes", "path": "/attributes/nested"}]`, false},
+		{`[{"op": "test", "path": "/attributes/cpu", "value": 2}]`, true},
+		{`[{"op": "remove", "path": "/attributes/missing"}]`, true},
+		{`[{"op": "replace", "path": "/attributes/missing", "value": 1}]`, true},
+		{`[{"op": "add", "path": "/tags/5", "value": "x"}]`, true},
+		{`[{"op": "remove", "path": ""}]`, true},
+	}
+
+	for _, tt := range tests {
+		_, err := applyPatch(patchTestDoc(), JSONPatchContentType, []byte(tt.patch))
+		require.Error(t, err, tt.patch)
+		patchErr, ok := err.(PatchError)
+		require.True(t, ok, tt.patch)
+		assert.Equal(t, tt.conflict, patchErr.Conflict, tt.patch)
+	}
+
+	_, err := applyPatch(patchTestDoc(), "application/json", []byte(`{}`))
+	assert.Error(t, err)
+}
+
+func TestCIPatchRequest(t *testing.T) {
+	current := &ConfigurationItem{
+		Attributes: map[string]interface{}{"hostname": "web-01"},
+		Tags:       []string{"prod"},
+	}
+
+	req, err := ciPatchRequest(current, map[string]interface{}{
+		"attributes": map[string]interface{}{"hostname": "web-01"},
+		"tags":       []interface{}{"prod"},
+	})
+	require.NoError(t, err)
+	assert.Nil(t, req.Attributes)
+	assert.Nil(t, req.Tags)
+
+	req, err = ciPatchRequest(current, map[string]interface{}{
+		"attributes": map[string]interface{}{"hostname": "web-02"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"hostname": "web-02"}, req.Attributes)
+	assert.Equal(t, []string{}, req.Tags)
+
+	_, err = ciPatchRequest(current, map[string]interface{}{"name": "web-02"})
+	assert.Error(t, err)
+	_, err = ciPatchRequest(current, map[string]interface{}{"tags": []interface{}{1}})
+	assert.Error(t, err)
+}
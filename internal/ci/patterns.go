@@ -0,0 +1,101 @@
+package ci
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// attributePatterns holds the compiled validation patterns of one CI type,
+// keyed by attribute name. Patterns that failed to compile map to their error.
+type attributePatterns struct {
+	updatedAt time.Time
+	compiled  map[string]*regexp.Regexp
+	invalid   map[string]error
+}
+
+// patternCache caches compiled patterns per CI type. An entry is rebuilt when
+// the type's updated_at changes, so schema updates take effect immediately.
+type patternCache struct {
+	mu    sync.RWMutex
+	types map[uuid.UUID]*attributePatterns
+}
+
+var ciTypePatterns = &patternCache{types: make(map[uuid.UUID]*attributePatterns)}
+
+// get returns the compiled patterns for a CI type, compiling them on first use.
+// Types without an ID (not yet persisted) are compiled but not cached.
+func (c *patternCache) get(ciType *CITypeDefinition) *attributePatterns {
+	if ciType.ID == uuid.Nil {
+		return compileCITypePatterns(ciType)
+	}
+
+	c.mu.RLock()
+	cached, ok := c.types[ciType.ID]
+	c.mu.RUnlock()
+	if ok && cached.updatedAt.Equal(ciType.UpdatedAt) {
+		return cached
+	}
+
+	compiled := compileCITypePatterns(ciType)
+
+	c.mu.Lock()
+	c.types[ciType.ID] = compiled
+	c.mu.Unlock()
+
+	return compiled
+}
+
+// forget drops a CI type's cached patterns
+func (c *patternCache) forget(id uuid.UUID) {
+	c.mu.Lock()
+	delete(c.types, id)
+	c.mu.Unlock()
+}
+
+func compileCITypePatterns(ciType *CITypeDefinition) *attributePatterns {
+	patterns := &attributePatterns{
+		updatedAt: ciType.UpdatedAt,
+		compiled:  make(map[string]*regexp.Regexp),
+		invalid:   make(map[string]error),
+	}
+
+	for _, attrs := range [][]AttributeDefinition{ciType.RequiredAttributes, ciType.OptionalAttributes} {
+		for _, attr := range attrs {
+			if attr.Validation == nil || attr.Validation.Pattern == "" {
+				continue
+			}
+			re, err := regexp.Compile(attr.Validation.Pattern)
+			if err != nil {
+				patterns.invalid[attr.Name] = err
+				continue
+			}
+			patterns.compiled[attr.Name] = re
+		}
+	}
+
+	return patterns
+}
+
+// validateAttributePatterns checks that every pattern in a list of attribute
+// definitions compiles. Fields are reported as section[index].validation.pattern.
+func validateAttributePatterns(section string, attrs []AttributeDefinition) []ValidationError {
+	var errors []ValidationError
+
+	for i, attr := range attrs {
+		if attr.Validation == nil || attr.Validation.Pattern == "" {
+			continue
+		}
+		if _, err := regexp.Compile(attr.Validation.Pattern); err != nil {
+			errors = append(errors, ValidationError{
+				Field:   fmt.Sprintf("%s[%d].validation.pattern", section, i),
+				Message: fmt.Sprintf("invalid pattern for attribute '%s': %v", attr.Name, err),
+			})
+		}
+	}
+
+	return errors
+}
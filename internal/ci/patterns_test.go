@@ -0,0 +1,69 @@
+package ci
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func applicationType(pattern string) *CITypeDefinition {
+	return &CITypeDefinition{
+		ID:        uuid.New(),
+		Name:      "Application",
+		UpdatedAt: time.Now(),
+		RequiredAttributes: []AttributeDefinition{
+			{Name: "version", Type: "string", Validation: &AttributeValidation{Pattern: pattern}},
+		},
+	}
+}
+
+func TestValidateAttributesPattern(t *testing.T) {
+	ciType := applicationType(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+
+	assert.Empty(t, ciType.ValidateAttributes(map[string]interface{}{"version": "1.2.3"}))
+
+	errors := ciType.ValidateAttributes(map[string]interface{}{"version": "1.2"})
+	require.Len(t, errors, 1)
+	assert.Equal(t, "version", errors[0].Field)
+	assert.Contains(t, errors[0].Message, "must match pattern")
+}
+
+func TestPatternCacheRefreshesOnUpdate(t *testing.T) {
+	ciType := applicationType(`^v`)
+	assert.Empty(t, ciType.ValidateAttributes(map[string]interface{}{"version": "v1"}))
+
+	ciType.RequiredAttributes[0].Validation.Pattern = `^[0-9]`
+	ciType.UpdatedAt = ciType.UpdatedAt.Add(time.Second)
+	assert.Len(t, ciType.ValidateAttributes(map[string]interface{}{"version": "v1"}), 1)
+}
+
+func TestValidateAttributesStoredInvalidPattern(t *testing.T) {
+	ciType := applicationType(`^(`)
+
+	errors := ciType.ValidateAttributes(map[string]interface{}{"version": "1.0.0"})
+	require.Len(t, errors, 1)
+	assert.Contains(t, errors[0].Message, "invalid pattern")
+}
+
+func TestCheckAttributePatterns(t *testing.T) {
+	err := checkAttributeDefinitions(
+		[]AttributeDefinition{
+			{Name: "hostname", Type: "string", Validation: &AttributeValidation{Pattern: `^[a-z]+$`}},
+		},
+		[]AttributeDefinition{
+			{Name: "os", Type: "string"},
+			{Name: "version", Type: "string", Validation: &AttributeValidation{Pattern: `(?<=v)1`}},
+		},
+	)
+
+	var validationErr ServiceValidationError
+	require.ErrorAs(t, err, &validationErr)
+	require.Len(t, validationErr.Errors, 1)
+	assert.Equal(t, "optional_attributes[1].validation.pattern", validationErr.Errors[0].Field)
+	assert.Contains(t, validationErr.Errors[0].Message, "'version'")
+
+	assert.NoError(t, checkAttributeDefinitions(nil, nil))
+}
@@ -0,0 +1,57 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+)
+
+// PreconditionFailedError is returned when a change was made conditional on
+// versions of an entity other than its current one
+type PreconditionFailedError struct {
+	CurrentVersion int
+}
+
+func (e PreconditionFailedError) Error() string {
+	return fmt.Sprintf("precondition failed: current version is %d", e.CurrentVersion)
+}
+
+type ifMatchKey struct{}
+
+// ifMatch is the condition carried by WithIfMatch or WithIfMatchAny
+type ifMatch struct {
+	any      bool
+	versions []int
+}
+
+// WithIfMatch returns a context under which updates and deletes only go ahead
+// while the entity is still at one of the given versions. An empty list never
+// matches.
+func WithIfMatch(ctx context.Context, versions []int) context.Context {
+	return context.WithValue(ctx, ifMatchKey{}, ifMatch{versions: versions})
+}
+
+// WithIfMatchAny returns a context under which updates and deletes only go
+// ahead while the entity exists, whatever its version
+func WithIfMatchAny(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ifMatchKey{}, ifMatch{any: true})
+}
+
+// checkIfMatch checks the entity's current version against the versions the
+// context requires, if any. A current version of 0 stands for an entity that
+// does not exist. Callers lock the entity first so the version cannot change
+// between the check and the write.
+func checkIfMatch(ctx context.Context, current int) error {
+	condition, ok := ctx.Value(ifMatchKey{}).(ifMatch)
+	if !ok {
+		return nil
+	}
+	if condition.any && current > 0 {
+		return nil
+	}
+	for _, version := range condition.versions {
+		if version == current {
+			return nil
+		}
+	}
+	return PreconditionFailedError{CurrentVersion: current}
+}
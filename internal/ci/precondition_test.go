@@ -0,0 +1,76 @@
+package ci
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+
+	pustakaLogger "github.com/pustaka/pustaka/pkg/logger"
+)
+
+func TestCheckIfMatch(t *testing.T) {
+	ctx := context.Background()
+	assert.NoError(t, checkIfMatch(ctx, 3))
+
+	assert.NoError(t, checkIfMatch(WithIfMatch(ctx, []int{2, 3}), 3))
+
+	err := checkIfMatch(WithIfMatch(ctx, []int{2}), 3)
+	assert.Equal(t, PreconditionFailedError{CurrentVersion: 3}, err)
+	assert.Equal(t, "precondition failed: current version is 3", err.Error())
+
+	// A header with no usable tags matches no version
+	assert.Error(t, checkIfMatch(WithIfMatch(ctx, nil), 1))
+
+	// If-Match: * matches any version of an existing entity, but not a
+	// missing one
+	assert.NoError(t, checkIfMatch(WithIfMatchAny(ctx), 3))
+	assert.Equal(t, PreconditionFailedError{CurrentVersion: 0}, checkIfMatch(WithIfMatchAny(ctx), 0))
+}
+
+// ciRowTx is a transaction that answers every single-row query with a CI at
+// version and records the statements it runs
+type ciRowTx struct {
+	pgx.Tx
+	version    int
+	statements []string
+}
+
+func (tx *ciRowTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	tx.statements = append(tx.statements, sql)
+	return pgconn.CommandTag{}, nil
+}
+
+func (tx *ciRowTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	tx.statements = append(tx.statements, sql)
+	return ciVersionRow(tx.version)
+}
+
+// ciVersionRow scans as a CI at its version, or as true for an EXISTS check
+type ciVersionRow int
+
+func (row ciVersionRow) Scan(dest ...interface{}) error {
+	switch last := dest[len(dest)-1].(type) {
+	case *int:
+		*last = int(row)
+	case *bool:
+		*last = true
+	}
+	return nil
+}
+
+func TestRestoreCIVersionChecksIfMatch(t *testing.T) {
+	tx := &ciRowTx{version: 2}
+	ctx := context.WithValue(context.Background(), txContextKey{}, pgx.Tx(tx))
+	service := NewService(NewRepository(nil, pustakaLogger.Default()), nil, nil, nil, pustakaLogger.Default())
+
+	_, err := service.RestoreCIVersion(WithIfMatch(ctx, []int{1}), uuid.New(), 1, uuid.New())
+
+	assert.Equal(t, PreconditionFailedError{CurrentVersion: 2}, err)
+	// The CI is locked and checked before the snapshot is read
+	assert.Len(t, tx.statements, 2)
+	assert.Contains(t, tx.statements[0], "FOR NO KEY UPDATE")
+}
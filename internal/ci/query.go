@@ -0,0 +1,849 @@
+package ci
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// CI query language
+//
+// The q filter of GET /ci is an expression such as
+//
+//	ci_type:Server AND attributes.os:Ubuntu AND attributes.cpu_cores>=16 AND tags:prod
+//
+// A term compares a field with a value: ":" or "=" for equality, "!=", ">",
+// ">=", "<" and "<=", "IN (a, b)" or ":(a, b)" for any of a list, and ":*"
+// for existence. Terms are combined with AND, which may be left out, OR and
+// NOT (or a leading "-"), and grouped with parentheses. Attribute paths may
+// reach into object attributes, e.g. attributes.config.kernel.
+//
+// Equality, lists and existence on attributes compile to jsonb containment
+// and key existence, which the GIN index on attributes serves; ranges are
+// checked on the rows that have the attribute.
+
+// Limits on the size of a query
+const (
+	maxCIQueryTerms  = 50
+	maxCIQueryValues = 100
+)
+
+// Query operators
+const (
+	ciQueryEq     = ":"
+	ciQueryIn     = "in"
+	ciQueryExists = "exists"
+	ciQueryGt     = ">"
+	ciQueryGte    = ">="
+	ciQueryLt     = "<"
+	ciQueryLte    = "<="
+)
+
+// ciQueryFields are the fields a term may compare besides attributes.<path>
+var ciQueryFields = map[string]bool{
+	"ci_type":    true,
+	"name":       true,
+	"tags":       true,
+	"created_by": true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+// ciQueryNode is a parsed query expression
+type ciQueryNode interface {
+	sql(args *[]interface{}) string
+}
+
+// ciQueryBool joins expressions with AND or OR
+type ciQueryBool struct {
+	op    string
+	nodes []ciQueryNode
+}
+
+type ciQueryNot struct {
+	node ciQueryNode
+}
+
+// ciQueryTerm compares one field with its values. Path is the attribute path
+// of attributes.<path> terms. Values holds the values converted by check.
+type ciQueryTerm struct {
+	Field  string
+	Path   []string
+	Op     string
+	Raw    []ciQueryValue
+	Pos    int
+	Values []interface{}
+	// AttrType is the attribute's type, or empty for a path nested in an
+	// object attribute, whose values are typed by how they are written
+	AttrType string
+}
+
+// ciQueryValue is a value as written; quoted values are always strings
+type ciQueryValue struct {
+	Text   string
+	Quoted bool
+}
+
+// parseCIQuery parses a query expression. Errors name the position, counted
+// in characters from 1, where parsing stopped.
+func parseCIQuery(input string) (ciQueryNode, error) {
+	p := &ciQueryParser{input: []rune(input)}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.done() {
+		return nil, p.errorf("unexpected '%c'", p.peek())
+	}
+	return node, nil
+}
+
+type ciQueryParser struct {
+	input []rune
+	pos   int
+	terms int
+}
+
+func (p *ciQueryParser) parseOr() (ciQueryNode, error) {
+	var nodes []ciQueryNode
+	for {
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if !p.keyword("OR") {
+			break
+		}
+	}
+	return joinCIQuery("OR", nodes), nil
+}
+
+func (p *ciQueryParser) parseAnd() (ciQueryNode, error) {
+	var nodes []ciQueryNode
+	for {
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+
+		if p.keyword("AND") {
+			continue
+		}
+		p.skipSpace()
+		if p.done() || p.peek() == ')' || p.peekKeyword("OR") {
+			break
+		}
+	}
+	return joinCIQuery("AND", nodes), nil
+}
+
+func (p *ciQueryParser) parseUnary() (ciQueryNode, error) {
+	p.skipSpace()
+	switch {
+	case p.done():
+		return nil, p.errorf("expected a term")
+	case p.keyword("NOT"), p.consume('-'):
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &ciQueryNot{node: node}, nil
+	case p.consume('('):
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !p.consume(')') {
+			return nil, p.errorf("expected ')'")
+		}
+		return node, nil
+	}
+	return p.parseTerm()
+}
+
+func (p *ciQueryParser) parseTerm() (ciQueryNode, error) {
+	if p.terms++; p.terms > maxCIQueryTerms {
+		return nil, p.errorf("a query may have at most %d terms", maxCIQueryTerms)
+	}
+
+	term := &ciQueryTerm{Pos: p.pos + 1}
+	field := p.readWhile(func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+	})
+	if field == "" {
+		return nil, p.errorf("expected a field")
+	}
+	if err := term.setField(field); err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	negate := false
+	switch {
+	case p.keyword("IN"):
+		term.Op = ciQueryIn
+	case p.consumeString("!="):
+		term.Op, negate = ciQueryEq, true
+	case p.consumeString(">="):
+		term.Op = ciQueryGte
+	case p.consumeString("<="):
+		term.Op = ciQueryLte
+	case p.consume('>'):
+		term.Op = ciQueryGt
+	case p.consume('<'):
+		term.Op = ciQueryLt
+	case p.consume(':'), p.consume('='):
+		term.Op = ciQueryEq
+	default:
+		return nil, p.errorf("expected an operator after '%s'", field)
+	}
+
+	p.skipSpace()
+	switch {
+	case term.Op == ciQueryEq && p.peek() == '*' && p.delimiterAt(p.pos+1):
+		p.pos++
+		term.Op = ciQueryExists
+	case term.Op == ciQueryEq || term.Op == ciQueryIn:
+		if p.peek() == '(' {
+			values, err := p.readList()
+			if err != nil {
+				return nil, err
+			}
+			term.Op, term.Raw = ciQueryIn, values
+			break
+		}
+		if term.Op == ciQueryIn {
+			return nil, p.errorf("expected '(' after IN")
+		}
+		fallthrough
+	default:
+		value, err := p.readValue()
+		if err != nil {
+			return nil, err
+		}
+		term.Raw = []ciQueryValue{value}
+	}
+
+	if negate {
+		return &ciQueryNot{node: term}, nil
+	}
+	return term, nil
+}
+
+// setField splits a field into the field and its attribute path
+func (t *ciQueryTerm) setField(field string) error {
+	parts := strings.Split(field, ".")
+	if parts[0] == "attributes" && len(parts) > 1 {
+		for _, part := range parts[1:] {
+			if part == "" {
+				return fmt.Errorf("at position %d: '%s' has an empty path segment", t.Pos, field)
+			}
+		}
+		t.Field, t.Path = "attributes", parts[1:]
+		return nil
+	}
+	if len(parts) > 1 || !ciQueryFields[field] {
+		return fmt.Errorf("at position %d: unknown field '%s'; use ci_type, name, tags, created_by, created_at, updated_at or attributes.<name>", t.Pos, field)
+	}
+	t.Field = field
+	return nil
+}
+
+func (p *ciQueryParser) readList() ([]ciQueryValue, error) {
+	p.pos++ // (
+	var values []ciQueryValue
+	for {
+		p.skipSpace()
+		value, err := p.readValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if len(values) > maxCIQueryValues {
+			return nil, p.errorf("a list may have at most %d values", maxCIQueryValues)
+		}
+
+		p.skipSpace()
+		if p.consume(')') {
+			return values, nil
+		}
+		if !p.consume(',') {
+			return nil, p.errorf("expected ',' or ')'")
+		}
+	}
+}
+
+// readValue reads a quoted value, with \" and \\ escapes, or a bare value,
+// which runs to the next space, comma or parenthesis
+func (p *ciQueryParser) readValue() (ciQueryValue, error) {
+	if !p.consume('"') {
+		text := p.readWhile(func(r rune) bool {
+			return !unicode.IsSpace(r) && r != ',' && r != '(' && r != ')' && r != '"'
+		})
+		if text == "" {
+			return ciQueryValue{}, p.errorf("expected a value")
+		}
+		return ciQueryValue{Text: text}, nil
+	}
+
+	var text strings.Builder
+	for !p.done() {
+		r := p.input[p.pos]
+		p.pos++
+		switch {
+		case r == '"':
+			return ciQueryValue{Text: text.String(), Quoted: true}, nil
+		case r == '\\' && !p.done():
+			text.WriteRune(p.input[p.pos])
+			p.pos++
+		default:
+			text.WriteRune(r)
+		}
+	}
+	return ciQueryValue{}, p.errorf("unterminated quoted value")
+}
+
+func (p *ciQueryParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *ciQueryParser) peek() rune {
+	if p.done() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *ciQueryParser) skipSpace() {
+	for !p.done() && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *ciQueryParser) consume(r rune) bool {
+	if p.peek() == r {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *ciQueryParser) consumeString(s string) bool {
+	if strings.HasPrefix(string(p.input[p.pos:]), s) {
+		p.pos += len([]rune(s))
+		return true
+	}
+	return false
+}
+
+func (p *ciQueryParser) readWhile(ok func(rune) bool) string {
+	start := p.pos
+	for !p.done() && ok(p.input[p.pos]) {
+		p.pos++
+	}
+	return string(p.input[start:p.pos])
+}
+
+// delimiterAt reports whether a word may end before position i
+func (p *ciQueryParser) delimiterAt(i int) bool {
+	return i >= len(p.input) || unicode.IsSpace(p.input[i]) || p.input[i] == '(' || p.input[i] == ')'
+}
+
+// peekKeyword reports whether the next word is the keyword, in any case
+func (p *ciQueryParser) peekKeyword(word string) bool {
+	p.skipSpace()
+	end := p.pos + len(word)
+	return end <= len(p.input) && strings.EqualFold(string(p.input[p.pos:end]), word) && p.delimiterAt(end)
+}
+
+// keyword consumes the keyword if it comes next
+func (p *ciQueryParser) keyword(word string) bool {
+	if p.peekKeyword(word) {
+		p.pos += len(word)
+		return true
+	}
+	return false
+}
+
+func (p *ciQueryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at position %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func joinCIQuery(op string, nodes []ciQueryNode) ciQueryNode {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	return &ciQueryBool{op: op, nodes: nodes}
+}
+
+// walkCIQuery calls fn for every term of a query
+func walkCIQuery(node ciQueryNode, fn func(*ciQueryTerm)) {
+	switch n := node.(type) {
+	case *ciQueryBool:
+		for _, child := range n.nodes {
+			walkCIQuery(child, fn)
+		}
+	case *ciQueryNot:
+		walkCIQuery(n.node, fn)
+	case *ciQueryTerm:
+		fn(n)
+	}
+}
+
+// ciQueryTypes returns the CI types a query is limited to by ci_type terms
+// every match must satisfy, or nil when the query does not limit the type
+func ciQueryTypes(node ciQueryNode) []string {
+	switch n := node.(type) {
+	case *ciQueryTerm:
+		if n.Field != "ci_type" || (n.Op != ciQueryEq && n.Op != ciQueryIn) {
+			return nil
+		}
+		types := make([]string, 0, len(n.Raw))
+		for _, value := range n.Raw {
+			types = append(types, value.Text)
+		}
+		return types
+	case *ciQueryBool:
+		if n.op != "AND" {
+			return nil
+		}
+		var types []string
+		for _, child := range n.nodes {
+			childTypes := ciQueryTypes(child)
+			switch {
+			case childTypes == nil:
+			case types == nil:
+				types = childTypes
+			default:
+				types = intersectStrings(types, childTypes)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func intersectStrings(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, s := range b {
+		in[s] = true
+	}
+	result := []string{}
+	for _, s := range a {
+		if in[s] {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// ciQuerySchema is what a query is checked against: the existing CI types and
+// the attribute types of the CI types the query can match
+type ciQuerySchema struct {
+	ciTypes    map[string]bool
+	scope      []string
+	attributes map[string]map[string]string // attribute → CI type → attribute type
+}
+
+func newCIQuerySchema(allTypes []string, scope []*CITypeDefinition) ciQuerySchema {
+	schema := ciQuerySchema{
+		ciTypes:    make(map[string]bool, len(allTypes)),
+		attributes: map[string]map[string]string{},
+	}
+	for _, name := range allTypes {
+		schema.ciTypes[name] = true
+	}
+	for _, ciType := range scope {
+		schema.scope = append(schema.scope, ciType.Name)
+		for _, attrs := range [][]AttributeDefinition{ciType.RequiredAttributes, ciType.OptionalAttributes} {
+			for _, attr := range attrs {
+				if schema.attributes[attr.Name] == nil {
+					schema.attributes[attr.Name] = map[string]string{}
+				}
+				schema.attributes[attr.Name][ciType.Name] = attr.Type
+			}
+		}
+	}
+	sort.Strings(schema.scope)
+	return schema
+}
+
+// checkCIQuery type-checks every term of a query against the schema and
+// converts its values for comparison
+func checkCIQuery(node ciQueryNode, schema ciQuerySchema) []ValidationError {
+	var errors []ValidationError
+	walkCIQuery(node, func(term *ciQueryTerm) {
+		if err := term.check(schema); err != nil {
+			errors = append(errors, ValidationError{
+				Field:   "q",
+				Message: fmt.Sprintf("at position %d: %v", term.Pos, err),
+			})
+		}
+	})
+	return errors
+}
+
+func (t *ciQueryTerm) check(schema ciQuerySchema) error {
+	ranged := t.Op == ciQueryGt || t.Op == ciQueryGte || t.Op == ciQueryLt || t.Op == ciQueryLte
+	t.Values = nil
+
+	switch t.Field {
+	case "ci_type", "name":
+		if t.Op == ciQueryExists || (ranged && t.Field == "ci_type") {
+			return t.unsupported()
+		}
+		for _, value := range t.Raw {
+			if t.Field == "ci_type" && !schema.ciTypes[value.Text] {
+				return fmt.Errorf("CI type '%s' does not exist", value.Text)
+			}
+			t.Values = append(t.Values, value.Text)
+		}
+
+	case "tags":
+		if ranged {
+			return t.unsupported()
+		}
+		for _, value := range t.Raw {
+			t.Values = append(t.Values, value.Text)
+		}
+
+	case "created_by":
+		if ranged || t.Op == ciQueryExists {
+			return t.unsupported()
+		}
+		for _, value := range t.Raw {
+			id, err := uuid.Parse(value.Text)
+			if err != nil {
+				return fmt.Errorf("created_by must be a user ID, not '%s'", value.Text)
+			}
+			t.Values = append(t.Values, id)
+		}
+
+	case "created_at", "updated_at":
+		if !ranged {
+			return t.unsupported()
+		}
+		value, ok := parseDateTime(t.Raw[0].Text)
+		if !ok {
+			return fmt.Errorf("%s must be compared with a date or datetime, not '%s'", t.Field, t.Raw[0].Text)
+		}
+		t.Values = append(t.Values, value)
+
+	case "attributes":
+		return t.checkAttribute(schema, ranged)
+	}
+	return nil
+}
+
+func (t *ciQueryTerm) checkAttribute(schema ciQuerySchema, ranged bool) error {
+	name := t.Path[0]
+	types := schema.attributes[name]
+	if len(types) == 0 {
+		return fmt.Errorf("attribute '%s' is not defined by %s", name, schema.scopeName())
+	}
+
+	attrType, err := commonAttributeType(name, types)
+	if err != nil {
+		return err
+	}
+	nested := len(t.Path) > 1
+	if nested && attrType != "object" {
+		return fmt.Errorf("attribute '%s' is %s and has no nested fields", name, withArticle(attrType))
+	}
+	if t.Op == ciQueryExists {
+		return nil
+	}
+
+	if nested || attrType == "array" {
+		// Nested fields and array elements have no schema; values are typed
+		// by how they are written
+		if !nested {
+			t.AttrType = attrType
+		}
+		for _, value := range t.Raw {
+			t.Values = append(t.Values, inferCIQueryValue(value))
+		}
+		if ranged {
+			if !nested {
+				return t.unsupported()
+			}
+			switch t.Values[0].(type) {
+			case float64, string:
+			default:
+				return fmt.Errorf("%s needs a number or text to compare with", t.Op)
+			}
+		}
+		return nil
+	}
+
+	t.AttrType = attrType
+	switch attrType {
+	case "object":
+		return fmt.Errorf("attribute '%s' is an object; compare one of its fields, e.g. attributes.%s.<field>", name, name)
+	case "integer", "number", "date", "datetime":
+	default:
+		if ranged {
+			return t.unsupported()
+		}
+	}
+
+	for _, raw := range t.Raw {
+		value, err := ciQueryAttributeValue(raw.Text, attrType, ranged)
+		if err != nil {
+			return fmt.Errorf("cannot compare %s attribute '%s' with '%s'", attrType, name, raw.Text)
+		}
+		t.Values = append(t.Values, value)
+	}
+	return nil
+}
+
+func (t *ciQueryTerm) unsupported() error {
+	field := t.Field
+	if len(t.Path) > 0 {
+		field = "attributes." + strings.Join(t.Path, ".")
+	}
+	op := t.Op
+	if op == ciQueryExists {
+		op = ":*"
+	}
+	return fmt.Errorf("%s does not support %s", field, strings.ToUpper(op))
+}
+
+// commonAttributeType returns the type an attribute has in every CI type in
+// scope. Types stored alike are compared alike: integers and numbers as
+// numbers and the string types as strings.
+func commonAttributeType(name string, types map[string]string) (string, error) {
+	kinds := map[string]bool{}
+	distinct := map[string]bool{}
+	for _, attrType := range types {
+		distinct[attrType] = true
+		kinds[attributeJSONKind(attrType)] = true
+	}
+	if len(distinct) == 1 {
+		for attrType := range distinct {
+			return attrType, nil
+		}
+	}
+	if len(kinds) == 1 {
+		for kind := range kinds {
+			return kind, nil
+		}
+	}
+
+	ciTypes := make([]string, 0, len(types))
+	for ciType := range types {
+		ciTypes = append(ciTypes, ciType)
+	}
+	sort.Strings(ciTypes)
+	described := make([]string, 0, len(ciTypes))
+	for _, ciType := range ciTypes {
+		described = append(described, fmt.Sprintf("%s in %s", types[ciType], ciType))
+	}
+	return "", fmt.Errorf("attribute '%s' has different types (%s); limit the query with ci_type", name, strings.Join(described, ", "))
+}
+
+// attributeJSONKind is the JSON type an attribute type is stored as
+func attributeJSONKind(attrType string) string {
+	switch attrType {
+	case "integer", "number":
+		return "number"
+	case "boolean", "array", "object":
+		return attrType
+	}
+	return "string"
+}
+
+// ciQueryAttributeValue converts a value to the form an attribute of the type
+// is stored in. Datetimes compared by range are compared as timestamps.
+func ciQueryAttributeValue(text, attrType string, ranged bool) (interface{}, error) {
+	switch attrType {
+	case "integer", "number":
+		value, err := convertAttributeValue(text, attrType)
+		if err != nil || !isFinite(value.(float64)) {
+			return nil, fmt.Errorf("invalid %s", attrType)
+		}
+		return value, nil
+	case "boolean":
+		return convertAttributeValue(text, attrType)
+	case "date":
+		t, ok := parseDate(text)
+		if !ok {
+			return nil, fmt.Errorf("invalid date")
+		}
+		return t.Format("2006-01-02"), nil
+	case "datetime":
+		t, ok := parseDateTime(text)
+		if !ok {
+			return nil, fmt.Errorf("invalid datetime")
+		}
+		if ranged {
+			return t, nil
+		}
+		return t.UTC().Format(time.RFC3339Nano), nil
+	}
+	return text, nil
+}
+
+// inferCIQueryValue types a value that has no schema: true, false and numbers
+// as written unquoted, anything else as a string
+func inferCIQueryValue(value ciQueryValue) interface{} {
+	if value.Quoted {
+		return value.Text
+	}
+	if value.Text == "true" || value.Text == "false" {
+		return value.Text == "true"
+	}
+	if f, err := strconv.ParseFloat(value.Text, 64); err == nil && isFinite(f) {
+		return f
+	}
+	return value.Text
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+func (schema ciQuerySchema) scopeName() string {
+	switch len(schema.scope) {
+	case 0:
+		return "any CI type"
+	case 1:
+		return "CI type " + schema.scope[0]
+	}
+	return "CI types " + strings.Join(schema.scope, ", ")
+}
+
+func withArticle(word string) string {
+	if strings.ContainsRune("aeiou", rune(word[0])) {
+		return "an " + word
+	}
+	return "a " + word
+}
+
+// SQL compilation. Every term compiles to a condition that is true or false,
+// never NULL, so NOT matches exactly the CIs the term does not.
+
+// addQueryArg appends a query argument and returns its placeholder
+func addQueryArg(args *[]interface{}, value interface{}) string {
+	*args = append(*args, value)
+	return fmt.Sprintf("$%d", len(*args))
+}
+
+func (n *ciQueryBool) sql(args *[]interface{}) string {
+	conditions := make([]string, 0, len(n.nodes))
+	for _, node := range n.nodes {
+		conditions = append(conditions, node.sql(args))
+	}
+	return "(" + strings.Join(conditions, " "+n.op+" ") + ")"
+}
+
+func (n *ciQueryNot) sql(args *[]interface{}) string {
+	return "NOT " + n.node.sql(args)
+}
+
+func (t *ciQueryTerm) sql(args *[]interface{}) string {
+	switch t.Field {
+	case "ci_type", "name", "created_by":
+		switch t.Op {
+		case ciQueryEq:
+			return fmt.Sprintf("COALESCE(%s = %s, false)", t.Field, addQueryArg(args, t.Values[0]))
+		case ciQueryIn:
+			return fmt.Sprintf("COALESCE(%s = ANY(%s), false)", t.Field, addQueryArg(args, t.listArg()))
+		}
+		return fmt.Sprintf("COALESCE(%s %s %s, false)", t.Field, t.Op, addQueryArg(args, t.Values[0]))
+
+	case "tags":
+		switch t.Op {
+		case ciQueryExists:
+			return "COALESCE(cardinality(tags) > 0, false)"
+		case ciQueryIn:
+			return fmt.Sprintf("COALESCE(tags && %s, false)", addQueryArg(args, t.listArg()))
+		}
+		return fmt.Sprintf("COALESCE(tags @> %s, false)", addQueryArg(args, []string{t.Values[0].(string)}))
+
+	case "created_at", "updated_at":
+		return fmt.Sprintf("(%s %s %s)", t.Field, t.Op, addQueryArg(args, t.Values[0]))
+	}
+
+	return t.attributeSQL(args)
+}
+
+func (t *ciQueryTerm) attributeSQL(args *[]interface{}) string {
+	switch t.Op {
+	case ciQueryExists:
+		key := addQueryArg(args, t.Path[0])
+		if len(t.Path) == 1 {
+			return fmt.Sprintf("(attributes ? %s)", key)
+		}
+		parent := addQueryArg(args, t.Path[:len(t.Path)-1])
+		return fmt.Sprintf("COALESCE(attributes ? %s AND (attributes #> %s) ? %s, false)", key, parent, addQueryArg(args, t.Path[len(t.Path)-1]))
+
+	case ciQueryEq, ciQueryIn:
+		conditions := make([]string, 0, len(t.Values))
+		for _, value := range t.Values {
+			conditions = append(conditions, fmt.Sprintf("attributes @> %s::jsonb", addQueryArg(args, t.containment(value))))
+		}
+		return "(" + strings.Join(conditions, " OR ") + ")"
+	}
+
+	// Ranges compare the value where it has the expected JSON type; the key
+	// check narrows the rows through the index first
+	key := addQueryArg(args, t.Path[0])
+	path := addQueryArg(args, t.Path)
+	value := addQueryArg(args, t.Values[0])
+
+	var field string
+	switch {
+	case t.AttrType == "datetime":
+		field = fmt.Sprintf(`CASE WHEN attributes #>> %s ~ '^\d{4}-\d{2}-\d{2}T' THEN (attributes #>> %s)::timestamptz END`, path, path)
+	case t.AttrType == "integer" || t.AttrType == "number" || t.AttrType == "":
+		if _, numeric := t.Values[0].(float64); numeric {
+			field = fmt.Sprintf("CASE WHEN jsonb_typeof(attributes #> %s) = 'number' THEN (attributes #>> %s)::numeric END", path, path)
+			break
+		}
+		fallthrough
+	default:
+		field = fmt.Sprintf("CASE WHEN jsonb_typeof(attributes #> %s) = 'string' THEN attributes #>> %s END", path, path)
+	}
+	return fmt.Sprintf("COALESCE(attributes ? %s AND %s %s %s, false)", key, field, t.Op, value)
+}
+
+// containment returns the JSON document that attributes contain when the
+// term's path has the value. Arrays contain their elements.
+func (t *ciQueryTerm) containment(value interface{}) string {
+	if t.AttrType == "array" {
+		value = []interface{}{value}
+	}
+	doc := value
+	for i := len(t.Path) - 1; i >= 0; i-- {
+		doc = map[string]interface{}{t.Path[i]: doc}
+	}
+	encoded, _ := json.Marshal(doc)
+	return string(encoded)
+}
+
+func (t *ciQueryTerm) listArg() interface{} {
+	if t.Field == "created_by" {
+		ids := make([]uuid.UUID, 0, len(t.Values))
+		for _, value := range t.Values {
+			ids = append(ids, value.(uuid.UUID))
+		}
+		return ids
+	}
+	values := make([]string, 0, len(t.Values))
+	for _, value := range t.Values {
+		values = append(values, value.(string))
+	}
+	return values
+}
@@ -0,0 +1,183 @@
+package ci
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func queryTestSchema() ciQuerySchema {
+	server := &CITypeDefinition{
+		Name: "Server",
+		RequiredAttributes: []AttributeDefinition{
+			{Name: "os", Type: "string"},
+			{Name: "cpu_cores", Type: "integer"},
+		},
+		OptionalAttributes: []AttributeDefinition{
+			{Name: "config", Type: "object"},
+			{Name: "disks", Type: "array"},
+			{Name: "commissioned", Type: "date"},
+			{Name: "last_seen", Type: "datetime"},
+			{Name: "virtual", Type: "boolean"},
+			{Name: "version", Type: "semver"},
+		},
+	}
+	database := &CITypeDefinition{
+		Name: "Database",
+		RequiredAttributes: []AttributeDefinition{
+			{Name: "engine", Type: "string"},
+			{Name: "cpu_cores", Type: "number"},
+			{Name: "version", Type: "string"},
+			{Name: "disks", Type: "integer"},
+		},
+	}
+	return newCIQuerySchema([]string{"Database", "Server"}, []*CITypeDefinition{server, database})
+}
+
+// compileCIQuery parses, checks and compiles a query
+func compileCIQuery(t *testing.T, schema ciQuerySchema, query string) (string, []interface{}) {
+	t.Helper()
+	node, err := parseCIQuery(query)
+	require.NoError(t, err)
+	require.Empty(t, checkCIQuery(node, schema))
+	var args []interface{}
+	return node.sql(&args), args
+}
+
+func TestParseCIQuery(t *testing.T) {
+	node, err := parseCIQuery(`ci_type:Server AND attributes.os:Ubuntu attributes.cpu_cores >= 16 AND tags:prod`)
+	require.NoError(t, err)
+	and, ok := node.(*ciQueryBool)
+	require.True(t, ok)
+	assert.Equal(t, "AND", and.op)
+	require.Len(t, and.nodes, 4)
+	assert.Equal(t, &ciQueryTerm{Field: "attributes", Path: []string{"cpu_cores"}, Op: ciQueryGte, Raw: []ciQueryValue{{Text: "16"}}, Pos: 41}, and.nodes[2])
+
+	// OR binds looser than AND; NOT, "-" and "!=" negate
+	node, err = parseCIQuery(`name:a OR name:b tags:x`)
+	require.NoError(t, err)
+	or := node.(*ciQueryBool)
+	assert.Equal(t, "OR", or.op)
+	assert.IsType(t, &ciQueryBool{}, or.nodes[1])
+
+	for _, query := range []string{`NOT tags:x`, `-tags:x`, `tags!=x`, `not (tags:x)`} {
+		node, err = parseCIQuery(query)
+		require.NoError(t, err, query)
+		assert.IsType(t, &ciQueryNot{}, node, query)
+	}
+
+	node, err = parseCIQuery(`attributes.os IN (Ubuntu, "Red Hat") attributes.config.kernel:* name:"say \"hi\""`)
+	require.NoError(t, err)
+	terms := node.(*ciQueryBool).nodes
+	assert.Equal(t, ciQueryIn, terms[0].(*ciQueryTerm).Op)
+	assert.Equal(t, []ciQueryValue{{Text: "Ubuntu"}, {Text: "Red Hat", Quoted: true}}, terms[0].(*ciQueryTerm).Raw)
+	assert.Equal(t, ciQueryExists, terms[1].(*ciQueryTerm).Op)
+	assert.Equal(t, []string{"config", "kernel"}, terms[1].(*ciQueryTerm).Path)
+	assert.Equal(t, `say "hi"`, terms[2].(*ciQueryTerm).Raw[0].Text)
+
+	// Bare values run to the next space, so datetimes need no quotes
+	node, err = parseCIQuery(`created_at>=2024-03-01T10:00:00Z`)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-03-01T10:00:00Z", node.(*ciQueryTerm).Raw[0].Text)
+
+	for query, message := range map[string]string{
+		`owner:bob`:        "at position 1: unknown field 'owner'",
+		`attributes..os:x`: "empty path segment",
+		`tags`:             "at position 5: expected an operator after 'tags'",
+		`tags:`:            "at position 6: expected a value",
+		`(tags:x`:          "at position 8: expected ')'",
+		`tags:x)`:          "at position 7: unexpected ')'",
+		`name:"open`:       "unterminated quoted value",
+		`tags IN x`:        "expected '(' after IN",
+		`tags:(a b)`:       "expected ',' or ')'",
+		`tags:x AND`:       "expected a term",
+	} {
+		_, err := parseCIQuery(query)
+		require.Error(t, err, query)
+		assert.Contains(t, err.Error(), message, query)
+	}
+}
+
+func TestCheckCIQuery(t *testing.T) {
+	schema := queryTestSchema()
+
+	for query, message := range map[string]string{
+		`ci_type:Router`:                   "CI type 'Router' does not exist",
+		`attributes.owner:bob`:             "attribute 'owner' is not defined by CI types Database, Server",
+		`attributes.disks:*`:               "attribute 'disks' has different types (integer in Database, array in Server)",
+		`attributes.os>=b`:                 "attributes.os does not support >=",
+		`attributes.os.name:x`:             "attribute 'os' is a string and has no nested fields",
+		`attributes.config:x`:              "attribute 'config' is an object",
+		`attributes.cpu_cores:many`:        "cannot compare number attribute 'cpu_cores' with 'many'",
+		`attributes.commissioned>=someday`: "cannot compare date attribute 'commissioned'",
+		`attributes.virtual:maybe`:         "cannot compare boolean attribute 'virtual'",
+		`tags>a`:                           "tags does not support >",
+		`created_by:bob`:                   "created_by must be a user ID",
+		`created_at:2024-01-01`:            "created_at does not support :",
+		`attributes.config.a>true`:         "needs a number or text",
+	} {
+		node, err := parseCIQuery(query)
+		require.NoError(t, err, query)
+		errors := checkCIQuery(node, schema)
+		require.Len(t, errors, 1, query)
+		assert.Equal(t, "q", errors[0].Field)
+		assert.Contains(t, errors[0].Message, message, query)
+	}
+
+	// Integers and numbers compare alike, as do the string types
+	node, err := parseCIQuery(`attributes.cpu_cores:16 attributes.version:"1.2.0"`)
+	require.NoError(t, err)
+	assert.Empty(t, checkCIQuery(node, schema))
+}
+
+func TestCIQueryTypes(t *testing.T) {
+	types := func(query string) []string {
+		node, err := parseCIQuery(query)
+		require.NoError(t, err)
+		return ciQueryTypes(node)
+	}
+
+	assert.Nil(t, types(`tags:prod`))
+	assert.Equal(t, []string{"Server"}, types(`ci_type:Server tags:prod`))
+	assert.Equal(t, []string{"Server"}, types(`ci_type IN (Server, Database) ci_type:Server`))
+	assert.Equal(t, []string{}, types(`ci_type:Server ci_type:Database`))
+	assert.Nil(t, types(`ci_type:Server OR tags:prod`))
+	assert.Nil(t, types(`NOT ci_type:Server`))
+}
+
+func TestCIQuerySQL(t *testing.T) {
+	server := queryTestSchema()
+	server.scope = []string{"Server"}
+	server.attributes["disks"] = map[string]string{"Server": "array"}
+
+	sql, args := compileCIQuery(t, server, `ci_type:Server AND attributes.os:Ubuntu AND attributes.cpu_cores>=16 AND tags:prod`)
+	assert.Equal(t, "(COALESCE(ci_type = $1, false) AND (attributes @> $2::jsonb) AND "+
+		"COALESCE(attributes ? $3 AND CASE WHEN jsonb_typeof(attributes #> $4) = 'number' THEN (attributes #>> $4)::numeric END >= $5, false) AND "+
+		"COALESCE(tags @> $6, false))", sql)
+	assert.Equal(t, []interface{}{"Server", `{"os":"Ubuntu"}`, "cpu_cores", []string{"cpu_cores"}, float64(16), []string{"prod"}}, args)
+
+	sql, args = compileCIQuery(t, server, `attributes.os IN (Ubuntu, Debian) -attributes.virtual:true`)
+	assert.Equal(t, "((attributes @> $1::jsonb OR attributes @> $2::jsonb) AND NOT (attributes @> $3::jsonb))", sql)
+	assert.Equal(t, []interface{}{`{"os":"Ubuntu"}`, `{"os":"Debian"}`, `{"virtual":true}`}, args)
+
+	// Arrays contain their elements; nested values are typed as written
+	_, args = compileCIQuery(t, server, `attributes.disks:sda attributes.config.kernel.version:"6" attributes.config.numa:2`)
+	assert.Equal(t, []interface{}{`{"disks":["sda"]}`, `{"config":{"kernel":{"version":"6"}}}`, `{"config":{"numa":2}}`}, args)
+
+	sql, args = compileCIQuery(t, server, `attributes.os:* attributes.config.kernel:*`)
+	assert.Equal(t, "((attributes ? $1) AND COALESCE(attributes ? $2 AND (attributes #> $3) ? $4, false))", sql)
+	assert.Equal(t, []interface{}{"os", "config", []string{"config"}, "kernel"}, args)
+
+	// Dates are normalized and compare as text; datetimes as timestamps
+	sql, args = compileCIQuery(t, server, `attributes.commissioned<2024/03/01 attributes.last_seen>"2024-03-01 10:00:00"`)
+	assert.Contains(t, sql, "CASE WHEN jsonb_typeof(attributes #> $2) = 'string' THEN attributes #>> $2 END < $3")
+	assert.Contains(t, sql, "::timestamptz END > $6")
+	assert.Equal(t, "2024-03-01", args[2])
+	assert.Equal(t, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), args[5])
+
+	sql, args = compileCIQuery(t, server, `tags IN (a, b) created_at>=2024-01-01 name<m`)
+	assert.Equal(t, "(COALESCE(tags && $1, false) AND (created_at >= $2) AND COALESCE(name < $3, false))", sql)
+	assert.Equal(t, []string{"a", "b"}, args[0])
+}
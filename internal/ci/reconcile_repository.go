@@ -0,0 +1,100 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Graph reconciliation queries
+
+// ListCIUpdateTimes returns the last update time of every CI, keyed by ID
+func (r *Repository) ListCIUpdateTimes(ctx context.Context) (map[uuid.UUID]time.Time, error) {
+	return r.listUpdateTimes(ctx, "configuration_items", "SELECT id, updated_at FROM configuration_items")
+}
+
+// ListRelationshipUpdateTimes returns the last update time of every relationship, keyed by ID
+func (r *Repository) ListRelationshipUpdateTimes(ctx context.Context) (map[uuid.UUID]time.Time, error) {
+	return r.listUpdateTimes(ctx, "relationships", "SELECT id, COALESCE(updated_at, created_at) FROM relationships")
+}
+
+func (r *Repository) listUpdateTimes(ctx context.Context, table, query string) (map[uuid.UUID]time.Time, error) {
+	rows, err := r.conn(ctx).Query(ctx, query)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", table, err, nil)
+		return nil, fmt.Errorf("failed to list %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	result := make(map[uuid.UUID]time.Time)
+	for rows.Next() {
+		var id uuid.UUID
+		var updatedAt time.Time
+		if err := rows.Scan(&id, &updatedAt); err != nil {
+			r.logger.ErrorDatabase("SELECT", table, err, nil)
+			return nil, fmt.Errorf("failed to scan %s: %w", table, err)
+		}
+		result[id] = updatedAt
+	}
+
+	return result, rows.Err()
+}
+
+// GetCIsByIDs returns the CIs with the given IDs; IDs that no longer exist are skipped
+func (r *Repository) GetCIsByIDs(ctx context.Context, ids []uuid.UUID) ([]ConfigurationItem, error) {
+	query := `
+		SELECT ` + ciColumns + `
+		FROM configuration_items
+		WHERE id = ANY($1)
+	`
+
+	rows, err := r.conn(ctx).Query(ctx, query, ids)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "configuration_items", err, nil)
+		return nil, fmt.Errorf("failed to get CIs: %w", err)
+	}
+	defer rows.Close()
+
+	var cis []ConfigurationItem
+	for rows.Next() {
+		var ci ConfigurationItem
+		if err := scanCI(rows, &ci); err != nil {
+			r.logger.ErrorDatabase("SELECT", "configuration_items", err, nil)
+			return nil, fmt.Errorf("failed to scan CI: %w", err)
+		}
+		cis = append(cis, ci)
+	}
+
+	return cis, rows.Err()
+}
+
+// GetRelationshipsByIDs returns the relationships with the given IDs; IDs that
+// no longer exist are skipped
+func (r *Repository) GetRelationshipsByIDs(ctx context.Context, ids []uuid.UUID) ([]Relationship, error) {
+	query := `
+		SELECT ` + relationshipColumns + `
+		FROM relationships
+		WHERE id = ANY($1)
+	`
+
+	rows, err := r.conn(ctx).Query(ctx, query, ids)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "relationships", err, nil)
+		return nil, fmt.Errorf("failed to get relationships: %w", err)
+	}
+	defer rows.Close()
+
+	var relationships []Relationship
+	for rows.Next() {
+		var rel Relationship
+		if err := scanRelationship(rows, &rel); err != nil {
+			r.logger.ErrorDatabase("SELECT", "relationships", err, nil)
+			return nil, fmt.Errorf("failed to scan relationship: %w", err)
+		}
+		relationships = append(relationships, rel)
+	}
+
+	return relationships, rows.Err()
+}
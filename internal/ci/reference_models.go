@@ -0,0 +1,68 @@
+package ci
+
+import (
+	"github.com/google/uuid"
+)
+
+// What happens to a referencing CI when the CI it points to is deleted
+const (
+	ReferenceOnDeleteRestrict = "restrict"
+	ReferenceOnDeleteCascade  = "cascade"
+)
+
+// CIReference is a reference attribute on one CI that points at another
+type CIReference struct {
+	CIID      uuid.UUID `json:"ci_id"`
+	CIName    string    `json:"ci_name"`
+	CIType    string    `json:"ci_type"`
+	Attribute string    `json:"attribute"`
+	OnDelete  string    `json:"on_delete"`
+}
+
+// ExpandedCI is a CI with the targets of its reference attributes inlined,
+// keyed by attribute name. A dangling reference maps to null.
+type ExpandedCI struct {
+	*ConfigurationItem
+	References map[string]*ConfigurationItem `json:"references"`
+}
+
+// ReferencedCIError is returned when deleting a CI that other CIs still
+// reference with on_delete restrict
+type ReferencedCIError struct {
+	References []CIReference `json:"references"`
+}
+
+func (e ReferencedCIError) Error() string {
+	return "cannot delete CI referenced by other CIs"
+}
+
+// referenceAttributes returns the CI type's attributes of type reference
+func (ciType *CITypeDefinition) referenceAttributes() []AttributeDefinition {
+	var attrs []AttributeDefinition
+	for _, list := range [][]AttributeDefinition{ciType.RequiredAttributes, ciType.OptionalAttributes} {
+		for _, attr := range list {
+			if attr.Type == "reference" {
+				attrs = append(attrs, attr)
+			}
+		}
+	}
+	return attrs
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAnyString(values, candidates []string) bool {
+	for _, candidate := range candidates {
+		if containsString(values, candidate) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// ListReferencingCIs returns every reference attribute, on any CI, whose value
+// is the given CI ID. Reference attributes are found through the CI type
+// definitions, so only attributes declared as type reference count. A type's
+// inherited reference attributes apply to its CIs, with the nearest
+// definition deciding on_delete.
+func (r *Repository) ListReferencingCIs(ctx context.Context, id uuid.UUID) ([]CIReference, error) {
+	query := fmt.Sprintf(`
+		WITH RECURSIVE type_ancestors AS (
+			SELECT name AS ci_type, name AS ancestor, 0 AS depth FROM ci_type_definitions
+			UNION ALL
+			SELECT ta.ci_type, t.parent, ta.depth + 1
+			FROM type_ancestors ta JOIN ci_type_definitions t ON t.name = ta.ancestor
+			WHERE t.parent IS NOT NULL AND ta.depth < %d
+		),
+		reference_attributes AS (
+			SELECT DISTINCT ON (ta.ci_type, attr->>'name') ta.ci_type,
+				attr->>'name' AS attribute,
+				COALESCE(NULLIF(attr->'reference'->>'on_delete', ''), 'restrict') AS on_delete
+			FROM type_ancestors ta
+			JOIN ci_type_definitions t ON t.name = ta.ancestor
+			CROSS JOIN LATERAL jsonb_array_elements(t.required_attributes || t.optional_attributes) AS attr
+			WHERE attr->>'type' = 'reference'
+			ORDER BY ta.ci_type, attr->>'name', ta.depth
+		)
+		SELECT ci.id, ci.name, ci.ci_type, ra.attribute, ra.on_delete
+		FROM reference_attributes ra
+		JOIN configuration_items ci ON ci.ci_type = ra.ci_type
+		WHERE ci.attributes @> jsonb_build_object(ra.attribute, $1::text)
+		ORDER BY ci.ci_type, ci.name, ra.attribute
+	`, ciTypeHierarchyMaxDepth)
+
+	rows, err := r.conn(ctx).Query(ctx, query, id.String())
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "configuration_items", err, map[string]interface{}{
+			"ci_id": id,
+		})
+		return nil, fmt.Errorf("failed to list referencing CIs: %w", err)
+	}
+	defer rows.Close()
+
+	references := []CIReference{}
+	for rows.Next() {
+		var ref CIReference
+		if err := rows.Scan(&ref.CIID, &ref.CIName, &ref.CIType, &ref.Attribute, &ref.OnDelete); err != nil {
+			r.logger.ErrorDatabase("SELECT", "configuration_items", err, nil)
+			return nil, fmt.Errorf("failed to scan CI reference: %w", err)
+		}
+		references = append(references, ref)
+	}
+
+	return references, rows.Err()
+}
@@ -0,0 +1,180 @@
+package ci
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Relationship cardinalities, read from source to target. 1:N allows a source
+// many targets but gives each target at most one source; 1:1 limits both sides.
+const (
+	CardinalityOneToOne   = "1:1"
+	CardinalityOneToMany  = "1:N"
+	CardinalityManyToMany = "N:M"
+)
+
+var relationshipTypeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+
+// RelationshipTypeDefinition registers a relationship type. Empty source or
+// target CI type lists allow any type. A dependency type means the source
+// depends on the target, and impact analysis only follows dependency types.
+type RelationshipTypeDefinition struct {
+	ID                 uuid.UUID             `json:"id" db:"id"`
+	Name               string                `json:"name" db:"name"`
+	InverseName        string                `json:"inverse_name" db:"inverse_name"`
+	Description        *string               `json:"description,omitempty" db:"description"`
+	SourceCITypes      []string              `json:"source_ci_types" db:"source_ci_types"`
+	TargetCITypes      []string              `json:"target_ci_types" db:"target_ci_types"`
+	Cardinality        string                `json:"cardinality" db:"cardinality"`
+	IsDependency       bool                  `json:"is_dependency" db:"is_dependency"`
+	RequiredAttributes []AttributeDefinition `json:"required_attributes" db:"required_attributes"`
+	OptionalAttributes []AttributeDefinition `json:"optional_attributes" db:"optional_attributes"`
+	CreatedBy          *uuid.UUID            `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt          time.Time             `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time             `json:"updated_at" db:"updated_at"`
+}
+
+type CreateRelationshipTypeRequest struct {
+	Name               string                `json:"name" validate:"required"`
+	InverseName        string                `json:"inverse_name" validate:"required"`
+	Description        *string               `json:"description,omitempty"`
+	SourceCITypes      []string              `json:"source_ci_types,omitempty"`
+	TargetCITypes      []string              `json:"target_ci_types,omitempty"`
+	Cardinality        string                `json:"cardinality,omitempty"`
+	IsDependency       bool                  `json:"is_dependency"`
+	RequiredAttributes []AttributeDefinition `json:"required_attributes,omitempty"`
+	OptionalAttributes []AttributeDefinition `json:"optional_attributes,omitempty"`
+}
+
+// UpdateRelationshipTypeRequest changes a relationship type. The name cannot
+// change; nil fields are left as they are.
+type UpdateRelationshipTypeRequest struct {
+	InverseName        *string               `json:"inverse_name,omitempty"`
+	Description        *string               `json:"description,omitempty"`
+	SourceCITypes      []string              `json:"source_ci_types,omitempty"`
+	TargetCITypes      []string              `json:"target_ci_types,omitempty"`
+	Cardinality        *string               `json:"cardinality,omitempty"`
+	IsDependency       *bool                 `json:"is_dependency,omitempty"`
+	RequiredAttributes []AttributeDefinition `json:"required_attributes,omitempty"`
+	OptionalAttributes []AttributeDefinition `json:"optional_attributes,omitempty"`
+}
+
+type RelationshipTypeListResponse struct {
+	RelationshipTypes []RelationshipTypeDefinition `json:"relationship_types"`
+	Page              int                          `json:"page"`
+	Limit             int                          `json:"limit"`
+	Total             int64                        `json:"total"`
+	TotalPages        int                          `json:"total_pages"`
+}
+
+// attributeSchema returns the relationship attribute schema in the form
+// ValidateAttributes works on
+func (d *RelationshipTypeDefinition) attributeSchema() *CITypeDefinition {
+	return &CITypeDefinition{
+		ID:                 d.ID,
+		Name:               d.Name,
+		RequiredAttributes: d.RequiredAttributes,
+		OptionalAttributes: d.OptionalAttributes,
+		UpdatedAt:          d.UpdatedAt,
+	}
+}
+
+// ValidateAttributes validates relationship attributes against the type's schema
+func (d *RelationshipTypeDefinition) ValidateAttributes(attributes map[string]interface{}) []ValidationError {
+	return d.attributeSchema().ValidateAttributes(attributes)
+}
+
+// NormalizeAttributes rewrites date and datetime attributes into canonical form
+// and derives computed attributes
+func (d *RelationshipTypeDefinition) NormalizeAttributes(attributes map[string]interface{}) {
+	d.attributeSchema().NormalizeAttributes(attributes)
+}
+
+// ApplyDefaults fills in the default of every attribute missing from a new relationship
+func (d *RelationshipTypeDefinition) ApplyDefaults(attributes map[string]interface{}) {
+	d.attributeSchema().ApplyDefaults(attributes)
+}
+
+// allowsSource reports whether a CI may be the source, given its type
+// followed by the types it inherits from
+func (d *RelationshipTypeDefinition) allowsSource(lineage ...string) bool {
+	return len(d.SourceCITypes) == 0 || containsAnyString(d.SourceCITypes, lineage)
+}
+
+// allowsTarget reports whether a CI may be the target, given its type
+// followed by the types it inherits from
+func (d *RelationshipTypeDefinition) allowsTarget(lineage ...string) bool {
+	return len(d.TargetCITypes) == 0 || containsAnyString(d.TargetCITypes, lineage)
+}
+
+// validateRelationshipTypeDefinition checks a definition's own fields. CI type
+// names are checked against the database by the service.
+func validateRelationshipTypeDefinition(def *RelationshipTypeDefinition) []ValidationError {
+	var errors []ValidationError
+
+	if !relationshipTypeNamePattern.MatchString(def.Name) {
+		errors = append(errors, ValidationError{
+			Field:   "name",
+			Message: "must be lower case letters, digits and underscores, starting with a letter (at most 50 characters)",
+		})
+	}
+	if !relationshipTypeNamePattern.MatchString(def.InverseName) {
+		errors = append(errors, ValidationError{
+			Field:   "inverse_name",
+			Message: "must be lower case letters, digits and underscores, starting with a letter (at most 50 characters)",
+		})
+	}
+
+	switch def.Cardinality {
+	case CardinalityOneToOne, CardinalityOneToMany, CardinalityManyToMany:
+	default:
+		errors = append(errors, ValidationError{
+			Field:   "cardinality",
+			Message: fmt.Sprintf("must be one of %s, %s or %s", CardinalityOneToOne, CardinalityOneToMany, CardinalityManyToMany),
+		})
+	}
+
+	names := make(map[string]bool)
+	for _, section := range []struct {
+		name  string
+		attrs []AttributeDefinition
+	}{
+		{"required_attributes", def.RequiredAttributes},
+		{"optional_attributes", def.OptionalAttributes},
+	} {
+		for i, attr := range section.attrs {
+			if names[attr.Name] {
+				errors = append(errors, ValidationError{
+					Field:   fmt.Sprintf("%s[%d].name", section.name, i),
+					Message: fmt.Sprintf("duplicate attribute name: %s", attr.Name),
+				})
+			}
+			names[attr.Name] = true
+
+			// Deleting a CI only looks for references held by other CIs
+			if attr.Type == "reference" {
+				errors = append(errors, ValidationError{
+					Field:   fmt.Sprintf("%s[%d].type", section.name, i),
+					Message: "relationship attributes cannot be of type reference",
+				})
+			}
+		}
+		errors = append(errors, validateAttributeTypes(section.name, section.attrs)...)
+		errors = append(errors, validateAttributePatterns(section.name, section.attrs)...)
+		errors = append(errors, validateAttributeRules(section.name, section.attrs)...)
+		for i, attr := range section.attrs {
+			if attr.Unique != "" {
+				errors = append(errors, ValidationError{
+					Field:   fmt.Sprintf("%s[%d].unique", section.name, i),
+					Message: "unique only applies to CI type attributes",
+				})
+			}
+		}
+	}
+	errors = append(errors, def.attributeSchema().checkAttributeDependencies()...)
+
+	return errors
+}
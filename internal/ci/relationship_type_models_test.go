@@ -0,0 +1,67 @@
+package ci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRelationshipTypeDefinition(t *testing.T) {
+	valid := &RelationshipTypeDefinition{
+		Name:          "runs_on",
+		InverseName:   "hosts",
+		SourceCITypes: []string{"Application"},
+		TargetCITypes: []string{"Server"},
+		Cardinality:   CardinalityManyToMany,
+		IsDependency:  true,
+		OptionalAttributes: []AttributeDefinition{
+			{Name: "since", Type: "date"},
+		},
+	}
+	assert.Empty(t, validateRelationshipTypeDefinition(valid))
+
+	invalid := &RelationshipTypeDefinition{
+		Name:        "Runs On",
+		InverseName: "hosts",
+		Cardinality: "N:1",
+		RequiredAttributes: []AttributeDefinition{
+			{Name: "port", Type: "integer"},
+		},
+		OptionalAttributes: []AttributeDefinition{
+			{Name: "port", Type: "integer"},
+			{Name: "owner", Type: "reference"},
+		},
+	}
+
+	fields := []string{}
+	for _, err := range validateRelationshipTypeDefinition(invalid) {
+		fields = append(fields, err.Field)
+	}
+	assert.ElementsMatch(t, []string{
+		"name",
+		"cardinality",
+		"optional_attributes[0].name",
+		"optional_attributes[1].type",
+	}, fields)
+}
+
+func TestRelationshipTypeAllowedCITypes(t *testing.T) {
+	def := &RelationshipTypeDefinition{TargetCITypes: []string{"Server"}}
+
+	assert.True(t, def.allowsSource("Application"))
+	assert.True(t, def.allowsTarget("Server"))
+	assert.False(t, def.allowsTarget("Database"))
+}
+
+func TestRelationshipTypeValidateAttributes(t *testing.T) {
+	def := &RelationshipTypeDefinition{
+		Name:               "connects_to",
+		RequiredAttributes: []AttributeDefinition{{Name: "port", Type: "integer"}},
+	}
+
+	errors := def.ValidateAttributes(map[string]interface{}{"port": 443.5, "protocol": "tcp"})
+	require.Len(t, errors, 2)
+	assert.Equal(t, "port", errors[0].Field)
+	assert.Equal(t, "protocol", errors[1].Field)
+}
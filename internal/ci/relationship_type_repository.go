@@ -0,0 +1,340 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// relationshipTypeColumns lists the relationship_type_definitions columns in the
+// order scanRelationshipType expects
+const relationshipTypeColumns = "id, name, inverse_name, description, source_ci_types, target_ci_types, cardinality, is_dependency, required_attributes, optional_attributes, created_by, created_at, updated_at"
+
+func scanRelationshipType(row pgx.Row, def *RelationshipTypeDefinition) error {
+	return row.Scan(
+		&def.ID,
+		&def.Name,
+		&def.InverseName,
+		&def.Description,
+		&def.SourceCITypes,
+		&def.TargetCITypes,
+		&def.Cardinality,
+		&def.IsDependency,
+		&def.RequiredAttributes,
+		&def.OptionalAttributes,
+		&def.CreatedBy,
+		&def.CreatedAt,
+		&def.UpdatedAt,
+	)
+}
+
+func (r *Repository) CreateRelationshipType(ctx context.Context, def *RelationshipTypeDefinition) (*RelationshipTypeDefinition, error) {
+	query := `
+		INSERT INTO relationship_type_definitions (id, name, inverse_name, description, source_ci_types, target_ci_types, cardinality, is_dependency, required_attributes, optional_attributes, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $12)
+		RETURNING ` + relationshipTypeColumns
+
+	if def.ID == uuid.Nil {
+		def.ID = uuid.New()
+	}
+
+	var result RelationshipTypeDefinition
+	err := scanRelationshipType(r.conn(ctx).QueryRow(ctx, query,
+		def.ID,
+		def.Name,
+		def.InverseName,
+		def.Description,
+		nonNilStrings(def.SourceCITypes),
+		nonNilStrings(def.TargetCITypes),
+		def.Cardinality,
+		def.IsDependency,
+		nonNilAttributes(def.RequiredAttributes),
+		nonNilAttributes(def.OptionalAttributes),
+		def.CreatedBy,
+		time.Now(),
+	), &result)
+
+	if err != nil {
+		r.logger.ErrorDatabase("INSERT", "relationship_type_definitions", err, map[string]interface{}{
+			"relationship_type": def.Name,
+		})
+		return nil, fmt.Errorf("failed to create relationship type: %w", err)
+	}
+
+	return &result, nil
+}
+
+func (r *Repository) GetRelationshipType(ctx context.Context, id uuid.UUID) (*RelationshipTypeDefinition, error) {
+	query := `SELECT ` + relationshipTypeColumns + ` FROM relationship_type_definitions WHERE id = $1`
+
+	var def RelationshipTypeDefinition
+	if err := scanRelationshipType(r.conn(ctx).QueryRow(ctx, query, id), &def); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("relationship type not found")
+		}
+		r.logger.ErrorDatabase("SELECT", "relationship_type_definitions", err, map[string]interface{}{
+			"relationship_type_id": id,
+		})
+		return nil, fmt.Errorf("failed to get relationship type: %w", err)
+	}
+
+	return &def, nil
+}
+
+func (r *Repository) GetRelationshipTypeByName(ctx context.Context, name string) (*RelationshipTypeDefinition, error) {
+	query := `SELECT ` + relationshipTypeColumns + ` FROM relationship_type_definitions WHERE name = $1`
+
+	var def RelationshipTypeDefinition
+	if err := scanRelationshipType(r.conn(ctx).QueryRow(ctx, query, name), &def); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("relationship type not found")
+		}
+		r.logger.ErrorDatabase("SELECT", "relationship_type_definitions", err, map[string]interface{}{
+			"relationship_type": name,
+		})
+		return nil, fmt.Errorf("failed to get relationship type: %w", err)
+	}
+
+	return &def, nil
+}
+
+func (r *Repository) ListRelationshipTypes(ctx context.Context, page, limit int, search string) (*RelationshipTypeListResponse, error) {
+	offset := (page - 1) * limit
+
+	whereClause := "WHERE 1=1"
+	args := []interface{}{}
+	argIndex := 1
+
+	if search != "" {
+		whereClause += fmt.Sprintf(" AND (name ILIKE $%d OR inverse_name ILIKE $%d OR description ILIKE $%d)", argIndex, argIndex, argIndex)
+		args = append(args, "%"+search+"%")
+		argIndex++
+	}
+
+	var total int64
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM relationship_type_definitions %s", whereClause)
+	if err := r.conn(ctx).QueryRow(ctx, countQuery, args...).Scan(&total); err != nil {
+		r.logger.ErrorDatabase("SELECT", "relationship_type_definitions", err, nil)
+		return nil, fmt.Errorf("failed to count relationship types: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM relationship_type_definitions %s
+		ORDER BY name
+		LIMIT $%d OFFSET $%d
+	`, relationshipTypeColumns, whereClause, argIndex, argIndex+1)
+	args = append(args, limit, offset)
+
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "relationship_type_definitions", err, nil)
+		return nil, fmt.Errorf("failed to list relationship types: %w", err)
+	}
+	defer rows.Close()
+
+	definitions := []RelationshipTypeDefinition{}
+	for rows.Next() {
+		var def RelationshipTypeDefinition
+		if err := scanRelationshipType(rows, &def); err != nil {
+			r.logger.ErrorDatabase("SELECT", "relationship_type_definitions", err, nil)
+			return nil, fmt.Errorf("failed to scan relationship type: %w", err)
+		}
+		definitions = append(definitions, def)
+	}
+
+	return &RelationshipTypeListResponse{
+		RelationshipTypes: definitions,
+		Page:              page,
+		Limit:             limit,
+		Total:             total,
+		TotalPages:        int((total + int64(limit) - 1) / int64(limit)),
+	}, nil
+}
+
+// UpdateRelationshipType writes the full definition; the service merges the
+// update request into the current definition first
+func (r *Repository) UpdateRelationshipType(ctx context.Context, def *RelationshipTypeDefinition) (*RelationshipTypeDefinition, error) {
+	query := `
+		UPDATE relationship_type_definitions
+		SET inverse_name = $2, description = $3, source_ci_types = $4, target_ci_types = $5,
+			cardinality = $6, is_dependency = $7, required_attributes = $8, optional_attributes = $9,
+			updated_at = $10
+		WHERE id = $1
+		RETURNING ` + relationshipTypeColumns
+
+	var result RelationshipTypeDefinition
+	err := scanRelationshipType(r.conn(ctx).QueryRow(ctx, query,
+		def.ID,
+		def.InverseName,
+		def.Description,
+		nonNilStrings(def.SourceCITypes),
+		nonNilStrings(def.TargetCITypes),
+		def.Cardinality,
+		def.IsDependency,
+		nonNilAttributes(def.RequiredAttributes),
+		nonNilAttributes(def.OptionalAttributes),
+		time.Now(),
+	), &result)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("relationship type not found")
+		}
+		r.logger.ErrorDatabase("UPDATE", "relationship_type_definitions", err, map[string]interface{}{
+			"relationship_type_id": def.ID,
+		})
+		return nil, fmt.Errorf("failed to update relationship type: %w", err)
+	}
+
+	return &result, nil
+}
+
+func (r *Repository) DeleteRelationshipType(ctx context.Context, id uuid.UUID) error {
+	var inUse bool
+	err := r.conn(ctx).QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM relationships
+			WHERE relationship_type = (SELECT name FROM relationship_type_definitions WHERE id = $1)
+		)
+	`, id).Scan(&inUse)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "relationships", err, map[string]interface{}{
+			"relationship_type_id": id,
+		})
+		return fmt.Errorf("failed to check relationships: %w", err)
+	}
+
+	if inUse {
+		return fmt.Errorf("cannot delete relationship type with existing relationships")
+	}
+
+	if _, err := r.conn(ctx).Exec(ctx, "DELETE FROM relationship_type_definitions WHERE id = $1", id); err != nil {
+		r.logger.ErrorDatabase("DELETE", "relationship_type_definitions", err, map[string]interface{}{
+			"relationship_type_id": id,
+		})
+		return fmt.Errorf("failed to delete relationship type: %w", err)
+	}
+
+	return nil
+}
+
+// ListDependencyRelationshipTypes returns the names of the types impact analysis follows
+func (r *Repository) ListDependencyRelationshipTypes(ctx context.Context) ([]string, error) {
+	rows, err := r.conn(ctx).Query(ctx, "SELECT name FROM relationship_type_definitions WHERE is_dependency ORDER BY name")
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "relationship_type_definitions", err, nil)
+		return nil, fmt.Errorf("failed to list dependency relationship types: %w", err)
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan relationship type: %w", err)
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// LockCIs takes row locks on the given CIs until the transaction ends, so
+// concurrent writes that check cardinality or rewrite attributes run one at a time
+func (r *Repository) LockCIs(ctx context.Context, ids ...uuid.UUID) error {
+	_, err := r.conn(ctx).Exec(ctx, "SELECT id FROM configuration_items WHERE id = ANY($1) ORDER BY id FOR NO KEY UPDATE", ids)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "configuration_items", err, nil)
+		return fmt.Errorf("failed to lock CIs: %w", err)
+	}
+	return nil
+}
+
+// LockRelationship locks a relationship row until the transaction ends
+func (r *Repository) LockRelationship(ctx context.Context, id uuid.UUID) error {
+	_, err := r.conn(ctx).Exec(ctx, "SELECT id FROM relationships WHERE id = $1 FOR NO KEY UPDATE", id)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "relationships", err, map[string]interface{}{
+			"relationship_id": id,
+		})
+		return fmt.Errorf("failed to lock relationship: %w", err)
+	}
+	return nil
+}
+
+// CountRelationshipsOfType counts relationships of a type from a source, to a
+// target, or both when both IDs are given
+func (r *Repository) CountRelationshipsOfType(ctx context.Context, relationshipType string, sourceID, targetID *uuid.UUID) (int, error) {
+	query := "SELECT COUNT(*) FROM relationships WHERE relationship_type = $1 AND ($2::uuid IS NULL OR source_id = $2) AND ($3::uuid IS NULL OR target_id = $3)"
+
+	var count int
+	if err := r.conn(ctx).QueryRow(ctx, query, relationshipType, sourceID, targetID).Scan(&count); err != nil {
+		r.logger.ErrorDatabase("SELECT", "relationships", err, map[string]interface{}{
+			"relationship_type": relationshipType,
+		})
+		return 0, fmt.Errorf("failed to count relationships: %w", err)
+	}
+
+	return count, nil
+}
+
+// CountRelationshipTypeViolations counts existing relationships of a type that
+// a changed definition would no longer allow, by endpoint CI type or
+// cardinality. A CI type allows its subtypes too.
+func (r *Repository) CountRelationshipTypeViolations(ctx context.Context, def *RelationshipTypeDefinition) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM relationships rel
+		JOIN configuration_items s ON s.id = rel.source_id
+		JOIN configuration_items t ON t.id = rel.target_id
+		WHERE rel.relationship_type = $1
+		AND (
+			(cardinality(CAST($2 AS TEXT[])) > 0 AND s.ci_type NOT IN (` + ciTypeFamilyQuery(2) + `))
+			OR (cardinality(CAST($3 AS TEXT[])) > 0 AND t.ci_type NOT IN (` + ciTypeFamilyQuery(3) + `))
+			OR ($4 IN ('1:1', '1:N') AND EXISTS (
+				SELECT 1 FROM relationships other
+				WHERE other.relationship_type = rel.relationship_type
+				AND other.target_id = rel.target_id AND other.id <> rel.id
+			))
+			OR ($4 = '1:1' AND EXISTS (
+				SELECT 1 FROM relationships other
+				WHERE other.relationship_type = rel.relationship_type
+				AND other.source_id = rel.source_id AND other.id <> rel.id
+			))
+		)
+	`
+
+	var count int
+	err := r.conn(ctx).QueryRow(ctx, query,
+		def.Name,
+		nonNilStrings(def.SourceCITypes),
+		nonNilStrings(def.TargetCITypes),
+		def.Cardinality,
+	).Scan(&count)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "relationships", err, map[string]interface{}{
+			"relationship_type": def.Name,
+		})
+		return 0, fmt.Errorf("failed to check existing relationships: %w", err)
+	}
+
+	return count, nil
+}
+
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func nonNilAttributes(attrs []AttributeDefinition) []AttributeDefinition {
+	if attrs == nil {
+		return []AttributeDefinition{}
+	}
+	return attrs
+}
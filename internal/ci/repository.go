@@ -23,14 +23,41 @@ func NewRepository(db *pgxpool.Pool, logger *pustakaLogger.Logger) *Repository {
 	}
 }
 
+// WithTx runs fn in a single PostgreSQL transaction shared by every repository
+// call made with the context it receives
+func (r *Repository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return runInTx(ctx, r.db, fn)
+}
+
+func (r *Repository) conn(ctx context.Context) querier {
+	return conn(ctx, r.db)
+}
+
 // Configuration Item operations
 
+// ciColumns lists the configuration_items columns in the order scanCI expects
+const ciColumns = "id, name, ci_type, attributes, tags, created_at, updated_at, created_by, updated_by, version"
+
+func scanCI(row pgx.Row, ci *ConfigurationItem) error {
+	return row.Scan(
+		&ci.ID,
+		&ci.Name,
+		&ci.CIType,
+		&ci.Attributes,
+		&ci.Tags,
+		&ci.CreatedAt,
+		&ci.UpdatedAt,
+		&ci.CreatedBy,
+		&ci.UpdatedBy,
+		&ci.Version,
+	)
+}
+
 func (r *Repository) CreateCI(ctx context.Context, ci *ConfigurationItem) (*ConfigurationItem, error) {
 	query := `
 		INSERT INTO configuration_items (id, name, ci_type, attributes, tags, created_by, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING id, name, ci_type, attributes, tags, created_at, updated_at, created_by, updated_by
-	`
+		RETURNING ` + ciColumns
 
 	if ci.ID == uuid.Nil {
 		ci.ID = uuid.New()
@@ -38,26 +65,23 @@ func (r *Repository) CreateCI(ctx context.Context, ci *ConfigurationItem) (*Conf
 
 	now := time.Now()
 	var result ConfigurationItem
-	err := r.db.QueryRow(ctx, query,
-		ci.ID,
-		ci.Name,
-		ci.CIType,
-		ci.Attributes,
-		ci.Tags,
-		ci.CreatedBy,
-		now,
-		now,
-	).Scan(
-		&result.ID,
-		&result.Name,
-		&result.CIType,
-		&result.Attributes,
-		&result.Tags,
-		&result.CreatedAt,
-		&result.UpdatedAt,
-		&result.CreatedBy,
-		&result.UpdatedBy,
-	)
+	err := r.WithTx(ctx, func(ctx context.Context) error {
+		err := scanCI(r.conn(ctx).QueryRow(ctx, query,
+			ci.ID,
+			ci.Name,
+			ci.CIType,
+			ci.Attributes,
+			ci.Tags,
+			ci.CreatedBy,
+			now,
+			now,
+		), &result)
+		if err != nil {
+			return err
+		}
+
+		return r.createCIVersion(ctx, &result, result.CreatedBy)
+	})
 
 	if err != nil {
 		r.logger.ErrorDatabase("INSERT", "configuration_items", err, map[string]interface{}{
@@ -75,25 +99,73 @@ func (r *Repository) CreateCI(ctx context.Context, ci *ConfigurationItem) (*Conf
 	return &result, nil
 }
 
+// CreateCIIfAbsent inserts a CI unless one with the same name and type
+// exists, in which case it returns nil. A concurrent insert of the same CI is
+// waited for rather than reported as an error.
+func (r *Repository) CreateCIIfAbsent(ctx context.Context, ci *ConfigurationItem) (*ConfigurationItem, error) {
+	query := `
+		INSERT INTO configuration_items (id, name, ci_type, attributes, tags, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT ON CONSTRAINT unique_name_per_type DO NOTHING
+		RETURNING ` + ciColumns
+
+	if ci.ID == uuid.Nil {
+		ci.ID = uuid.New()
+	}
+
+	now := time.Now()
+	var result ConfigurationItem
+	inserted := true
+	err := r.WithTx(ctx, func(ctx context.Context) error {
+		err := scanCI(r.conn(ctx).QueryRow(ctx, query,
+			ci.ID,
+			ci.Name,
+			ci.CIType,
+			ci.Attributes,
+			ci.Tags,
+			ci.CreatedBy,
+			now,
+			now,
+		), &result)
+		if err == pgx.ErrNoRows {
+			inserted = false
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return r.createCIVersion(ctx, &result, result.CreatedBy)
+	})
+
+	if err != nil {
+		r.logger.ErrorDatabase("INSERT", "configuration_items", err, map[string]interface{}{
+			"ci_name": ci.Name,
+			"ci_type": ci.CIType,
+		})
+		return nil, fmt.Errorf("failed to create CI: %w", err)
+	}
+	if !inserted {
+		return nil, nil
+	}
+
+	r.logger.InfoDatabase("INSERT", "configuration_items", 0, map[string]interface{}{
+		"ci_id":   result.ID,
+		"ci_name": result.Name,
+	})
+
+	return &result, nil
+}
+
 func (r *Repository) GetCI(ctx context.Context, id uuid.UUID) (*ConfigurationItem, error) {
 	query := `
-		SELECT id, name, ci_type, attributes, tags, created_at, updated_at, created_by, updated_by
+		SELECT ` + ciColumns + `
 		FROM configuration_items
 		WHERE id = $1
 	`
 
 	var ci ConfigurationItem
-	err := r.db.QueryRow(ctx, query, id).Scan(
-		&ci.ID,
-		&ci.Name,
-		&ci.CIType,
-		&ci.Attributes,
-		&ci.Tags,
-		&ci.CreatedAt,
-		&ci.UpdatedAt,
-		&ci.CreatedBy,
-		&ci.UpdatedBy,
-	)
+	err := scanCI(r.conn(ctx).QueryRow(ctx, query, id), &ci)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -109,77 +181,30 @@ func (r *Repository) GetCI(ctx context.Context, id uuid.UUID) (*ConfigurationIte
 }
 
 func (r *Repository) ListCIs(ctx context.Context, filters ListCIFilters, page, limit int) (*CIListResponse, error) {
-	offset := (page - 1) * limit
-
-	// Build WHERE clause
-	whereClause := "WHERE 1=1"
-	args := []interface{}{}
-	argIndex := 1
-
-	if filters.CIType != "" {
-		whereClause += fmt.Sprintf(" AND ci_type = $%d", argIndex)
-		args = append(args, filters.CIType)
-		argIndex++
-	}
-
-	if filters.Search != "" {
-		whereClause += fmt.Sprintf(" AND (name ILIKE $%d OR attributes::text ILIKE $%d)", argIndex, argIndex+1)
-		args = append(args, "%"+filters.Search+"%", "%"+filters.Search+"%")
-		argIndex += 2
-	}
-
-	if len(filters.Tags) > 0 {
-		whereClause += fmt.Sprintf(" AND tags && $%d", argIndex)
-		args = append(args, filters.Tags)
-		argIndex++
-	}
-
-	if filters.CreatedBy != "" {
-		whereClause += fmt.Sprintf(" AND created_by = $%d", argIndex)
-		args = append(args, filters.CreatedBy)
-		argIndex++
-	}
-
-	// Build ORDER BY clause
-	orderBy := "ORDER BY created_at DESC"
-	if filters.Sort != "" {
-		orderField := filters.Sort
-		if orderField == "name" {
-			orderField = "name"
-		} else if orderField == "type" {
-			orderField = "ci_type"
-		} else if orderField == "updated_at" {
-			orderField = "updated_at"
-		} else {
-			orderField = "created_at"
-		}
-
-		orderDirection := "DESC"
-		if filters.Order == "asc" {
-			orderDirection = "ASC"
-		}
-		orderBy = fmt.Sprintf("ORDER BY %s %s", orderField, orderDirection)
+	whereClause, args, _ := ciListQuery(filters)
+	sort, desc := ciListSort(filters)
+	keyset, err := newKeysetPage(ciKeysetColumns, sort, desc, page, limit, filters.Cursor, filters.Count)
+	if err != nil {
+		return nil, err
 	}
 
 	// Get total count
-	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM configuration_items %s", whereClause)
-	var total int64
-	err := r.db.QueryRow(ctx, countQuery, args...).Scan(&total)
+	total, err := keyset.countRows(ctx, r.conn(ctx), "configuration_items", whereClause, args)
 	if err != nil {
 		r.logger.ErrorDatabase("SELECT", "configuration_items", err, nil)
 		return nil, fmt.Errorf("failed to count CIs: %w", err)
 	}
 
 	// Get paginated results
+	if condition := keyset.where(&args); condition != "" {
+		whereClause += " AND " + condition
+	}
 	query := fmt.Sprintf(`
-		SELECT id, name, ci_type, attributes, tags, created_at, updated_at, created_by, updated_by
+		SELECT %s
 		FROM configuration_items %s %s
-		LIMIT $%d OFFSET $%d
-	`, whereClause, orderBy, argIndex, argIndex+1)
-
-	args = append(args, limit, offset)
+	`, ciColumns, whereClause, keyset.orderBy(&args))
 
-	rows, err := r.db.Query(ctx, query, args...)
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
 	if err != nil {
 		r.logger.ErrorDatabase("SELECT", "configuration_items", err, nil)
 		return nil, fmt.Errorf("failed to list CIs: %w", err)
@@ -189,33 +214,121 @@ func (r *Repository) ListCIs(ctx context.Context, filters ListCIFilters, page, l
 	var cis []ConfigurationItem
 	for rows.Next() {
 		var ci ConfigurationItem
-		err := rows.Scan(
-			&ci.ID,
-			&ci.Name,
-			&ci.CIType,
-			&ci.Attributes,
-			&ci.Tags,
-			&ci.CreatedAt,
-			&ci.UpdatedAt,
-			&ci.CreatedBy,
-			&ci.UpdatedBy,
-		)
+		err := scanCI(rows, &ci)
 		if err != nil {
 			r.logger.ErrorDatabase("SELECT", "configuration_items", err, nil)
 			return nil, fmt.Errorf("failed to scan CI: %w", err)
 		}
 		cis = append(cis, ci)
 	}
+	if err := rows.Err(); err != nil {
+		r.logger.ErrorDatabase("SELECT", "configuration_items", err, nil)
+		return nil, fmt.Errorf("failed to list CIs: %w", err)
+	}
 
-	totalPages := int((total + int64(limit) - 1) / int64(limit))
+	n, more := keyset.trim(len(cis))
+	cis = cis[:n]
+	if keyset.backward() {
+		for i, j := 0, len(cis)-1; i < j; i, j = i+1, j-1 {
+			cis[i], cis[j] = cis[j], cis[i]
+		}
+	}
 
-	return &CIListResponse{
+	response := &CIListResponse{
 		CIs:        cis,
-		Page:       page,
+		Page:       keyset.pageNumber(),
 		Limit:      limit,
 		Total:      total,
-		TotalPages: totalPages,
-	}, nil
+		TotalPages: keyset.totalPages(total),
+		CursorPage: keyset.cursors(more, nil, uuid.Nil, nil, uuid.Nil),
+	}
+	if n > 0 {
+		first, last := &cis[0], &cis[n-1]
+		response.CursorPage = keyset.cursors(more, ciSortValue(first, keyset.sort), first.ID, ciSortValue(last, keyset.sort), last.ID)
+	}
+	return response, nil
+}
+
+// ciSortValue returns a CI's value of a sort key
+func ciSortValue(ci *ConfigurationItem, sort string) interface{} {
+	switch sort {
+	case "name":
+		return ci.Name
+	case "ci_type":
+		return ci.CIType
+	case "updated_at":
+		return ci.UpdatedAt
+	}
+	return ci.CreatedAt
+}
+
+// ciListSort returns the sort key and direction of a CI listing: newest first
+// unless filters ask otherwise
+func ciListSort(filters ListCIFilters) (string, bool) {
+	if filters.Sort == "" {
+		return "created_at", true
+	}
+
+	sort := "created_at"
+	switch filters.Sort {
+	case "name", "updated_at":
+		sort = filters.Sort
+	case "type", "ci_type":
+		sort = "ci_type"
+	}
+	return sort, filters.Order != "asc"
+}
+
+// ciListQuery builds the WHERE and ORDER BY clauses selecting the CIs that
+// match filters, with the arguments they bind
+func ciListQuery(filters ListCIFilters) (string, []interface{}, string) {
+	// Build WHERE clause
+	whereClause := "WHERE 1=1"
+	args := []interface{}{}
+	argIndex := 1
+
+	if filters.CIType != "" {
+		if filters.IncludeSubtypes {
+			whereClause += fmt.Sprintf(" AND ci_type IN (%s)", ciTypeFamilyQuery(argIndex))
+			args = append(args, []string{filters.CIType})
+		} else {
+			whereClause += fmt.Sprintf(" AND ci_type = $%d", argIndex)
+			args = append(args, filters.CIType)
+		}
+		argIndex++
+	}
+
+	if filters.Search != "" {
+		whereClause += fmt.Sprintf(" AND (name ILIKE $%d OR attributes::text ILIKE $%d)", argIndex, argIndex+1)
+		args = append(args, "%"+filters.Search+"%", "%"+filters.Search+"%")
+		argIndex += 2
+	}
+
+	if len(filters.Tags) > 0 {
+		whereClause += fmt.Sprintf(" AND tags && $%d", argIndex)
+		args = append(args, filters.Tags)
+		argIndex++
+	}
+
+	if filters.CreatedBy != "" {
+		whereClause += fmt.Sprintf(" AND created_by = $%d", argIndex)
+		args = append(args, filters.CreatedBy)
+		argIndex++
+	}
+
+	if filters.query != nil {
+		whereClause += " AND " + filters.query.sql(&args)
+	}
+
+	// Build ORDER BY clause
+	sort, desc := ciListSort(filters)
+	orderDirection := "ASC"
+	if desc {
+		orderDirection = "DESC"
+	}
+	orderBy := fmt.Sprintf("ORDER BY %s %s", sort, orderDirection)
+
+	return whereClause, args, orderBy
 }
 
 func (r *Repository) UpdateCI(ctx context.Context, id uuid.UUID, updates *UpdateCIRequest, updatedBy uuid.UUID) (*ConfigurationItem, error) {
@@ -254,26 +367,25 @@ func (r *Repository) UpdateCI(ctx context.Context, id uuid.UUID, updates *Update
 	args = append(args, updatedBy)
 	argIndex++
 
+	setClauses = append(setClauses, "version = version + 1")
+
 	setClause := "SET " + setClauses[0]
 	for i := 1; i < len(setClauses); i++ {
 		setClause += ", " + setClauses[i]
 	}
 
-	query := fmt.Sprintf("UPDATE configuration_items %s WHERE id = $%d RETURNING id, name, ci_type, attributes, tags, created_at, updated_at, created_by, updated_by", setClause, argIndex)
+	query := fmt.Sprintf("UPDATE configuration_items %s WHERE id = $%d RETURNING %s", setClause, argIndex, ciColumns)
 	args = append(args, id)
 
+	// The row and its snapshot in configuration_item_versions are written together
 	var result ConfigurationItem
-	err = r.db.QueryRow(ctx, query, args...).Scan(
-		&result.ID,
-		&result.Name,
-		&result.CIType,
-		&result.Attributes,
-		&result.Tags,
-		&result.CreatedAt,
-		&result.UpdatedAt,
-		&result.CreatedBy,
-		&result.UpdatedBy,
-	)
+	err = r.WithTx(ctx, func(ctx context.Context) error {
+		if err := scanCI(r.conn(ctx).QueryRow(ctx, query, args...), &result); err != nil {
+			return err
+		}
+
+		return r.createCIVersion(ctx, &result, updatedBy)
+	})
 
 	if err != nil {
 		r.logger.ErrorDatabase("UPDATE", "configuration_items", err, map[string]interface{}{
@@ -293,7 +405,7 @@ func (r *Repository) UpdateCI(ctx context.Context, id uuid.UUID, updates *Update
 func (r *Repository) DeleteCI(ctx context.Context, id uuid.UUID) error {
 	// Check for existing relationships
 	var relationshipCount int
-	err := r.db.QueryRow(ctx, "SELECT COUNT(*) FROM relationships WHERE source_id = $1 OR target_id = $1", id).Scan(&relationshipCount)
+	err := r.conn(ctx).QueryRow(ctx, "SELECT COUNT(*) FROM relationships WHERE source_id = $1 OR target_id = $1", id).Scan(&relationshipCount)
 	if err != nil {
 		r.logger.ErrorDatabase("SELECT", "relationships", err, map[string]interface{}{
 			"ci_id": id,
@@ -306,7 +418,7 @@ func (r *Repository) DeleteCI(ctx context.Context, id uuid.UUID) error {
 	}
 
 	query := "DELETE FROM configuration_items WHERE id = $1"
-	_, err = r.db.Exec(ctx, query, id)
+	_, err = r.conn(ctx).Exec(ctx, query, id)
 	if err != nil {
 		r.logger.ErrorDatabase("DELETE", "configuration_items", err, map[string]interface{}{
 			"ci_id": id,
@@ -323,12 +435,31 @@ func (r *Repository) DeleteCI(ctx context.Context, id uuid.UUID) error {
 
 // CI Type operations
 
+// ciTypeColumns lists the ci_type_definitions columns in the order scanCIType expects
+const ciTypeColumns = "id, name, description, required_attributes, optional_attributes, created_by, created_at, updated_at, schema_version, parent, abstract, version"
+
+func scanCIType(row pgx.Row, ciType *CITypeDefinition) error {
+	return row.Scan(
+		&ciType.ID,
+		&ciType.Name,
+		&ciType.Description,
+		&ciType.RequiredAttributes,
+		&ciType.OptionalAttributes,
+		&ciType.CreatedBy,
+		&ciType.CreatedAt,
+		&ciType.UpdatedAt,
+		&ciType.SchemaVersion,
+		&ciType.Parent,
+		&ciType.Abstract,
+		&ciType.Version,
+	)
+}
+
 func (r *Repository) CreateCIType(ctx context.Context, ciType *CITypeDefinition) (*CITypeDefinition, error) {
 	query := `
-		INSERT INTO ci_type_definitions (id, name, description, required_attributes, optional_attributes, created_by, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING id, name, description, required_attributes, optional_attributes, created_by, created_at, updated_at
-	`
+		INSERT INTO ci_type_definitions (id, name, description, required_attributes, optional_attributes, parent, abstract, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING ` + ciTypeColumns
 
 	if ciType.ID == uuid.Nil {
 		ciType.ID = uuid.New()
@@ -336,25 +467,25 @@ func (r *Repository) CreateCIType(ctx context.Context, ciType *CITypeDefinition)
 
 	now := time.Now()
 	var result CITypeDefinition
-	err := r.db.QueryRow(ctx, query,
-		ciType.ID,
-		ciType.Name,
-		ciType.Description,
-		ciType.RequiredAttributes,
-		ciType.OptionalAttributes,
-		ciType.CreatedBy,
-		now,
-		now,
-	).Scan(
-		&result.ID,
-		&result.Name,
-		&result.Description,
-		&result.RequiredAttributes,
-		&result.OptionalAttributes,
-		&result.CreatedBy,
-		&result.CreatedAt,
-		&result.UpdatedAt,
-	)
+	err := r.WithTx(ctx, func(ctx context.Context) error {
+		err := scanCIType(r.conn(ctx).QueryRow(ctx, query,
+			ciType.ID,
+			ciType.Name,
+			ciType.Description,
+			ciType.RequiredAttributes,
+			ciType.OptionalAttributes,
+			ciType.Parent,
+			ciType.Abstract,
+			ciType.CreatedBy,
+			now,
+			now,
+		), &result)
+		if err != nil {
+			return err
+		}
+
+		return r.createCITypeSchemaVersion(ctx, &result, result.CreatedBy)
+	})
 
 	if err != nil {
 		r.logger.ErrorDatabase("INSERT", "ci_type_definitions", err, map[string]interface{}{
@@ -368,22 +499,13 @@ func (r *Repository) CreateCIType(ctx context.Context, ciType *CITypeDefinition)
 
 func (r *Repository) GetCIType(ctx context.Context, id uuid.UUID) (*CITypeDefinition, error) {
 	query := `
-		SELECT id, name, description, required_attributes, optional_attributes, created_by, created_at, updated_at
+		SELECT ` + ciTypeColumns + `
 		FROM ci_type_definitions
 		WHERE id = $1
 	`
 
 	var ciType CITypeDefinition
-	err := r.db.QueryRow(ctx, query, id).Scan(
-		&ciType.ID,
-		&ciType.Name,
-		&ciType.Description,
-		&ciType.RequiredAttributes,
-		&ciType.OptionalAttributes,
-		&ciType.CreatedBy,
-		&ciType.CreatedAt,
-		&ciType.UpdatedAt,
-	)
+	err := scanCIType(r.conn(ctx).QueryRow(ctx, query, id), &ciType)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -400,22 +522,13 @@ func (r *Repository) GetCIType(ctx context.Context, id uuid.UUID) (*CITypeDefini
 
 func (r *Repository) GetCITypeByName(ctx context.Context, name string) (*CITypeDefinition, error) {
 	query := `
-		SELECT id, name, description, required_attributes, optional_attributes, created_by, created_at, updated_at
+		SELECT ` + ciTypeColumns + `
 		FROM ci_type_definitions
 		WHERE name = $1
 	`
 
 	var ciType CITypeDefinition
-	err := r.db.QueryRow(ctx, query, name).Scan(
-		&ciType.ID,
-		&ciType.Name,
-		&ciType.Description,
-		&ciType.RequiredAttributes,
-		&ciType.OptionalAttributes,
-		&ciType.CreatedBy,
-		&ciType.CreatedAt,
-		&ciType.UpdatedAt,
-	)
+	err := scanCIType(r.conn(ctx).QueryRow(ctx, query, name), &ciType)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -446,7 +559,7 @@ func (r *Repository) ListCITypes(ctx context.Context, page, limit int, search st
 	// Get total count
 	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM ci_type_definitions %s", whereClause)
 	var total int64
-	err := r.db.QueryRow(ctx, countQuery, args...).Scan(&total)
+	err := r.conn(ctx).QueryRow(ctx, countQuery, args...).Scan(&total)
 	if err != nil {
 		r.logger.ErrorDatabase("SELECT", "ci_type_definitions", err, nil)
 		return nil, fmt.Errorf("failed to count CI types: %w", err)
@@ -454,15 +567,15 @@ func (r *Repository) ListCITypes(ctx context.Context, page, limit int, search st
 
 	// Get paginated results
 	query := fmt.Sprintf(`
-		SELECT id, name, description, required_attributes, optional_attributes, created_by, created_at, updated_at
+		SELECT %s
 		FROM ci_type_definitions %s
 		ORDER BY created_at DESC
 		LIMIT $%d OFFSET $%d
-	`, whereClause, argIndex, argIndex+1)
+	`, ciTypeColumns, whereClause, argIndex, argIndex+1)
 
 	args = append(args, limit, offset)
 
-	rows, err := r.db.Query(ctx, query, args...)
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
 	if err != nil {
 		r.logger.ErrorDatabase("SELECT", "ci_type_definitions", err, nil)
 		return nil, fmt.Errorf("failed to list CI types: %w", err)
@@ -472,17 +585,7 @@ func (r *Repository) ListCITypes(ctx context.Context, page, limit int, search st
 	var ciTypes []CITypeDefinition
 	for rows.Next() {
 		var ciType CITypeDefinition
-		err := rows.Scan(
-			&ciType.ID,
-			&ciType.Name,
-			&ciType.Description,
-			&ciType.RequiredAttributes,
-			&ciType.OptionalAttributes,
-			&ciType.CreatedBy,
-			&ciType.CreatedAt,
-			&ciType.UpdatedAt,
-		)
-		if err != nil {
+		if err := scanCIType(rows, &ciType); err != nil {
 			r.logger.ErrorDatabase("SELECT", "ci_type_definitions", err, nil)
 			return nil, fmt.Errorf("failed to scan CI type: %w", err)
 		}
@@ -500,7 +603,10 @@ func (r *Repository) ListCITypes(ctx context.Context, page, limit int, search st
 	}, nil
 }
 
-func (r *Repository) UpdateCIType(ctx context.Context, id uuid.UUID, updates *UpdateCITypeRequest) (*CITypeDefinition, error) {
+// UpdateCIType applies updates to a CI type. A change to the attribute schema
+// or the parent bumps schema_version and records the new schema in
+// ci_type_schema_versions.
+func (r *Repository) UpdateCIType(ctx context.Context, id uuid.UUID, updates *UpdateCITypeRequest, updatedBy uuid.UUID) (*CITypeDefinition, error) {
 	// Build UPDATE query
 	setClauses := []string{}
 	args := []interface{}{}
@@ -512,6 +618,8 @@ func (r *Repository) UpdateCIType(ctx context.Context, id uuid.UUID, updates *Up
 		argIndex++
 	}
 
+	schemaChanged := updates.RequiredAttributes != nil || updates.OptionalAttributes != nil || updates.Parent != nil
+
 	if updates.RequiredAttributes != nil {
 		setClauses = append(setClauses, fmt.Sprintf("required_attributes = $%d", argIndex))
 		args = append(args, updates.RequiredAttributes)
@@ -524,10 +632,31 @@ func (r *Repository) UpdateCIType(ctx context.Context, id uuid.UUID, updates *Up
 		argIndex++
 	}
 
+	if updates.Parent != nil {
+		setClauses = append(setClauses, fmt.Sprintf("parent = $%d", argIndex))
+		if *updates.Parent == "" {
+			args = append(args, nil)
+		} else {
+			args = append(args, *updates.Parent)
+		}
+		argIndex++
+	}
+
+	if updates.Abstract != nil {
+		setClauses = append(setClauses, fmt.Sprintf("abstract = $%d", argIndex))
+		args = append(args, *updates.Abstract)
+		argIndex++
+	}
+
 	if len(setClauses) == 0 {
 		return r.GetCIType(ctx, id)
 	}
 
+	if schemaChanged {
+		setClauses = append(setClauses, "schema_version = schema_version + 1")
+	}
+	setClauses = append(setClauses, "version = version + 1")
+
 	setClauses = append(setClauses, fmt.Sprintf("updated_at = $%d", argIndex))
 	args = append(args, time.Now())
 	argIndex++
@@ -537,22 +666,25 @@ func (r *Repository) UpdateCIType(ctx context.Context, id uuid.UUID, updates *Up
 		setClause += ", " + setClauses[i]
 	}
 
-	query := fmt.Sprintf("UPDATE ci_type_definitions %s WHERE id = $%d RETURNING id, name, description, required_attributes, optional_attributes, created_by, created_at, updated_at", setClause, argIndex)
+	query := fmt.Sprintf("UPDATE ci_type_definitions %s WHERE id = $%d RETURNING %s", setClause, argIndex, ciTypeColumns)
 	args = append(args, id)
 
 	var result CITypeDefinition
-	err := r.db.QueryRow(ctx, query, args...).Scan(
-		&result.ID,
-		&result.Name,
-		&result.Description,
-		&result.RequiredAttributes,
-		&result.OptionalAttributes,
-		&result.CreatedBy,
-		&result.CreatedAt,
-		&result.UpdatedAt,
-	)
+	err := r.WithTx(ctx, func(ctx context.Context) error {
+		if err := scanCIType(r.conn(ctx).QueryRow(ctx, query, args...), &result); err != nil {
+			return err
+		}
+
+		if !schemaChanged {
+			return nil
+		}
+		return r.createCITypeSchemaVersion(ctx, &result, updatedBy)
+	})
 
 	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("CI type not found")
+		}
 		r.logger.ErrorDatabase("UPDATE", "ci_type_definitions", err, map[string]interface{}{
 			"ci_type_id": id,
 		})
@@ -565,7 +697,7 @@ func (r *Repository) UpdateCIType(ctx context.Context, id uuid.UUID, updates *Up
 func (r *Repository) DeleteCIType(ctx context.Context, id uuid.UUID) error {
 	// Check for existing CIs of this type
 	var ciCount int
-	err := r.db.QueryRow(ctx, "SELECT COUNT(*) FROM configuration_items WHERE ci_type = (SELECT name FROM ci_type_definitions WHERE id = $1)", id).Scan(&ciCount)
+	err := r.conn(ctx).QueryRow(ctx, "SELECT COUNT(*) FROM configuration_items WHERE ci_type = (SELECT name FROM ci_type_definitions WHERE id = $1)", id).Scan(&ciCount)
 	if err != nil {
 		r.logger.ErrorDatabase("SELECT", "configuration_items", err, map[string]interface{}{
 			"ci_type_id": id,
@@ -577,8 +709,21 @@ func (r *Repository) DeleteCIType(ctx context.Context, id uuid.UUID) error {
 		return fmt.Errorf("cannot delete CI type with existing CIs")
 	}
 
+	var subtypeCount int
+	err = r.conn(ctx).QueryRow(ctx, "SELECT COUNT(*) FROM ci_type_definitions WHERE parent = (SELECT name FROM ci_type_definitions WHERE id = $1)", id).Scan(&subtypeCount)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "ci_type_definitions", err, map[string]interface{}{
+			"ci_type_id": id,
+		})
+		return fmt.Errorf("failed to check subtypes: %w", err)
+	}
+
+	if subtypeCount > 0 {
+		return fmt.Errorf("cannot delete CI type with subtypes")
+	}
+
 	query := "DELETE FROM ci_type_definitions WHERE id = $1"
-	_, err = r.db.Exec(ctx, query, id)
+	_, err = r.conn(ctx).Exec(ctx, query, id)
 	if err != nil {
 		r.logger.ErrorDatabase("DELETE", "ci_type_definitions", err, map[string]interface{}{
 			"ci_type_id": id,
@@ -593,23 +738,13 @@ func (r *Repository) DeleteCIType(ctx context.Context, id uuid.UUID) error {
 
 func (r *Repository) GetCIByNameAndType(ctx context.Context, name, ciType string) (*ConfigurationItem, error) {
 	query := `
-		SELECT id, name, ci_type, attributes, tags, created_at, updated_at, created_by, updated_by
+		SELECT ` + ciColumns + `
 		FROM configuration_items
 		WHERE name = $1 AND ci_type = $2
 	`
 
 	var ci ConfigurationItem
-	err := r.db.QueryRow(ctx, query, name, ciType).Scan(
-		&ci.ID,
-		&ci.Name,
-		&ci.CIType,
-		&ci.Attributes,
-		&ci.Tags,
-		&ci.CreatedAt,
-		&ci.UpdatedAt,
-		&ci.CreatedBy,
-		&ci.UpdatedBy,
-	)
+	err := scanCI(r.conn(ctx).QueryRow(ctx, query, name, ciType), &ci)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -625,12 +760,29 @@ func (r *Repository) GetCIByNameAndType(ctx context.Context, name, ciType string
 	return &ci, nil
 }
 
+// relationshipColumns lists the relationships columns in the order scanRelationship expects
+const relationshipColumns = "id, source_id, target_id, relationship_type, attributes, created_at, updated_at, created_by, updated_by, version"
+
+func scanRelationship(row pgx.Row, rel *Relationship) error {
+	return row.Scan(
+		&rel.ID,
+		&rel.SourceID,
+		&rel.TargetID,
+		&rel.RelationshipType,
+		&rel.Attributes,
+		&rel.CreatedAt,
+		&rel.UpdatedAt,
+		&rel.CreatedBy,
+		&rel.UpdatedBy,
+		&rel.Version,
+	)
+}
+
 func (r *Repository) CreateRelationship(ctx context.Context, rel *Relationship) (*Relationship, error) {
 	query := `
 		INSERT INTO relationships (id, source_id, target_id, relationship_type, attributes, created_by, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING id, source_id, target_id, relationship_type, attributes, created_at, updated_at, created_by, updated_by
-	`
+		RETURNING ` + relationshipColumns
 
 	if rel.ID == uuid.Nil {
 		rel.ID = uuid.New()
@@ -638,7 +790,7 @@ func (r *Repository) CreateRelationship(ctx context.Context, rel *Relationship)
 
 	now := time.Now()
 	var result Relationship
-	err := r.db.QueryRow(ctx, query,
+	err := scanRelationship(r.conn(ctx).QueryRow(ctx, query,
 		rel.ID,
 		rel.SourceID,
 		rel.TargetID,
@@ -647,17 +799,7 @@ func (r *Repository) CreateRelationship(ctx context.Context, rel *Relationship)
 		rel.CreatedBy,
 		now,
 		now,
-	).Scan(
-		&result.ID,
-		&result.SourceID,
-		&result.TargetID,
-		&result.RelationshipType,
-		&result.Attributes,
-		&result.CreatedAt,
-		&result.UpdatedAt,
-		&result.CreatedBy,
-		&result.UpdatedBy,
-	)
+	), &result)
 
 	if err != nil {
 		r.logger.ErrorDatabase("INSERT", "relationships", err, map[string]interface{}{
@@ -677,23 +819,13 @@ func (r *Repository) CreateRelationship(ctx context.Context, rel *Relationship)
 
 func (r *Repository) GetRelationship(ctx context.Context, id uuid.UUID) (*Relationship, error) {
 	query := `
-		SELECT id, source_id, target_id, relationship_type, attributes, created_at, updated_at, created_by, updated_by
+		SELECT ` + relationshipColumns + `
 		FROM relationships
 		WHERE id = $1
 	`
 
 	var rel Relationship
-	err := r.db.QueryRow(ctx, query, id).Scan(
-		&rel.ID,
-		&rel.SourceID,
-		&rel.TargetID,
-		&rel.RelationshipType,
-		&rel.Attributes,
-		&rel.CreatedAt,
-		&rel.UpdatedAt,
-		&rel.CreatedBy,
-		&rel.UpdatedBy,
-	)
+	err := scanRelationship(r.conn(ctx).QueryRow(ctx, query, id), &rel)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -709,57 +841,29 @@ func (r *Repository) GetRelationship(ctx context.Context, id uuid.UUID) (*Relati
 }
 
 func (r *Repository) ListRelationships(ctx context.Context, filters ListRelationshipFilters, page, limit int) (*RelationshipListResponse, error) {
-	offset := (page - 1) * limit
-
-	// Build WHERE clause
-	whereClause := "WHERE 1=1"
-	args := []interface{}{}
-	argIndex := 1
-
-	if filters.SourceID != nil {
-		whereClause += fmt.Sprintf(" AND source_id = $%d", argIndex)
-		args = append(args, filters.SourceID)
-		argIndex++
-	}
-
-	if filters.TargetID != nil {
-		whereClause += fmt.Sprintf(" AND target_id = $%d", argIndex)
-		args = append(args, filters.TargetID)
-		argIndex++
-	}
-
-	if filters.RelationshipType != "" {
-		whereClause += fmt.Sprintf(" AND relationship_type = $%d", argIndex)
-		args = append(args, filters.RelationshipType)
-		argIndex++
-	}
-
-	if filters.Search != "" {
-		whereClause += fmt.Sprintf(" AND (id::text ILIKE $%d OR relationship_type ILIKE $%d OR attributes::text ILIKE $%d)", argIndex, argIndex+1, argIndex+2)
-		args = append(args, "%"+filters.Search+"%", "%"+filters.Search+"%", "%"+filters.Search+"%")
-		argIndex += 3
+	whereClause, args := relationshipListQuery(filters)
+	keyset, err := newKeysetPage(relationshipKeysetColumns, "created_at", true, page, limit, filters.Cursor, filters.Count)
+	if err != nil {
+		return nil, err
 	}
 
 	// Get total count
-	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM relationships %s", whereClause)
-	var total int64
-	err := r.db.QueryRow(ctx, countQuery, args...).Scan(&total)
+	total, err := keyset.countRows(ctx, r.conn(ctx), "relationships", whereClause, args)
 	if err != nil {
 		r.logger.ErrorDatabase("SELECT", "relationships", err, nil)
 		return nil, fmt.Errorf("failed to count relationships: %w", err)
 	}
 
 	// Get paginated results
+	if condition := keyset.where(&args); condition != "" {
+		whereClause += " AND " + condition
+	}
 	query := fmt.Sprintf(`
-		SELECT id, source_id, target_id, relationship_type, attributes, created_at, updated_at, created_by, updated_by
-		FROM relationships %s
-		ORDER BY created_at DESC
-		LIMIT $%d OFFSET $%d
-	`, whereClause, argIndex, argIndex+1)
+		SELECT %s
+		FROM relationships %s %s
+	`, relationshipColumns, whereClause, keyset.orderBy(&args))
 
-	args = append(args, limit, offset)
-
-	rows, err := r.db.Query(ctx, query, args...)
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
 	if err != nil {
 		r.logger.ErrorDatabase("SELECT", "relationships", err, nil)
 		return nil, fmt.Errorf("failed to list relationships: %w", err)
@@ -769,33 +873,74 @@ func (r *Repository) ListRelationships(ctx context.Context, filters ListRelation
 	var relationships []Relationship
 	for rows.Next() {
 		var rel Relationship
-		err := rows.Scan(
-			&rel.ID,
-			&rel.SourceID,
-			&rel.TargetID,
-			&rel.RelationshipType,
-			&rel.Attributes,
-			&rel.CreatedAt,
-			&rel.UpdatedAt,
-			&rel.CreatedBy,
-			&rel.UpdatedBy,
-		)
+		err := scanRelationship(rows, &rel)
 		if err != nil {
 			r.logger.ErrorDatabase("SELECT", "relationships", err, nil)
 			return nil, fmt.Errorf("failed to scan relationship: %w", err)
 		}
 		relationships = append(relationships, rel)
 	}
+	if err := rows.Err(); err != nil {
+		r.logger.ErrorDatabase("SELECT", "relationships", err, nil)
+		return nil, fmt.Errorf("failed to list relationships: %w", err)
+	}
 
-	totalPages := int((total + int64(limit) - 1) / int64(limit))
+	n, more := keyset.trim(len(relationships))
+	relationships = relationships[:n]
+	if keyset.backward() {
+		for i, j := 0, len(relationships)-1; i < j; i, j = i+1, j-1 {
+			relationships[i], relationships[j] = relationships[j], relationships[i]
+		}
+	}
 
-	return &RelationshipListResponse{
+	response := &RelationshipListResponse{
 		Relationships: relationships,
-		Page:          page,
+		Page:          keyset.pageNumber(),
 		Limit:         limit,
 		Total:         total,
-		TotalPages:    totalPages,
-	}, nil
+		TotalPages:    keyset.totalPages(total),
+		CursorPage:    keyset.cursors(more, nil, uuid.Nil, nil, uuid.Nil),
+	}
+	if n > 0 {
+		first, last := &relationships[0], &relationships[n-1]
+		response.CursorPage = keyset.cursors(more, first.CreatedAt, first.ID, last.CreatedAt, last.ID)
+	}
+	return response, nil
+}
+
+// relationshipListQuery builds the WHERE clause selecting the relationships
+// that match filters, with the arguments it binds
+func relationshipListQuery(filters ListRelationshipFilters) (string, []interface{}) {
+	// Build WHERE clause
+	whereClause := "WHERE 1=1"
+	args := []interface{}{}
+	argIndex := 1
+
+	if filters.SourceID != nil {
+		whereClause += fmt.Sprintf(" AND source_id = $%d", argIndex)
+		args = append(args, filters.SourceID)
+		argIndex++
+	}
+
+	if filters.TargetID != nil {
+		whereClause += fmt.Sprintf(" AND target_id = $%d", argIndex)
+		args = append(args, filters.TargetID)
+		argIndex++
+	}
+
+	if filters.RelationshipType != "" {
+		whereClause += fmt.Sprintf(" AND relationship_type = $%d", argIndex)
+		args = append(args, filters.RelationshipType)
+		argIndex++
+	}
+
+	if filters.Search != "" {
+		whereClause += fmt.Sprintf(" AND (id::text ILIKE $%d OR relationship_type ILIKE $%d OR attributes::text ILIKE $%d)", argIndex, argIndex+1, argIndex+2)
+		args = append(args, "%"+filters.Search+"%", "%"+filters.Search+"%", "%"+filters.Search+"%")
+		argIndex += 3
+	}
+
+	return whereClause, args
 }
 
 func (r *Repository) UpdateRelationship(ctx context.Context, id uuid.UUID, updates *UpdateRelationshipRequest, updatedBy uuid.UUID) (*Relationship, error) {
@@ -835,26 +980,18 @@ func (r *Repository) UpdateRelationship(ctx context.Context, id uuid.UUID, updat
 	args = append(args, updatedBy)
 	argIndex++
 
+	setClauses = append(setClauses, "version = version + 1")
+
 	setClause := "SET " + setClauses[0]
 	for i := 1; i < len(setClauses); i++ {
 		setClause += ", " + setClauses[i]
 	}
 
-	query := fmt.Sprintf("UPDATE relationships %s WHERE id = $%d RETURNING id, source_id, target_id, relationship_type, attributes, created_at, updated_at, created_by, updated_by", setClause, argIndex)
+	query := fmt.Sprintf("UPDATE relationships %s WHERE id = $%d RETURNING %s", setClause, argIndex, relationshipColumns)
 	args = append(args, id)
 
 	var result Relationship
-	err = r.db.QueryRow(ctx, query, args...).Scan(
-		&result.ID,
-		&result.SourceID,
-		&result.TargetID,
-		&result.RelationshipType,
-		&result.Attributes,
-		&result.CreatedAt,
-		&result.UpdatedAt,
-		&result.CreatedBy,
-		&result.UpdatedBy,
-	)
+	err = scanRelationship(r.conn(ctx).QueryRow(ctx, query, args...), &result)
 
 	if err != nil {
 		r.logger.ErrorDatabase("UPDATE", "relationships", err, map[string]interface{}{
@@ -873,7 +1010,7 @@ func (r *Repository) UpdateRelationship(ctx context.Context, id uuid.UUID, updat
 
 func (r *Repository) DeleteRelationship(ctx context.Context, id uuid.UUID) error {
 	query := "DELETE FROM relationships WHERE id = $1"
-	_, err := r.db.Exec(ctx, query, id)
+	_, err := r.conn(ctx).Exec(ctx, query, id)
 	if err != nil {
 		r.logger.ErrorDatabase("DELETE", "relationships", err, map[string]interface{}{
 			"relationship_id": id,
@@ -888,13 +1025,65 @@ func (r *Repository) DeleteRelationship(ctx context.Context, id uuid.UUID) error
 	return nil
 }
 
+// CIHasRelationships reports whether any relationship starts or ends at the CI
+func (r *Repository) CIHasRelationships(ctx context.Context, id uuid.UUID) (bool, error) {
+	query := "SELECT EXISTS(SELECT 1 FROM relationships WHERE source_id = $1 OR target_id = $1)"
+
+	var exists bool
+	if err := r.conn(ctx).QueryRow(ctx, query, id).Scan(&exists); err != nil {
+		r.logger.ErrorDatabase("SELECT", "relationships", err, map[string]interface{}{
+			"ci_id": id,
+		})
+		return false, fmt.Errorf("failed to check relationships: %w", err)
+	}
+
+	return exists, nil
+}
+
+// ListCIsWithRelationships returns which of the given CIs are the source or
+// target of at least one relationship, with one query
+func (r *Repository) ListCIsWithRelationships(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]bool, error) {
+	query := "SELECT source_id, target_id FROM relationships WHERE source_id = ANY($1) OR target_id = ANY($1)"
+
+	rows, err := r.conn(ctx).Query(ctx, query, ids)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "relationships", err, map[string]interface{}{
+			"count": len(ids),
+		})
+		return nil, fmt.Errorf("failed to check relationships: %w", err)
+	}
+	defer rows.Close()
+
+	wanted := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	related := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var sourceID, targetID uuid.UUID
+		if err := rows.Scan(&sourceID, &targetID); err != nil {
+			return nil, fmt.Errorf("failed to scan relationship: %w", err)
+		}
+		for _, id := range []uuid.UUID{sourceID, targetID} {
+			if wanted[id] {
+				related[id] = true
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to check relationships: %w", err)
+	}
+
+	return related, nil
+}
+
 // Count methods for dashboard statistics
 
 func (r *Repository) CountCIs(ctx context.Context) (int64, error) {
 	query := "SELECT COUNT(*) FROM configuration_items"
 
 	var count int64
-	err := r.db.QueryRow(ctx, query).Scan(&count)
+	err := r.conn(ctx).QueryRow(ctx, query).Scan(&count)
 	if err != nil {
 		r.logger.ErrorDatabase("SELECT", "configuration_items", err, nil)
 		return 0, fmt.Errorf("failed to count CIs: %w", err)
@@ -907,7 +1096,7 @@ func (r *Repository) CountCITypes(ctx context.Context) (int64, error) {
 	query := "SELECT COUNT(*) FROM ci_type_definitions"
 
 	var count int64
-	err := r.db.QueryRow(ctx, query).Scan(&count)
+	err := r.conn(ctx).QueryRow(ctx, query).Scan(&count)
 	if err != nil {
 		r.logger.ErrorDatabase("SELECT", "ci_type_definitions", err, nil)
 		return 0, fmt.Errorf("failed to count CI types: %w", err)
@@ -920,7 +1109,7 @@ func (r *Repository) CountRelationships(ctx context.Context) (int64, error) {
 	query := "SELECT COUNT(*) FROM relationships"
 
 	var count int64
-	err := r.db.QueryRow(ctx, query).Scan(&count)
+	err := r.conn(ctx).QueryRow(ctx, query).Scan(&count)
 	if err != nil {
 		r.logger.ErrorDatabase("SELECT", "relationships", err, nil)
 		return 0, fmt.Errorf("failed to count relationships: %w", err)
@@ -933,7 +1122,7 @@ func (r *Repository) CountUsers(ctx context.Context) (int64, error) {
 	query := "SELECT COUNT(*) FROM users"
 
 	var count int64
-	err := r.db.QueryRow(ctx, query).Scan(&count)
+	err := r.conn(ctx).QueryRow(ctx, query).Scan(&count)
 	if err != nil {
 		r.logger.ErrorDatabase("SELECT", "users", err, nil)
 		return 0, fmt.Errorf("failed to count users: %w", err)
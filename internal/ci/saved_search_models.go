@@ -0,0 +1,162 @@
+package ci
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of saved searches: a CI listing or a graph query
+const (
+	SavedSearchKindCI    = "ci"
+	SavedSearchKindGraph = "graph"
+)
+
+// ciViewColumns are the CI fields a CI listing view can show besides
+// attributes.<name>
+var ciViewColumns = []string{"id", "name", "ci_type", "tags", "created_at", "updated_at", "created_by", "updated_by", "version"}
+
+// SavedSearch is a named CI listing or graph query a user keeps to run again.
+// Shared with a role, it is visible to everyone holding the role, but only
+// its owner may change it. Columns are the fields a CI listing view shows,
+// in order.
+type SavedSearch struct {
+	ID             uuid.UUID      `json:"id" db:"id"`
+	OwnerID        uuid.UUID      `json:"owner_id" db:"owner_id"`
+	Name           string         `json:"name" db:"name"`
+	Description    *string        `json:"description,omitempty" db:"description"`
+	Kind           string         `json:"kind" db:"kind"`
+	CIFilters      *ListCIFilters `json:"ci_filters,omitempty" db:"ci_filters"`
+	GraphFilters   *GraphFilters  `json:"graph_filters,omitempty" db:"graph_filters"`
+	Columns        []string       `json:"columns" db:"columns"`
+	SharedWithRole *string        `json:"shared_with_role,omitempty" db:"shared_with_role"`
+	CreatedAt      time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at" db:"updated_at"`
+	Version        int            `json:"version" db:"version"`
+}
+
+type CreateSavedSearchRequest struct {
+	Name           string         `json:"name" validate:"required"`
+	Description    *string        `json:"description,omitempty"`
+	Kind           string         `json:"kind" validate:"required"`
+	CIFilters      *ListCIFilters `json:"ci_filters,omitempty"`
+	GraphFilters   *GraphFilters  `json:"graph_filters,omitempty"`
+	Columns        []string       `json:"columns,omitempty"`
+	SharedWithRole *string        `json:"shared_with_role,omitempty"`
+}
+
+// UpdateSavedSearchRequest changes a saved search. The kind cannot change;
+// nil fields are left as they are, and an empty shared_with_role stops
+// sharing.
+type UpdateSavedSearchRequest struct {
+	Name           *string        `json:"name,omitempty"`
+	Description    *string        `json:"description,omitempty"`
+	CIFilters      *ListCIFilters `json:"ci_filters,omitempty"`
+	GraphFilters   *GraphFilters  `json:"graph_filters,omitempty"`
+	Columns        []string       `json:"columns,omitempty"`
+	SharedWithRole *string        `json:"shared_with_role,omitempty"`
+}
+
+type SavedSearchListResponse struct {
+	SavedSearches []SavedSearch `json:"saved_searches"`
+	Page          int           `json:"page"`
+	Limit         int           `json:"limit"`
+	Total         int64         `json:"total"`
+	TotalPages    int           `json:"total_pages"`
+}
+
+// SavedSearchViewer is the user looking at saved searches, who sees their own
+// and those shared with any of their roles
+type SavedSearchViewer struct {
+	UserID uuid.UUID
+	Roles  []string
+}
+
+// canSee reports whether the viewer may see and run a saved search
+func (v SavedSearchViewer) canSee(search *SavedSearch) bool {
+	return search.OwnerID == v.UserID || (search.SharedWithRole != nil && containsString(v.Roles, *search.SharedWithRole))
+}
+
+// SavedSearchResults is a saved search run in one call: the search with the
+// CI list page or graph data it finds
+type SavedSearchResults struct {
+	SavedSearch *SavedSearch    `json:"saved_search"`
+	CIs         *CIListResponse `json:"cis,omitempty"`
+	Graph       *GraphData      `json:"graph,omitempty"`
+}
+
+// normalizeSavedSearch drops what is not kept with a saved search: paging
+// state, and the filters of the other kind
+func normalizeSavedSearch(search *SavedSearch) {
+	if search.SharedWithRole != nil && *search.SharedWithRole == "" {
+		search.SharedWithRole = nil
+	}
+	if search.Columns == nil {
+		search.Columns = []string{}
+	}
+	switch search.Kind {
+	case SavedSearchKindCI:
+		search.GraphFilters = nil
+		if search.CIFilters != nil {
+			search.CIFilters.Cursor = ""
+		}
+	case SavedSearchKindGraph:
+		search.CIFilters = nil
+	}
+}
+
+// validateSavedSearch checks a saved search's own fields. CI types, roles and
+// the attributes named by filters and columns are checked against the
+// database by the service.
+func validateSavedSearch(search *SavedSearch) []ValidationError {
+	var errors []ValidationError
+
+	name := strings.TrimSpace(search.Name)
+	if name == "" {
+		errors = append(errors, ValidationError{Field: "name", Message: "name is required"})
+	} else if len(name) > 255 {
+		errors = append(errors, ValidationError{Field: "name", Message: "name must be at most 255 characters"})
+	}
+
+	switch search.Kind {
+	case SavedSearchKindCI:
+		if search.CIFilters == nil {
+			errors = append(errors, ValidationError{Field: "ci_filters", Message: "ci_filters is required for a CI search"})
+		}
+	case SavedSearchKindGraph:
+		if search.GraphFilters == nil {
+			errors = append(errors, ValidationError{Field: "graph_filters", Message: "graph_filters is required for a graph search"})
+		} else if search.GraphFilters.Limit < 0 || search.GraphFilters.Limit > 500 {
+			errors = append(errors, ValidationError{Field: "graph_filters.limit", Message: "limit must be between 1 and 500"})
+		}
+		if len(search.Columns) > 0 {
+			errors = append(errors, ValidationError{Field: "columns", Message: "only CI searches have columns"})
+		}
+	default:
+		errors = append(errors, ValidationError{
+			Field:   "kind",
+			Message: fmt.Sprintf("kind must be %s or %s", SavedSearchKindCI, SavedSearchKindGraph),
+		})
+	}
+
+	seen := map[string]bool{}
+	for i, column := range search.Columns {
+		field := fmt.Sprintf("columns[%d]", i)
+		switch {
+		case seen[column]:
+			errors = append(errors, ValidationError{Field: field, Message: fmt.Sprintf("column '%s' is listed twice", column)})
+		case containsString(ciViewColumns, column):
+		case strings.HasPrefix(column, "attributes.") && column != "attributes.":
+		default:
+			errors = append(errors, ValidationError{
+				Field:   field,
+				Message: fmt.Sprintf("unknown column '%s'; use one of %s or attributes.<name>", column, strings.Join(ciViewColumns, ", ")),
+			})
+		}
+		seen[column] = true
+	}
+
+	return errors
+}
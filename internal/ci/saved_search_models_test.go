@@ -0,0 +1,72 @@
+package ci
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeSavedSearch(t *testing.T) {
+	empty := ""
+	search := &SavedSearch{
+		Kind:           SavedSearchKindCI,
+		CIFilters:      &ListCIFilters{CIType: "Server", Cursor: "abc", Count: CountNone},
+		GraphFilters:   &GraphFilters{Limit: 10},
+		SharedWithRole: &empty,
+	}
+	normalizeSavedSearch(search)
+
+	// Paging position and the other kind's filters are not kept
+	assert.Equal(t, &ListCIFilters{CIType: "Server", Count: CountNone}, search.CIFilters)
+	assert.Nil(t, search.GraphFilters)
+	assert.Nil(t, search.SharedWithRole)
+	assert.Equal(t, []string{}, search.Columns)
+}
+
+func TestValidateSavedSearch(t *testing.T) {
+	valid := &SavedSearch{
+		Name:      "Linux servers",
+		Kind:      SavedSearchKindCI,
+		CIFilters: &ListCIFilters{Query: "attributes.os:linux"},
+		Columns:   []string{"name", "attributes.os", "attributes.config.kernel", "updated_at"},
+	}
+	assert.Empty(t, validateSavedSearch(valid))
+	assert.Empty(t, validateSavedSearch(&SavedSearch{Name: "Map", Kind: SavedSearchKindGraph, GraphFilters: &GraphFilters{}}))
+
+	fields := func(search *SavedSearch) []string {
+		var fields []string
+		for _, e := range validateSavedSearch(search) {
+			fields = append(fields, e.Field)
+		}
+		return fields
+	}
+	assert.Equal(t, []string{"name", "kind"}, fields(&SavedSearch{Name: " ", Kind: "report"}))
+	assert.Equal(t, []string{"ci_filters"}, fields(&SavedSearch{Name: "a", Kind: SavedSearchKindCI}))
+	assert.Equal(t, []string{"graph_filters.limit", "columns"}, fields(&SavedSearch{
+		Name: "a", Kind: SavedSearchKindGraph, GraphFilters: &GraphFilters{Limit: 1000}, Columns: []string{"name"},
+	}))
+
+	errors := validateSavedSearch(&SavedSearch{
+		Name: "a", Kind: SavedSearchKindCI, CIFilters: &ListCIFilters{},
+		Columns: []string{"name", "owner", "name", "attributes."},
+	})
+	require.Len(t, errors, 3)
+	assert.Equal(t, "columns[1]", errors[0].Field)
+	assert.Contains(t, errors[0].Message, "unknown column 'owner'")
+	assert.Equal(t, ValidationError{Field: "columns[2]", Message: "column 'name' is listed twice"}, errors[1])
+	assert.Equal(t, "columns[3]", errors[2].Field)
+}
+
+func TestSavedSearchViewerCanSee(t *testing.T) {
+	owner, other := uuid.New(), uuid.New()
+	ops := "ops"
+	private := &SavedSearch{OwnerID: owner}
+	shared := &SavedSearch{OwnerID: owner, SharedWithRole: &ops}
+
+	assert.True(t, SavedSearchViewer{UserID: owner}.canSee(private))
+	assert.False(t, SavedSearchViewer{UserID: other, Roles: []string{"ops"}}.canSee(private))
+	assert.True(t, SavedSearchViewer{UserID: other, Roles: []string{"viewer", "ops"}}.canSee(shared))
+	assert.False(t, SavedSearchViewer{UserID: other, Roles: []string{"viewer"}}.canSee(shared))
+}
@@ -0,0 +1,220 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// savedSearchColumns lists the saved_searches columns in the order
+// scanSavedSearch expects
+const savedSearchColumns = "id, owner_id, name, description, kind, ci_filters, graph_filters, columns, shared_with_role, created_at, updated_at, version"
+
+func scanSavedSearch(row pgx.Row, search *SavedSearch) error {
+	return row.Scan(
+		&search.ID,
+		&search.OwnerID,
+		&search.Name,
+		&search.Description,
+		&search.Kind,
+		&search.CIFilters,
+		&search.GraphFilters,
+		&search.Columns,
+		&search.SharedWithRole,
+		&search.CreatedAt,
+		&search.UpdatedAt,
+		&search.Version,
+	)
+}
+
+func (r *Repository) CreateSavedSearch(ctx context.Context, search *SavedSearch) (*SavedSearch, error) {
+	query := `
+		INSERT INTO saved_searches (id, owner_id, name, description, kind, ci_filters, graph_filters, columns, shared_with_role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10)
+		RETURNING ` + savedSearchColumns
+
+	if search.ID == uuid.Nil {
+		search.ID = uuid.New()
+	}
+
+	var result SavedSearch
+	err := scanSavedSearch(r.conn(ctx).QueryRow(ctx, query,
+		search.ID,
+		search.OwnerID,
+		search.Name,
+		search.Description,
+		search.Kind,
+		search.CIFilters,
+		search.GraphFilters,
+		nonNilStrings(search.Columns),
+		search.SharedWithRole,
+		time.Now(),
+	), &result)
+
+	if err != nil {
+		r.logger.ErrorDatabase("INSERT", "saved_searches", err, map[string]interface{}{
+			"saved_search": search.Name,
+			"owner_id":     search.OwnerID,
+		})
+		return nil, fmt.Errorf("failed to create saved search: %w", err)
+	}
+
+	return &result, nil
+}
+
+func (r *Repository) GetSavedSearch(ctx context.Context, id uuid.UUID) (*SavedSearch, error) {
+	query := `SELECT ` + savedSearchColumns + ` FROM saved_searches WHERE id = $1`
+
+	var search SavedSearch
+	if err := scanSavedSearch(r.conn(ctx).QueryRow(ctx, query, id), &search); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("saved search not found")
+		}
+		r.logger.ErrorDatabase("SELECT", "saved_searches", err, map[string]interface{}{
+			"saved_search_id": id,
+		})
+		return nil, fmt.Errorf("failed to get saved search: %w", err)
+	}
+
+	return &search, nil
+}
+
+// ListSavedSearches lists the saved searches a viewer can see, of one kind
+// or all kinds, by name
+func (r *Repository) ListSavedSearches(ctx context.Context, viewer SavedSearchViewer, kind string, page, limit int) (*SavedSearchListResponse, error) {
+	offset := (page - 1) * limit
+	whereClause := "WHERE (owner_id = $1 OR shared_with_role = ANY($2)) AND ($3 = '' OR kind = $3)"
+	args := []interface{}{viewer.UserID, nonNilStrings(viewer.Roles), kind}
+
+	var total int64
+	countQuery := "SELECT COUNT(*) FROM saved_searches " + whereClause
+	if err := r.conn(ctx).QueryRow(ctx, countQuery, args...).Scan(&total); err != nil {
+		r.logger.ErrorDatabase("SELECT", "saved_searches", err, nil)
+		return nil, fmt.Errorf("failed to count saved searches: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM saved_searches %s
+		ORDER BY name, id
+		LIMIT $4 OFFSET $5
+	`, savedSearchColumns, whereClause)
+	args = append(args, limit, offset)
+
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "saved_searches", err, nil)
+		return nil, fmt.Errorf("failed to list saved searches: %w", err)
+	}
+	defer rows.Close()
+
+	searches := []SavedSearch{}
+	for rows.Next() {
+		var search SavedSearch
+		if err := scanSavedSearch(rows, &search); err != nil {
+			r.logger.ErrorDatabase("SELECT", "saved_searches", err, nil)
+			return nil, fmt.Errorf("failed to scan saved search: %w", err)
+		}
+		searches = append(searches, search)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list saved searches: %w", err)
+	}
+
+	return &SavedSearchListResponse{
+		SavedSearches: searches,
+		Page:          page,
+		Limit:         limit,
+		Total:         total,
+		TotalPages:    int((total + int64(limit) - 1) / int64(limit)),
+	}, nil
+}
+
+// UpdateSavedSearch writes the full saved search; the service merges the
+// update request into the current one first
+func (r *Repository) UpdateSavedSearch(ctx context.Context, search *SavedSearch) (*SavedSearch, error) {
+	query := `
+		UPDATE saved_searches
+		SET name = $2, description = $3, ci_filters = $4, graph_filters = $5, columns = $6,
+			shared_with_role = $7, updated_at = $8, version = version + 1
+		WHERE id = $1
+		RETURNING ` + savedSearchColumns
+
+	var result SavedSearch
+	err := scanSavedSearch(r.conn(ctx).QueryRow(ctx, query,
+		search.ID,
+		search.Name,
+		search.Description,
+		search.CIFilters,
+		search.GraphFilters,
+		nonNilStrings(search.Columns),
+		search.SharedWithRole,
+		time.Now(),
+	), &result)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("saved search not found")
+		}
+		r.logger.ErrorDatabase("UPDATE", "saved_searches", err, map[string]interface{}{
+			"saved_search_id": search.ID,
+		})
+		return nil, fmt.Errorf("failed to update saved search: %w", err)
+	}
+
+	return &result, nil
+}
+
+func (r *Repository) DeleteSavedSearch(ctx context.Context, id uuid.UUID) error {
+	if _, err := r.conn(ctx).Exec(ctx, "DELETE FROM saved_searches WHERE id = $1", id); err != nil {
+		r.logger.ErrorDatabase("DELETE", "saved_searches", err, map[string]interface{}{
+			"saved_search_id": id,
+		})
+		return fmt.Errorf("failed to delete saved search: %w", err)
+	}
+	return nil
+}
+
+// LockSavedSearch locks a saved search row until the transaction ends
+func (r *Repository) LockSavedSearch(ctx context.Context, id uuid.UUID) error {
+	_, err := r.conn(ctx).Exec(ctx, "SELECT id FROM saved_searches WHERE id = $1 FOR UPDATE", id)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "saved_searches", err, map[string]interface{}{
+			"saved_search_id": id,
+		})
+		return fmt.Errorf("failed to lock saved search: %w", err)
+	}
+	return nil
+}
+
+// SavedSearchNameTaken reports whether an owner has another saved search,
+// other than exceptID, with the given name
+func (r *Repository) SavedSearchNameTaken(ctx context.Context, ownerID uuid.UUID, name string, exceptID uuid.UUID) (bool, error) {
+	var taken bool
+	err := r.conn(ctx).QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM saved_searches WHERE owner_id = $1 AND name = $2 AND id <> $3)",
+		ownerID, name, exceptID,
+	).Scan(&taken)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "saved_searches", err, map[string]interface{}{
+			"owner_id": ownerID,
+		})
+		return false, fmt.Errorf("failed to check saved search name: %w", err)
+	}
+	return taken, nil
+}
+
+// RoleExists reports whether a role with the given name exists
+func (r *Repository) RoleExists(ctx context.Context, name string) (bool, error) {
+	var exists bool
+	if err := r.conn(ctx).QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)", name).Scan(&exists); err != nil {
+		r.logger.ErrorDatabase("SELECT", "roles", err, map[string]interface{}{
+			"role": name,
+		})
+		return false, fmt.Errorf("failed to check role: %w", err)
+	}
+	return exists, nil
+}
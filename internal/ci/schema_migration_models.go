@@ -0,0 +1,362 @@
+package ci
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Schema migration operations
+const (
+	SchemaOpRename     = "rename"
+	SchemaOpSetDefault = "set_default"
+	SchemaOpConvert    = "convert"
+	SchemaOpDrop       = "drop"
+)
+
+// Schema migration job statuses
+const (
+	SchemaMigrationStatusPending   = "pending"
+	SchemaMigrationStatusRunning   = "running"
+	SchemaMigrationStatusCompleted = "completed"
+	SchemaMigrationStatusFailed    = "failed"
+)
+
+// schemaReportSampleLimit caps how many failing CIs a dry run or job lists
+const schemaReportSampleLimit = 100
+
+// schemaScanBatchSize is how many CIs a dry run loads at a time
+const schemaScanBatchSize = 500
+
+// CITypeSchemaVersion is the attribute schema of a CI type as it was at one version
+type CITypeSchemaVersion struct {
+	CITypeID           uuid.UUID             `json:"ci_type_id" db:"ci_type_id"`
+	Version            int                   `json:"version" db:"version"`
+	RequiredAttributes []AttributeDefinition `json:"required_attributes" db:"required_attributes"`
+	OptionalAttributes []AttributeDefinition `json:"optional_attributes" db:"optional_attributes"`
+	Parent             *string               `json:"parent,omitempty" db:"parent"`
+	ChangedBy          *uuid.UUID            `json:"changed_by,omitempty" db:"changed_by"`
+	ChangedAt          time.Time             `json:"changed_at" db:"changed_at"`
+}
+
+// CITypeSchemaVersionListResponse represents a paginated schema history, newest first
+type CITypeSchemaVersionListResponse struct {
+	Versions   []CITypeSchemaVersion `json:"versions"`
+	Page       int                   `json:"page"`
+	Limit      int                   `json:"limit"`
+	Total      int64                 `json:"total"`
+	TotalPages int                   `json:"total_pages"`
+}
+
+// AttributeMigration is one declarative step of a schema migration. Rename
+// moves Attribute to To, set_default fills Value into CIs that lack the
+// attribute, convert changes the attribute to Type and converts stored
+// values, and drop removes the attribute from the schema and every CI.
+type AttributeMigration struct {
+	Op        string      `json:"op"`
+	Attribute string      `json:"attribute"`
+	To        string      `json:"to,omitempty"`
+	Type      string      `json:"type,omitempty"`
+	Value     interface{} `json:"value,omitempty"`
+}
+
+type SchemaMigrationRequest struct {
+	Operations []AttributeMigration `json:"operations"`
+	DryRun     bool                 `json:"dry_run"`
+}
+
+// SchemaMigrationJob tracks a migration applied to the CIs of one CI type in
+// the background. Failures is a capped sample; FailedCIs is exact.
+type SchemaMigrationJob struct {
+	ID           uuid.UUID                `json:"id" db:"id"`
+	CITypeID     uuid.UUID                `json:"ci_type_id" db:"ci_type_id"`
+	FromVersion  int                      `json:"from_version" db:"from_version"`
+	ToVersion    int                      `json:"to_version" db:"to_version"`
+	Operations   []AttributeMigration     `json:"operations" db:"operations"`
+	Status       string                   `json:"status" db:"status"`
+	TotalCIs     int                      `json:"total_cis" db:"total_cis"`
+	ProcessedCIs int                      `json:"processed_cis" db:"processed_cis"`
+	MigratedCIs  int                      `json:"migrated_cis" db:"migrated_cis"`
+	FailedCIs    int                      `json:"failed_cis" db:"failed_cis"`
+	Failures     []SchemaMigrationFailure `json:"failures" db:"failures"`
+	LastCIID     *uuid.UUID               `json:"-" db:"last_ci_id"`
+	Error        *string                  `json:"error,omitempty" db:"error"`
+	CreatedBy    uuid.UUID                `json:"created_by" db:"created_by"`
+	CreatedAt    time.Time                `json:"created_at" db:"created_at"`
+	StartedAt    *time.Time               `json:"started_at,omitempty" db:"started_at"`
+	UpdatedAt    time.Time                `json:"updated_at" db:"updated_at"`
+	FinishedAt   *time.Time               `json:"finished_at,omitempty" db:"finished_at"`
+}
+
+// SchemaMigrationFailure is a CI that does not fit a schema
+type SchemaMigrationFailure struct {
+	CIID   uuid.UUID         `json:"ci_id"`
+	CIName string            `json:"ci_name"`
+	Errors []ValidationError `json:"errors"`
+}
+
+// SchemaDryRunReport describes how the existing CIs of a type fare against a
+// proposed schema. ChangedCIs counts CIs whose attributes a migration would
+// rewrite, including computed attributes the schema would derive anew;
+// BrokenCIs counts CIs that would fail validation.
+type SchemaDryRunReport struct {
+	CIType        string                   `json:"ci_type"`
+	SchemaVersion int                      `json:"schema_version"`
+	TotalCIs      int                      `json:"total_cis"`
+	ChangedCIs    int                      `json:"changed_cis"`
+	BrokenCIs     int                      `json:"broken_cis"`
+	Failures      []SchemaMigrationFailure `json:"failures"`
+}
+
+// withSchemaUpdate returns a copy of the CI type with the schema changes in
+// req applied. The copy has no ID so its patterns are not cached.
+func (ciType *CITypeDefinition) withSchemaUpdate(req *UpdateCITypeRequest) *CITypeDefinition {
+	proposed := ciType.schemaCopy()
+	if req.RequiredAttributes != nil {
+		proposed.RequiredAttributes = req.RequiredAttributes
+	}
+	if req.OptionalAttributes != nil {
+		proposed.OptionalAttributes = req.OptionalAttributes
+	}
+	if req.Parent != nil {
+		proposed.Parent = nil
+		if *req.Parent != "" {
+			proposed.Parent = req.Parent
+		}
+	}
+	if req.Abstract != nil {
+		proposed.Abstract = *req.Abstract
+	}
+	return proposed
+}
+
+func (ciType *CITypeDefinition) schemaCopy() *CITypeDefinition {
+	return &CITypeDefinition{
+		Name:               ciType.Name,
+		Description:        ciType.Description,
+		RequiredAttributes: append([]AttributeDefinition{}, ciType.RequiredAttributes...),
+		OptionalAttributes: append([]AttributeDefinition{}, ciType.OptionalAttributes...),
+		SchemaVersion:      ciType.SchemaVersion,
+		Parent:             ciType.Parent,
+		Abstract:           ciType.Abstract,
+	}
+}
+
+// findAttribute returns the definition of the named attribute, or nil
+func (ciType *CITypeDefinition) findAttribute(name string) *AttributeDefinition {
+	for _, attrs := range [][]AttributeDefinition{ciType.RequiredAttributes, ciType.OptionalAttributes} {
+		for i := range attrs {
+			if attrs[i].Name == name {
+				return &attrs[i]
+			}
+		}
+	}
+	return nil
+}
+
+// migrateSchema applies migration operations to a copy of the CI type's
+// schema, checking each operation against the schema left by the ones before it
+func (ciType *CITypeDefinition) migrateSchema(operations []AttributeMigration) (*CITypeDefinition, []ValidationError) {
+	if len(operations) == 0 {
+		return nil, []ValidationError{{Field: "operations", Message: "at least one operation is required"}}
+	}
+
+	migrated := ciType.schemaCopy()
+	var errors []ValidationError
+
+	for i, op := range operations {
+		field := fmt.Sprintf("operations[%d]", i)
+
+		attr := migrated.findAttribute(op.Attribute)
+		if attr == nil {
+			errors = append(errors, ValidationError{
+				Field:   field + ".attribute",
+				Message: fmt.Sprintf("attribute '%s' is not defined", op.Attribute),
+			})
+			continue
+		}
+
+		switch op.Op {
+		case SchemaOpRename:
+			if op.To == "" {
+				errors = append(errors, ValidationError{Field: field + ".to", Message: "new attribute name is required"})
+			} else if migrated.findAttribute(op.To) != nil {
+				errors = append(errors, ValidationError{
+					Field:   field + ".to",
+					Message: fmt.Sprintf("attribute '%s' already exists", op.To),
+				})
+			} else {
+				attr.Name = op.To
+			}
+
+		case SchemaOpSetDefault:
+			if op.Value == nil {
+				errors = append(errors, ValidationError{Field: field + ".value", Message: "default value is required"})
+				continue
+			}
+			check := &CITypeDefinition{OptionalAttributes: []AttributeDefinition{*attr}}
+			for _, err := range check.ValidateAttributes(map[string]interface{}{attr.Name: op.Value}) {
+				errors = append(errors, ValidationError{Field: field + ".value", Message: err.Message})
+			}
+
+		case SchemaOpConvert:
+			if !attributeTypes[op.Type] {
+				errors = append(errors, ValidationError{
+					Field:   field + ".type",
+					Message: fmt.Sprintf("unknown type '%s'", op.Type),
+				})
+				continue
+			}
+			attr.Type = op.Type
+			if op.Type != "reference" {
+				attr.Reference = nil
+			}
+
+		case SchemaOpDrop:
+			migrated.RequiredAttributes = removeAttribute(migrated.RequiredAttributes, op.Attribute)
+			migrated.OptionalAttributes = removeAttribute(migrated.OptionalAttributes, op.Attribute)
+
+		default:
+			errors = append(errors, ValidationError{
+				Field:   field + ".op",
+				Message: fmt.Sprintf("unknown operation '%s'; must be rename, set_default, convert or drop", op.Op),
+			})
+		}
+	}
+
+	return migrated, errors
+}
+
+func removeAttribute(attrs []AttributeDefinition, name string) []AttributeDefinition {
+	kept := []AttributeDefinition{}
+	for _, attr := range attrs {
+		if attr.Name != name {
+			kept = append(kept, attr)
+		}
+	}
+	return kept
+}
+
+// migrateAttributes applies migration operations to a copy of a CI's
+// attributes and reports whether anything changed. The operations are
+// idempotent, so an interrupted job can safely run them again.
+func migrateAttributes(attributes map[string]interface{}, operations []AttributeMigration) (map[string]interface{}, bool, []ValidationError) {
+	migrated := make(map[string]interface{}, len(attributes))
+	for key, value := range attributes {
+		migrated[key] = value
+	}
+
+	changed := false
+	var errors []ValidationError
+
+	for _, op := range operations {
+		value, exists := migrated[op.Attribute]
+
+		switch op.Op {
+		case SchemaOpRename:
+			if exists {
+				migrated[op.To] = value
+				delete(migrated, op.Attribute)
+				changed = true
+			}
+
+		case SchemaOpSetDefault:
+			if !exists || value == nil {
+				migrated[op.Attribute] = op.Value
+				changed = true
+			}
+
+		case SchemaOpConvert:
+			if !exists || value == nil {
+				continue
+			}
+			converted, err := convertAttributeValue(value, op.Type)
+			if err != nil {
+				errors = append(errors, ValidationError{Field: op.Attribute, Message: err.Error()})
+				continue
+			}
+			if !reflect.DeepEqual(converted, value) {
+				migrated[op.Attribute] = converted
+				changed = true
+			}
+
+		case SchemaOpDrop:
+			if exists {
+				delete(migrated, op.Attribute)
+				changed = true
+			}
+		}
+	}
+
+	return migrated, changed, errors
+}
+
+// convertAttributeValue converts a stored JSON value to the given attribute
+// type. String-backed types such as date or ipv4 only accept strings; whether
+// the string fits the type is left to ValidateAttributes.
+func convertAttributeValue(value interface{}, toType string) (interface{}, error) {
+	switch toType {
+	case "string":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+
+	case "integer":
+		switch v := value.(type) {
+		case float64:
+			if v == float64(int64(v)) {
+				return v, nil
+			}
+		case string:
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return float64(i), nil
+			}
+		}
+
+	case "number":
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f, nil
+			}
+		}
+
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, nil
+			}
+		}
+
+	case "array":
+		if v, ok := value.([]interface{}); ok {
+			return v, nil
+		}
+		return []interface{}{value}, nil
+
+	case "object":
+		if v, ok := value.(map[string]interface{}); ok {
+			return v, nil
+		}
+
+	default:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("cannot convert %v to %s", value, toType)
+}
@@ -0,0 +1,134 @@
+package ci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateSchema(t *testing.T) {
+	ciType := &CITypeDefinition{
+		Name: "Server",
+		RequiredAttributes: []AttributeDefinition{
+			{Name: "hostname", Type: "string"},
+			{Name: "cpu_cores", Type: "string"},
+		},
+		OptionalAttributes: []AttributeDefinition{
+			{Name: "legacy_id", Type: "string"},
+			{Name: "environment", Type: "string", Validation: &AttributeValidation{Enum: []string{"dev", "prod"}}},
+		},
+		SchemaVersion: 3,
+	}
+
+	migrated, errors := ciType.migrateSchema([]AttributeMigration{
+		{Op: SchemaOpRename, Attribute: "hostname", To: "host_name"},
+		{Op: SchemaOpConvert, Attribute: "cpu_cores", Type: "integer"},
+		{Op: SchemaOpDrop, Attribute: "legacy_id"},
+		{Op: SchemaOpSetDefault, Attribute: "environment", Value: "prod"},
+	})
+	require.Empty(t, errors)
+
+	assert.Equal(t, []AttributeDefinition{
+		{Name: "host_name", Type: "string"},
+		{Name: "cpu_cores", Type: "integer"},
+	}, migrated.RequiredAttributes)
+	require.Len(t, migrated.OptionalAttributes, 1)
+	assert.Equal(t, "environment", migrated.OptionalAttributes[0].Name)
+
+	// The original schema is left alone
+	assert.Equal(t, "hostname", ciType.RequiredAttributes[0].Name)
+	assert.Len(t, ciType.OptionalAttributes, 2)
+}
+
+func TestMigrateSchemaRejectsInvalidOperations(t *testing.T) {
+	ciType := &CITypeDefinition{
+		OptionalAttributes: []AttributeDefinition{
+			{Name: "a", Type: "string", Validation: &AttributeValidation{Enum: []string{"x"}}},
+			{Name: "b", Type: "string"},
+		},
+	}
+
+	_, errors := ciType.migrateSchema([]AttributeMigration{
+		{Op: SchemaOpRename, Attribute: "a", To: "b"},
+		{Op: SchemaOpDrop, Attribute: "missing"},
+		{Op: SchemaOpSetDefault, Attribute: "a", Value: "y"},
+		{Op: SchemaOpConvert, Attribute: "b", Type: "bogus"},
+		{Op: "split", Attribute: "b"},
+	})
+
+	fields := []string{}
+	for _, err := range errors {
+		fields = append(fields, err.Field)
+	}
+	assert.Equal(t, []string{
+		"operations[0].to",
+		"operations[1].attribute",
+		"operations[2].value",
+		"operations[3].type",
+		"operations[4].op",
+	}, fields)
+
+	_, errors = ciType.migrateSchema(nil)
+	assert.Len(t, errors, 1)
+}
+
+func TestMigrateAttributes(t *testing.T) {
+	operations := []AttributeMigration{
+		{Op: SchemaOpRename, Attribute: "hostname", To: "host_name"},
+		{Op: SchemaOpConvert, Attribute: "cpu_cores", Type: "integer"},
+		{Op: SchemaOpDrop, Attribute: "legacy_id"},
+		{Op: SchemaOpSetDefault, Attribute: "environment", Value: "prod"},
+	}
+	attributes := map[string]interface{}{"hostname": "web-01", "cpu_cores": "8", "legacy_id": "X1"}
+
+	migrated, changed, errors := migrateAttributes(attributes, operations)
+	require.Empty(t, errors)
+	assert.True(t, changed)
+	assert.Equal(t, map[string]interface{}{
+		"host_name":   "web-01",
+		"cpu_cores":   float64(8),
+		"environment": "prod",
+	}, migrated)
+	assert.Contains(t, attributes, "legacy_id", "input must not be modified")
+
+	// Running the same operations again changes nothing
+	_, changed, errors = migrateAttributes(migrated, operations)
+	assert.Empty(t, errors)
+	assert.False(t, changed)
+
+	_, _, errors = migrateAttributes(map[string]interface{}{"cpu_cores": "eight"}, operations)
+	require.Len(t, errors, 1)
+	assert.Equal(t, "cpu_cores", errors[0].Field)
+}
+
+func TestConvertAttributeValue(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		toType   string
+		expected interface{}
+		ok       bool
+	}{
+		{float64(8), "string", "8", true},
+		{2.5, "string", "2.5", true},
+		{true, "string", "true", true},
+		{"42", "integer", float64(42), true},
+		{2.5, "integer", nil, false},
+		{"2.5", "number", 2.5, true},
+		{"yes", "boolean", nil, false},
+		{"false", "boolean", false, true},
+		{"a", "array", []interface{}{"a"}, true},
+		{"10.0.0.1", "ipv4", "10.0.0.1", true},
+		{float64(1), "date", nil, false},
+	}
+
+	for _, tt := range tests {
+		converted, err := convertAttributeValue(tt.value, tt.toType)
+		if !tt.ok {
+			assert.Error(t, err, "%v to %s", tt.value, tt.toType)
+			continue
+		}
+		require.NoError(t, err, "%v to %s", tt.value, tt.toType)
+		assert.Equal(t, tt.expected, converted)
+	}
+}
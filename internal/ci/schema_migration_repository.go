@@ -0,0 +1,381 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// CI type schema versions and migration jobs
+
+const ciTypeSchemaVersionColumns = "ci_type_id, version, required_attributes, optional_attributes, parent, changed_by, changed_at"
+
+func scanCITypeSchemaVersion(row pgx.Row, v *CITypeSchemaVersion) error {
+	return row.Scan(
+		&v.CITypeID,
+		&v.Version,
+		&v.RequiredAttributes,
+		&v.OptionalAttributes,
+		&v.Parent,
+		&v.ChangedBy,
+		&v.ChangedAt,
+	)
+}
+
+// createCITypeSchemaVersion records the current schema of a CI type. It runs
+// on the caller's connection so the snapshot commits together with the change.
+func (r *Repository) createCITypeSchemaVersion(ctx context.Context, ciType *CITypeDefinition, changedBy uuid.UUID) error {
+	query := `
+		INSERT INTO ci_type_schema_versions (ci_type_id, version, required_attributes, optional_attributes, parent, changed_by, changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err := r.conn(ctx).Exec(ctx, query,
+		ciType.ID,
+		ciType.SchemaVersion,
+		ciType.RequiredAttributes,
+		ciType.OptionalAttributes,
+		ciType.Parent,
+		changedBy,
+		ciType.UpdatedAt,
+	)
+	if err != nil {
+		r.logger.ErrorDatabase("INSERT", "ci_type_schema_versions", err, map[string]interface{}{
+			"ci_type_id": ciType.ID,
+			"version":    ciType.SchemaVersion,
+		})
+		return fmt.Errorf("failed to record CI type schema version: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) ListCITypeSchemaVersions(ctx context.Context, ciTypeID uuid.UUID, page, limit int) (*CITypeSchemaVersionListResponse, error) {
+	offset := (page - 1) * limit
+
+	var total int64
+	err := r.conn(ctx).QueryRow(ctx, "SELECT COUNT(*) FROM ci_type_schema_versions WHERE ci_type_id = $1", ciTypeID).Scan(&total)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "ci_type_schema_versions", err, map[string]interface{}{
+			"ci_type_id": ciTypeID,
+		})
+		return nil, fmt.Errorf("failed to count CI type schema versions: %w", err)
+	}
+
+	query := `
+		SELECT ` + ciTypeSchemaVersionColumns + `
+		FROM ci_type_schema_versions
+		WHERE ci_type_id = $1
+		ORDER BY version DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.conn(ctx).Query(ctx, query, ciTypeID, limit, offset)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "ci_type_schema_versions", err, map[string]interface{}{
+			"ci_type_id": ciTypeID,
+		})
+		return nil, fmt.Errorf("failed to list CI type schema versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []CITypeSchemaVersion{}
+	for rows.Next() {
+		var v CITypeSchemaVersion
+		if err := scanCITypeSchemaVersion(rows, &v); err != nil {
+			r.logger.ErrorDatabase("SELECT", "ci_type_schema_versions", err, nil)
+			return nil, fmt.Errorf("failed to scan CI type schema version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+
+	totalPages := int((total + int64(limit) - 1) / int64(limit))
+
+	return &CITypeSchemaVersionListResponse{
+		Versions:   versions,
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: totalPages,
+	}, nil
+}
+
+func (r *Repository) GetCITypeSchemaVersion(ctx context.Context, ciTypeID uuid.UUID, version int) (*CITypeSchemaVersion, error) {
+	query := `
+		SELECT ` + ciTypeSchemaVersionColumns + `
+		FROM ci_type_schema_versions
+		WHERE ci_type_id = $1 AND version = $2
+	`
+
+	var v CITypeSchemaVersion
+	err := scanCITypeSchemaVersion(r.conn(ctx).QueryRow(ctx, query, ciTypeID, version), &v)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("CI type schema version not found")
+		}
+		r.logger.ErrorDatabase("SELECT", "ci_type_schema_versions", err, map[string]interface{}{
+			"ci_type_id": ciTypeID,
+			"version":    version,
+		})
+		return nil, fmt.Errorf("failed to get CI type schema version: %w", err)
+	}
+
+	return &v, nil
+}
+
+// LockCIType takes a row lock on a CI type until the transaction ends
+func (r *Repository) LockCIType(ctx context.Context, id uuid.UUID) error {
+	var locked uuid.UUID
+	err := r.conn(ctx).QueryRow(ctx, "SELECT id FROM ci_type_definitions WHERE id = $1 FOR UPDATE", id).Scan(&locked)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("CI type not found")
+		}
+		r.logger.ErrorDatabase("SELECT", "ci_type_definitions", err, map[string]interface{}{
+			"ci_type_id": id,
+		})
+		return fmt.Errorf("failed to lock CI type: %w", err)
+	}
+	return nil
+}
+
+// ListCIsOfTypeAfter returns up to limit CIs of the given types ordered by ID,
+// starting after the given ID, for walking every CI of a type family in batches
+func (r *Repository) ListCIsOfTypeAfter(ctx context.Context, ciTypes []string, after uuid.UUID, limit int) ([]ConfigurationItem, error) {
+	query := `
+		SELECT ` + ciColumns + `
+		FROM configuration_items
+		WHERE ci_type = ANY($1) AND id > $2
+		ORDER BY id
+		LIMIT $3
+	`
+
+	rows, err := r.conn(ctx).Query(ctx, query, ciTypes, after, limit)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "configuration_items", err, map[string]interface{}{
+			"ci_types": ciTypes,
+		})
+		return nil, fmt.Errorf("failed to list CIs: %w", err)
+	}
+	defer rows.Close()
+
+	var cis []ConfigurationItem
+	for rows.Next() {
+		var ci ConfigurationItem
+		if err := scanCI(rows, &ci); err != nil {
+			r.logger.ErrorDatabase("SELECT", "configuration_items", err, nil)
+			return nil, fmt.Errorf("failed to scan CI: %w", err)
+		}
+		cis = append(cis, ci)
+	}
+
+	return cis, rows.Err()
+}
+
+func (r *Repository) CountCIsOfType(ctx context.Context, ciTypes []string) (int, error) {
+	var count int
+	if err := r.conn(ctx).QueryRow(ctx, "SELECT COUNT(*) FROM configuration_items WHERE ci_type = ANY($1)", ciTypes).Scan(&count); err != nil {
+		r.logger.ErrorDatabase("SELECT", "configuration_items", err, map[string]interface{}{
+			"ci_types": ciTypes,
+		})
+		return 0, fmt.Errorf("failed to count CIs: %w", err)
+	}
+	return count, nil
+}
+
+const schemaMigrationJobColumns = "id, ci_type_id, from_version, to_version, operations, status, total_cis, processed_cis, migrated_cis, failed_cis, failures, last_ci_id, error, created_by, created_at, started_at, updated_at, finished_at"
+
+func scanSchemaMigrationJob(row pgx.Row, job *SchemaMigrationJob) error {
+	return row.Scan(
+		&job.ID,
+		&job.CITypeID,
+		&job.FromVersion,
+		&job.ToVersion,
+		&job.Operations,
+		&job.Status,
+		&job.TotalCIs,
+		&job.ProcessedCIs,
+		&job.MigratedCIs,
+		&job.FailedCIs,
+		&job.Failures,
+		&job.LastCIID,
+		&job.Error,
+		&job.CreatedBy,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.UpdatedAt,
+		&job.FinishedAt,
+	)
+}
+
+func (r *Repository) CreateSchemaMigrationJob(ctx context.Context, job *SchemaMigrationJob) (*SchemaMigrationJob, error) {
+	query := `
+		INSERT INTO ci_type_migration_jobs (ci_type_id, from_version, to_version, operations, total_cis, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + schemaMigrationJobColumns
+
+	var result SchemaMigrationJob
+	err := scanSchemaMigrationJob(r.conn(ctx).QueryRow(ctx, query,
+		job.CITypeID,
+		job.FromVersion,
+		job.ToVersion,
+		job.Operations,
+		job.TotalCIs,
+		job.CreatedBy,
+	), &result)
+	if err != nil {
+		r.logger.ErrorDatabase("INSERT", "ci_type_migration_jobs", err, map[string]interface{}{
+			"ci_type_id": job.CITypeID,
+		})
+		return nil, fmt.Errorf("failed to create schema migration job: %w", err)
+	}
+
+	return &result, nil
+}
+
+func (r *Repository) GetSchemaMigrationJob(ctx context.Context, ciTypeID, id uuid.UUID) (*SchemaMigrationJob, error) {
+	query := `
+		SELECT ` + schemaMigrationJobColumns + `
+		FROM ci_type_migration_jobs
+		WHERE id = $1 AND ci_type_id = $2
+	`
+
+	var job SchemaMigrationJob
+	err := scanSchemaMigrationJob(r.conn(ctx).QueryRow(ctx, query, id, ciTypeID), &job)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("schema migration job not found")
+		}
+		r.logger.ErrorDatabase("SELECT", "ci_type_migration_jobs", err, map[string]interface{}{
+			"job_id": id,
+		})
+		return nil, fmt.Errorf("failed to get schema migration job: %w", err)
+	}
+
+	return &job, nil
+}
+
+// ListSchemaMigrationJobs returns the most recent migration jobs of a CI type, newest first
+func (r *Repository) ListSchemaMigrationJobs(ctx context.Context, ciTypeID uuid.UUID, limit int) ([]SchemaMigrationJob, error) {
+	query := `
+		SELECT ` + schemaMigrationJobColumns + `
+		FROM ci_type_migration_jobs
+		WHERE ci_type_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+
+	rows, err := r.conn(ctx).Query(ctx, query, ciTypeID, limit)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "ci_type_migration_jobs", err, map[string]interface{}{
+			"ci_type_id": ciTypeID,
+		})
+		return nil, fmt.Errorf("failed to list schema migration jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []SchemaMigrationJob{}
+	for rows.Next() {
+		var job SchemaMigrationJob
+		if err := scanSchemaMigrationJob(rows, &job); err != nil {
+			r.logger.ErrorDatabase("SELECT", "ci_type_migration_jobs", err, nil)
+			return nil, fmt.Errorf("failed to scan schema migration job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// HasActiveSchemaMigration reports whether a CI type has a pending or running migration
+func (r *Repository) HasActiveSchemaMigration(ctx context.Context, ciTypeID uuid.UUID) (bool, error) {
+	var active bool
+	err := r.conn(ctx).QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM ci_type_migration_jobs WHERE ci_type_id = $1 AND status IN ('pending', 'running'))",
+		ciTypeID,
+	).Scan(&active)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "ci_type_migration_jobs", err, map[string]interface{}{
+			"ci_type_id": ciTypeID,
+		})
+		return false, fmt.Errorf("failed to check schema migrations: %w", err)
+	}
+	return active, nil
+}
+
+// ClaimSchemaMigrationJob marks the oldest pending job as running and returns
+// it. A running job whose progress has not moved for staleAfter is assumed to
+// belong to a stopped process and is claimed again. Returns nil when there is
+// nothing to do.
+func (r *Repository) ClaimSchemaMigrationJob(ctx context.Context, staleAfter time.Duration) (*SchemaMigrationJob, error) {
+	query := `
+		UPDATE ci_type_migration_jobs
+		SET status = 'running', started_at = COALESCE(started_at, NOW()), updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM ci_type_migration_jobs
+			WHERE status = 'pending' OR (status = 'running' AND updated_at < $1)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + schemaMigrationJobColumns
+
+	var job SchemaMigrationJob
+	err := scanSchemaMigrationJob(r.conn(ctx).QueryRow(ctx, query, time.Now().Add(-staleAfter)), &job)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		r.logger.ErrorDatabase("UPDATE", "ci_type_migration_jobs", err, nil)
+		return nil, fmt.Errorf("failed to claim schema migration job: %w", err)
+	}
+
+	return &job, nil
+}
+
+// UpdateSchemaMigrationProgress saves a job's counters, failure sample and
+// position, which also marks the job as alive
+func (r *Repository) UpdateSchemaMigrationProgress(ctx context.Context, job *SchemaMigrationJob) error {
+	query := `
+		UPDATE ci_type_migration_jobs
+		SET processed_cis = $2, migrated_cis = $3, failed_cis = $4, failures = $5, last_ci_id = $6, updated_at = NOW()
+		WHERE id = $1
+	`
+
+	_, err := r.conn(ctx).Exec(ctx, query, job.ID, job.ProcessedCIs, job.MigratedCIs, job.FailedCIs, job.Failures, job.LastCIID)
+	if err != nil {
+		r.logger.ErrorDatabase("UPDATE", "ci_type_migration_jobs", err, map[string]interface{}{
+			"job_id": job.ID,
+		})
+		return fmt.Errorf("failed to update schema migration job: %w", err)
+	}
+	return nil
+}
+
+// FinishSchemaMigrationJob records a job's final status and, for failed jobs, the error
+func (r *Repository) FinishSchemaMigrationJob(ctx context.Context, id uuid.UUID, status string, jobErr error) error {
+	var message *string
+	if jobErr != nil {
+		text := jobErr.Error()
+		message = &text
+	}
+
+	query := `
+		UPDATE ci_type_migration_jobs
+		SET status = $2, error = $3, finished_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+	`
+
+	_, err := r.conn(ctx).Exec(ctx, query, id, status, message)
+	if err != nil {
+		r.logger.ErrorDatabase("UPDATE", "ci_type_migration_jobs", err, map[string]interface{}{
+			"job_id": id,
+			"status": status,
+		})
+		return fmt.Errorf("failed to finish schema migration job: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package ci
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	pustakaLogger "github.com/pustaka/pustaka/pkg/logger"
+)
+
+// SchemaMigrationOptions controls how the runner picks up and works through jobs
+type SchemaMigrationOptions struct {
+	PollInterval time.Duration
+	BatchSize    int
+	StaleAfter   time.Duration
+}
+
+// SchemaMigrationRunner works through queued CI type migration jobs in the
+// background. Progress is saved after every batch; a job left running by a
+// stopped process is picked up again once it has gone stale and resumes after
+// the last saved CI.
+type SchemaMigrationRunner struct {
+	service *Service
+	options SchemaMigrationOptions
+	logger  *pustakaLogger.Logger
+}
+
+func NewSchemaMigrationRunner(service *Service, options SchemaMigrationOptions, logger *pustakaLogger.Logger) *SchemaMigrationRunner {
+	if options.PollInterval <= 0 {
+		options.PollInterval = 5 * time.Second
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 100
+	}
+	if options.StaleAfter <= 0 {
+		options.StaleAfter = 5 * time.Minute
+	}
+
+	return &SchemaMigrationRunner{
+		service: service,
+		options: options,
+		logger:  logger,
+	}
+}
+
+// Run processes migration jobs until ctx is cancelled
+func (m *SchemaMigrationRunner) Run(ctx context.Context) {
+	m.logger.InfoService("schema_migration", "runner_start", map[string]interface{}{
+		"poll_interval": m.options.PollInterval.String(),
+		"batch_size":    m.options.BatchSize,
+	})
+
+	ticker := time.NewTicker(m.options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			job, err := m.service.repo.ClaimSchemaMigrationJob(ctx, m.options.StaleAfter)
+			if err != nil {
+				if ctx.Err() == nil {
+					m.logger.ErrorService("schema_migration", "claim_job", err, nil)
+				}
+				break
+			}
+			if job == nil {
+				break
+			}
+			m.RunJob(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			m.logger.InfoService("schema_migration", "runner_stop", nil)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunJob migrates the CIs of a claimed job and records its outcome. A job
+// interrupted by cancellation stays running so it can be resumed.
+func (m *SchemaMigrationRunner) RunJob(ctx context.Context, job *SchemaMigrationJob) {
+	m.logger.InfoService("schema_migration", "job_start", map[string]interface{}{
+		"job_id":     job.ID,
+		"ci_type_id": job.CITypeID,
+		"to_version": job.ToVersion,
+	})
+
+	err := m.migrate(ctx, job)
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	status := SchemaMigrationStatusCompleted
+	if err != nil {
+		status = SchemaMigrationStatusFailed
+		m.logger.ErrorService("schema_migration", "run_job", err, map[string]interface{}{
+			"job_id": job.ID,
+		})
+	}
+
+	if err := m.service.repo.FinishSchemaMigrationJob(ctx, job.ID, status, err); err != nil {
+		m.logger.ErrorService("schema_migration", "finish_job", err, map[string]interface{}{
+			"job_id": job.ID,
+		})
+		return
+	}
+
+	m.logger.InfoService("schema_migration", "job_finish", map[string]interface{}{
+		"job_id":        job.ID,
+		"status":        status,
+		"processed_cis": job.ProcessedCIs,
+		"migrated_cis":  job.MigratedCIs,
+		"failed_cis":    job.FailedCIs,
+	})
+}
+
+func (m *SchemaMigrationRunner) migrate(ctx context.Context, job *SchemaMigrationJob) error {
+	ciType, err := m.service.repo.GetCIType(ctx, job.CITypeID)
+	if err != nil {
+		return err
+	}
+
+	family, err := m.service.repo.ExpandCITypes(ctx, []string{ciType.Name})
+	if err != nil {
+		return err
+	}
+
+	// resolved holds the effective schema of each type in the family
+	resolved := make(map[string]*CITypeDefinition, len(family))
+
+	after := uuid.Nil
+	if job.LastCIID != nil {
+		after = *job.LastCIID
+	}
+
+	for {
+		cis, err := m.service.repo.ListCIsOfTypeAfter(ctx, family, after, m.options.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(cis) == 0 {
+			return nil
+		}
+
+		for _, ci := range cis {
+			schema, ok := resolved[ci.CIType]
+			if !ok {
+				schema, err = m.service.resolveCITypeByName(ctx, ci.CIType)
+				if err != nil {
+					return err
+				}
+				resolved[ci.CIType] = schema
+			}
+
+			migrated, failures, err := m.service.migrateCI(ctx, schema, ci.ID, job)
+			if err != nil {
+				return err
+			}
+
+			job.ProcessedCIs++
+			if migrated {
+				job.MigratedCIs++
+			}
+			if len(failures) > 0 {
+				job.FailedCIs++
+				if len(job.Failures) < schemaReportSampleLimit {
+					job.Failures = append(job.Failures, SchemaMigrationFailure{
+						CIID:   ci.ID,
+						CIName: ci.Name,
+						Errors: failures,
+					})
+				}
+			}
+		}
+
+		after = cis[len(cis)-1].ID
+		job.LastCIID = &after
+		if err := m.service.repo.UpdateSchemaMigrationProgress(ctx, job); err != nil {
+			return err
+		}
+
+		if len(cis) < m.options.BatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package ci
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// Full-text search
+
+// Kinds of search results
+const (
+	SearchKindCI           = "ci"
+	SearchKindCIType       = "ci_type"
+	SearchKindRelationship = "relationship"
+)
+
+// SearchKinds lists every kind of search result, in the order searched
+var SearchKinds = []string{SearchKindCI, SearchKindCIType, SearchKindRelationship}
+
+// Bounds of a search
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+	maxSearchTerms     = 10
+)
+
+// searchHeadlineOptions marks matches with <mark> in highlights, keeping a
+// few short fragments of long text
+const searchHeadlineOptions = `StartSel="<mark>", StopSel="</mark>", MaxWords=20, MinWords=8, MaxFragments=3, FragmentDelimiter=" … "`
+
+// SearchRequest asks for the results of some kinds that match a query,
+// best first
+type SearchRequest struct {
+	Query string   `json:"q"`
+	Kinds []string `json:"kinds,omitempty"`
+	Limit int      `json:"limit,omitempty"`
+}
+
+// SearchResult is a CI, CI type or relationship matching a search. Name is
+// the CI or CI type name, or the relationship type. Highlights holds the
+// matching text of each searched field, HTML-escaped, with matches wrapped
+// in <mark>; the name is always included.
+type SearchResult struct {
+	Kind       string            `json:"kind"`
+	ID         uuid.UUID         `json:"id"`
+	Name       string            `json:"name"`
+	CIType     string            `json:"ci_type,omitempty"`
+	SourceID   *uuid.UUID        `json:"source_id,omitempty"`
+	TargetID   *uuid.UUID        `json:"target_id,omitempty"`
+	Rank       float32           `json:"rank"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+type SearchResponse struct {
+	Query   string          `json:"query"`
+	Results []*SearchResult `json:"results"`
+}
+
+// searchTSQuery turns search text into a tsquery matching documents with
+// every word as a prefix. Words are runs of letters, digits and the
+// punctuation inside identifiers such as host names, so nothing of the
+// tsquery syntax gets through.
+func searchTSQuery(text string) (string, error) {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(".-_@", r)
+	})
+
+	var terms []string
+	for _, word := range words {
+		word = strings.Trim(word, ".-_@")
+		if word == "" {
+			continue
+		}
+		terms = append(terms, "'"+word+"':*")
+	}
+
+	if len(terms) == 0 {
+		return "", fmt.Errorf("search query has no words")
+	}
+	if len(terms) > maxSearchTerms {
+		return "", fmt.Errorf("search query has more than %d words", maxSearchTerms)
+	}
+	return strings.Join(terms, " & "), nil
+}
+
+// searchHighlight escapes a headline for HTML, keeping its <mark> tags
+func searchHighlight(headline string) string {
+	escaped := html.EscapeString(headline)
+	escaped = strings.ReplaceAll(escaped, "&lt;mark&gt;", "<mark>")
+	return strings.ReplaceAll(escaped, "&lt;/mark&gt;", "</mark>")
+}
+
+// mergeSearchResults merges the ranked results of each kind into the limit
+// best, keeping the order of equally ranked results
+func mergeSearchResults(results [][]*SearchResult, limit int) []*SearchResult {
+	merged := []*SearchResult{}
+	for _, found := range results {
+		merged = append(merged, found...)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Rank > merged[j].Rank
+	})
+	if len(merged) > limit {
+		merged = merged[:limit]
+	}
+	return merged
+}
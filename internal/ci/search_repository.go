@@ -0,0 +1,141 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Full-text search through the search_vector columns. Each search returns the
+// best limit matches of one kind, ranked by ts_rank over the weighted search
+// document, with highlights of the fields that matched.
+
+// searchStringAttributes is the SQL text of the string values of an
+// attributes column, the part of it in the search document
+const searchStringAttributes = `(SELECT string_agg(value, ' ') FROM jsonb_array_elements_text(
+	jsonb_path_query_array(COALESCE(attributes, '{}'), 'strict $.** ? (@.type() == "string")')) AS value)`
+
+// SearchCIs returns the CIs best matching tsquery
+func (r *Repository) SearchCIs(ctx context.Context, tsquery string, limit int) ([]*SearchResult, error) {
+	query := fmt.Sprintf(`
+		WITH matches AS (
+			SELECT id, name, ci_type, tags, attributes, ts_rank(search_vector, query) AS rank
+			FROM configuration_items, to_tsquery('simple', $1) AS query
+			WHERE search_vector @@ query
+			ORDER BY rank DESC, name, id
+			LIMIT $2
+		)
+		SELECT id, name, ci_type, rank,
+			ts_headline('simple', name, query, $3),
+			CASE WHEN to_tsvector('simple', array_to_string(tags, ' ')) @@ query
+				THEN ts_headline('simple', array_to_string(tags, ' '), query, $3) END,
+			CASE WHEN jsonb_to_tsvector('simple', attributes, '["string"]') @@ query
+				THEN ts_headline('simple', %s, query, $3) END
+		FROM matches, to_tsquery('simple', $1) AS query
+		ORDER BY rank DESC, name, id
+	`, searchStringAttributes)
+
+	return r.search(ctx, "configuration_items", query, []interface{}{tsquery, limit, searchHeadlineOptions}, func(rows pgx.Rows) (*SearchResult, error) {
+		result := &SearchResult{Kind: SearchKindCI}
+		var name string
+		var tags, attributes *string
+		if err := rows.Scan(&result.ID, &result.Name, &result.CIType, &result.Rank, &name, &tags, &attributes); err != nil {
+			return nil, err
+		}
+		result.Highlights = searchHighlights(name, map[string]*string{"tags": tags, "attributes": attributes})
+		return result, nil
+	})
+}
+
+// SearchCITypes returns the CI types best matching tsquery
+func (r *Repository) SearchCITypes(ctx context.Context, tsquery string, limit int) ([]*SearchResult, error) {
+	query := `
+		WITH matches AS (
+			SELECT id, name, description, ts_rank(search_vector, query) AS rank
+			FROM ci_type_definitions, to_tsquery('simple', $1) AS query
+			WHERE search_vector @@ query
+			ORDER BY rank DESC, name
+			LIMIT $2
+		)
+		SELECT id, name, rank,
+			ts_headline('simple', name, query, $3),
+			CASE WHEN to_tsvector('simple', COALESCE(description, '')) @@ query
+				THEN ts_headline('simple', description, query, $3) END
+		FROM matches, to_tsquery('simple', $1) AS query
+		ORDER BY rank DESC, name
+	`
+
+	return r.search(ctx, "ci_type_definitions", query, []interface{}{tsquery, limit, searchHeadlineOptions}, func(rows pgx.Rows) (*SearchResult, error) {
+		result := &SearchResult{Kind: SearchKindCIType}
+		var name string
+		var description *string
+		if err := rows.Scan(&result.ID, &result.Name, &result.Rank, &name, &description); err != nil {
+			return nil, err
+		}
+		result.Highlights = searchHighlights(name, map[string]*string{"description": description})
+		return result, nil
+	})
+}
+
+// SearchRelationships returns the relationships best matching tsquery
+func (r *Repository) SearchRelationships(ctx context.Context, tsquery string, limit int) ([]*SearchResult, error) {
+	query := fmt.Sprintf(`
+		WITH matches AS (
+			SELECT id, relationship_type, source_id, target_id, attributes, ts_rank(search_vector, query) AS rank
+			FROM relationships, to_tsquery('simple', $1) AS query
+			WHERE search_vector @@ query
+			ORDER BY rank DESC, created_at DESC, id
+			LIMIT $2
+		)
+		SELECT id, relationship_type, source_id, target_id, rank,
+			ts_headline('simple', relationship_type, query, $3),
+			CASE WHEN jsonb_to_tsvector('simple', COALESCE(attributes, '{}'), '["string"]') @@ query
+				THEN ts_headline('simple', %s, query, $3) END
+		FROM matches, to_tsquery('simple', $1) AS query
+		ORDER BY rank DESC
+	`, searchStringAttributes)
+
+	return r.search(ctx, "relationships", query, []interface{}{tsquery, limit, searchHeadlineOptions}, func(rows pgx.Rows) (*SearchResult, error) {
+		result := &SearchResult{Kind: SearchKindRelationship}
+		var name string
+		var attributes *string
+		if err := rows.Scan(&result.ID, &result.Name, &result.SourceID, &result.TargetID, &result.Rank, &name, &attributes); err != nil {
+			return nil, err
+		}
+		result.Highlights = searchHighlights(name, map[string]*string{"attributes": attributes})
+		return result, nil
+	})
+}
+
+// search runs a search query of table, scanning each row with scan
+func (r *Repository) search(ctx context.Context, table, query string, args []interface{}, scan func(pgx.Rows) (*SearchResult, error)) ([]*SearchResult, error) {
+	rows, err := r.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", table, err, nil)
+		return nil, fmt.Errorf("failed to search %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	results := []*SearchResult{}
+	for rows.Next() {
+		result, err := scan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// searchHighlights maps field names to highlights: the name's, and those of
+// the other fields that matched, whose headlines are nil otherwise
+func searchHighlights(name string, fields map[string]*string) map[string]string {
+	highlights := map[string]string{"name": searchHighlight(name)}
+	for field, headline := range fields {
+		if headline != nil {
+			highlights[field] = searchHighlight(*headline)
+		}
+	}
+	return highlights
+}
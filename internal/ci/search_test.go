@@ -0,0 +1,48 @@
+package ci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchTSQuery(t *testing.T) {
+	query, err := searchTSQuery("web-01 db.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "'web-01':* & 'db.example.com':*", query)
+
+	// tsquery syntax and stray punctuation are dropped
+	query, err = searchTSQuery(`  nginx' | !prod:* (ubuntu) ..-`)
+	require.NoError(t, err)
+	assert.Equal(t, "'nginx':* & 'prod':* & 'ubuntu':*", query)
+
+	query, err = searchTSQuery("Größe 東京")
+	require.NoError(t, err)
+	assert.Equal(t, "'Größe':* & '東京':*", query)
+
+	_, err = searchTSQuery(" &| ")
+	assert.EqualError(t, err, "search query has no words")
+
+	_, err = searchTSQuery("a b c d e f g h i j k")
+	assert.EqualError(t, err, "search query has more than 10 words")
+}
+
+func TestSearchHighlight(t *testing.T) {
+	assert.Equal(t, "<mark>web</mark>-01 &lt;b&gt;", searchHighlight("<mark>web</mark>-01 <b>"))
+
+	attributes := "os <mark>db</mark>"
+	highlights := searchHighlights("<mark>db</mark>", map[string]*string{"tags": nil, "attributes": &attributes})
+	assert.Equal(t, map[string]string{"name": "<mark>db</mark>", "attributes": "os <mark>db</mark>"}, highlights)
+}
+
+func TestMergeSearchResults(t *testing.T) {
+	cis := []*SearchResult{{Kind: SearchKindCI, Name: "a", Rank: 0.6}, {Kind: SearchKindCI, Name: "b", Rank: 0.2}}
+	types := []*SearchResult{{Kind: SearchKindCIType, Name: "c", Rank: 0.6}, {Kind: SearchKindCIType, Name: "d", Rank: 0.4}}
+
+	merged := mergeSearchResults([][]*SearchResult{cis, types}, 3)
+	require.Len(t, merged, 3)
+	assert.Equal(t, []string{"a", "c", "d"}, []string{merged[0].Name, merged[1].Name, merged[2].Name})
+
+	assert.Empty(t, mergeSearchResults(nil, 3))
+}
@@ -3,7 +3,12 @@ package ci
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,14 +19,16 @@ import (
 type Service struct {
 	repo   *Repository
 	neo4j  *Neo4jService
+	audit  *AuditService
 	redis  *redis.Client
 	logger *pustakaLogger.Logger
 }
 
-func NewService(db *Repository, neo4j *Neo4jService, redis *redis.Client, logger *pustakaLogger.Logger) *Service {
+func NewService(db *Repository, neo4j *Neo4jService, audit *AuditService, redis *redis.Client, logger *pustakaLogger.Logger) *Service {
 	return &Service{
 		repo:   db,
 		neo4j:  neo4j,
+		audit:  audit,
 		redis:  redis,
 		logger: logger,
 	}
@@ -31,7 +38,7 @@ func NewService(db *Repository, neo4j *Neo4jService, redis *redis.Client, logger
 
 func (s *Service) CreateCI(ctx context.Context, req *CreateCIRequest, userID uuid.UUID) (*ConfigurationItem, error) {
 	// Validate CI type exists
-	ciType, err := s.repo.GetCITypeByName(ctx, req.CIType)
+	ciType, err := s.resolveCITypeByName(ctx, req.CIType)
 	if err != nil {
 		s.logger.ErrorService("ci", "create_ci", err, map[string]interface{}{
 			"ci_type": req.CIType,
@@ -40,18 +47,16 @@ func (s *Service) CreateCI(ctx context.Context, req *CreateCIRequest, userID uui
 		return nil, fmt.Errorf("CI type '%s' does not exist", req.CIType)
 	}
 
-	// Validate attributes against schema
-	validationErrors := ciType.ValidateAttributes(req.Attributes)
-	if len(validationErrors) > 0 {
-		s.logger.ErrorService("ci", "CREATE_CI_VALIDATION_DETAIL", fmt.Errorf("validation errors"), map[string]interface{}{
-			"ci_type": req.CIType,
-			"attributes": req.Attributes,
-			"validation_errors": validationErrors,
-		})
-		return nil, ServiceValidationError{
-			Message: "Attribute validation failed",
-			Errors:  validationErrors,
+	if err := s.checkNewCI(ctx, ciType, req); err != nil {
+		var validationErr ServiceValidationError
+		if errors.As(err, &validationErr) && validationErr.Message == "Attribute validation failed" {
+			s.logger.ErrorService("ci", "CREATE_CI_VALIDATION_DETAIL", fmt.Errorf("validation errors"), map[string]interface{}{
+				"ci_type": req.CIType,
+				"attributes": req.Attributes,
+				"validation_errors": validationErr.Errors,
+			})
 		}
+		return nil, err
 	}
 
 	// Check for duplicate name within type
@@ -69,28 +74,23 @@ func (s *Service) CreateCI(ctx context.Context, req *CreateCIRequest, userID uui
 		CreatedBy: userID,
 	}
 
-	result, err := s.repo.CreateCI(ctx, ci)
+	var result *ConfigurationItem
+	err = s.repo.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = s.repo.CreateCI(ctx, ci)
+		if err != nil {
+			return err
+		}
+
+		return s.recordNewCI(ctx, ciType, result, userID, nil)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Sync to Neo4j
-	if err := s.neo4j.SyncCI(ctx, result); err != nil {
-		s.logger.ErrorService("neo4j", "sync_ci", err, map[string]interface{}{
-			"ci_id": result.ID,
-		})
-		// Log error but don't fail the operation
-	}
-
 	// Invalidate cache
 	s.invalidateCICache(ctx, result.ID)
 
-	// Log audit event
-	s.logAuditEvent(ctx, "ci", result.ID.String(), "create", userID.String(), map[string]interface{}{
-		"ci_name": result.Name,
-		"ci_type": result.CIType,
-	})
-
 	s.logger.InfoService("ci", "create_ci", map[string]interface{}{
 		"ci_id":   result.ID,
 		"ci_name": result.Name,
@@ -100,6 +100,70 @@ func (s *Service) CreateCI(ctx context.Context, req *CreateCIRequest, userID uui
 	return result, nil
 }
 
+// recordNewCI claims the unique values of a CI just inserted, audits its
+// creation and queues it for Neo4j, in the transaction that inserted it
+func (s *Service) recordNewCI(ctx context.Context, ciType *CITypeDefinition, created *ConfigurationItem, userID uuid.UUID, auditDetails map[string]interface{}) error {
+	if err := s.repo.ClaimUniqueValues(ctx, created.ID, ciType.uniqueConstraints()); err != nil {
+		return err
+	}
+
+	details := map[string]interface{}{
+		"ci_name": created.Name,
+		"ci_type": created.CIType,
+	}
+	for key, value := range auditDetails {
+		details[key] = value
+	}
+	details, err := withChangeDetails(details, nil, created)
+	if err != nil {
+		return err
+	}
+
+	if err := s.logAuditEvent(ctx, "ci", created.ID, "create", userID, details); err != nil {
+		return err
+	}
+
+	return s.repo.EnqueueGraphSync(ctx, GraphSyncEntityCI, created.ID, GraphSyncOpUpsert)
+}
+
+// checkNewCI fills in defaults and computed attributes of a CI about to be
+// created as ciType and validates them
+func (s *Service) checkNewCI(ctx context.Context, ciType *CITypeDefinition, req *CreateCIRequest) error {
+	if ciType.Abstract {
+		return ServiceValidationError{
+			Message: "CI type validation failed",
+			Errors: []ValidationError{{
+				Field:   "ci_type",
+				Message: fmt.Sprintf("'%s' is abstract; create a CI of one of its subtypes", req.CIType),
+			}},
+		}
+	}
+
+	if req.Attributes == nil {
+		req.Attributes = map[string]interface{}{}
+	}
+
+	// Validate attributes against schema
+	ciType.ApplyDefaults(req.Attributes)
+	ciType.NormalizeAttributes(req.Attributes)
+	validationErrors := ciType.ValidateAttributes(req.Attributes)
+	if len(validationErrors) == 0 {
+		var err error
+		validationErrors, err = s.validateReferences(ctx, ciType, req.Attributes)
+		if err != nil {
+			return err
+		}
+	}
+	if len(validationErrors) > 0 {
+		return ServiceValidationError{
+			Message: "Attribute validation failed",
+			Errors:  validationErrors,
+		}
+	}
+
+	return nil
+}
+
 func (s *Service) GetCI(ctx context.Context, id uuid.UUID) (*ConfigurationItem, error) {
 	// Try cache first
 	if ci, err := s.getCIFromCache(ctx, id); err == nil {
@@ -118,219 +182,2814 @@ func (s *Service) GetCI(ctx context.Context, id uuid.UUID) (*ConfigurationItem,
 	return ci, nil
 }
 
+// ListCIs lists the CIs matching filters, with the facet counts they ask for
 func (s *Service) ListCIs(ctx context.Context, filters ListCIFilters, page, limit int) (*CIListResponse, error) {
-	return s.repo.ListCIs(ctx, filters, page, limit)
-}
+	if err := parseCIListQuery(&filters); err != nil {
+		return nil, err
+	}
 
-func (s *Service) UpdateCI(ctx context.Context, id uuid.UUID, req *UpdateCIRequest, userID uuid.UUID) (*ConfigurationItem, error) {
-	// Get current CI
-	current, err := s.repo.GetCI(ctx, id)
+	var facets []ciFacet
+	if filters.query != nil || len(filters.Facets) > 0 {
+		// The query and the facets are checked against the same CI types
+		allTypes, scope, err := s.ciListScope(ctx, filters)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkCIListQuery(filters, allTypes, scope); err != nil {
+			return nil, err
+		}
+		var errors []ValidationError
+		if facets, errors = planCIFacets(filters.Facets, scope); len(errors) > 0 {
+			return nil, ServiceValidationError{Message: "Facet validation failed", Errors: errors}
+		}
+	}
+
+	response, err := s.repo.ListCIs(ctx, filters, page, limit)
 	if err != nil {
 		return nil, err
 	}
+	if len(facets) > 0 {
+		limit := filters.FacetLimit
+		if limit <= 0 {
+			limit = DefaultFacetLimit
+		} else if limit > MaxFacetLimit {
+			limit = MaxFacetLimit
+		}
+		if response.Facets, err = s.repo.CountCIFacets(ctx, filters, facets, limit); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// prepareCIQuery parses the query filter and type-checks it against the CI
+// types the listing can match
+func (s *Service) prepareCIQuery(ctx context.Context, filters *ListCIFilters) error {
+	if err := parseCIListQuery(filters); err != nil || filters.query == nil {
+		return err
+	}
 
-	// Get CI type for validation
-	ciType, err := s.repo.GetCITypeByName(ctx, current.CIType)
+	allTypes, scope, err := s.ciListScope(ctx, *filters)
 	if err != nil {
-		return nil, fmt.Errorf("CI type '%s' does not exist", current.CIType)
+		return err
 	}
+	return checkCIListQuery(*filters, allTypes, scope)
+}
 
-	// Prepare updated attributes
-	updatedAttributes := current.Attributes
-	if req.Attributes != nil {
-		updatedAttributes = req.Attributes
+// parseCIListQuery parses the query filter, if there is one
+func parseCIListQuery(filters *ListCIFilters) error {
+	if strings.TrimSpace(filters.Query) == "" {
+		return nil
 	}
 
-	// Validate attributes against schema
-	validationErrors := ciType.ValidateAttributes(updatedAttributes)
-	if len(validationErrors) > 0 {
-		return nil, ServiceValidationError{
-			Message: "Attribute validation failed",
-			Errors:  validationErrors,
+	node, err := parseCIQuery(filters.Query)
+	if err != nil {
+		return ServiceValidationError{
+			Message: "Query validation failed",
+			Errors:  []ValidationError{{Field: "q", Message: err.Error()}},
 		}
 	}
+	filters.query = node
+	return nil
+}
 
-	// Update CI
-	result, err := s.repo.UpdateCI(ctx, id, req, userID)
+// checkCIListQuery type-checks a parsed query filter, if there is one, against
+// the listing's scope
+func checkCIListQuery(filters ListCIFilters, allTypes []string, scope []*CITypeDefinition) error {
+	if filters.query == nil {
+		return nil
+	}
+	if errors := checkCIQuery(filters.query, newCIQuerySchema(allTypes, scope)); len(errors) > 0 {
+		return ServiceValidationError{Message: "Query validation failed", Errors: errors}
+	}
+	return nil
+}
+
+// ciListScope returns the names of all CI types and the resolved CI types a
+// listing can match: those allowed by the type filter and by ci_type terms of
+// the query that every match must satisfy, or all types. The types are loaded
+// with one query and resolved in memory.
+func (s *Service) ciListScope(ctx context.Context, filters ListCIFilters) ([]string, []*CITypeDefinition, error) {
+	all, err := s.repo.ListAllCITypes(ctx)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	hierarchy := newCITypeHierarchy(all)
+	allTypes := make([]string, 0, len(all))
+	for _, ciType := range all {
+		allTypes = append(allTypes, ciType.Name)
 	}
 
-	// Sync to Neo4j
-	if err := s.neo4j.UpdateCI(ctx, result); err != nil {
-		s.logger.ErrorService("neo4j", "update_ci", err, map[string]interface{}{
-			"ci_id": result.ID,
-		})
-		// Log error but don't fail the operation
+	scope := allTypes
+	if filters.CIType != "" {
+		scope = []string{filters.CIType}
+		if filters.IncludeSubtypes {
+			scope = hierarchy.expand(scope)
+		}
+		scope = intersectStrings(scope, allTypes)
+	}
+	if filters.query != nil {
+		if queryTypes := ciQueryTypes(filters.query); queryTypes != nil {
+			scope = intersectStrings(scope, queryTypes)
+		}
 	}
 
-	// Invalidate cache
-	s.invalidateCICache(ctx, id)
+	ciTypes := make([]*CITypeDefinition, 0, len(scope))
+	for _, name := range scope {
+		ciTypes = append(ciTypes, hierarchy.resolve(name))
+	}
+	return allTypes, ciTypes, nil
+}
 
-	// Log audit event
-	s.logAuditEvent(ctx, "ci", id.String(), "update", userID.String(), map[string]interface{}{
-		"ci_name": result.Name,
-		"ci_type": result.CIType,
-		"changes": req,
-	})
+func (s *Service) UpdateCI(ctx context.Context, id uuid.UUID, req *UpdateCIRequest, userID uuid.UUID) (*ConfigurationItem, error) {
+	var result *ConfigurationItem
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := s.lockCIForChange(ctx, id); err != nil {
+			return err
+		}
 
-	s.logger.InfoService("ci", "update_ci", map[string]interface{}{
-		"ci_id":   id,
-		"user_id": userID,
+		var err error
+		result, err = s.updateCI(ctx, id, req, userID, nil)
+		return err
 	})
+	if err != nil {
+		return nil, err
+	}
 
+	s.invalidateCICache(ctx, id)
 	return result, nil
 }
 
-func (s *Service) DeleteCI(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
-	// Get CI for audit
-	ci, err := s.repo.GetCI(ctx, id)
+// lockCIForChange locks a CI for the rest of the transaction and checks it
+// against any If-Match precondition in ctx
+func (s *Service) lockCIForChange(ctx context.Context, id uuid.UUID) (*ConfigurationItem, error) {
+	if err := s.repo.LockCIs(ctx, id); err != nil {
+		return nil, err
+	}
+
+	current, err := s.repo.GetCI(ctx, id)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if err := checkIfMatch(ctx, current.Version); err != nil {
+		return nil, err
 	}
+	return current, nil
+}
 
-	// Check for relationships
-	relationships, err := s.neo4j.GetCIRelationships(ctx, id)
+// updateCI applies req to a CI; auditDetails are recorded alongside the diff
+func (s *Service) updateCI(ctx context.Context, id uuid.UUID, req *UpdateCIRequest, userID uuid.UUID, auditDetails map[string]interface{}) (*ConfigurationItem, error) {
+	// Get current CI
+	current, err := s.repo.GetCI(ctx, id)
 	if err != nil {
-		return fmt.Errorf("failed to check relationships: %w", err)
+		return nil, err
 	}
 
-	if len(relationships) > 0 {
-		return fmt.Errorf("cannot delete CI with existing relationships")
+	// Get CI type for validation
+	ciType, err := s.resolveCITypeByName(ctx, current.CIType)
+	if err != nil {
+		return nil, fmt.Errorf("CI type '%s' does not exist", current.CIType)
 	}
 
-	// Delete from database
-	if err := s.repo.DeleteCI(ctx, id); err != nil {
-		return err
+	update, err := s.checkCIUpdate(ctx, ciType, current, req)
+	if err != nil {
+		return nil, err
 	}
 
-	// Delete from Neo4j
-	if err := s.neo4j.DeleteCI(ctx, id); err != nil {
-		s.logger.ErrorService("neo4j", "delete_ci", err, map[string]interface{}{
-			"ci_id": id,
-		})
-		// Log error but don't fail the operation
+	// Update CI
+	var result *ConfigurationItem
+	err = s.repo.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = s.repo.UpdateCI(ctx, id, update, userID)
+		if err != nil {
+			return err
+		}
+
+		if update.Attributes != nil {
+			if err := s.repo.ClaimUniqueValues(ctx, id, ciType.uniqueConstraints()); err != nil {
+				return err
+			}
+		}
+
+		details := map[string]interface{}{
+			"ci_name": result.Name,
+			"ci_type": result.CIType,
+		}
+		for key, value := range auditDetails {
+			details[key] = value
+		}
+		details, err = withChangeDetails(details, current, result)
+		if err != nil {
+			return err
+		}
+
+		if err := s.logAuditEvent(ctx, "ci", id, "update", userID, details); err != nil {
+			return err
+		}
+
+		return s.repo.EnqueueGraphSync(ctx, GraphSyncEntityCI, id, GraphSyncOpUpsert)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Invalidate cache
 	s.invalidateCICache(ctx, id)
 
-	// Log audit event
-	s.logAuditEvent(ctx, "ci", id.String(), "delete", userID.String(), map[string]interface{}{
-		"ci_name": ci.Name,
-		"ci_type": ci.CIType,
-	})
-
-	s.logger.InfoService("ci", "delete_ci", map[string]interface{}{
+	s.logger.InfoService("ci", "update_ci", map[string]interface{}{
 		"ci_id":   id,
-		"ci_name": ci.Name,
 		"user_id": userID,
 	})
 
-	return nil
+	return result, nil
 }
 
-// CI Type Operations
-
-func (s *Service) CreateCIType(ctx context.Context, req *CreateCITypeRequest, userID uuid.UUID) (*CITypeDefinition, error) {
-	// Validate CI type name doesn't exist
-	existing, err := s.repo.GetCITypeByName(ctx, req.Name)
-	if err == nil && existing != nil {
-		return nil, fmt.Errorf("CI type '%s' already exists", req.Name)
+// checkCIUpdate works out and validates the attributes a CI of ciType would
+// have after req, and returns the update to write. Normalizing recomputes
+// computed attributes, so it works on a copy, which is only written back when
+// req changes attributes or the recomputation changed something.
+func (s *Service) checkCIUpdate(ctx context.Context, ciType *CITypeDefinition, current *ConfigurationItem, req *UpdateCIRequest) (*UpdateCIRequest, error) {
+	source := current.Attributes
+	if req.Attributes != nil {
+		source = req.Attributes
 	}
-
-	// Validate schema
-	if err := s.validateCITypeSchema(req); err != nil {
-		return nil, err
+	updatedAttributes := make(map[string]interface{}, len(source))
+	for key, value := range source {
+		updatedAttributes[key] = value
 	}
+	update := *req
+	update.Attributes = updatedAttributes
 
-	ciType := &CITypeDefinition{
-		Name:                req.Name,
-		Description:         req.Description,
-		RequiredAttributes: req.RequiredAttributes,
-		OptionalAttributes: req.OptionalAttributes,
-		CreatedBy:           userID,
+	// Validate attributes against schema
+	ciType.NormalizeAttributes(updatedAttributes)
+	validationErrors := ciType.ValidateAttributes(updatedAttributes)
+	if len(validationErrors) == 0 {
+		var err error
+		validationErrors, err = s.validateReferences(ctx, ciType, updatedAttributes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(validationErrors) > 0 {
+		return nil, ServiceValidationError{
+			Message: "Attribute validation failed",
+			Errors:  validationErrors,
+		}
 	}
 
-	result, err := s.repo.CreateCIType(ctx, ciType)
-	if err != nil {
-		return nil, err
+	if req.Attributes == nil && reflect.DeepEqual(updatedAttributes, current.Attributes) {
+		update.Attributes = nil
 	}
+	return &update, nil
+}
 
-	// Log audit event
-	s.logAuditEvent(ctx, "ci_type", result.ID.String(), "create", userID.String(), map[string]interface{}{
-		"ci_type_name": result.Name,
-	})
+// PatchCI applies a merge patch or JSON Patch, chosen by content type, to a
+// document holding the CI's attributes and tags. The CI stays locked from read
+// to write, so concurrent patches to different keys do not overwrite each
+// other, and the patched attributes are validated like any update.
+func (s *Service) PatchCI(ctx context.Context, id uuid.UUID, contentType string, patch []byte, userID uuid.UUID) (*ConfigurationItem, error) {
+	var result *ConfigurationItem
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		current, err := s.lockCIForChange(ctx, id)
+		if err != nil {
+			return err
+		}
 
-	s.logger.InfoService("ci_type", "create_ci_type", map[string]interface{}{
-		"ci_type_id":   result.ID,
-		"ci_type_name": result.Name,
-		"user_id":      userID,
+		doc := map[string]interface{}{
+			"attributes": current.Attributes,
+			"tags":       current.Tags,
+		}
+		if current.Attributes == nil {
+			doc["attributes"] = map[string]interface{}{}
+		}
+		if current.Tags == nil {
+			doc["tags"] = []string{}
+		}
+
+		patched, err := applyPatch(doc, contentType, patch)
+		if err != nil {
+			return err
+		}
+
+		req, err := ciPatchRequest(current, patched)
+		if err != nil {
+			return err
+		}
+		if req.Attributes == nil && req.Tags == nil {
+			result = current
+			return nil
+		}
+
+		result, err = s.updateCI(ctx, id, req, userID, map[string]interface{}{
+			"patch_format": contentType,
+		})
+		return err
 	})
+	if err != nil {
+		return nil, err
+	}
 
+	s.invalidateCICache(ctx, id)
 	return result, nil
 }
 
-func (s *Service) GetCIType(ctx context.Context, id uuid.UUID) (*CITypeDefinition, error) {
-	return s.repo.GetCIType(ctx, id)
-}
-
-func (s *Service) ListCITypes(ctx context.Context, page, limit int, search string) (*CITypeListResponse, error) {
-	return s.repo.ListCITypes(ctx, page, limit, search)
+// ciPatchRequest turns a patched CI document into an update of whatever changed
+func ciPatchRequest(current *ConfigurationItem, patched map[string]interface{}) (*UpdateCIRequest, error) {
+	req := &UpdateCIRequest{}
+	for key, value := range patched {
+		switch key {
+		case "attributes":
+			attributes, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, invalidPatch("attributes must be an object")
+			}
+			if !reflect.DeepEqual(attributes, current.Attributes) {
+				req.Attributes = attributes
+			}
+		case "tags":
+			tags, err := patchedTags(value)
+			if err != nil {
+				return nil, err
+			}
+			if !(len(tags) == 0 && len(current.Tags) == 0) && !reflect.DeepEqual(tags, current.Tags) {
+				req.Tags = tags
+			}
+		default:
+			return nil, invalidPatch("'%s' cannot be patched; only attributes and tags can", key)
+		}
+	}
+	if _, ok := patched["attributes"]; !ok && len(current.Attributes) > 0 {
+		req.Attributes = map[string]interface{}{}
+	}
+	if _, ok := patched["tags"]; !ok && len(current.Tags) > 0 {
+		req.Tags = []string{}
+	}
+	return req, nil
 }
 
-func (s *Service) UpdateCIType(ctx context.Context, id uuid.UUID, req *UpdateCITypeRequest, userID uuid.UUID) (*CITypeDefinition, error) {
-	// Validate schema if provided
-	if req.RequiredAttributes != nil || req.OptionalAttributes != nil {
-		if err := s.validateCITypeSchemaUpdate(req); err != nil {
-			return nil, err
+func patchedTags(value interface{}) ([]string, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, invalidPatch("tags must be an array of strings")
+	}
+	tags := make([]string, 0, len(items))
+	for _, item := range items {
+		tag, ok := item.(string)
+		if !ok {
+			return nil, invalidPatch("tags must be an array of strings")
 		}
+		tags = append(tags, tag)
 	}
+	return tags, nil
+}
 
-	result, err := s.repo.UpdateCIType(ctx, id, req)
+func (s *Service) DeleteCI(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
+	// Get CI for audit
+	ci, err := s.repo.GetCI(ctx, id)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Log audit event
-	s.logAuditEvent(ctx, "ci_type", id.String(), "update", userID.String(), map[string]interface{}{
-		"ci_type_name": result.Name,
-		"changes":      req,
-	})
+	// Delete from database, along with any CIs that cascade from it
+	deletion := &ciDeletion{scheduled: map[uuid.UUID]bool{id: true}}
+	err = s.repo.WithTx(ctx, func(ctx context.Context) error {
+		locked, err := s.lockCIForChange(ctx, id)
+		if err != nil {
+			return err
+		}
 
-	s.logger.InfoService("ci_type", "update_ci_type", map[string]interface{}{
-		"ci_type_id": id,
-		"user_id":     userID,
+		// Checked under the lock, which creating a relationship also takes,
+		// so the relationships cannot be removed unaudited by the FK cascade
+		if err := s.checkCIHasNoRelationships(ctx, id); err != nil {
+			return err
+		}
+		return s.deleteCI(ctx, locked, userID, deletion, nil)
 	})
-
-	return result, nil
-}
-
-func (s *Service) DeleteCIType(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
-	// Get CI type for audit
-	ciType, err := s.repo.GetCIType(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	if err := s.repo.DeleteCIType(ctx, id); err != nil {
-		return err
+	// Invalidate cache
+	for _, deletedID := range deletion.deleted {
+		s.invalidateCICache(ctx, deletedID)
 	}
 
-	// Log audit event
-	s.logAuditEvent(ctx, "ci_type", id.String(), "delete", userID.String(), map[string]interface{}{
+	s.logger.InfoService("ci", "delete_ci", map[string]interface{}{
+		"ci_id":    id,
+		"ci_name":  ci.Name,
+		"cascaded": len(deletion.deleted) - 1,
+		"user_id":  userID,
+	})
+
+	return nil
+}
+
+// ciDeletion tracks the CIs scheduled for and already removed by one DeleteCI call
+type ciDeletion struct {
+	scheduled map[uuid.UUID]bool
+	deleted   []uuid.UUID
+}
+
+// deleteCI deletes a CI inside the caller's transaction. CIs that reference it
+// with on_delete cascade are deleted first; any other reference from a CI
+// that is not itself being deleted aborts the whole deletion.
+func (s *Service) deleteCI(ctx context.Context, ci *ConfigurationItem, userID uuid.UUID, deletion *ciDeletion, cause *CIReference) error {
+	references, err := s.repo.ListReferencingCIs(ctx, ci.ID)
+	if err != nil {
+		return err
+	}
+
+	var blocking []CIReference
+	var cascade []CIReference
+	for _, ref := range references {
+		if deletion.scheduled[ref.CIID] {
+			continue
+		}
+		if ref.OnDelete == ReferenceOnDeleteCascade {
+			deletion.scheduled[ref.CIID] = true
+			cascade = append(cascade, ref)
+			continue
+		}
+		blocking = append(blocking, ref)
+	}
+	if len(blocking) > 0 {
+		return ReferencedCIError{References: blocking}
+	}
+
+	for i := range cascade {
+		if err := s.repo.LockCIs(ctx, cascade[i].CIID); err != nil {
+			return err
+		}
+		referencing, err := s.repo.GetCI(ctx, cascade[i].CIID)
+		if err != nil {
+			return err
+		}
+		if err := s.checkCIHasNoRelationships(ctx, referencing.ID); err != nil {
+			return err
+		}
+		if err := s.deleteCI(ctx, referencing, userID, deletion, &cascade[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := s.repo.DeleteCI(ctx, ci.ID); err != nil {
+		return err
+	}
+
+	auditDetails := map[string]interface{}{
+		"ci_name": ci.Name,
+		"ci_type": ci.CIType,
+	}
+	if cause != nil {
+		auditDetails["cascaded_from_attribute"] = cause.Attribute
+	}
+	details, err := withChangeDetails(auditDetails, ci, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := s.logAuditEvent(ctx, "ci", ci.ID, "delete", userID, details); err != nil {
+		return err
+	}
+
+	if err := s.repo.EnqueueGraphSync(ctx, GraphSyncEntityCI, ci.ID, GraphSyncOpDelete); err != nil {
+		return err
+	}
+
+	deletion.deleted = append(deletion.deleted, ci.ID)
+	return nil
+}
+
+// checkCIHasNoRelationships refuses to delete a CI with relationships, which
+// must be deleted first so that each is audited. PostgreSQL is asked, since
+// the graph may not have caught up yet.
+func (s *Service) checkCIHasNoRelationships(ctx context.Context, id uuid.UUID) error {
+	hasRelationships, err := s.repo.CIHasRelationships(ctx, id)
+	if err != nil {
+		return err
+	}
+	if hasRelationships {
+		return fmt.Errorf("cannot delete CI with existing relationships")
+	}
+	return nil
+}
+
+// validateReferences checks that every reference attribute points at an
+// existing CI of an allowed type. Malformed IDs are left to ValidateAttributes.
+func (s *Service) validateReferences(ctx context.Context, ciType *CITypeDefinition, attributes map[string]interface{}) ([]ValidationError, error) {
+	var errors []ValidationError
+
+	for _, attr := range ciType.referenceAttributes() {
+		value, ok := attributes[attr.Name].(string)
+		if !ok {
+			continue
+		}
+		targetID, err := uuid.Parse(value)
+		if err != nil {
+			continue
+		}
+
+		target, err := s.repo.GetCI(ctx, targetID)
+		if err != nil {
+			if err.Error() == "CI not found" {
+				errors = append(errors, ValidationError{
+					Field:   attr.Name,
+					Message: "referenced configuration item does not exist",
+				})
+				continue
+			}
+			return nil, err
+		}
+
+		if attr.Reference != nil && len(attr.Reference.CITypes) > 0 {
+			targetTypes, err := s.ciTypeLineageNames(ctx, target.CIType)
+			if err != nil {
+				return nil, err
+			}
+			if containsAnyString(attr.Reference.CITypes, targetTypes) {
+				continue
+			}
+			errors = append(errors, ValidationError{
+				Field:   attr.Name,
+				Message: fmt.Sprintf("referenced configuration item must be of type: %s", strings.Join(attr.Reference.CITypes, ", ")),
+			})
+		}
+	}
+
+	return errors, nil
+}
+
+// ExpandReferences returns the CI with the targets of its reference attributes
+// inlined. References to CIs that no longer exist expand to nil.
+func (s *Service) ExpandReferences(ctx context.Context, ci *ConfigurationItem) (*ExpandedCI, error) {
+	expanded := &ExpandedCI{
+		ConfigurationItem: ci,
+		References:        map[string]*ConfigurationItem{},
+	}
+
+	ciType, err := s.resolveCITypeByName(ctx, ci.CIType)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attr := range ciType.referenceAttributes() {
+		value, ok := ci.Attributes[attr.Name].(string)
+		if !ok {
+			continue
+		}
+		targetID, err := uuid.Parse(value)
+		if err != nil {
+			expanded.References[attr.Name] = nil
+			continue
+		}
+
+		target, err := s.GetCI(ctx, targetID)
+		if err != nil {
+			if err.Error() != "CI not found" {
+				return nil, err
+			}
+			target = nil
+		}
+		expanded.References[attr.Name] = target
+	}
+
+	return expanded, nil
+}
+
+// CI version history
+
+func (s *Service) GetCIHistory(ctx context.Context, id uuid.UUID, page, limit int) (*CIVersionListResponse, error) {
+	return s.repo.ListCIVersions(ctx, id, page, limit)
+}
+
+// GetCIAsOf returns the snapshot of a CI that was current at asOf
+func (s *Service) GetCIAsOf(ctx context.Context, id uuid.UUID, asOf time.Time) (*CIVersion, error) {
+	return s.repo.GetCIVersionAt(ctx, id, asOf)
+}
+
+func (s *Service) DiffCIVersions(ctx context.Context, id uuid.UUID, fromVersion, toVersion int) (*CIVersionDiff, error) {
+	from, err := s.repo.GetCIVersion(ctx, id, fromVersion)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.repo.GetCIVersion(ctx, id, toVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := DiffStates(from.content(), to.content())
+	if err != nil {
+		return nil, err
+	}
+	if changes == nil {
+		changes = []FieldChange{}
+	}
+
+	return &CIVersionDiff{
+		CIID:        id,
+		FromVersion: fromVersion,
+		ToVersion:   toVersion,
+		Changes:     changes,
+	}, nil
+}
+
+// RestoreCIVersion brings a CI's attributes and tags back to an earlier
+// version. The restore is an ordinary update: it is validated against the
+// current CI type schema, audited and recorded as a new version.
+func (s *Service) RestoreCIVersion(ctx context.Context, id uuid.UUID, version int, userID uuid.UUID) (*ConfigurationItem, error) {
+	var result *ConfigurationItem
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := s.lockCIForChange(ctx, id); err != nil {
+			return err
+		}
+
+		snapshot, err := s.repo.GetCIVersion(ctx, id, version)
+		if err != nil {
+			return err
+		}
+
+		tags := snapshot.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+
+		req := &UpdateCIRequest{
+			Attributes: snapshot.Attributes,
+			Tags:       tags,
+		}
+
+		result, err = s.updateCI(ctx, id, req, userID, map[string]interface{}{
+			"restored_from_version": version,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidateCICache(ctx, id)
+	return result, nil
+}
+
+// UpsertCI creates the CI named by key if it does not exist and updates it
+// otherwise, replacing attributes and tags like UpdateCI. The insert uses ON
+// CONFLICT on the name, so concurrent upserts of one CI never fail on the
+// unique constraint: whichever loses the race updates the winner's row. It
+// reports whether the CI was created.
+func (s *Service) UpsertCI(ctx context.Context, key CIUpsertKey, req *UpsertCIRequest, userID uuid.UUID) (*ConfigurationItem, bool, error) {
+	ciType, err := s.resolveCITypeByName(ctx, key.CIType)
+	if err != nil {
+		if err.Error() == "CI type not found" {
+			return nil, false, fmt.Errorf("CI type '%s' does not exist", key.CIType)
+		}
+		return nil, false, err
+	}
+
+	name, auditKey, err := checkUpsertKey(ciType, key, req)
+	if err != nil {
+		return nil, false, err
+	}
+	auditDetails := map[string]interface{}{"upsert_key": auditKey}
+
+	var result *ConfigurationItem
+	created := false
+	err = s.repo.WithTx(ctx, func(ctx context.Context) error {
+		current, err := s.getCIByUpsertKey(ctx, ciType, key)
+		if err != nil {
+			return err
+		}
+
+		if current == nil {
+			// A conditional request cannot match a CI that does not exist
+			if err := checkIfMatch(ctx, 0); err != nil {
+				return err
+			}
+
+			createReq := &CreateCIRequest{Name: name, CIType: ciType.Name, Attributes: req.Attributes, Tags: req.Tags}
+			if err := s.checkNewCI(ctx, ciType, createReq); err != nil {
+				return err
+			}
+
+			result, err = s.repo.CreateCIIfAbsent(ctx, &ConfigurationItem{
+				Name:       createReq.Name,
+				CIType:     createReq.CIType,
+				Attributes: createReq.Attributes,
+				Tags:       createReq.Tags,
+				CreatedBy:  userID,
+			})
+			if err != nil {
+				return err
+			}
+			if result != nil {
+				created = true
+				return s.recordNewCI(ctx, ciType, result, userID, auditDetails)
+			}
+
+			// Another request created the CI since it was looked up
+			if current, err = s.repo.GetCIByNameAndType(ctx, name, ciType.Name); err != nil {
+				return err
+			}
+			if current == nil {
+				return fmt.Errorf("CI with name '%s' already exists for type '%s'", name, ciType.Name)
+			}
+		}
+
+		if _, err := s.lockCIForChange(ctx, current.ID); err != nil {
+			return err
+		}
+		result, err = s.updateCI(ctx, current.ID, &UpdateCIRequest{Attributes: req.Attributes, Tags: req.Tags}, userID, auditDetails)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.invalidateCICache(ctx, result.ID)
+	return result, created, nil
+}
+
+// checkUpsertKey checks an upsert key against the CI type and request. A
+// unique attribute key is written into the attributes, converted to the
+// attribute's type, so the CI keeps the value it was found by. It returns the
+// name to create the CI under and the key recorded in the audit log.
+func checkUpsertKey(ciType *CITypeDefinition, key CIUpsertKey, req *UpsertCIRequest) (string, string, error) {
+	invalid := func(field, format string, args ...interface{}) error {
+		return ServiceValidationError{
+			Message: "Upsert key validation failed",
+			Errors:  []ValidationError{{Field: field, Message: fmt.Sprintf(format, args...)}},
+		}
+	}
+
+	if (key.Name == "") == (key.Attribute == "") {
+		return "", "", invalid("name", "give either name, or attribute and value")
+	}
+
+	if key.Name != "" {
+		if req.Name != "" && req.Name != key.Name {
+			return "", "", invalid("name", "name '%s' does not match the key '%s'", req.Name, key.Name)
+		}
+		return key.Name, "name", nil
+	}
+
+	attr := ciType.findAttribute(key.Attribute)
+	if attr == nil || attr.Unique == "" {
+		return "", "", invalid("attribute", "'%s' is not a unique attribute of CI type '%s'", key.Attribute, ciType.Name)
+	}
+	if key.Value == "" {
+		return "", "", invalid("value", "value is required")
+	}
+
+	value, err := convertAttributeValue(key.Value, attr.Type)
+	if err != nil {
+		return "", "", invalid("value", "%v", err)
+	}
+	if req.Attributes == nil {
+		req.Attributes = map[string]interface{}{}
+	}
+	if existing, ok := req.Attributes[key.Attribute]; ok && !reflect.DeepEqual(existing, value) {
+		return "", "", invalid("attributes."+key.Attribute, "value %v does not match the key '%s'", existing, key.Value)
+	}
+	req.Attributes[key.Attribute] = value
+
+	return req.Name, key.Attribute, nil
+}
+
+// getCIByUpsertKey returns the CI named by an upsert key, or nil. A CI holding
+// a globally unique value must also be of the key's type.
+func (s *Service) getCIByUpsertKey(ctx context.Context, ciType *CITypeDefinition, key CIUpsertKey) (*ConfigurationItem, error) {
+	if key.Name != "" {
+		return s.repo.GetCIByNameAndType(ctx, key.Name, ciType.Name)
+	}
+
+	for _, constraint := range ciType.uniqueConstraints() {
+		if constraint.Attribute != key.Attribute {
+			continue
+		}
+
+		value, err := convertAttributeValue(key.Value, ciType.findAttribute(key.Attribute).Type)
+		if err != nil {
+			return nil, err
+		}
+		claimed, err := claimedValue(value)
+		if err != nil {
+			return nil, err
+		}
+
+		current, err := s.repo.GetCIByUniqueValue(ctx, constraint, claimed)
+		if err != nil || current == nil {
+			return current, err
+		}
+		if current.CIType != ciType.Name {
+			return nil, UniqueViolationError{
+				Attribute: key.Attribute,
+				Value:     claimed,
+				Scope:     uniqueScopeName(constraint.Scope),
+				CIID:      current.ID,
+				CIName:    current.Name,
+				CIType:    current.CIType,
+			}
+		}
+		return current, nil
+	}
+
+	return nil, nil
+}
+
+// claimedValue renders an attribute value the way PostgreSQL's ->> operator
+// does, which is how unique values are claimed
+func claimedValue(value interface{}) (string, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// Bulk CI operations
+
+// bulkItem is one valid operation of a bulk request, planned against the CIs
+// as they were when the request arrived
+type bulkItem struct {
+	op      BulkCIOperation
+	action  string
+	ciType  *CITypeDefinition
+	current *ConfigurationItem
+	create  *ConfigurationItem
+	update  *UpdateCIRequest
+	result  *BulkCIResult
+}
+
+// BulkCIs applies many CI operations in one call. Every operation is checked
+// up front; the CIs are then created, updated and deleted a batch at a time,
+// with the CIs of a batch locked together and its new rows, versions, audit
+// events and graph sync entries each written with one statement. In atomic
+// mode nothing is written unless every operation succeeds.
+func (s *Service) BulkCIs(ctx context.Context, req *BulkCIRequest, userID uuid.UUID) (*BulkCIResponse, error) {
+	mode := req.Mode
+	if mode == "" {
+		mode = BulkModeAtomic
+	}
+
+	var requestErrors []ValidationError
+	if mode != BulkModeAtomic && mode != BulkModeBestEffort {
+		requestErrors = append(requestErrors, ValidationError{
+			Field:   "mode",
+			Message: fmt.Sprintf("mode must be %s or %s", BulkModeAtomic, BulkModeBestEffort),
+		})
+	}
+	if len(req.Operations) == 0 {
+		requestErrors = append(requestErrors, ValidationError{Field: "operations", Message: "at least one operation is required"})
+	}
+	if len(req.Operations) > MaxBulkOperations {
+		requestErrors = append(requestErrors, ValidationError{
+			Field:   "operations",
+			Message: fmt.Sprintf("at most %d operations are allowed per request", MaxBulkOperations),
+		})
+	}
+	if len(requestErrors) > 0 {
+		return nil, ServiceValidationError{Message: "Bulk request validation failed", Errors: requestErrors}
+	}
+
+	response := &BulkCIResponse{Mode: mode, Results: make([]BulkCIResult, len(req.Operations))}
+	items, err := s.planBulkCIs(ctx, req.Operations, response.Results, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode == BulkModeAtomic {
+		if err := s.applyBulkCIsAtomically(ctx, items, response.Results, userID); err != nil {
+			return nil, err
+		}
+	} else {
+		s.applyBulkCIs(ctx, items, userID)
+	}
+
+	for _, result := range response.Results {
+		switch result.Status {
+		case BulkStatusFailed:
+			response.Failed++
+		case BulkStatusSkipped:
+			response.Skipped++
+		default:
+			response.Succeeded++
+		}
+	}
+
+	s.logger.InfoService("ci", "bulk_cis", map[string]interface{}{
+		"mode":       mode,
+		"operations": len(req.Operations),
+		"succeeded":  response.Succeeded,
+		"failed":     response.Failed,
+		"user_id":    userID,
+	})
+
+	return response, nil
+}
+
+// planBulkCIs validates each operation against the current CIs, which it
+// loads with one query per way of naming them, and records failures in
+// results. It returns the operations that can go ahead.
+func (s *Service) planBulkCIs(ctx context.Context, ops []BulkCIOperation, results []BulkCIResult, userID uuid.UUID) ([]*bulkItem, error) {
+	var ids []uuid.UUID
+	var keys []ciKey
+	for i, op := range ops {
+		results[i] = BulkCIResult{Index: i, Op: op.Op}
+		if op.ID != nil {
+			ids = append(ids, *op.ID)
+		} else if op.Name != "" && op.CIType != "" {
+			keys = append(keys, ciKey{Name: op.Name, CIType: op.CIType})
+		}
+	}
+
+	byID := make(map[uuid.UUID]*ConfigurationItem)
+	byKey := make(map[ciKey]*ConfigurationItem)
+	if len(ids) > 0 {
+		found, err := s.repo.GetCIsByIDs(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for i := range found {
+			byID[found[i].ID] = &found[i]
+		}
+	}
+	found, err := s.repo.GetCIsByKeys(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	for i := range found {
+		byKey[ciKey{Name: found[i].Name, CIType: found[i].CIType}] = &found[i]
+	}
+
+	ciTypes := make(map[string]*CITypeDefinition)
+	resolve := func(name string) *CITypeDefinition {
+		if ciType, ok := ciTypes[name]; ok {
+			return ciType
+		}
+		ciType, err := s.resolveCITypeByName(ctx, name)
+		if err != nil {
+			ciType = nil
+		}
+		ciTypes[name] = ciType
+		return ciType
+	}
+
+	// Each CI may only be named once, as every operation is checked against
+	// the CI as it was before the request
+	seen := make(map[ciKey]int)
+
+	var items []*bulkItem
+	for i, op := range ops {
+		result := &results[i]
+		fail := func(err error) {
+			result.Status = BulkStatusFailed
+			result.Error, result.Errors = bulkFailure(err)
+		}
+
+		if errs := op.validate(); len(errs) > 0 {
+			fail(ServiceValidationError{Message: "Operation validation failed", Errors: errs})
+			continue
+		}
+
+		current := byKey[ciKey{Name: op.Name, CIType: op.CIType}]
+		if op.ID != nil {
+			current = byID[*op.ID]
+		}
+
+		key := ciKey{Name: op.Name, CIType: op.CIType}
+		if current != nil {
+			key = ciKey{Name: current.Name, CIType: current.CIType}
+			result.ID = &current.ID
+		}
+		if current != nil || op.ID == nil {
+			if earlier, ok := seen[key]; ok {
+				fail(fmt.Errorf("CI '%s' (%s) is already changed by operation %d", key.Name, key.CIType, earlier))
+				continue
+			}
+			seen[key] = i
+		}
+
+		item := &bulkItem{op: op, current: current, result: result}
+		switch {
+		case op.Op == BulkOpCreate && current != nil:
+			fail(fmt.Errorf("CI with name '%s' already exists for type '%s'", op.Name, op.CIType))
+			continue
+		case current == nil && (op.Op == BulkOpUpdate || op.Op == BulkOpDelete):
+			fail(fmt.Errorf("CI not found"))
+			continue
+		case op.Op == BulkOpDelete:
+			item.action = BulkOpDelete
+		case current == nil:
+			item.action = BulkOpCreate
+		default:
+			item.action = BulkOpUpdate
+		}
+
+		if item.action != BulkOpDelete {
+			typeName := key.CIType
+			item.ciType = resolve(typeName)
+			if item.ciType == nil {
+				fail(ServiceValidationError{
+					Message: "CI type validation failed",
+					Errors:  []ValidationError{{Field: "ci_type", Message: fmt.Sprintf("CI type '%s' does not exist", typeName)}},
+				})
+				continue
+			}
+		}
+
+		switch item.action {
+		case BulkOpCreate:
+			req := &CreateCIRequest{Name: op.Name, CIType: op.CIType, Attributes: op.Attributes, Tags: op.Tags}
+			if err := s.checkNewCI(ctx, item.ciType, req); err != nil {
+				fail(err)
+				continue
+			}
+			item.create = &ConfigurationItem{
+				Name:       req.Name,
+				CIType:     req.CIType,
+				Attributes: req.Attributes,
+				Tags:       req.Tags,
+				CreatedBy:  userID,
+			}
+		case BulkOpUpdate:
+			update, err := s.checkCIUpdate(ctx, item.ciType, current, &UpdateCIRequest{Attributes: op.Attributes, Tags: op.Tags})
+			if err != nil {
+				fail(err)
+				continue
+			}
+			item.update = update
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// applyBulkCIsAtomically applies every planned operation in one transaction.
+// If any operation failed, at planning or while being applied, the others are
+// reported as skipped and nothing is written.
+func (s *Service) applyBulkCIsAtomically(ctx context.Context, items []*bulkItem, results []BulkCIResult, userID uuid.UUID) error {
+	skipAll := func() {
+		for _, item := range items {
+			if item.result.Status != BulkStatusFailed {
+				item.result.Status = BulkStatusSkipped
+				item.result.CI = nil
+				if item.current == nil {
+					item.result.ID = nil
+				}
+			}
+		}
+	}
+
+	for _, result := range results {
+		if result.Status == BulkStatusFailed {
+			skipAll()
+			return nil
+		}
+	}
+
+	var failed *bulkItem
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		for start := 0; start < len(items); start += bulkBatchSize {
+			end := start + bulkBatchSize
+			if end > len(items) {
+				end = len(items)
+			}
+
+			var err error
+			if failed, err = s.applyBulkCIBatch(ctx, items[start:end], userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if failed == nil {
+			return err
+		}
+		failed.result.Status = BulkStatusFailed
+		failed.result.Error, failed.result.Errors = bulkFailure(err)
+		skipAll()
+		return nil
+	}
+
+	// Updates and deletes cleared the cache before the transaction committed
+	for _, item := range items {
+		if item.current != nil {
+			s.invalidateCICache(ctx, item.current.ID)
+		}
+	}
+
+	return nil
+}
+
+// applyBulkCIBatch applies a batch of planned operations, stopping at the
+// first that fails. It returns the failed operation, or nil when the error
+// cannot be pinned on one.
+func (s *Service) applyBulkCIBatch(ctx context.Context, items []*bulkItem, userID uuid.UUID) (*bulkItem, error) {
+	var creates []*bulkItem
+	for _, item := range items {
+		if item.action == BulkOpCreate {
+			creates = append(creates, item)
+		}
+	}
+	if failed, err := s.createBulkCIs(ctx, creates, userID); err != nil {
+		return failed, err
+	}
+
+	return s.changeBulkCIs(ctx, bulkChanges(items), userID)
+}
+
+// bulkChanges returns the planned updates and deletes among items
+func bulkChanges(items []*bulkItem) []*bulkItem {
+	var changes []*bulkItem
+	for _, item := range items {
+		if item.action == BulkOpUpdate || item.action == BulkOpDelete {
+			changes = append(changes, item)
+		}
+	}
+	return changes
+}
+
+// applyBulkCIs applies planned operations independently of each other, a
+// batch at a time. If a batch of creates or of changes fails, its operations
+// are retried one by one to find which of them are at fault.
+func (s *Service) applyBulkCIs(ctx context.Context, items []*bulkItem, userID uuid.UUID) {
+	fail := func(item *bulkItem, err error) {
+		item.result.Status = BulkStatusFailed
+		item.result.Error, item.result.Errors = bulkFailure(err)
+	}
+
+	for start := 0; start < len(items); start += bulkBatchSize {
+		end := start + bulkBatchSize
+		if end > len(items) {
+			end = len(items)
+		}
+
+		var creates []*bulkItem
+		for _, item := range items[start:end] {
+			if item.action == BulkOpCreate {
+				creates = append(creates, item)
+			}
+		}
+		if failed, err := s.createBulkCIs(ctx, creates, userID); err != nil {
+			if len(creates) == 1 {
+				fail(failed, err)
+			} else {
+				for _, item := range creates {
+					if _, err := s.createBulkCIs(ctx, []*bulkItem{item}, userID); err != nil {
+						fail(item, err)
+					}
+				}
+			}
+		}
+
+		changes := bulkChanges(items[start:end])
+		if failed, err := s.changeBulkCIs(ctx, changes, userID); err != nil {
+			if len(changes) == 1 {
+				fail(failed, err)
+			} else {
+				for _, item := range changes {
+					if _, err := s.changeBulkCIs(ctx, []*bulkItem{item}, userID); err != nil {
+						fail(item, err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// changeBulkCIs applies planned updates and deletes in one transaction. The
+// CIs are locked together and reread, so an update is only checked again if
+// its CI changed since planning. The CIs to delete are checked for
+// relationships with one query under the same lock, and updates are written,
+// audited and queued for Neo4j with one statement each. It returns the
+// operation at fault when that is known.
+func (s *Service) changeBulkCIs(ctx context.Context, items []*bulkItem, userID uuid.UUID) (*bulkItem, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(items))
+	var deleteIDs []uuid.UUID
+	for _, item := range items {
+		ids = append(ids, item.current.ID)
+		if item.action == BulkOpDelete {
+			deleteIDs = append(deleteIDs, item.current.ID)
+		}
+	}
+
+	updated := make(map[*bulkItem]*ConfigurationItem)
+	var auditLogs []*AuditLog
+	var deleted []uuid.UUID
+	var failed *bulkItem
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.LockCIs(ctx, ids...); err != nil {
+			return err
+		}
+		if len(deleteIDs) > 0 {
+			related, err := s.repo.ListCIsWithRelationships(ctx, deleteIDs)
+			if err != nil {
+				return err
+			}
+			for _, item := range items {
+				if item.action == BulkOpDelete && related[item.current.ID] {
+					failed = item
+					return fmt.Errorf("cannot delete CI with existing relationships")
+				}
+			}
+		}
+		locked, err := s.repo.GetCIsByIDs(ctx, ids)
+		if err != nil {
+			return err
+		}
+		byID := make(map[uuid.UUID]*ConfigurationItem, len(locked))
+		for i := range locked {
+			byID[locked[i].ID] = &locked[i]
+		}
+
+		var updates []*bulkItem
+		var writes []ciUpdate
+		claims := make(map[*bulkItem]bool)
+		for _, item := range items {
+			current := byID[item.current.ID]
+			if current == nil {
+				failed = item
+				return fmt.Errorf("CI not found")
+			}
+			if item.action != BulkOpUpdate {
+				continue
+			}
+
+			update := item.update
+			if current.Version != item.current.Version {
+				if update, err = s.checkCIUpdate(ctx, item.ciType, current, &UpdateCIRequest{Attributes: item.op.Attributes, Tags: item.op.Tags}); err != nil {
+					failed = item
+					return err
+				}
+			}
+			updates = append(updates, item)
+			if update.Attributes == nil && update.Tags == nil {
+				updated[item] = current
+				continue
+			}
+			claims[item] = update.Attributes != nil
+			writes = append(writes, ciUpdate{ID: current.ID, Attributes: update.Attributes, Tags: update.Tags})
+		}
+
+		written, err := s.repo.UpdateCIs(ctx, writes, userID)
+		if err != nil {
+			return err
+		}
+		writtenByID := make(map[uuid.UUID]*ConfigurationItem, len(written))
+		for i := range written {
+			writtenByID[written[i].ID] = &written[i]
+		}
+
+		meta := RequestMetadataFromContext(ctx)
+		auditIDs := make([]uuid.UUID, 0, len(updates))
+		for _, item := range updates {
+			result, ok := updated[item]
+			if !ok {
+				result = writtenByID[item.current.ID]
+				if result == nil {
+					failed = item
+					return fmt.Errorf("CI not found")
+				}
+				if claims[item] {
+					if err := s.repo.ClaimUniqueValues(ctx, result.ID, item.ciType.uniqueConstraints()); err != nil {
+						failed = item
+						return err
+					}
+				}
+				updated[item] = result
+			}
+
+			details, err := withChangeDetails(map[string]interface{}{
+				"ci_name": result.Name,
+				"ci_type": result.CIType,
+			}, byID[result.ID], result)
+			if err != nil {
+				return err
+			}
+			auditLogs = append(auditLogs, &AuditLog{
+				EntityType:  "ci",
+				EntityID:    &result.ID,
+				Action:      "update",
+				PerformedBy: userID,
+				Details:     details,
+				IPAddress:   meta.IPAddress,
+				UserAgent:   meta.UserAgent,
+			})
+			auditIDs = append(auditIDs, result.ID)
+		}
+
+		if len(auditLogs) > 0 {
+			if err := s.audit.CreateAuditLogs(ctx, auditLogs); err != nil {
+				return fmt.Errorf("failed to record audit events: %w", err)
+			}
+			if err := s.repo.EnqueueGraphSyncBatch(ctx, GraphSyncEntityCI, auditIDs, GraphSyncOpUpsert); err != nil {
+				return err
+			}
+		}
+
+		for _, item := range items {
+			if item.action != BulkOpDelete {
+				continue
+			}
+			deletion := &ciDeletion{scheduled: map[uuid.UUID]bool{item.current.ID: true}}
+			if err := s.deleteCI(ctx, byID[item.current.ID], userID, deletion, nil); err != nil {
+				failed = item
+				return err
+			}
+			deleted = append(deleted, deletion.deleted...)
+		}
+
+		return nil
+	})
+	if err != nil {
+		if failed == nil && len(items) == 1 {
+			failed = items[0]
+		}
+		return failed, err
+	}
+
+	for _, item := range items {
+		s.invalidateCICache(ctx, item.current.ID)
+		if item.action == BulkOpDelete {
+			item.result.Status = BulkStatusDeleted
+			continue
+		}
+		item.result.Status = BulkStatusUpdated
+		item.result.CI = updated[item]
+	}
+	for _, id := range deleted {
+		s.invalidateCICache(ctx, id)
+	}
+	for _, auditLog := range auditLogs {
+		s.logger.InfoAudit("ci", auditLog.EntityID.String(), "update", userID.String(), auditLog.Details)
+	}
+
+	s.logger.InfoService("ci", "change_cis", map[string]interface{}{
+		"updated": len(auditLogs),
+		"deleted": len(deleted),
+		"user_id": userID,
+	})
+
+	return nil, nil
+}
+
+// createBulkCIs creates the CIs of planned create operations in one
+// transaction, with one statement each for the CIs, their versions, their
+// audit events and their graph sync entries. Only unique attribute values are
+// claimed CI by CI. It returns the operation at fault when that is known.
+func (s *Service) createBulkCIs(ctx context.Context, items []*bulkItem, userID uuid.UUID) (*bulkItem, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	cis := make([]ConfigurationItem, len(items))
+	for i, item := range items {
+		cis[i] = *item.create
+	}
+
+	var created []ConfigurationItem
+	var auditLogs []*AuditLog
+	var failed *bulkItem
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		created, err = s.repo.CreateCIs(ctx, cis)
+		if err != nil {
+			return err
+		}
+
+		meta := RequestMetadataFromContext(ctx)
+		auditLogs = make([]*AuditLog, 0, len(created))
+		ids := make([]uuid.UUID, 0, len(created))
+		for i := range created {
+			result := &created[i]
+
+			if constraints := items[i].ciType.uniqueConstraints(); len(constraints) > 0 {
+				if err := s.repo.ClaimUniqueValues(ctx, result.ID, constraints); err != nil {
+					failed = items[i]
+					return err
+				}
+			}
+
+			details, err := withChangeDetails(map[string]interface{}{
+				"ci_name": result.Name,
+				"ci_type": result.CIType,
+			}, nil, result)
+			if err != nil {
+				return err
+			}
+
+			auditLogs = append(auditLogs, &AuditLog{
+				EntityType:  "ci",
+				EntityID:    &result.ID,
+				Action:      "create",
+				PerformedBy: userID,
+				Details:     details,
+				IPAddress:   meta.IPAddress,
+				UserAgent:   meta.UserAgent,
+			})
+			ids = append(ids, result.ID)
+		}
+
+		if err := s.audit.CreateAuditLogs(ctx, auditLogs); err != nil {
+			return fmt.Errorf("failed to record audit events: %w", err)
+		}
+
+		return s.repo.EnqueueGraphSyncBatch(ctx, GraphSyncEntityCI, ids, GraphSyncOpUpsert)
+	})
+	if err != nil {
+		if failed == nil && len(items) == 1 {
+			failed = items[0]
+		}
+		return failed, err
+	}
+
+	for i, item := range items {
+		item.result.Status = BulkStatusCreated
+		item.result.ID = &created[i].ID
+		item.result.CI = &created[i]
+		s.logger.InfoAudit("ci", created[i].ID.String(), "create", userID.String(), auditLogs[i].Details)
+	}
+
+	s.logger.InfoService("ci", "create_cis", map[string]interface{}{
+		"count":   len(created),
+		"user_id": userID,
+	})
+
+	return nil, nil
+}
+
+// CSV import
+
+// StartCIImport checks an uploaded CSV file and its column mapping against the
+// CI type and queues a job that imports the rows in the background
+func (s *Service) StartCIImport(ctx context.Context, req *CIImportRequest, userID uuid.UUID) (*CIImportJob, error) {
+	invalid := func(errors ...ValidationError) error {
+		return ServiceValidationError{Message: "CSV import validation failed", Errors: errors}
+	}
+
+	if req.Mode == "" {
+		req.Mode = CIImportModeCreate
+	}
+	if req.Mode != CIImportModeCreate && req.Mode != CIImportModeUpsert {
+		return nil, invalid(ValidationError{Field: "mode", Message: fmt.Sprintf("unknown mode '%s'; must be create or upsert", req.Mode)})
+	}
+	if len(req.Content) == 0 {
+		return nil, invalid(ValidationError{Field: "file", Message: "file is required"})
+	}
+
+	ciType, err := s.resolveCITypeByName(ctx, req.CIType)
+	if err != nil {
+		if err.Error() == "CI type not found" {
+			return nil, invalid(ValidationError{Field: "ci_type", Message: fmt.Sprintf("CI type '%s' does not exist", req.CIType)})
+		}
+		return nil, err
+	}
+	if ciType.Abstract {
+		return nil, invalid(ValidationError{Field: "ci_type", Message: fmt.Sprintf("'%s' is abstract; import CIs of one of its subtypes", req.CIType)})
+	}
+
+	file, validationErrors := parseCIImportFile(req.Content, req.Mapping, ciType)
+	if len(validationErrors) > 0 {
+		return nil, invalid(validationErrors...)
+	}
+
+	job := &CIImportJob{
+		CIType:    ciType.Name,
+		Mode:      req.Mode,
+		DryRun:    req.DryRun,
+		Mapping:   file.mapping(),
+		TotalRows: len(file.Records),
+		CreatedBy: userID,
+	}
+	if req.FileName != "" {
+		job.FileName = &req.FileName
+	}
+
+	job, err = s.repo.CreateCIImportJob(ctx, job, req.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoService("ci", "start_ci_import", map[string]interface{}{
+		"job_id":     job.ID,
+		"ci_type":    job.CIType,
+		"mode":       job.Mode,
+		"dry_run":    job.DryRun,
+		"total_rows": job.TotalRows,
+		"user_id":    userID,
+	})
+
+	return job, nil
+}
+
+func (s *Service) GetCIImport(ctx context.Context, id uuid.UUID) (*CIImportJob, error) {
+	return s.repo.GetCIImportJob(ctx, id)
+}
+
+func (s *Service) ListCIImports(ctx context.Context, limit int) ([]CIImportJob, error) {
+	return s.repo.ListCIImportJobs(ctx, limit)
+}
+
+// GetCIImportErrors returns the error report of an import
+func (s *Service) GetCIImportErrors(ctx context.Context, id uuid.UUID) ([]CIImportError, error) {
+	if _, err := s.repo.GetCIImportJob(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.repo.ListCIImportErrors(ctx, id)
+}
+
+// importCIRow applies the record at index i of an import file. The job's
+// progress is saved in the transaction that applies the row, so a resumed job
+// neither skips nor repeats a row. Rows that fail are recorded in the error
+// report; only errors that are not the row's fault are returned. dryRun is
+// set for a dry run.
+func (s *Service) importCIRow(ctx context.Context, ciType *CITypeDefinition, job *CIImportJob, file *ciImportFile, i int, dryRun *ciImportDryRun) error {
+	row := file.row(i, ciType)
+	progress := *job
+	progress.ProcessedRows++
+
+	if len(row.Errors) == 0 {
+		var id uuid.UUID
+		err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+			var created bool
+			var err error
+			id, created, err = s.applyCIImportRow(ctx, ciType, job, row, dryRun)
+			if err != nil {
+				return err
+			}
+
+			if created {
+				progress.CreatedRows++
+			} else {
+				progress.UpdatedRows++
+			}
+			return s.repo.UpdateCIImportProgress(ctx, &progress)
+		})
+
+		var validationErr ServiceValidationError
+		var uniqueErr UniqueViolationError
+		switch {
+		case err == nil:
+			if id != uuid.Nil {
+				s.invalidateCICache(ctx, id)
+			}
+			*job = progress
+			return nil
+
+		case errors.As(err, &validationErr):
+			row.Errors = file.rowErrors(row.Number, validationErr.Errors)
+
+		case errors.As(err, &uniqueErr):
+			row.Errors = file.rowErrors(row.Number, []ValidationError{{Field: uniqueErr.Attribute, Message: uniqueErr.Error()}})
+
+		default:
+			return err
+		}
+	}
+
+	progress.FailedRows++
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.AddCIImportErrors(ctx, job.ID, row.Errors); err != nil {
+			return err
+		}
+		return s.repo.UpdateCIImportProgress(ctx, &progress)
+	})
+	if err != nil {
+		return err
+	}
+	*job = progress
+	return nil
+}
+
+// applyCIImportRow creates the CI a row describes or, in upsert mode, updates
+// it. An update only replaces the attributes that have a value in the row,
+// and the tags if the row has any. It reports the CI and whether it was
+// created. A dry run validates the row the same way, including against the
+// CIs and unique values of earlier rows, but writes nothing and reports no CI.
+func (s *Service) applyCIImportRow(ctx context.Context, ciType *CITypeDefinition, job *CIImportJob, row ciImportRow, dryRun *ciImportDryRun) (uuid.UUID, bool, error) {
+	details := map[string]interface{}{"ci_import_job": job.ID, "ci_import_row": row.Number}
+
+	var current *ConfigurationItem
+	if dryRun != nil {
+		current = dryRun.cis[row.Name]
+	}
+	if current == nil {
+		var err error
+		if current, err = s.repo.GetCIByNameAndType(ctx, row.Name, ciType.Name); err != nil {
+			return uuid.Nil, false, err
+		}
+	}
+
+	if current == nil {
+		req := &CreateCIRequest{Name: row.Name, CIType: ciType.Name, Attributes: row.Attributes, Tags: row.Tags}
+		if err := s.checkNewCI(ctx, ciType, req); err != nil {
+			return uuid.Nil, true, err
+		}
+		if dryRun != nil {
+			return uuid.Nil, true, s.recordCIImportDryRun(ctx, ciType, dryRun, &ConfigurationItem{
+				Name:       req.Name,
+				CIType:     req.CIType,
+				Attributes: req.Attributes,
+				Tags:       req.Tags,
+			})
+		}
+
+		created, err := s.repo.CreateCIIfAbsent(ctx, &ConfigurationItem{
+			Name:       req.Name,
+			CIType:     req.CIType,
+			Attributes: req.Attributes,
+			Tags:       req.Tags,
+			CreatedBy:  job.CreatedBy,
+		})
+		if err != nil {
+			return uuid.Nil, false, err
+		}
+		if created != nil {
+			return created.ID, true, s.recordNewCI(ctx, ciType, created, job.CreatedBy, details)
+		}
+
+		// Another request created the CI since it was looked up
+		if current, err = s.repo.GetCIByNameAndType(ctx, row.Name, ciType.Name); err != nil || current == nil {
+			return uuid.Nil, false, fmt.Errorf("CI with name '%s' already exists for type '%s'", row.Name, ciType.Name)
+		}
+	}
+
+	if job.Mode != CIImportModeUpsert {
+		return uuid.Nil, false, ServiceValidationError{
+			Message: "CI already exists",
+			Errors:  []ValidationError{{Field: "name", Message: fmt.Sprintf("CI '%s' already exists", row.Name)}},
+		}
+	}
+
+	if dryRun == nil {
+		if err := s.repo.LockCIs(ctx, current.ID); err != nil {
+			return uuid.Nil, false, err
+		}
+		var err error
+		if current, err = s.repo.GetCI(ctx, current.ID); err != nil {
+			return uuid.Nil, false, err
+		}
+	}
+
+	attributes := make(map[string]interface{}, len(current.Attributes)+len(row.Attributes))
+	for key, value := range current.Attributes {
+		attributes[key] = value
+	}
+	for key, value := range row.Attributes {
+		attributes[key] = value
+	}
+	req := &UpdateCIRequest{Attributes: attributes, Tags: row.Tags}
+
+	if dryRun != nil {
+		update, err := s.checkCIUpdate(ctx, ciType, current, req)
+		if err != nil {
+			return uuid.Nil, false, err
+		}
+		updated := *current
+		if update.Attributes != nil {
+			updated.Attributes = update.Attributes
+		}
+		if update.Tags != nil {
+			updated.Tags = update.Tags
+		}
+		return uuid.Nil, false, s.recordCIImportDryRun(ctx, ciType, dryRun, &updated)
+	}
+
+	_, err := s.updateCI(ctx, current.ID, req, job.CreatedBy, details)
+	return current.ID, false, err
+}
+
+// recordCIImportDryRun checks that a dry run could leave ci as it is without
+// taking a unique value another CI holds, in the database or after an earlier
+// row, and records it
+func (s *Service) recordCIImportDryRun(ctx context.Context, ciType *CITypeDefinition, dryRun *ciImportDryRun, ci *ConfigurationItem) error {
+	constraints := ciType.uniqueConstraints()
+	for _, constraint := range constraints {
+		value := ci.Attributes[constraint.Attribute]
+		if value == nil {
+			continue
+		}
+		claimed, err := claimedValue(value)
+		if err != nil {
+			return err
+		}
+
+		holder, err := dryRun.holder(constraint, claimed)
+		if err != nil {
+			return err
+		}
+		if holder == nil {
+			if holder, err = s.repo.GetCIByUniqueValue(ctx, constraint, claimed); err != nil {
+				return err
+			}
+			// An earlier row may have changed the holder's value
+			if holder != nil && holder.CIType == ciType.Name && dryRun.cis[holder.Name] != nil {
+				holder = nil
+			}
+		}
+
+		if holder != nil && (holder.Name != ci.Name || holder.CIType != ci.CIType) {
+			return UniqueViolationError{
+				Attribute: constraint.Attribute,
+				Value:     claimed,
+				Scope:     uniqueScopeName(constraint.Scope),
+				CIID:      holder.ID,
+				CIName:    holder.Name,
+				CIType:    holder.CIType,
+			}
+		}
+	}
+
+	return dryRun.record(ci, constraints)
+}
+
+// Export
+
+// ExportCIs writes every CI matching filters to out in the given format. CSV
+// has a column per attribute of the filtered CI type, its subtypes when they
+// are included, or every type when there is no type filter. Nothing is
+// written when the format or CI type is unknown.
+func (s *Service) ExportCIs(ctx context.Context, filters ListCIFilters, format string, out io.Writer) error {
+	if !ValidExportFormat(format) {
+		return invalidExportFormat(format)
+	}
+	if err := s.prepareCIQuery(ctx, &filters); err != nil {
+		return err
+	}
+
+	var attributes, header []string
+	if format == ExportFormatCSV {
+		var err error
+		if attributes, err = s.ciExportAttributes(ctx, filters); err != nil {
+			return err
+		}
+		header = append(append(append(header, ciExportColumns...), attributes...), ciExportMetaColumns...)
+	}
+
+	w, err := newExportWriter(format, out, header)
+	if err != nil {
+		return err
+	}
+	err = s.repo.StreamCIs(ctx, filters, func(item *ConfigurationItem) error {
+		return w.write(item, func() []string { return ciExportRow(item, attributes) })
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.close(); err != nil {
+		return err
+	}
+
+	s.logger.InfoService("ci", "export_cis", map[string]interface{}{
+		"format":  format,
+		"count":   w.records,
+		"filters": filters,
+	})
+	return nil
+}
+
+// ciExportAttributes returns the attribute columns of a CSV export of CIs
+func (s *Service) ciExportAttributes(ctx context.Context, filters ListCIFilters) ([]string, error) {
+	var names []string
+	var err error
+	switch {
+	case filters.CIType == "":
+		names, err = s.repo.ListCITypeNames(ctx)
+	case filters.IncludeSubtypes:
+		names, err = s.repo.ExpandCITypes(ctx, []string{filters.CIType})
+	default:
+		names = []string{filters.CIType}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var schemas [][]AttributeDefinition
+	for _, name := range names {
+		ciType, err := s.resolveCITypeByName(ctx, name)
+		if err != nil {
+			if err.Error() == "CI type not found" {
+				return nil, ServiceValidationError{
+					Message: "Export validation failed",
+					Errors:  []ValidationError{{Field: "ci_type", Message: fmt.Sprintf("CI type '%s' does not exist", name)}},
+				}
+			}
+			return nil, err
+		}
+		schemas = append(schemas, ciType.RequiredAttributes, ciType.OptionalAttributes)
+	}
+	return exportAttributeNames(schemas...), nil
+}
+
+// ExportRelationships writes every relationship matching filters to out in
+// the given format. CSV has a column per attribute of the filtered
+// relationship type, or of every relationship type when there is no filter.
+func (s *Service) ExportRelationships(ctx context.Context, filters ListRelationshipFilters, format string, out io.Writer) error {
+	if !ValidExportFormat(format) {
+		return invalidExportFormat(format)
+	}
+
+	var attributes, header []string
+	if format == ExportFormatCSV {
+		schemas, err := s.repo.ListRelationshipTypeSchemas(ctx, filters.RelationshipType)
+		if err != nil {
+			return err
+		}
+		attributes = exportAttributeNames(schemas...)
+		header = append(append(append(header, relationshipExportColumns...), attributes...), relationshipExportMeta...)
+	}
+
+	w, err := newExportWriter(format, out, header)
+	if err != nil {
+		return err
+	}
+	err = s.repo.StreamRelationships(ctx, filters, func(rel *Relationship) error {
+		return w.write(rel, func() []string { return relationshipExportRow(rel, attributes) })
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.close(); err != nil {
+		return err
+	}
+
+	s.logger.InfoService("relationship", "export_relationships", map[string]interface{}{
+		"format":  format,
+		"count":   w.records,
+		"filters": filters,
+	})
+	return nil
+}
+
+func invalidExportFormat(format string) error {
+	return ServiceValidationError{
+		Message: "Export validation failed",
+		Errors:  []ValidationError{{Field: "format", Message: fmt.Sprintf("unknown format '%s'; must be csv, json or ndjson", format)}},
+	}
+}
+
+// CI Type Operations
+
+func (s *Service) CreateCIType(ctx context.Context, req *CreateCITypeRequest, userID uuid.UUID) (*CITypeDefinition, error) {
+	// Validate CI type name doesn't exist
+	existing, err := s.repo.GetCITypeByName(ctx, req.Name)
+	if err == nil && existing != nil {
+		return nil, fmt.Errorf("CI type '%s' already exists", req.Name)
+	}
+
+	// Validate schema
+	if err := s.validateCITypeSchema(req); err != nil {
+		return nil, err
+	}
+
+	if req.Parent != nil && *req.Parent == "" {
+		req.Parent = nil
+	}
+
+	ciType := &CITypeDefinition{
+		Name:                req.Name,
+		Description:         req.Description,
+		RequiredAttributes: req.RequiredAttributes,
+		OptionalAttributes: req.OptionalAttributes,
+		Parent:              req.Parent,
+		Abstract:            req.Abstract,
+		CreatedBy:           userID,
+	}
+
+	if _, err := s.planCITypeHierarchy(ctx, ciType); err != nil {
+		return nil, err
+	}
+
+	var result *CITypeDefinition
+	err = s.repo.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = s.repo.CreateCIType(ctx, ciType)
+		if err != nil {
+			return err
+		}
+
+		details, err := withChangeDetails(map[string]interface{}{
+			"ci_type_name": result.Name,
+		}, nil, result)
+		if err != nil {
+			return err
+		}
+
+		return s.logAuditEvent(ctx, "ci_type", result.ID, "create", userID, details)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoService("ci_type", "create_ci_type", map[string]interface{}{
+		"ci_type_id":   result.ID,
+		"ci_type_name": result.Name,
+		"user_id":      userID,
+	})
+
+	return result, nil
+}
+
+func (s *Service) GetCIType(ctx context.Context, id uuid.UUID) (*CITypeDefinition, error) {
+	return s.repo.GetCIType(ctx, id)
+}
+
+func (s *Service) ListCITypes(ctx context.Context, page, limit int, search string) (*CITypeListResponse, error) {
+	return s.repo.ListCITypes(ctx, page, limit, search)
+}
+
+// UpdateCIType changes a CI type. Schema and parent changes are checked
+// against the type's ancestors and every subtype; a type that still has CIs
+// of its own cannot become abstract.
+func (s *Service) UpdateCIType(ctx context.Context, id uuid.UUID, req *UpdateCITypeRequest, userID uuid.UUID) (*CITypeDefinition, error) {
+	schemaChanged := req.RequiredAttributes != nil || req.OptionalAttributes != nil || req.Parent != nil
+
+	// Validate schema if provided
+	if req.RequiredAttributes != nil || req.OptionalAttributes != nil {
+		if err := s.validateCITypeSchemaUpdate(req); err != nil {
+			return nil, err
+		}
+	}
+
+	var result *CITypeDefinition
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		if schemaChanged {
+			if err := s.checkNoActiveSchemaMigration(ctx, id); err != nil {
+				return err
+			}
+		}
+
+		current, err := s.lockCITypeForChange(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		var uniqueChanges map[string][]uniqueConstraint
+		if schemaChanged {
+			schemas, err := s.planCITypeHierarchy(ctx, current.withSchemaUpdate(req))
+			if err != nil {
+				return err
+			}
+			if uniqueChanges, err = s.uniqueConstraintChanges(ctx, schemas); err != nil {
+				return err
+			}
+		}
+
+		if req.Abstract != nil && *req.Abstract && !current.Abstract {
+			count, err := s.repo.CountCIsOfType(ctx, []string{current.Name})
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return ServiceValidationError{
+					Message: "CI type schema validation failed",
+					Errors: []ValidationError{{
+						Field:   "abstract",
+						Message: fmt.Sprintf("'%s' still has %d CIs of its own", current.Name, count),
+					}},
+				}
+			}
+		}
+
+		result, err = s.repo.UpdateCIType(ctx, id, req, userID)
+		if err != nil {
+			return err
+		}
+
+		if err := s.rebuildUniqueValues(ctx, uniqueChanges); err != nil {
+			return err
+		}
+
+		details, err := withChangeDetails(map[string]interface{}{
+			"ci_type_name": result.Name,
+		}, current, result)
+		if err != nil {
+			return err
+		}
+
+		return s.logAuditEvent(ctx, "ci_type", id, "update", userID, details)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoService("ci_type", "update_ci_type", map[string]interface{}{
+		"ci_type_id": id,
+		"user_id":     userID,
+	})
+
+	return result, nil
+}
+
+func (s *Service) DeleteCIType(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
+	// Get CI type for audit
+	ciType, err := s.repo.GetCIType(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.WithTx(ctx, func(ctx context.Context) error {
+		if ciType, err = s.lockCITypeForChange(ctx, id); err != nil {
+			return err
+		}
+
+		if err := s.repo.DeleteCIType(ctx, id); err != nil {
+			return err
+		}
+
+		details, err := withChangeDetails(map[string]interface{}{
+			"ci_type_name": ciType.Name,
+		}, ciType, nil)
+		if err != nil {
+			return err
+		}
+
+		return s.logAuditEvent(ctx, "ci_type", id, "delete", userID, details)
+	})
+	if err != nil {
+		return err
+	}
+
+	ciTypePatterns.forget(id)
+
+	s.logger.InfoService("ci_type", "delete_ci_type", map[string]interface{}{
+		"ci_type_id":   id,
 		"ci_type_name": ciType.Name,
+		"user_id":      userID,
+	})
+
+	return nil
+}
+
+// lockCITypeForChange locks a CI type for the rest of the transaction and
+// checks it against any If-Match precondition in ctx
+func (s *Service) lockCITypeForChange(ctx context.Context, id uuid.UUID) (*CITypeDefinition, error) {
+	if err := s.repo.LockCIType(ctx, id); err != nil {
+		return nil, err
+	}
+
+	current, err := s.repo.GetCIType(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkIfMatch(ctx, current.Version); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// CI type schema evolution
+
+func (s *Service) ListCITypeSchemaVersions(ctx context.Context, id uuid.UUID, page, limit int) (*CITypeSchemaVersionListResponse, error) {
+	if _, err := s.repo.GetCIType(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.repo.ListCITypeSchemaVersions(ctx, id, page, limit)
+}
+
+func (s *Service) GetCITypeSchemaVersion(ctx context.Context, id uuid.UUID, version int) (*CITypeSchemaVersion, error) {
+	return s.repo.GetCITypeSchemaVersion(ctx, id, version)
+}
+
+// DryRunCITypeUpdate reports which existing CIs would fail validation if the
+// schema in req were applied. Nothing is changed.
+func (s *Service) DryRunCITypeUpdate(ctx context.Context, id uuid.UUID, req *UpdateCITypeRequest) (*SchemaDryRunReport, error) {
+	if err := s.validateCITypeSchemaUpdate(req); err != nil {
+		return nil, err
+	}
+
+	current, err := s.repo.GetCIType(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	schemas, err := s.planCITypeHierarchy(ctx, current.withSchemaUpdate(req))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.checkCIsAgainstSchema(ctx, current, schemas, nil)
+}
+
+// DryRunCITypeMigration reports which CIs a migration would rewrite and which
+// would still fail validation afterwards. Nothing is changed.
+func (s *Service) DryRunCITypeMigration(ctx context.Context, id uuid.UUID, operations []AttributeMigration) (*SchemaDryRunReport, error) {
+	current, err := s.repo.GetCIType(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	migrated, err := planCITypeMigration(current, operations)
+	if err != nil {
+		return nil, err
+	}
+
+	schemas, err := s.planCITypeHierarchy(ctx, migrated)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.checkCIsAgainstSchema(ctx, current, schemas, operations)
+}
+
+// StartCITypeMigration applies a migration to the CI type's schema as a new
+// schema version and queues a job that migrates the existing CIs of the type
+// and its subtypes. Only one migration per CI type may be pending or running
+// at a time.
+func (s *Service) StartCITypeMigration(ctx context.Context, id uuid.UUID, operations []AttributeMigration, userID uuid.UUID) (*SchemaMigrationJob, error) {
+	var job *SchemaMigrationJob
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		if err := s.checkNoActiveSchemaMigration(ctx, id); err != nil {
+			return err
+		}
+
+		current, err := s.repo.GetCIType(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		migrated, err := planCITypeMigration(current, operations)
+		if err != nil {
+			return err
+		}
+
+		schemas, err := s.planCITypeHierarchy(ctx, migrated)
+		if err != nil {
+			return err
+		}
+
+		total, err := s.repo.CountCIsOfType(ctx, ciTypeNames(schemas))
+		if err != nil {
+			return err
+		}
+
+		uniqueChanges, err := s.uniqueConstraintChanges(ctx, schemas)
+		if err != nil {
+			return err
+		}
+
+		updated, err := s.repo.UpdateCIType(ctx, id, &UpdateCITypeRequest{
+			RequiredAttributes: migrated.RequiredAttributes,
+			OptionalAttributes: migrated.OptionalAttributes,
+		}, userID)
+		if err != nil {
+			return err
+		}
+
+		if err := s.rebuildUniqueValues(ctx, uniqueChanges); err != nil {
+			return err
+		}
+
+		job, err = s.repo.CreateSchemaMigrationJob(ctx, &SchemaMigrationJob{
+			CITypeID:    id,
+			FromVersion: current.SchemaVersion,
+			ToVersion:   updated.SchemaVersion,
+			Operations:  operations,
+			TotalCIs:    total,
+			CreatedBy:   userID,
+		})
+		if err != nil {
+			return err
+		}
+
+		details, err := withChangeDetails(map[string]interface{}{
+			"ci_type_name":         updated.Name,
+			"schema_migration_job": job.ID,
+			"operations":           operations,
+		}, current, updated)
+		if err != nil {
+			return err
+		}
+
+		return s.logAuditEvent(ctx, "ci_type", id, "update", userID, details)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoService("ci_type", "start_schema_migration", map[string]interface{}{
+		"ci_type_id":   id,
+		"job_id":       job.ID,
+		"from_version": job.FromVersion,
+		"to_version":   job.ToVersion,
+		"total_cis":    job.TotalCIs,
+		"user_id":      userID,
+	})
+
+	return job, nil
+}
+
+func (s *Service) GetCITypeMigration(ctx context.Context, id, jobID uuid.UUID) (*SchemaMigrationJob, error) {
+	return s.repo.GetSchemaMigrationJob(ctx, id, jobID)
+}
+
+func (s *Service) ListCITypeMigrations(ctx context.Context, id uuid.UUID, limit int) ([]SchemaMigrationJob, error) {
+	if _, err := s.repo.GetCIType(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.repo.ListSchemaMigrationJobs(ctx, id, limit)
+}
+
+// checkNoActiveSchemaMigration locks a CI type and refuses schema changes while
+// a migration of it is pending or running. Must be called inside a transaction.
+func (s *Service) checkNoActiveSchemaMigration(ctx context.Context, id uuid.UUID) error {
+	if err := s.repo.LockCIType(ctx, id); err != nil {
+		return err
+	}
+
+	active, err := s.repo.HasActiveSchemaMigration(ctx, id)
+	if err != nil {
+		return err
+	}
+	if active {
+		return fmt.Errorf("schema migration already in progress")
+	}
+	return nil
+}
+
+// uniqueConstraintChanges returns the unique constraints of each type in
+// schemas whose constraints differ from the ones it has now
+func (s *Service) uniqueConstraintChanges(ctx context.Context, schemas map[string]*CITypeDefinition) (map[string][]uniqueConstraint, error) {
+	changes := map[string][]uniqueConstraint{}
+	for name, proposed := range schemas {
+		current, err := s.resolveCITypeByName(ctx, name)
+		if err != nil {
+			if err.Error() == "CI type not found" {
+				continue
+			}
+			return nil, err
+		}
+		constraints := proposed.uniqueConstraints()
+		if !reflect.DeepEqual(constraints, current.uniqueConstraints()) {
+			changes[name] = constraints
+		}
+	}
+	return changes, nil
+}
+
+// rebuildUniqueValues reclaims the unique values of the CIs of each changed type
+func (s *Service) rebuildUniqueValues(ctx context.Context, changes map[string][]uniqueConstraint) error {
+	names := make([]string, 0, len(changes))
+	for name := range changes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := s.repo.RebuildUniqueValues(ctx, name, changes[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planCITypeMigration checks migration operations against a CI type and
+// returns the schema they produce
+func planCITypeMigration(ciType *CITypeDefinition, operations []AttributeMigration) (*CITypeDefinition, error) {
+	migrated, validationErrors := ciType.migrateSchema(operations)
+	if len(validationErrors) > 0 {
+		return nil, ServiceValidationError{
+			Message: "Schema migration validation failed",
+			Errors:  validationErrors,
+		}
+	}
+
+	if err := checkAttributeDefinitions(migrated.RequiredAttributes, migrated.OptionalAttributes); err != nil {
+		return nil, err
+	}
+
+	return migrated, nil
+}
+
+// checkCIsAgainstSchema walks every CI of a type and its subtypes, applies the
+// migration operations if there are any, and validates the result against
+// the proposed schema of the CI's own type
+func (s *Service) checkCIsAgainstSchema(ctx context.Context, current *CITypeDefinition, schemas map[string]*CITypeDefinition, operations []AttributeMigration) (*SchemaDryRunReport, error) {
+	report := &SchemaDryRunReport{
+		CIType:        current.Name,
+		SchemaVersion: current.SchemaVersion,
+		Failures:      []SchemaMigrationFailure{},
+	}
+
+	names := ciTypeNames(schemas)
+	after := uuid.Nil
+	for {
+		cis, err := s.repo.ListCIsOfTypeAfter(ctx, names, after, schemaScanBatchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ci := range cis {
+			proposed := schemas[ci.CIType]
+			attributes, changed, validationErrors := migrateAttributes(ci.Attributes, operations)
+			if len(validationErrors) == 0 {
+				proposed.NormalizeAttributes(attributes)
+				changed = changed || !reflect.DeepEqual(attributes, ci.Attributes)
+				validationErrors = proposed.ValidateAttributes(attributes)
+			}
+			if changed {
+				report.ChangedCIs++
+			}
+
+			if len(validationErrors) > 0 {
+				report.BrokenCIs++
+				if len(report.Failures) < schemaReportSampleLimit {
+					report.Failures = append(report.Failures, SchemaMigrationFailure{
+						CIID:   ci.ID,
+						CIName: ci.Name,
+						Errors: validationErrors,
+					})
+				}
+			}
+		}
+
+		report.TotalCIs += len(cis)
+		if len(cis) < schemaScanBatchSize {
+			break
+		}
+		after = cis[len(cis)-1].ID
+	}
+
+	return report, nil
+}
+
+// migrateCI rewrites one CI's attributes for a migration job, as an ordinary
+// audited update. ciType is the resolved type of the CI itself, which may be a
+// subtype of the migrated type. A CI that does not fit the migrated schema is
+// left as it is and its validation errors are returned.
+func (s *Service) migrateCI(ctx context.Context, ciType *CITypeDefinition, id uuid.UUID, job *SchemaMigrationJob) (bool, []ValidationError, error) {
+	migrated := false
+	var failures []ValidationError
+
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.LockCIs(ctx, id); err != nil {
+			return err
+		}
+
+		current, err := s.repo.GetCI(ctx, id)
+		if err != nil {
+			if err.Error() == "CI not found" {
+				return nil
+			}
+			return err
+		}
+
+		attributes, changed, validationErrors := migrateAttributes(current.Attributes, job.Operations)
+		if len(validationErrors) > 0 {
+			failures = validationErrors
+			return nil
+		}
+		if !changed {
+			ciType.NormalizeAttributes(attributes)
+			changed = !reflect.DeepEqual(attributes, current.Attributes)
+		}
+		if !changed {
+			failures = ciType.ValidateAttributes(attributes)
+			return nil
+		}
+
+		_, err = s.updateCI(ctx, id, &UpdateCIRequest{Attributes: attributes}, job.CreatedBy, map[string]interface{}{
+			"schema_migration_job": job.ID,
+		})
+		var validationErr ServiceValidationError
+		if errors.As(err, &validationErr) {
+			failures = validationErr.Errors
+			return nil
+		}
+		migrated = err == nil
+		return err
 	})
+	// A unique value clash is only found once the CI has been written, so the
+	// transaction is rolled back and the CI reported like a validation failure
+	var uniqueErr UniqueViolationError
+	if errors.As(err, &uniqueErr) {
+		return false, []ValidationError{{Field: uniqueErr.Attribute, Message: uniqueErr.Error()}}, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
 
-	s.logger.InfoService("ci_type", "delete_ci_type", map[string]interface{}{
-		"ci_type_id":   id,
-		"ci_type_name": ciType.Name,
-		"user_id":      userID,
+	if migrated {
+		s.invalidateCICache(ctx, id)
+	}
+
+	return migrated, failures, nil
+}
+
+// CI type JSON Schema
+
+// GetCITypeJSONSchema returns a JSON Schema for the body of a request
+// creating a CI of the type, inherited attributes included
+func (s *Service) GetCITypeJSONSchema(ctx context.Context, id uuid.UUID) (*JSONSchema, error) {
+	ciType, err := s.GetResolvedCIType(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return ciType.JSONSchema(), nil
+}
+
+// ImportCITypeJSONSchema creates the CI type a JSON Schema document describes,
+// or updates the type of that name to match it. Attributes the document shares
+// unchanged with the parent type are left to inheritance. Only the settings
+// that differ are written, so importing an unchanged document does nothing.
+// The boolean result reports whether the type was created.
+func (s *Service) ImportCITypeJSONSchema(ctx context.Context, doc *JSONSchema, userID uuid.UUID) (*CITypeDefinition, bool, error) {
+	req, validationErrors := doc.CITypeRequest()
+	if len(validationErrors) > 0 {
+		return nil, false, ServiceValidationError{
+			Message: "JSON Schema import failed",
+			Errors:  validationErrors,
+		}
+	}
+
+	if req.Parent != nil {
+		parent, err := s.resolveCITypeByName(ctx, *req.Parent)
+		if err != nil && err.Error() != "CI type not found" {
+			return nil, false, err
+		}
+		// A missing parent is reported by the create or update
+		if err == nil {
+			req.RequiredAttributes = withoutInherited(req.RequiredAttributes, parent.RequiredAttributes)
+			req.OptionalAttributes = withoutInherited(req.OptionalAttributes, parent.OptionalAttributes)
+		}
+	}
+
+	existing, err := s.repo.GetCITypeByName(ctx, req.Name)
+	if err != nil {
+		if err.Error() != "CI type not found" {
+			return nil, false, err
+		}
+		created, err := s.CreateCIType(ctx, req, userID)
+		return created, err == nil, err
+	}
+
+	update := &UpdateCITypeRequest{}
+	changed := false
+	description := ""
+	if req.Description != nil {
+		description = *req.Description
+	}
+	currentDescription := ""
+	if existing.Description != nil {
+		currentDescription = *existing.Description
+	}
+	if description != currentDescription {
+		update.Description = &description
+		changed = true
+	}
+	if !sameAttributes(req.RequiredAttributes, existing.RequiredAttributes) ||
+		!sameAttributes(req.OptionalAttributes, existing.OptionalAttributes) {
+		update.RequiredAttributes = req.RequiredAttributes
+		update.OptionalAttributes = req.OptionalAttributes
+		changed = true
+	}
+	if !reflect.DeepEqual(req.Parent, existing.Parent) {
+		parent := ""
+		if req.Parent != nil {
+			parent = *req.Parent
+		}
+		update.Parent = &parent
+		changed = true
+	}
+	if req.Abstract != existing.Abstract {
+		update.Abstract = &req.Abstract
+		changed = true
+	}
+	if !changed {
+		return existing, false, nil
+	}
+
+	updated, err := s.UpdateCIType(ctx, existing.ID, update, userID)
+	return updated, false, err
+}
+
+// CI type inheritance
+
+// GetResolvedCIType returns a CI type with the attributes it inherits merged in
+func (s *Service) GetResolvedCIType(ctx context.Context, id uuid.UUID) (*CITypeDefinition, error) {
+	ciType, err := s.repo.GetCIType(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.resolveCITypeByName(ctx, ciType.Name)
+}
+
+// resolveCITypeByName returns the effective definition of a CI type, the one
+// its CIs are validated against
+func (s *Service) resolveCITypeByName(ctx context.Context, name string) (*CITypeDefinition, error) {
+	lineage, err := s.repo.GetCITypeLineage(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return resolveCIType(lineage), nil
+}
+
+// ciTypeLineageNames returns a CI type's name followed by its ancestors',
+// nearest first
+func (s *Service) ciTypeLineageNames(ctx context.Context, name string) ([]string, error) {
+	ciType, err := s.resolveCITypeByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return ciType.lineageNames(), nil
+}
+
+// planCITypeHierarchy checks a proposed CI type definition against the type it
+// extends and the types that extend it, and checks that the attribute rules
+// of each resulting schema only depend on attributes it has. It returns the
+// effective schema the type and each of its subtypes would have, keyed by
+// type name.
+func (s *Service) planCITypeHierarchy(ctx context.Context, proposed *CITypeDefinition) (map[string]*CITypeDefinition, error) {
+	var parentLineage []CITypeDefinition
+	if proposed.Parent != nil {
+		lineage, err := s.repo.GetCITypeLineage(ctx, *proposed.Parent)
+		if err != nil {
+			if err.Error() != "CI type not found" {
+				return nil, err
+			}
+			return nil, ServiceValidationError{
+				Message: "CI type schema validation failed",
+				Errors: []ValidationError{{
+					Field:   "parent",
+					Message: fmt.Sprintf("CI type '%s' does not exist", *proposed.Parent),
+				}},
+			}
+		}
+		for _, ancestor := range lineage {
+			if ancestor.Name == proposed.Name {
+				return nil, ServiceValidationError{
+					Message: "CI type schema validation failed",
+					Errors: []ValidationError{{
+						Field:   "parent",
+						Message: fmt.Sprintf("'%s' already inherits from '%s'", *proposed.Parent, proposed.Name),
+					}},
+				}
+			}
+		}
+		parentLineage = lineage
+	}
+
+	var validationErrors []ValidationError
+	if len(parentLineage) > 0 {
+		validationErrors = checkInheritedAttributes(proposed, resolveCIType(parentLineage))
+	}
+
+	schemas := map[string]*CITypeDefinition{
+		proposed.Name: resolveCIType(proposedLineage(nil, parentLineage, proposed)),
+	}
+	validationErrors = append(validationErrors, schemas[proposed.Name].checkAttributeDependencies()...)
+
+	family, err := s.repo.ExpandCITypes(ctx, []string{proposed.Name})
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range family {
+		if name == proposed.Name {
+			continue
+		}
+		lineage, err := s.repo.GetCITypeLineage(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		full := proposedLineage(lineage, parentLineage, proposed)
+		schemas[name] = resolveCIType(full)
+
+		subtypeErrors := checkInheritedAttributes(&full[len(full)-1], resolveCIType(full[:len(full)-1]))
+		subtypeErrors = append(subtypeErrors, schemas[name].checkAttributeDependencies()...)
+		for _, e := range subtypeErrors {
+			validationErrors = append(validationErrors, ValidationError{
+				Field:   name + "." + e.Field,
+				Message: e.Message,
+			})
+		}
+	}
+
+	if len(validationErrors) > 0 {
+		return nil, ServiceValidationError{
+			Message: "CI type schema validation failed",
+			Errors:  validationErrors,
+		}
+	}
+
+	return schemas, nil
+}
+
+// Relationship Type Operations
+
+func (s *Service) CreateRelationshipType(ctx context.Context, req *CreateRelationshipTypeRequest, userID uuid.UUID) (*RelationshipTypeDefinition, error) {
+	if existing, err := s.repo.GetRelationshipTypeByName(ctx, req.Name); err == nil && existing != nil {
+		return nil, fmt.Errorf("relationship type already exists")
+	}
+
+	def := &RelationshipTypeDefinition{
+		Name:               req.Name,
+		InverseName:        req.InverseName,
+		Description:        req.Description,
+		SourceCITypes:      req.SourceCITypes,
+		TargetCITypes:      req.TargetCITypes,
+		Cardinality:        req.Cardinality,
+		IsDependency:       req.IsDependency,
+		RequiredAttributes: req.RequiredAttributes,
+		OptionalAttributes: req.OptionalAttributes,
+		CreatedBy:          &userID,
+	}
+	if def.Cardinality == "" {
+		def.Cardinality = CardinalityManyToMany
+	}
+
+	if err := s.validateRelationshipType(ctx, def); err != nil {
+		return nil, err
+	}
+
+	var result *RelationshipTypeDefinition
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = s.repo.CreateRelationshipType(ctx, def)
+		if err != nil {
+			return err
+		}
+
+		details, err := withChangeDetails(map[string]interface{}{
+			"relationship_type": result.Name,
+		}, nil, result)
+		if err != nil {
+			return err
+		}
+
+		return s.logAuditEvent(ctx, "relationship_type", result.ID, "create", userID, details)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoService("relationship_type", "create_relationship_type", map[string]interface{}{
+		"relationship_type_id": result.ID,
+		"relationship_type":    result.Name,
+		"user_id":              userID,
+	})
+
+	return result, nil
+}
+
+func (s *Service) GetRelationshipType(ctx context.Context, id uuid.UUID) (*RelationshipTypeDefinition, error) {
+	return s.repo.GetRelationshipType(ctx, id)
+}
+
+func (s *Service) ListRelationshipTypes(ctx context.Context, page, limit int, search string) (*RelationshipTypeListResponse, error) {
+	return s.repo.ListRelationshipTypes(ctx, page, limit, search)
+}
+
+// UpdateRelationshipType changes a relationship type. Narrowing the allowed CI
+// types or the cardinality is refused while existing relationships would break it.
+func (s *Service) UpdateRelationshipType(ctx context.Context, id uuid.UUID, req *UpdateRelationshipTypeRequest, userID uuid.UUID) (*RelationshipTypeDefinition, error) {
+	var result *RelationshipTypeDefinition
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		current, err := s.repo.GetRelationshipType(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		updated := *current
+		if req.InverseName != nil {
+			updated.InverseName = *req.InverseName
+		}
+		if req.Description != nil {
+			updated.Description = req.Description
+		}
+		if req.SourceCITypes != nil {
+			updated.SourceCITypes = req.SourceCITypes
+		}
+		if req.TargetCITypes != nil {
+			updated.TargetCITypes = req.TargetCITypes
+		}
+		if req.Cardinality != nil {
+			updated.Cardinality = *req.Cardinality
+		}
+		if req.IsDependency != nil {
+			updated.IsDependency = *req.IsDependency
+		}
+		if req.RequiredAttributes != nil {
+			updated.RequiredAttributes = req.RequiredAttributes
+		}
+		if req.OptionalAttributes != nil {
+			updated.OptionalAttributes = req.OptionalAttributes
+		}
+
+		if err := s.validateRelationshipType(ctx, &updated); err != nil {
+			return err
+		}
+
+		violations, err := s.repo.CountRelationshipTypeViolations(ctx, &updated)
+		if err != nil {
+			return err
+		}
+		if violations > 0 {
+			return fmt.Errorf("relationship type change conflicts with %d existing relationships", violations)
+		}
+
+		result, err = s.repo.UpdateRelationshipType(ctx, &updated)
+		if err != nil {
+			return err
+		}
+
+		details, err := withChangeDetails(map[string]interface{}{
+			"relationship_type": result.Name,
+		}, current, result)
+		if err != nil {
+			return err
+		}
+
+		return s.logAuditEvent(ctx, "relationship_type", id, "update", userID, details)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoService("relationship_type", "update_relationship_type", map[string]interface{}{
+		"relationship_type_id": id,
+		"user_id":              userID,
+	})
+
+	return result, nil
+}
+
+func (s *Service) DeleteRelationshipType(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
+	def, err := s.repo.GetRelationshipType(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.WithTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.DeleteRelationshipType(ctx, id); err != nil {
+			return err
+		}
+
+		details, err := withChangeDetails(map[string]interface{}{
+			"relationship_type": def.Name,
+		}, def, nil)
+		if err != nil {
+			return err
+		}
+
+		return s.logAuditEvent(ctx, "relationship_type", id, "delete", userID, details)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logger.InfoService("relationship_type", "delete_relationship_type", map[string]interface{}{
+		"relationship_type_id": id,
+		"relationship_type":    def.Name,
+		"user_id":              userID,
 	})
 
 	return nil
 }
 
+// validateRelationshipType checks a definition and that the CI types it names exist
+func (s *Service) validateRelationshipType(ctx context.Context, def *RelationshipTypeDefinition) error {
+	errors := validateRelationshipTypeDefinition(def)
+
+	for _, section := range []struct {
+		field   string
+		ciTypes []string
+	}{
+		{"source_ci_types", def.SourceCITypes},
+		{"target_ci_types", def.TargetCITypes},
+	} {
+		for i, name := range section.ciTypes {
+			if _, err := s.repo.GetCITypeByName(ctx, name); err != nil {
+				if err.Error() != "CI type not found" {
+					return err
+				}
+				errors = append(errors, ValidationError{
+					Field:   fmt.Sprintf("%s[%d]", section.field, i),
+					Message: fmt.Sprintf("CI type '%s' does not exist", name),
+				})
+			}
+		}
+	}
+
+	if len(errors) > 0 {
+		return ServiceValidationError{
+			Message: "Relationship type validation failed",
+			Errors:  errors,
+		}
+	}
+	return nil
+}
+
 // Relationship Operations
 
 func (s *Service) CreateRelationship(ctx context.Context, req *CreateRelationshipRequest, userID uuid.UUID) (*Relationship, error) {
@@ -350,6 +3009,55 @@ func (s *Service) CreateRelationship(ctx context.Context, req *CreateRelationshi
 		return nil, fmt.Errorf("cannot create self-referencing relationship")
 	}
 
+	// Validate against the relationship type definition
+	def, err := s.repo.GetRelationshipTypeByName(ctx, req.RelationshipType)
+	if err != nil {
+		if err.Error() == "relationship type not found" {
+			return nil, ServiceValidationError{
+				Message: "Relationship validation failed",
+				Errors: []ValidationError{{
+					Field:   "relationship_type",
+					Message: fmt.Sprintf("relationship type '%s' is not defined", req.RelationshipType),
+				}},
+			}
+		}
+		return nil, err
+	}
+
+	if req.Attributes == nil {
+		req.Attributes = map[string]interface{}{}
+	}
+	sourceTypes, err := s.ciTypeLineageNames(ctx, sourceCI.CIType)
+	if err != nil {
+		return nil, err
+	}
+	targetTypes, err := s.ciTypeLineageNames(ctx, targetCI.CIType)
+	if err != nil {
+		return nil, err
+	}
+
+	def.ApplyDefaults(req.Attributes)
+	def.NormalizeAttributes(req.Attributes)
+	validationErrors := def.ValidateAttributes(req.Attributes)
+	if !def.allowsSource(sourceTypes...) {
+		validationErrors = append(validationErrors, ValidationError{
+			Field:   "source_id",
+			Message: fmt.Sprintf("'%s' relationships cannot start at a %s; allowed: %s", def.Name, sourceCI.CIType, strings.Join(def.SourceCITypes, ", ")),
+		})
+	}
+	if !def.allowsTarget(targetTypes...) {
+		validationErrors = append(validationErrors, ValidationError{
+			Field:   "target_id",
+			Message: fmt.Sprintf("'%s' relationships cannot end at a %s; allowed: %s", def.Name, targetCI.CIType, strings.Join(def.TargetCITypes, ", ")),
+		})
+	}
+	if len(validationErrors) > 0 {
+		return nil, ServiceValidationError{
+			Message: "Relationship validation failed",
+			Errors:  validationErrors,
+		}
+	}
+
 	// Create relationship
 	relationship := &Relationship{
 		SourceID:        req.SourceID,
@@ -359,25 +3067,36 @@ func (s *Service) CreateRelationship(ctx context.Context, req *CreateRelationshi
 		CreatedBy:       userID,
 	}
 
-	result, err := s.repo.CreateRelationship(ctx, relationship)
-	if err != nil {
-		return nil, err
-	}
+	var result *Relationship
+	err = s.repo.WithTx(ctx, func(ctx context.Context) error {
+		if err := s.checkCardinality(ctx, def, req.SourceID, req.TargetID); err != nil {
+			return err
+		}
 
-	// Sync to Neo4j
-	if err := s.neo4j.CreateRelationship(ctx, result, sourceCI, targetCI); err != nil {
-		s.logger.ErrorService("neo4j", "create_relationship", err, map[string]interface{}{
-			"relationship_id": result.ID,
-		})
-		// Log error but don't fail the operation
-	}
+		var err error
+		result, err = s.repo.CreateRelationship(ctx, relationship)
+		if err != nil {
+			return err
+		}
+
+		details, err := withChangeDetails(map[string]interface{}{
+			"source_id":         req.SourceID,
+			"target_id":         req.TargetID,
+			"relationship_type": req.RelationshipType,
+		}, nil, result)
+		if err != nil {
+			return err
+		}
+
+		if err := s.logAuditEvent(ctx, "relationship", result.ID, "create", userID, details); err != nil {
+			return err
+		}
 
-	// Log audit event
-	s.logAuditEvent(ctx, "relationship", result.ID.String(), "create", userID.String(), map[string]interface{}{
-		"source_id":         req.SourceID,
-		"target_id":         req.TargetID,
-		"relationship_type": req.RelationshipType,
+		return s.repo.EnqueueGraphSync(ctx, GraphSyncEntityRelationship, result.ID, GraphSyncOpUpsert)
 	})
+	if err != nil {
+		return nil, err
+	}
 
 	s.logger.InfoService("relationship", "create_relationship", map[string]interface{}{
 		"relationship_id": result.ID,
@@ -395,28 +3114,151 @@ func (s *Service) ListRelationships(ctx context.Context, filters ListRelationshi
 	return s.repo.ListRelationships(ctx, filters, page, limit)
 }
 
+// checkCardinality refuses a new relationship that would exceed its type's
+// cardinality. It must run in the transaction that creates the relationship.
+func (s *Service) checkCardinality(ctx context.Context, def *RelationshipTypeDefinition, sourceID, targetID uuid.UUID) error {
+	if def.Cardinality == CardinalityManyToMany {
+		return nil
+	}
+
+	if err := s.repo.LockCIs(ctx, sourceID, targetID); err != nil {
+		return err
+	}
+
+	count, err := s.repo.CountRelationshipsOfType(ctx, def.Name, nil, &targetID)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("cardinality violation: target already has a '%s' relationship and '%s' is %s", def.Name, def.Name, def.Cardinality)
+	}
+
+	if def.Cardinality == CardinalityOneToOne {
+		count, err := s.repo.CountRelationshipsOfType(ctx, def.Name, &sourceID, nil)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("cardinality violation: source already has a '%s' relationship and '%s' is %s", def.Name, def.Name, def.Cardinality)
+		}
+	}
+
+	return nil
+}
+
 func (s *Service) UpdateRelationship(ctx context.Context, id uuid.UUID, req *UpdateRelationshipRequest, userID uuid.UUID) (*Relationship, error) {
-	result, err := s.repo.UpdateRelationship(ctx, id, req, userID)
+	var result *Relationship
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		current, err := s.lockRelationshipForChange(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if req.Attributes != nil {
+			def, err := s.repo.GetRelationshipTypeByName(ctx, current.RelationshipType)
+			if err != nil {
+				return err
+			}
+			def.NormalizeAttributes(req.Attributes)
+			if validationErrors := def.ValidateAttributes(req.Attributes); len(validationErrors) > 0 {
+				return ServiceValidationError{
+					Message: "Relationship validation failed",
+					Errors:  validationErrors,
+				}
+			}
+		}
+
+		result, err = s.repo.UpdateRelationship(ctx, id, req, userID)
+		if err != nil {
+			return err
+		}
+
+		details, err := withChangeDetails(map[string]interface{}{
+			"source_id":         result.SourceID,
+			"target_id":         result.TargetID,
+			"relationship_type": result.RelationshipType,
+		}, current, result)
+		if err != nil {
+			return err
+		}
+
+		if err := s.logAuditEvent(ctx, "relationship", id, "update", userID, details); err != nil {
+			return err
+		}
+
+		return s.repo.EnqueueGraphSync(ctx, GraphSyncEntityRelationship, id, GraphSyncOpUpsert)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Sync to Neo4j
-	if err := s.neo4j.UpdateRelationship(ctx, result); err != nil {
-		s.logger.ErrorService("neo4j", "update_relationship", err, map[string]interface{}{
-			"relationship_id": id,
-		})
-		// Log error but don't fail the operation
-	}
+	return result, nil
+}
+
+// PatchRelationship applies a merge patch or JSON Patch to a document holding
+// the relationship's attributes, with the relationship locked from read to write
+func (s *Service) PatchRelationship(ctx context.Context, id uuid.UUID, contentType string, patch []byte, userID uuid.UUID) (*Relationship, error) {
+	var result *Relationship
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		current, err := s.lockRelationshipForChange(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		attributes := current.Attributes
+		if attributes == nil {
+			attributes = map[string]interface{}{}
+		}
+		patched, err := applyPatch(map[string]interface{}{"attributes": attributes}, contentType, patch)
+		if err != nil {
+			return err
+		}
+
+		for key := range patched {
+			if key != "attributes" {
+				return invalidPatch("'%s' cannot be patched; only attributes can", key)
+			}
+		}
+		updated, ok := patched["attributes"].(map[string]interface{})
+		if _, present := patched["attributes"]; present && !ok {
+			return invalidPatch("attributes must be an object")
+		}
+		if updated == nil {
+			updated = map[string]interface{}{}
+		}
+		if reflect.DeepEqual(updated, attributes) {
+			result = current
+			return nil
+		}
 
-	// Log audit event
-	s.logAuditEvent(ctx, "relationship", id.String(), "update", userID.String(), map[string]interface{}{
-		"changes": req,
+		result, err = s.UpdateRelationship(ctx, id, &UpdateRelationshipRequest{Attributes: updated}, userID)
+		return err
 	})
+	if err != nil {
+		return nil, err
+	}
 
 	return result, nil
 }
 
+// lockRelationshipForChange locks a relationship for the rest of the
+// transaction and checks it against any If-Match precondition in ctx
+func (s *Service) lockRelationshipForChange(ctx context.Context, id uuid.UUID) (*Relationship, error) {
+	if err := s.repo.LockRelationship(ctx, id); err != nil {
+		return nil, err
+	}
+
+	current, err := s.repo.GetRelationship(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkIfMatch(ctx, current.Version); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
 func (s *Service) DeleteRelationship(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
 	// Get relationship for audit
 	relationship, err := s.repo.GetRelationship(ctx, id)
@@ -424,26 +3266,70 @@ func (s *Service) DeleteRelationship(ctx context.Context, id uuid.UUID, userID u
 		return err
 	}
 
-	if err := s.repo.DeleteRelationship(ctx, id); err != nil {
+	err = s.repo.WithTx(ctx, func(ctx context.Context) error {
+		if relationship, err = s.lockRelationshipForChange(ctx, id); err != nil {
+			return err
+		}
+
+		if err := s.repo.DeleteRelationship(ctx, id); err != nil {
+			return err
+		}
+
+		details, err := withChangeDetails(map[string]interface{}{
+			"source_id":         relationship.SourceID,
+			"target_id":         relationship.TargetID,
+			"relationship_type": relationship.RelationshipType,
+		}, relationship, nil)
+		if err != nil {
+			return err
+		}
+
+		if err := s.logAuditEvent(ctx, "relationship", id, "delete", userID, details); err != nil {
+			return err
+		}
+
+		return s.repo.EnqueueGraphSync(ctx, GraphSyncEntityRelationship, id, GraphSyncOpDelete)
+	})
+	if err != nil {
 		return err
 	}
 
-	// Delete from Neo4j
-	if err := s.neo4j.DeleteRelationship(ctx, id); err != nil {
-		s.logger.ErrorService("neo4j", "delete_relationship", err, map[string]interface{}{
-			"relationship_id": id,
+	return nil
+}
+
+// Graph sync operations
+
+// GetGraphSyncStatus reports the PostgreSQL-to-Neo4j sync backlog and failed entries
+func (s *Service) GetGraphSyncStatus(ctx context.Context, failedLimit int) (*GraphSyncStatus, error) {
+	return s.repo.GetGraphSyncStatus(ctx, failedLimit)
+}
+
+// RetryGraphSync re-queues a dead-lettered outbox entry
+func (s *Service) RetryGraphSync(ctx context.Context, id int64, userID uuid.UUID) (*GraphSyncEntry, error) {
+	var entry *GraphSyncEntry
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		entry, err = s.repo.RetryGraphSync(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		return s.logAuditEvent(ctx, entry.EntityType, entry.EntityID, "graph_sync_retry", userID, map[string]interface{}{
+			"outbox_id": id,
+			"operation": entry.Operation,
 		})
-		// Log error but don't fail the operation
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Log audit event
-	s.logAuditEvent(ctx, "relationship", id.String(), "delete", userID.String(), map[string]interface{}{
-		"source_id":         relationship.SourceID,
-		"target_id":         relationship.TargetID,
-		"relationship_type": relationship.RelationshipType,
+	s.logger.InfoService("graph_sync", "retry_entry", map[string]interface{}{
+		"outbox_id": id,
+		"entity_id": entry.EntityID,
+		"user_id":   userID,
 	})
 
-	return nil
+	return entry, nil
 }
 
 // Helper methods
@@ -470,7 +3356,7 @@ func (s *Service) validateCITypeSchema(req *CreateCITypeRequest) error {
 		optionalNames[attr.Name] = true
 	}
 
-	return nil
+	return checkAttributeDefinitions(req.RequiredAttributes, req.OptionalAttributes)
 }
 
 func (s *Service) validateCITypeSchemaUpdate(req *UpdateCITypeRequest) error {
@@ -491,6 +3377,24 @@ func (s *Service) validateCITypeSchemaUpdate(req *UpdateCITypeRequest) error {
 		}
 	}
 
+	return checkAttributeDefinitions(req.RequiredAttributes, req.OptionalAttributes)
+}
+
+// checkAttributeDefinitions rejects unknown attribute types and validation
+// patterns that do not compile
+func checkAttributeDefinitions(required, optional []AttributeDefinition) error {
+	errors := validateAttributeTypes("required_attributes", required)
+	errors = append(errors, validateAttributeTypes("optional_attributes", optional)...)
+	errors = append(errors, validateAttributePatterns("required_attributes", required)...)
+	errors = append(errors, validateAttributePatterns("optional_attributes", optional)...)
+	errors = append(errors, validateAttributeRules("required_attributes", required)...)
+	errors = append(errors, validateAttributeRules("optional_attributes", optional)...)
+	if len(errors) > 0 {
+		return ServiceValidationError{
+			Message: "CI type schema validation failed",
+			Errors:  errors,
+		}
+	}
 	return nil
 }
 
@@ -524,9 +3428,380 @@ func (s *Service) invalidateCICache(ctx context.Context, id uuid.UUID) {
 	s.redis.Del(ctx, key)
 }
 
-func (s *Service) logAuditEvent(ctx context.Context, entityType, entityID, action, performedBy string, details map[string]interface{}) {
-	// This would integrate with the audit service
-	s.logger.InfoAudit(entityType, entityID, action, performedBy, details)
+// logAuditEvent persists an audit entry through the audit service. Callers run
+// it inside the same transaction as the change it describes, so the change and
+// its audit record are committed or rolled back together.
+func (s *Service) logAuditEvent(ctx context.Context, entityType string, entityID uuid.UUID, action string, performedBy uuid.UUID, details map[string]interface{}) error {
+	meta := RequestMetadataFromContext(ctx)
+	if err := s.audit.CreateAuditLog(ctx, entityType, &entityID, action, performedBy, details, meta.IPAddress, meta.UserAgent); err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+
+	s.logger.InfoAudit(entityType, entityID.String(), action, performedBy.String(), details)
+	return nil
+}
+
+// Full-text search
+
+// Search returns the CIs, CI types and relationships best matching the
+// request's words as prefixes, best first across kinds
+func (s *Service) Search(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
+	var errors []ValidationError
+	tsquery, err := searchTSQuery(req.Query)
+	if err != nil {
+		errors = append(errors, ValidationError{Field: "q", Message: err.Error()})
+	}
+	kinds := req.Kinds
+	if len(kinds) == 0 {
+		kinds = SearchKinds
+	}
+	for _, kind := range kinds {
+		if !containsString(SearchKinds, kind) {
+			errors = append(errors, ValidationError{Field: "kinds", Message: fmt.Sprintf("unknown kind '%s'", kind)})
+		}
+	}
+	if len(errors) > 0 {
+		return nil, ServiceValidationError{Message: "Search validation failed", Errors: errors}
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	} else if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	searches := map[string]func(context.Context, string, int) ([]*SearchResult, error){
+		SearchKindCI:           s.repo.SearchCIs,
+		SearchKindCIType:       s.repo.SearchCITypes,
+		SearchKindRelationship: s.repo.SearchRelationships,
+	}
+	var results [][]*SearchResult
+	for _, kind := range SearchKinds {
+		if !containsString(kinds, kind) {
+			continue
+		}
+		found, err := searches[kind](ctx, tsquery, limit)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, found)
+	}
+
+	return &SearchResponse{Query: req.Query, Results: mergeSearchResults(results, limit)}, nil
+}
+
+// Saved Searches
+
+func (s *Service) CreateSavedSearch(ctx context.Context, req *CreateSavedSearchRequest, userID uuid.UUID) (*SavedSearch, error) {
+	search := &SavedSearch{
+		OwnerID:        userID,
+		Name:           strings.TrimSpace(req.Name),
+		Description:    req.Description,
+		Kind:           req.Kind,
+		CIFilters:      req.CIFilters,
+		GraphFilters:   req.GraphFilters,
+		Columns:        req.Columns,
+		SharedWithRole: req.SharedWithRole,
+	}
+	normalizeSavedSearch(search)
+	if err := s.checkSavedSearch(ctx, search); err != nil {
+		return nil, err
+	}
+
+	taken, err := s.repo.SavedSearchNameTaken(ctx, userID, search.Name, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, fmt.Errorf("saved search already exists")
+	}
+
+	result, err := s.repo.CreateSavedSearch(ctx, search)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoService("saved_search", "create_saved_search", map[string]interface{}{
+		"saved_search_id": result.ID,
+		"kind":            result.Kind,
+		"user_id":         userID,
+	})
+
+	return result, nil
+}
+
+// GetSavedSearch returns a saved search the viewer can see; others are not found
+func (s *Service) GetSavedSearch(ctx context.Context, id uuid.UUID, viewer SavedSearchViewer) (*SavedSearch, error) {
+	search, err := s.repo.GetSavedSearch(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !viewer.canSee(search) {
+		return nil, fmt.Errorf("saved search not found")
+	}
+	return search, nil
+}
+
+// ListSavedSearches lists the viewer's saved searches and those shared with
+// their roles, of one kind or all kinds
+func (s *Service) ListSavedSearches(ctx context.Context, viewer SavedSearchViewer, kind string, page, limit int) (*SavedSearchListResponse, error) {
+	if kind != "" && kind != SavedSearchKindCI && kind != SavedSearchKindGraph {
+		return nil, ServiceValidationError{
+			Message: "Saved search validation failed",
+			Errors: []ValidationError{{
+				Field:   "kind",
+				Message: fmt.Sprintf("kind must be %s or %s", SavedSearchKindCI, SavedSearchKindGraph),
+			}},
+		}
+	}
+	return s.repo.ListSavedSearches(ctx, viewer, kind, page, limit)
+}
+
+// UpdateSavedSearch changes a saved search; only its owner may
+func (s *Service) UpdateSavedSearch(ctx context.Context, id uuid.UUID, req *UpdateSavedSearchRequest, viewer SavedSearchViewer) (*SavedSearch, error) {
+	var result *SavedSearch
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		current, err := s.lockSavedSearchForChange(ctx, id, viewer)
+		if err != nil {
+			return err
+		}
+
+		updated := *current
+		if req.Name != nil {
+			updated.Name = strings.TrimSpace(*req.Name)
+		}
+		if req.Description != nil {
+			updated.Description = req.Description
+		}
+		if req.CIFilters != nil {
+			updated.CIFilters = req.CIFilters
+		}
+		if req.GraphFilters != nil {
+			updated.GraphFilters = req.GraphFilters
+		}
+		if req.Columns != nil {
+			updated.Columns = req.Columns
+		}
+		if req.SharedWithRole != nil {
+			updated.SharedWithRole = req.SharedWithRole
+		}
+		normalizeSavedSearch(&updated)
+		if err := s.checkSavedSearch(ctx, &updated); err != nil {
+			return err
+		}
+
+		if updated.Name != current.Name {
+			taken, err := s.repo.SavedSearchNameTaken(ctx, current.OwnerID, updated.Name, id)
+			if err != nil {
+				return err
+			}
+			if taken {
+				return fmt.Errorf("saved search already exists")
+			}
+		}
+
+		result, err = s.repo.UpdateSavedSearch(ctx, &updated)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoService("saved_search", "update_saved_search", map[string]interface{}{
+		"saved_search_id": id,
+		"user_id":         viewer.UserID,
+	})
+
+	return result, nil
+}
+
+// DeleteSavedSearch deletes a saved search; only its owner may
+func (s *Service) DeleteSavedSearch(ctx context.Context, id uuid.UUID, viewer SavedSearchViewer) error {
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := s.lockSavedSearchForChange(ctx, id, viewer); err != nil {
+			return err
+		}
+		return s.repo.DeleteSavedSearch(ctx, id)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logger.InfoService("saved_search", "delete_saved_search", map[string]interface{}{
+		"saved_search_id": id,
+		"user_id":         viewer.UserID,
+	})
+
+	return nil
+}
+
+// RunSavedSearch runs a saved search the viewer can see: a CI search lists
+// the given page, or the page of cursor, counted as count asks when given;
+// a graph search returns its graph data
+func (s *Service) RunSavedSearch(ctx context.Context, id uuid.UUID, viewer SavedSearchViewer, page, limit int, cursor, count string) (*SavedSearchResults, error) {
+	search, err := s.GetSavedSearch(ctx, id, viewer)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &SavedSearchResults{SavedSearch: search}
+	switch search.Kind {
+	case SavedSearchKindCI:
+		filters := *search.CIFilters
+		filters.Cursor = cursor
+		if count != "" {
+			filters.Count = count
+		}
+		results.CIs, err = s.ListCIs(ctx, filters, page, limit)
+	case SavedSearchKindGraph:
+		filters := *search.GraphFilters
+		if filters.Limit == 0 {
+			filters.Limit = 100
+		}
+		results.Graph, err = s.GetGraphData(ctx, filters)
+	default:
+		err = fmt.Errorf("unknown saved search kind '%s'", search.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// lockSavedSearchForChange locks a saved search the viewer owns and checks
+// its version against the context's If-Match versions. A search the viewer
+// cannot see is not found.
+func (s *Service) lockSavedSearchForChange(ctx context.Context, id uuid.UUID, viewer SavedSearchViewer) (*SavedSearch, error) {
+	if err := s.repo.LockSavedSearch(ctx, id); err != nil {
+		return nil, err
+	}
+
+	current, err := s.GetSavedSearch(ctx, id, viewer)
+	if err != nil {
+		return nil, err
+	}
+	if current.OwnerID != viewer.UserID {
+		return nil, fmt.Errorf("saved search is owned by another user")
+	}
+
+	if err := checkIfMatch(ctx, current.Version); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// checkSavedSearch validates a saved search, and what it names against the
+// database: the role it is shared with, the CI types of a graph search, and
+// the filters and column attributes of a CI search, checked as ListCIs
+// checks them
+func (s *Service) checkSavedSearch(ctx context.Context, search *SavedSearch) error {
+	validationErrors := validateSavedSearch(search)
+
+	if search.SharedWithRole != nil {
+		exists, err := s.repo.RoleExists(ctx, *search.SharedWithRole)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			validationErrors = append(validationErrors, ValidationError{
+				Field:   "shared_with_role",
+				Message: fmt.Sprintf("role '%s' does not exist", *search.SharedWithRole),
+			})
+		}
+	}
+
+	switch {
+	case search.Kind == SavedSearchKindCI && search.CIFilters != nil:
+		filterErrors, err := s.checkSavedCIFilters(ctx, *search.CIFilters, search.Columns)
+		if err != nil {
+			return err
+		}
+		validationErrors = append(validationErrors, filterErrors...)
+	case search.Kind == SavedSearchKindGraph && search.GraphFilters != nil:
+		allTypes, err := s.repo.ListCITypeNames(ctx)
+		if err != nil {
+			return err
+		}
+		for i, name := range search.GraphFilters.CITypes {
+			if !containsString(allTypes, name) {
+				validationErrors = append(validationErrors, ValidationError{
+					Field:   fmt.Sprintf("graph_filters.ci_types[%d]", i),
+					Message: fmt.Sprintf("CI type '%s' does not exist", name),
+				})
+			}
+		}
+	}
+
+	if len(validationErrors) > 0 {
+		return ServiceValidationError{Message: "Saved search validation failed", Errors: validationErrors}
+	}
+	return nil
+}
+
+// checkSavedCIFilters checks the filters of a CI search and that its columns
+// name attributes of the CI types it can match
+func (s *Service) checkSavedCIFilters(ctx context.Context, filters ListCIFilters, columns []string) ([]ValidationError, error) {
+	var validationErrors []ValidationError
+	addFilterErrors := func(filterErrors []ValidationError) {
+		for _, e := range filterErrors {
+			e.Field = "ci_filters." + e.Field
+			validationErrors = append(validationErrors, e)
+		}
+	}
+
+	addQueryErrors := func(err error) error {
+		var validationErr ServiceValidationError
+		if err != nil && !errors.As(err, &validationErr) {
+			return err
+		}
+		addFilterErrors(validationErr.Errors)
+		return nil
+	}
+
+	if err := addQueryErrors(parseCIListQuery(&filters)); err != nil {
+		return nil, err
+	}
+	allTypes, scope, err := s.ciListScope(ctx, filters)
+	if err != nil {
+		return nil, err
+	}
+	if err := addQueryErrors(checkCIListQuery(filters, allTypes, scope)); err != nil {
+		return nil, err
+	}
+	if filters.CIType != "" && !containsString(allTypes, filters.CIType) {
+		addFilterErrors([]ValidationError{{Field: "ci_type", Message: fmt.Sprintf("CI type '%s' does not exist", filters.CIType)}})
+	}
+	switch filters.Count {
+	case "", CountExact, CountEstimated, CountNone:
+	default:
+		addFilterErrors([]ValidationError{{Field: "count", Message: "count must be exact, estimated or none"}})
+	}
+	_, facetErrors := planCIFacets(filters.Facets, scope)
+	addFilterErrors(facetErrors)
+
+	attributes := map[string]bool{}
+	for _, ciType := range scope {
+		for _, attrs := range [][]AttributeDefinition{ciType.RequiredAttributes, ciType.OptionalAttributes} {
+			for _, attr := range attrs {
+				attributes[attr.Name] = true
+			}
+		}
+	}
+	for i, column := range columns {
+		if !strings.HasPrefix(column, "attributes.") || column == "attributes." {
+			continue
+		}
+		name := strings.SplitN(strings.TrimPrefix(column, "attributes."), ".", 2)[0]
+		if !attributes[name] {
+			validationErrors = append(validationErrors, ValidationError{
+				Field:   fmt.Sprintf("columns[%d]", i),
+				Message: fmt.Sprintf("attribute '%s' is not defined by the searched CI types", name),
+			})
+		}
+	}
+
+	return validationErrors, nil
 }
 
 // Graph Operations - delegating to Neo4j service
@@ -536,6 +3811,13 @@ func (s *Service) GetCIRelationships(ctx context.Context, id uuid.UUID) ([]Relat
 }
 
 func (s *Service) GetGraphData(ctx context.Context, filters GraphFilters) (*GraphData, error) {
+	if filters.IncludeSubtypes && len(filters.CITypes) > 0 {
+		ciTypes, err := s.repo.ExpandCITypes(ctx, filters.CITypes)
+		if err != nil {
+			return nil, err
+		}
+		filters.CITypes = ciTypes
+	}
 	return s.neo4j.GetGraphData(ctx, filters)
 }
 
@@ -543,12 +3825,29 @@ func (s *Service) GetCINetwork(ctx context.Context, id uuid.UUID, depth int) (*C
 	return s.neo4j.GetCINetwork(ctx, id, depth)
 }
 
+// GetImpactAnalysis follows only relationship types flagged as dependencies
 func (s *Service) GetImpactAnalysis(ctx context.Context, id uuid.UUID) (*ImpactAnalysis, error) {
-	return s.neo4j.GetImpactAnalysis(ctx, id)
+	dependencyTypes, err := s.repo.ListDependencyRelationshipTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.neo4j.GetImpactAnalysis(ctx, id, dependencyTypes)
 }
 
+// GetCITypesByUsage counts the CIs of every CI type, rolling the counts of
+// subtypes up into the total of each ancestor
 func (s *Service) GetCITypesByUsage(ctx context.Context) ([]CITypeUsage, error) {
-	return s.neo4j.GetCITypesByUsage(ctx)
+	counts, err := s.neo4j.GetCITypesByUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ciTypes, err := s.repo.ListAllCITypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return rollUpCITypeUsage(ciTypes, counts), nil
 }
 
 func (s *Service) FindCycles(ctx context.Context) ([][]uuid.UUID, error) {
@@ -8,31 +8,36 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/suite"
 
 	"github.com/pustaka/pustaka/internal/testutils"
+	pustakaLogger "github.com/pustaka/pustaka/pkg/logger"
 )
 
 type CIServiceSuite struct {
 	suite.Suite
-	db        *pgxpool.Pool
-	service   *Service
-	cleanup   func()
+	db      *pgxpool.Pool
+	redis   *redis.Client
+	service *Service
+	cleanup func()
 }
 
 func (suite *CIServiceSuite) SetupSuite() {
 	suite.db, suite.cleanup = testutils.SetupTestDB(suite.T())
 
-	// Initialize service with required dependencies
-	repo := NewRepository(suite.db)
-	neo4jService := &MockNeo4jService{} // We'll create a mock for testing
-	redisService := &MockRedisService{}  // We'll create a mock for testing
-	logger := &MockLogger{}              // We'll create a mock for testing
+	// Initialize service with required dependencies. Graph changes go
+	// through the outbox, so the service needs no Neo4j connection.
+	logger := pustakaLogger.Default()
+	repo := NewRepository(suite.db, logger)
+	audit := NewAuditService(NewAuditLogRepository(suite.db, logger), logger)
+	suite.redis = redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
 
-	suite.service = NewService(repo, neo4jService, redisService, logger)
+	suite.service = NewService(repo, nil, audit, suite.redis, logger)
 }
 
 func (suite *CIServiceSuite) TearDownSuite() {
+	suite.redis.Close()
 	suite.cleanup()
 }
 
@@ -128,7 +133,7 @@ func (suite *CIServiceSuite) TestListCIs() {
 	userID := uuid.New()
 
 	// Create test CIs
-	ci1, err := suite.service.CreateCI(ctx, &CreateCIRequest{
+	_, err := suite.service.CreateCI(ctx, &CreateCIRequest{
 		Name:   "web-server",
 		CIType: "Server",
 		Attributes: map[string]interface{}{
@@ -138,7 +143,7 @@ func (suite *CIServiceSuite) TestListCIs() {
 	}, userID)
 	require.NoError(suite.T(), err)
 
-	ci2, err := suite.service.CreateCI(ctx, &CreateCIRequest{
+	_, err = suite.service.CreateCI(ctx, &CreateCIRequest{
 		Name:   "database",
 		CIType: "Database",
 		Attributes: map[string]interface{}{
@@ -152,7 +157,7 @@ func (suite *CIServiceSuite) TestListCIs() {
 	response, err := suite.service.ListCIs(ctx, ListCIFilters{}, 1, 10)
 	require.NoError(suite.T(), err)
 	assert.GreaterOrEqual(suite.T(), len(response.CIs), 2)
-	assert.GreaterOrEqual(suite.T(), response.Total, 2)
+	assert.GreaterOrEqual(suite.T(), response.Total, int64(2))
 
 	// Test filtering by CI type
 	filters := ListCIFilters{CIType: "Server"}
@@ -202,7 +207,6 @@ func (suite *CIServiceSuite) TestUpdateCI() {
 
 	// Update the CI
 	updateReq := &UpdateCIRequest{
-		Name: strPtr("updated-server"),
 		Attributes: map[string]interface{}{
 			"os":       "Ubuntu 20.04",
 			"cpu_cores": 4,
@@ -212,10 +216,10 @@ func (suite *CIServiceSuite) TestUpdateCI() {
 
 	updatedCI, err := suite.service.UpdateCI(ctx, createdCI.ID, updateReq, userID)
 	require.NoError(suite.T(), err)
-	assert.Equal(suite.T(), *updateReq.Name, updatedCI.Name)
+	assert.Equal(suite.T(), createdCI.Name, updatedCI.Name)
 	assert.Equal(suite.T(), updateReq.Attributes, updatedCI.Attributes)
 	assert.Equal(suite.T(), updateReq.Tags, updatedCI.Tags)
-	assert.Equal(suite.T(), userID, updatedCI.UpdatedBy)
+	assert.Equal(suite.T(), &userID, updatedCI.UpdatedBy)
 	assert.NotZero(suite.T(), updatedCI.UpdatedAt)
 }
 
@@ -284,25 +288,7 @@ func (suite *CIServiceSuite) TestDeleteCIWithRelationships() {
 	// Try to delete CI that has relationships
 	err = suite.service.DeleteCI(ctx, ci1.ID, userID)
 	assert.Error(suite.T(), err)
-	assert.Contains(suite.T(), err.Error(), "has relationships")
-}
-
-// Mock implementations for testing
-type MockNeo4jService struct {
-	// Implement required methods for testing
-}
-
-type MockRedisService struct {
-	// Implement required methods for testing
-}
-
-type MockLogger struct {
-	// Implement required methods for testing
-}
-
-// Helper function
-func strPtr(s string) *string {
-	return &s
+	assert.Contains(suite.T(), err.Error(), "existing relationships")
 }
 
 func TestCIServiceSuite(t *testing.T) {
@@ -0,0 +1,72 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// querier is the subset of pgx shared by *pgxpool.Pool and pgx.Tx
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+type txContextKey struct{}
+
+// conn returns the transaction bound to ctx, or the pool when there is none
+func conn(ctx context.Context, pool *pgxpool.Pool) querier {
+	if tx, ok := ctx.Value(txContextKey{}).(pgx.Tx); ok {
+		return tx
+	}
+	return pool
+}
+
+// runInTx runs fn inside a PostgreSQL transaction. Repositories called with the
+// context passed to fn join that transaction. If ctx already carries a
+// transaction, fn simply joins it and the outermost caller commits.
+func runInTx(ctx context.Context, pool *pgxpool.Pool, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txContextKey{}).(pgx.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(context.WithValue(ctx, txContextKey{}, tx)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// RequestMetadata carries details about the originating HTTP request that are
+// recorded alongside audit events
+type RequestMetadata struct {
+	IPAddress string
+	UserAgent string
+}
+
+type requestMetadataKey struct{}
+
+// WithRequestMetadata returns a context carrying the request's IP address and user agent
+func WithRequestMetadata(ctx context.Context, meta RequestMetadata) context.Context {
+	return context.WithValue(ctx, requestMetadataKey{}, meta)
+}
+
+// RequestMetadataFromContext returns the request metadata stored in ctx, if any
+func RequestMetadataFromContext(ctx context.Context) RequestMetadata {
+	meta, _ := ctx.Value(requestMetadataKey{}).(RequestMetadata)
+	return meta
+}
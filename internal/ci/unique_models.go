@@ -0,0 +1,54 @@
+package ci
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Unique attribute scopes
+const (
+	UniqueScopeType   = "type"
+	UniqueScopeGlobal = "global"
+)
+
+// globalUniqueScope is the scope key under which globally unique values are claimed
+const globalUniqueScope = "*"
+
+// uniqueConstraint is a unique attribute of a resolved CI type. Scope is the
+// key values are claimed under: the CI type name, or globalUniqueScope.
+type uniqueConstraint struct {
+	Attribute string
+	Scope     string
+}
+
+// uniqueConstraints returns the unique attributes of a resolved CI type
+func (ciType *CITypeDefinition) uniqueConstraints() []uniqueConstraint {
+	var constraints []uniqueConstraint
+	for _, attrs := range [][]AttributeDefinition{ciType.RequiredAttributes, ciType.OptionalAttributes} {
+		for _, attr := range attrs {
+			switch attr.Unique {
+			case UniqueScopeType:
+				constraints = append(constraints, uniqueConstraint{Attribute: attr.Name, Scope: ciType.Name})
+			case UniqueScopeGlobal:
+				constraints = append(constraints, uniqueConstraint{Attribute: attr.Name, Scope: globalUniqueScope})
+			}
+		}
+	}
+	return constraints
+}
+
+// UniqueViolationError is returned when a CI attribute marked unique holds a
+// value another CI already has
+type UniqueViolationError struct {
+	Attribute string    `json:"attribute"`
+	Value     string    `json:"value"`
+	Scope     string    `json:"scope"`
+	CIID      uuid.UUID `json:"ci_id"`
+	CIName    string    `json:"ci_name"`
+	CIType    string    `json:"ci_type"`
+}
+
+func (e UniqueViolationError) Error() string {
+	return fmt.Sprintf("attribute '%s' value '%s' is already used by CI '%s' (%s)", e.Attribute, e.Value, e.CIName, e.CIType)
+}
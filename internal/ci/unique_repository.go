@@ -0,0 +1,168 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Unique attribute values
+
+// ClaimUniqueValues replaces the unique attribute values claimed by a CI with
+// the values it holds now. It must run in the transaction that wrote the CI.
+// A value already claimed by another CI is returned as a UniqueViolationError;
+// the primary key on the claims table makes this safe under concurrent writes.
+func (r *Repository) ClaimUniqueValues(ctx context.Context, ciID uuid.UUID, constraints []uniqueConstraint) error {
+	if _, err := r.conn(ctx).Exec(ctx, "DELETE FROM ci_unique_attribute_values WHERE ci_id = $1", ciID); err != nil {
+		r.logger.ErrorDatabase("DELETE", "ci_unique_attribute_values", err, map[string]interface{}{
+			"ci_id": ciID,
+		})
+		return fmt.Errorf("failed to release unique values: %w", err)
+	}
+
+	claimQuery := `
+		INSERT INTO ci_unique_attribute_values (scope, attribute, value, ci_id)
+		SELECT $2, $3, attributes->>$3, id
+		FROM configuration_items
+		WHERE id = $1 AND attributes->>$3 IS NOT NULL
+		ON CONFLICT (scope, attribute, value) DO NOTHING
+	`
+	conflictQuery := `
+		SELECT u.value, ci.id, ci.name, ci.ci_type
+		FROM configuration_items own
+		JOIN ci_unique_attribute_values u ON u.scope = $2 AND u.attribute = $3 AND u.value = own.attributes->>$3
+		JOIN configuration_items ci ON ci.id = u.ci_id
+		WHERE own.id = $1 AND u.ci_id <> own.id
+	`
+
+	for _, constraint := range constraints {
+		if _, err := r.conn(ctx).Exec(ctx, claimQuery, ciID, constraint.Scope, constraint.Attribute); err != nil {
+			r.logger.ErrorDatabase("INSERT", "ci_unique_attribute_values", err, map[string]interface{}{
+				"ci_id":     ciID,
+				"attribute": constraint.Attribute,
+			})
+			return fmt.Errorf("failed to claim unique value: %w", err)
+		}
+
+		violation := UniqueViolationError{Attribute: constraint.Attribute, Scope: uniqueScopeName(constraint.Scope)}
+		err := r.conn(ctx).QueryRow(ctx, conflictQuery, ciID, constraint.Scope, constraint.Attribute).Scan(
+			&violation.Value,
+			&violation.CIID,
+			&violation.CIName,
+			&violation.CIType,
+		)
+		if err == nil {
+			return violation
+		}
+		if err != pgx.ErrNoRows {
+			r.logger.ErrorDatabase("SELECT", "ci_unique_attribute_values", err, map[string]interface{}{
+				"ci_id":     ciID,
+				"attribute": constraint.Attribute,
+			})
+			return fmt.Errorf("failed to check unique value: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// GetCIByUniqueValue returns the CI holding a unique attribute value, or nil
+// when no CI holds it
+func (r *Repository) GetCIByUniqueValue(ctx context.Context, constraint uniqueConstraint, value string) (*ConfigurationItem, error) {
+	query := `
+		SELECT ` + ciColumns + `
+		FROM configuration_items
+		WHERE id = (
+			SELECT ci_id FROM ci_unique_attribute_values
+			WHERE scope = $1 AND attribute = $2 AND value = $3
+		)
+	`
+
+	var ci ConfigurationItem
+	err := scanCI(r.conn(ctx).QueryRow(ctx, query, constraint.Scope, constraint.Attribute, value), &ci)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		r.logger.ErrorDatabase("SELECT", "ci_unique_attribute_values", err, map[string]interface{}{
+			"attribute": constraint.Attribute,
+		})
+		return nil, fmt.Errorf("failed to get CI by unique value: %w", err)
+	}
+
+	return &ci, nil
+}
+
+// RebuildUniqueValues reclaims the unique attribute values of every CI of a
+// type after its constraints changed. The first value held by two CIs is
+// returned as a UniqueViolationError naming the CI that kept it.
+func (r *Repository) RebuildUniqueValues(ctx context.Context, ciType string, constraints []uniqueConstraint) error {
+	_, err := r.conn(ctx).Exec(ctx, `
+		DELETE FROM ci_unique_attribute_values u
+		USING configuration_items ci
+		WHERE u.ci_id = ci.id AND ci.ci_type = $1
+	`, ciType)
+	if err != nil {
+		r.logger.ErrorDatabase("DELETE", "ci_unique_attribute_values", err, map[string]interface{}{
+			"ci_type": ciType,
+		})
+		return fmt.Errorf("failed to release unique values: %w", err)
+	}
+
+	claimQuery := `
+		INSERT INTO ci_unique_attribute_values (scope, attribute, value, ci_id)
+		SELECT $2, $3, attributes->>$3, id
+		FROM configuration_items
+		WHERE ci_type = $1 AND attributes->>$3 IS NOT NULL
+		ORDER BY created_at, id
+		ON CONFLICT (scope, attribute, value) DO NOTHING
+	`
+	conflictQuery := `
+		SELECT u.value, ci.id, ci.name, ci.ci_type
+		FROM configuration_items own
+		JOIN ci_unique_attribute_values u ON u.scope = $2 AND u.attribute = $3 AND u.value = own.attributes->>$3
+		JOIN configuration_items ci ON ci.id = u.ci_id
+		WHERE own.ci_type = $1 AND u.ci_id <> own.id
+		LIMIT 1
+	`
+
+	for _, constraint := range constraints {
+		if _, err := r.conn(ctx).Exec(ctx, claimQuery, ciType, constraint.Scope, constraint.Attribute); err != nil {
+			r.logger.ErrorDatabase("INSERT", "ci_unique_attribute_values", err, map[string]interface{}{
+				"ci_type":   ciType,
+				"attribute": constraint.Attribute,
+			})
+			return fmt.Errorf("failed to claim unique values: %w", err)
+		}
+
+		violation := UniqueViolationError{Attribute: constraint.Attribute, Scope: uniqueScopeName(constraint.Scope)}
+		err := r.conn(ctx).QueryRow(ctx, conflictQuery, ciType, constraint.Scope, constraint.Attribute).Scan(
+			&violation.Value,
+			&violation.CIID,
+			&violation.CIName,
+			&violation.CIType,
+		)
+		if err == nil {
+			return violation
+		}
+		if err != pgx.ErrNoRows {
+			r.logger.ErrorDatabase("SELECT", "ci_unique_attribute_values", err, map[string]interface{}{
+				"ci_type":   ciType,
+				"attribute": constraint.Attribute,
+			})
+			return fmt.Errorf("failed to check unique values: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// uniqueScopeName turns a claim scope key back into the scope named in the schema
+func uniqueScopeName(scope string) string {
+	if scope == globalUniqueScope {
+		return UniqueScopeGlobal
+	}
+	return UniqueScopeType
+}
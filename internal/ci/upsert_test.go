@@ -0,0 +1,65 @@
+package ci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckUpsertKey(t *testing.T) {
+	ciType := &CITypeDefinition{
+		Name: "Server",
+		RequiredAttributes: []AttributeDefinition{
+			{Name: "serial_number", Type: "string", Unique: UniqueScopeGlobal},
+			{Name: "rack_unit", Type: "integer", Unique: UniqueScopeType},
+		},
+		OptionalAttributes: []AttributeDefinition{
+			{Name: "hostname", Type: "string"},
+		},
+	}
+
+	name, auditKey, err := checkUpsertKey(ciType, CIUpsertKey{CIType: "Server", Name: "web-01"}, &UpsertCIRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "web-01", name)
+	assert.Equal(t, "name", auditKey)
+
+	req := &UpsertCIRequest{Name: "web-01"}
+	name, auditKey, err = checkUpsertKey(ciType, CIUpsertKey{CIType: "Server", Attribute: "rack_unit", Value: "12"}, req)
+	require.NoError(t, err)
+	assert.Equal(t, "web-01", name)
+	assert.Equal(t, "rack_unit", auditKey)
+	assert.Equal(t, float64(12), req.Attributes["rack_unit"])
+
+	invalid := map[string]struct {
+		key CIUpsertKey
+		req UpsertCIRequest
+	}{
+		"name":                     {CIUpsertKey{CIType: "Server"}, UpsertCIRequest{}},
+		"attribute":                {CIUpsertKey{CIType: "Server", Attribute: "hostname", Value: "web-01"}, UpsertCIRequest{}},
+		"value":                    {CIUpsertKey{CIType: "Server", Attribute: "rack_unit", Value: "twelve"}, UpsertCIRequest{}},
+		"attributes.serial_number": {CIUpsertKey{CIType: "Server", Attribute: "serial_number", Value: "SN-1"}, UpsertCIRequest{Attributes: map[string]interface{}{"serial_number": "SN-2"}}},
+	}
+	for field, tc := range invalid {
+		_, _, err := checkUpsertKey(ciType, tc.key, &tc.req)
+		var validationErr ServiceValidationError
+		require.ErrorAs(t, err, &validationErr, field)
+		assert.Equal(t, field, validationErr.Errors[0].Field)
+	}
+
+	_, _, err = checkUpsertKey(ciType, CIUpsertKey{CIType: "Server", Name: "web-01"}, &UpsertCIRequest{Name: "web-02"})
+	assert.Error(t, err)
+}
+
+func TestClaimedValue(t *testing.T) {
+	for value, expected := range map[interface{}]string{
+		"SN-1234":    "SN-1234",
+		float64(12):  "12",
+		float64(1.5): "1.5",
+		true:         "true",
+	} {
+		claimed, err := claimedValue(value)
+		require.NoError(t, err)
+		assert.Equal(t, expected, claimed)
+	}
+}
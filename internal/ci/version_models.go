@@ -0,0 +1,48 @@
+package ci
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CIVersion is a full snapshot of a configuration item as it was after a
+// create or update
+type CIVersion struct {
+	CIID       uuid.UUID              `json:"ci_id" db:"ci_id"`
+	Version    int                    `json:"version" db:"version"`
+	Name       string                 `json:"name" db:"name"`
+	CIType     string                 `json:"ci_type" db:"ci_type"`
+	Attributes map[string]interface{} `json:"attributes" db:"attributes"`
+	Tags       []string               `json:"tags" db:"tags"`
+	ChangedBy  *uuid.UUID             `json:"changed_by,omitempty" db:"changed_by"`
+	ChangedAt  time.Time              `json:"changed_at" db:"changed_at"`
+}
+
+// CIVersionListResponse represents a paginated version history, newest first
+type CIVersionListResponse struct {
+	Versions   []CIVersion `json:"versions"`
+	Page       int         `json:"page"`
+	Limit      int         `json:"limit"`
+	Total      int64       `json:"total"`
+	TotalPages int         `json:"total_pages"`
+}
+
+// CIVersionDiff lists the differences between two versions of a CI
+type CIVersionDiff struct {
+	CIID        uuid.UUID     `json:"ci_id"`
+	FromVersion int           `json:"from_version"`
+	ToVersion   int           `json:"to_version"`
+	Changes     []FieldChange `json:"changes"`
+}
+
+// content returns the versioned part of the snapshot, leaving out who made the
+// change and when, so two versions can be diffed on content alone
+func (v *CIVersion) content() map[string]interface{} {
+	return map[string]interface{}{
+		"name":       v.Name,
+		"ci_type":    v.CIType,
+		"attributes": v.Attributes,
+		"tags":       v.Tags,
+	}
+}
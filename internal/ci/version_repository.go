@@ -0,0 +1,155 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// CI version history operations
+
+const ciVersionColumns = "ci_id, version, name, ci_type, attributes, tags, changed_by, changed_at"
+
+func scanCIVersion(row pgx.Row, v *CIVersion) error {
+	return row.Scan(
+		&v.CIID,
+		&v.Version,
+		&v.Name,
+		&v.CIType,
+		&v.Attributes,
+		&v.Tags,
+		&v.ChangedBy,
+		&v.ChangedAt,
+	)
+}
+
+// createCIVersion records a snapshot of ci at its current version. It runs on
+// the caller's connection so the snapshot commits together with the change.
+func (r *Repository) createCIVersion(ctx context.Context, ci *ConfigurationItem, changedBy uuid.UUID) error {
+	query := `
+		INSERT INTO configuration_item_versions (ci_id, version, name, ci_type, attributes, tags, changed_by, changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	_, err := r.conn(ctx).Exec(ctx, query,
+		ci.ID,
+		ci.Version,
+		ci.Name,
+		ci.CIType,
+		ci.Attributes,
+		ci.Tags,
+		changedBy,
+		ci.UpdatedAt,
+	)
+	if err != nil {
+		r.logger.ErrorDatabase("INSERT", "configuration_item_versions", err, map[string]interface{}{
+			"ci_id":   ci.ID,
+			"version": ci.Version,
+		})
+		return fmt.Errorf("failed to record CI version: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) ListCIVersions(ctx context.Context, ciID uuid.UUID, page, limit int) (*CIVersionListResponse, error) {
+	offset := (page - 1) * limit
+
+	var total int64
+	err := r.conn(ctx).QueryRow(ctx, "SELECT COUNT(*) FROM configuration_item_versions WHERE ci_id = $1", ciID).Scan(&total)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "configuration_item_versions", err, map[string]interface{}{
+			"ci_id": ciID,
+		})
+		return nil, fmt.Errorf("failed to count CI versions: %w", err)
+	}
+
+	query := `
+		SELECT ` + ciVersionColumns + `
+		FROM configuration_item_versions
+		WHERE ci_id = $1
+		ORDER BY version DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.conn(ctx).Query(ctx, query, ciID, limit, offset)
+	if err != nil {
+		r.logger.ErrorDatabase("SELECT", "configuration_item_versions", err, map[string]interface{}{
+			"ci_id": ciID,
+		})
+		return nil, fmt.Errorf("failed to list CI versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []CIVersion{}
+	for rows.Next() {
+		var v CIVersion
+		if err := scanCIVersion(rows, &v); err != nil {
+			r.logger.ErrorDatabase("SELECT", "configuration_item_versions", err, nil)
+			return nil, fmt.Errorf("failed to scan CI version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+
+	totalPages := int((total + int64(limit) - 1) / int64(limit))
+
+	return &CIVersionListResponse{
+		Versions:   versions,
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: totalPages,
+	}, nil
+}
+
+func (r *Repository) GetCIVersion(ctx context.Context, ciID uuid.UUID, version int) (*CIVersion, error) {
+	query := `
+		SELECT ` + ciVersionColumns + `
+		FROM configuration_item_versions
+		WHERE ci_id = $1 AND version = $2
+	`
+
+	var v CIVersion
+	err := scanCIVersion(r.conn(ctx).QueryRow(ctx, query, ciID, version), &v)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("CI version not found")
+		}
+		r.logger.ErrorDatabase("SELECT", "configuration_item_versions", err, map[string]interface{}{
+			"ci_id":   ciID,
+			"version": version,
+		})
+		return nil, fmt.Errorf("failed to get CI version: %w", err)
+	}
+
+	return &v, nil
+}
+
+// GetCIVersionAt returns the version of a CI that was current at asOf
+func (r *Repository) GetCIVersionAt(ctx context.Context, ciID uuid.UUID, asOf time.Time) (*CIVersion, error) {
+	query := `
+		SELECT ` + ciVersionColumns + `
+		FROM configuration_item_versions
+		WHERE ci_id = $1 AND changed_at <= $2
+		ORDER BY version DESC
+		LIMIT 1
+	`
+
+	var v CIVersion
+	err := scanCIVersion(r.conn(ctx).QueryRow(ctx, query, ciID, asOf), &v)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("CI version not found")
+		}
+		r.logger.ErrorDatabase("SELECT", "configuration_item_versions", err, map[string]interface{}{
+			"ci_id": ciID,
+			"as_of": asOf,
+		})
+		return nil, fmt.Errorf("failed to get CI version: %w", err)
+	}
+
+	return &v, nil
+}
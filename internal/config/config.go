@@ -21,6 +21,7 @@ type Config struct {
 	RateLimit  RateLimitConfig  `mapstructure:"rate_limit"`
 	Security   SecurityConfig   `mapstructure:"security"`
 	Admin      AdminConfig      `mapstructure:"admin"`
+	GraphSync  GraphSyncConfig  `mapstructure:"graph_sync"`
 	Env        string           `mapstructure:"environment"`
 }
 
@@ -93,6 +94,15 @@ type AdminConfig struct {
 	Password string `mapstructure:"password"`
 }
 
+type GraphSyncConfig struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	BatchSize    int           `mapstructure:"batch_size"`
+	MaxAttempts  int           `mapstructure:"max_attempts"`
+	BaseBackoff  time.Duration `mapstructure:"base_backoff"`
+	MaxBackoff   time.Duration `mapstructure:"max_backoff"`
+}
+
 func Load() (*Config, error) {
 	// Load .env file if it exists
 	if err := godotenv.Load(); err != nil {
@@ -145,6 +155,13 @@ func Load() (*Config, error) {
 	viper.BindEnv("admin.email", "ADMIN_EMAIL", "PUSTAKA_ADMIN_EMAIL")
 	viper.BindEnv("admin.password", "ADMIN_PASSWORD", "PUSTAKA_ADMIN_PASSWORD")
 
+	viper.BindEnv("graph_sync.enabled", "GRAPH_SYNC_ENABLED", "PUSTAKA_GRAPH_SYNC_ENABLED")
+	viper.BindEnv("graph_sync.poll_interval", "GRAPH_SYNC_POLL_INTERVAL", "PUSTAKA_GRAPH_SYNC_POLL_INTERVAL")
+	viper.BindEnv("graph_sync.batch_size", "GRAPH_SYNC_BATCH_SIZE", "PUSTAKA_GRAPH_SYNC_BATCH_SIZE")
+	viper.BindEnv("graph_sync.max_attempts", "GRAPH_SYNC_MAX_ATTEMPTS", "PUSTAKA_GRAPH_SYNC_MAX_ATTEMPTS")
+	viper.BindEnv("graph_sync.base_backoff", "GRAPH_SYNC_BASE_BACKOFF", "PUSTAKA_GRAPH_SYNC_BASE_BACKOFF")
+	viper.BindEnv("graph_sync.max_backoff", "GRAPH_SYNC_MAX_BACKOFF", "PUSTAKA_GRAPH_SYNC_MAX_BACKOFF")
+
 	viper.BindEnv("environment", "ENVIRONMENT", "PUSTAKA_ENVIRONMENT")
 
 	var config Config
@@ -192,8 +209,8 @@ func setDefaults() {
 
 	// CORS defaults
 	viper.SetDefault("cors.allowed_origins", []string{"http://localhost:3000"})
-	viper.SetDefault("cors.allowed_methods", []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"})
-	viper.SetDefault("cors.allowed_headers", []string{"Origin", "Content-Type", "Accept", "Authorization"})
+	viper.SetDefault("cors.allowed_methods", []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"})
+	viper.SetDefault("cors.allowed_headers", []string{"Origin", "Content-Type", "Accept", "Authorization", "If-Match", "If-None-Match"})
 
 	// Logging defaults
 	viper.SetDefault("logging.level", "info")
@@ -218,6 +235,14 @@ func setDefaults() {
 	viper.SetDefault("admin.email", "admin@pustaka.dev")
 	viper.SetDefault("admin.password", "Admin@123")
 
+	// Graph sync defaults
+	viper.SetDefault("graph_sync.enabled", true)
+	viper.SetDefault("graph_sync.poll_interval", "1s")
+	viper.SetDefault("graph_sync.batch_size", 100)
+	viper.SetDefault("graph_sync.max_attempts", 10)
+	viper.SetDefault("graph_sync.base_backoff", "1s")
+	viper.SetDefault("graph_sync.max_backoff", "10m")
+
 	// Environment defaults
 	viper.SetDefault("environment", "development")
 }
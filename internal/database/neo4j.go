@@ -120,8 +120,8 @@ func (db *Neo4jDB) InitializeIndexes(ctx context.Context) error {
 		"CREATE INDEX user_id_idx IF NOT EXISTS FOR (n:User) ON (n.id)",
 		"CREATE INDEX user_username_idx IF NOT EXISTS FOR (n:User) ON (n.username)",
 
-		// Full-text search index
-		"CREATE FULLTEXT INDEX ci_search_idx IF NOT EXISTS FOR (n:ConfigurationItem) ON EACH [n.name, n.type]",
+		// Full-text search is served by PostgreSQL; drop the unused index
+		"DROP INDEX ci_search_idx IF EXISTS",
 	}
 
 	for _, query := range queries {
@@ -27,6 +27,11 @@ func SetupTestDB(t *testing.T) (*pgxpool.Pool, func()) {
 	if err != nil {
 		log.Fatalf("Could not connect to Docker: %s", err)
 	}
+	// Without Docker only the tests needing a database are skipped, rather
+	// than the whole test binary exiting
+	if err := pool.Client.Ping(); err != nil {
+		t.Skipf("Docker is not available: %s", err)
+	}
 
 	// Pull postgres image
 	resource, err := pool.Run("postgres", "15-alpine", []string{
@@ -83,6 +88,11 @@ func SetupTestRedis(t *testing.T) (*redis.Client, func()) {
 	if err != nil {
 		log.Fatalf("Could not connect to Docker: %s", err)
 	}
+	// Without Docker only the tests needing a database are skipped, rather
+	// than the whole test binary exiting
+	if err := pool.Client.Ping(); err != nil {
+		t.Skipf("Docker is not available: %s", err)
+	}
 
 	resource, err := pool.Run("redis", "7-alpine", []string{})
 	if err != nil {
@@ -119,13 +129,22 @@ func CleanupDB(t *testing.T, db *pgxpool.Pool) {
 	// Delete all data in correct order respecting foreign keys
 	tables := []string{
 		"audit_logs",
+		"saved_searches",
+		"configuration_item_versions",
+		"graph_sync_outbox",
 		"relationships",
+		"relationship_type_definitions",
+		"ci_unique_attribute_values",
 		"configuration_items",
 		"user_roles",
 		"role_permissions",
 		"users",
 		"roles",
 		"permissions",
+		"ci_import_errors",
+		"ci_import_jobs",
+		"ci_type_migration_jobs",
+		"ci_type_schema_versions",
 		"ci_type_definitions",
 	}
 
@@ -223,6 +242,34 @@ func runMigrations(db *pgxpool.Pool) error {
 		return fmt.Errorf("failed to enable UUID extension: %w", err)
 	}
 
+	// Create the search document functions used by generated columns
+	_, err = db.Exec(ctx, `
+		CREATE OR REPLACE FUNCTION ci_search_vector(TEXT, TEXT[], JSONB) RETURNS tsvector
+			LANGUAGE SQL IMMUTABLE PARALLEL SAFE
+			AS $$
+				SELECT setweight(to_tsvector('simple', COALESCE($1, '')), 'A') ||
+				       setweight(to_tsvector('simple', COALESCE(array_to_string($2, ' '), '')), 'B') ||
+				       setweight(jsonb_to_tsvector('simple', COALESCE($3, '{}'), '["string"]'), 'C')
+			$$;
+
+		CREATE OR REPLACE FUNCTION ci_type_search_vector(TEXT, TEXT) RETURNS tsvector
+			LANGUAGE SQL IMMUTABLE PARALLEL SAFE
+			AS $$
+				SELECT setweight(to_tsvector('simple', COALESCE($1, '')), 'A') ||
+				       setweight(to_tsvector('simple', COALESCE($2, '')), 'B')
+			$$;
+
+		CREATE OR REPLACE FUNCTION relationship_search_vector(TEXT, JSONB) RETURNS tsvector
+			LANGUAGE SQL IMMUTABLE PARALLEL SAFE
+			AS $$
+				SELECT setweight(to_tsvector('simple', COALESCE($1, '')), 'A') ||
+				       setweight(jsonb_to_tsvector('simple', COALESCE($2, '{}'), '["string"]'), 'C')
+			$$;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create search functions: %w", err)
+	}
+
 	// Create tables (simplified version of the migration)
 	_, err = db.Exec(ctx, `
 		CREATE TABLE IF NOT EXISTS users (
@@ -272,9 +319,50 @@ func runMigrations(db *pgxpool.Pool) error {
 			optional_attributes JSONB NOT NULL DEFAULT '[]',
 			created_by UUID REFERENCES users(id),
 			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			schema_version INTEGER NOT NULL DEFAULT 1,
+			parent VARCHAR(100) REFERENCES ci_type_definitions(name),
+			abstract BOOLEAN NOT NULL DEFAULT false,
+			version INTEGER NOT NULL DEFAULT 1,
+			search_vector tsvector GENERATED ALWAYS AS (ci_type_search_vector(name, description)) STORED
+		);
+
+		CREATE TABLE IF NOT EXISTS ci_type_schema_versions (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			ci_type_id UUID NOT NULL,
+			version INTEGER NOT NULL,
+			required_attributes JSONB NOT NULL DEFAULT '[]',
+			optional_attributes JSONB NOT NULL DEFAULT '[]',
+			parent VARCHAR(100),
+			changed_by UUID REFERENCES users(id),
+			changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			CONSTRAINT unique_ci_type_schema_version UNIQUE (ci_type_id, version)
 		);
 
+		CREATE TABLE IF NOT EXISTS ci_type_migration_jobs (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			ci_type_id UUID NOT NULL REFERENCES ci_type_definitions(id) ON DELETE CASCADE,
+			from_version INTEGER NOT NULL,
+			to_version INTEGER NOT NULL,
+			operations JSONB NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			total_cis INTEGER NOT NULL DEFAULT 0,
+			processed_cis INTEGER NOT NULL DEFAULT 0,
+			migrated_cis INTEGER NOT NULL DEFAULT 0,
+			failed_cis INTEGER NOT NULL DEFAULT 0,
+			failures JSONB NOT NULL DEFAULT '[]',
+			last_ci_id UUID,
+			error TEXT,
+			created_by UUID REFERENCES users(id),
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			started_at TIMESTAMP WITH TIME ZONE,
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			finished_at TIMESTAMP WITH TIME ZONE
+		);
+
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_ci_type_migration_jobs_active ON ci_type_migration_jobs(ci_type_id)
+			WHERE status IN ('pending', 'running');
+
 		CREATE TABLE IF NOT EXISTS configuration_items (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 			name VARCHAR(255) NOT NULL,
@@ -285,9 +373,61 @@ func runMigrations(db *pgxpool.Pool) error {
 			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
 			created_by UUID REFERENCES users(id),
 			updated_by UUID REFERENCES users(id),
+			version INTEGER NOT NULL DEFAULT 1,
+			search_vector tsvector GENERATED ALWAYS AS (ci_search_vector(name, tags, attributes)) STORED,
 			CONSTRAINT unique_name_per_type UNIQUE (name, ci_type)
 		);
 
+		CREATE TABLE IF NOT EXISTS ci_unique_attribute_values (
+			scope VARCHAR(100) NOT NULL,
+			attribute VARCHAR(255) NOT NULL,
+			value TEXT NOT NULL,
+			ci_id UUID NOT NULL REFERENCES configuration_items(id) ON DELETE CASCADE,
+			PRIMARY KEY (scope, attribute, value)
+		);
+
+		CREATE TABLE IF NOT EXISTS configuration_item_versions (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			ci_id UUID NOT NULL,
+			version INTEGER NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			ci_type VARCHAR(100) NOT NULL,
+			attributes JSONB NOT NULL DEFAULT '{}',
+			tags TEXT[] DEFAULT '{}',
+			changed_by UUID REFERENCES users(id),
+			changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			CONSTRAINT unique_ci_version UNIQUE (ci_id, version)
+		);
+
+		CREATE TABLE IF NOT EXISTS graph_sync_outbox (
+			id BIGSERIAL PRIMARY KEY,
+			entity_type VARCHAR(50) NOT NULL,
+			entity_id UUID NOT NULL,
+			operation VARCHAR(20) NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+
+		CREATE TABLE IF NOT EXISTS relationship_type_definitions (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			name VARCHAR(50) UNIQUE NOT NULL,
+			inverse_name VARCHAR(50) NOT NULL,
+			description TEXT,
+			source_ci_types TEXT[] NOT NULL DEFAULT '{}',
+			target_ci_types TEXT[] NOT NULL DEFAULT '{}',
+			cardinality VARCHAR(3) NOT NULL DEFAULT 'N:M',
+			is_dependency BOOLEAN NOT NULL DEFAULT false,
+			required_attributes JSONB NOT NULL DEFAULT '[]',
+			optional_attributes JSONB NOT NULL DEFAULT '[]',
+			created_by UUID REFERENCES users(id),
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+
 		CREATE TABLE IF NOT EXISTS relationships (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 			source_id UUID NOT NULL REFERENCES configuration_items(id) ON DELETE CASCADE,
@@ -296,10 +436,60 @@ func runMigrations(db *pgxpool.Pool) error {
 			attributes JSONB DEFAULT '{}',
 			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
 			created_by UUID REFERENCES users(id),
+			version INTEGER NOT NULL DEFAULT 1,
+			search_vector tsvector GENERATED ALWAYS AS (relationship_search_vector(relationship_type, attributes)) STORED,
 			CONSTRAINT no_self_relationship CHECK (source_id != target_id),
 			CONSTRAINT unique_relationship UNIQUE (source_id, target_id, relationship_type)
 		);
 
+		CREATE TABLE IF NOT EXISTS ci_import_jobs (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			ci_type VARCHAR(100) NOT NULL,
+			mode VARCHAR(20) NOT NULL DEFAULT 'create',
+			dry_run BOOLEAN NOT NULL DEFAULT false,
+			mapping JSONB NOT NULL DEFAULT '{}',
+			file_name TEXT,
+			content BYTEA NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			total_rows INTEGER NOT NULL DEFAULT 0,
+			processed_rows INTEGER NOT NULL DEFAULT 0,
+			created_rows INTEGER NOT NULL DEFAULT 0,
+			updated_rows INTEGER NOT NULL DEFAULT 0,
+			failed_rows INTEGER NOT NULL DEFAULT 0,
+			error TEXT,
+			created_by UUID REFERENCES users(id),
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			started_at TIMESTAMP WITH TIME ZONE,
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			finished_at TIMESTAMP WITH TIME ZONE
+		);
+
+		CREATE TABLE IF NOT EXISTS ci_import_errors (
+			job_id UUID NOT NULL REFERENCES ci_import_jobs(id) ON DELETE CASCADE,
+			row_number INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			column_name TEXT,
+			field VARCHAR(255),
+			message TEXT NOT NULL,
+			PRIMARY KEY (job_id, row_number, position)
+		);
+
+		CREATE TABLE IF NOT EXISTS saved_searches (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			description TEXT,
+			kind VARCHAR(20) NOT NULL,
+			ci_filters JSONB,
+			graph_filters JSONB,
+			columns TEXT[] NOT NULL DEFAULT '{}',
+			shared_with_role VARCHAR(50) REFERENCES roles(name) ON UPDATE CASCADE ON DELETE SET NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			version INTEGER NOT NULL DEFAULT 1,
+			CONSTRAINT unique_saved_search_name UNIQUE (owner_id, name)
+		);
+
 		CREATE TABLE IF NOT EXISTS audit_logs (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 			entity_type VARCHAR(50) NOT NULL,
@@ -325,6 +515,7 @@ func runMigrations(db *pgxpool.Pool) error {
 		CREATE INDEX IF NOT EXISTS idx_cis_name ON configuration_items(name);
 		CREATE INDEX IF NOT EXISTS idx_relationships_source ON relationships(source_id);
 		CREATE INDEX IF NOT EXISTS idx_relationships_target ON relationships(target_id);
+		CREATE INDEX IF NOT EXISTS idx_cis_search_vector ON configuration_items USING GIN(search_vector);
 	`)
 
 	if err != nil {